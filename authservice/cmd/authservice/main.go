@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,32 +13,105 @@ import (
 	"authservice/internal/auth"
 	"authservice/internal/config"
 	"authservice/internal/database"
+	"authservice/internal/geoip"
+	"authservice/internal/logging"
+	"authservice/internal/mailer"
+	"authservice/internal/org"
+	"authservice/internal/privacy"
 	"authservice/internal/repository"
+	"authservice/internal/scim"
+	"authservice/internal/telemetry"
 )
 
 func main() {
-	log.Println("Starting auth service...")
+	// Structured JSON logging; the level is adjustable while running
+	logLevel := new(slog.LevelVar)
+	slog.SetDefault(logging.New(os.Stdout, logLevel))
+
+	slog.Info("Starting auth service...")
 
 	// Load configuration
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", err)
+	}
+
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		fatal("Invalid LOG_LEVEL", err)
+	}
+	logLevel.Set(level)
+
+	// Setup tracing
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg)
+	if err != nil {
+		fatal("Failed to setup tracing", err)
 	}
 
 	// Connect to database
 	pool, err := database.NewPostgresPool(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", err)
 	}
 	defer pool.Close()
 
+	// Apply pending schema migrations
+	if err := database.Migrate(context.Background(), pool); err != nil {
+		fatal("Failed to migrate database", err)
+	}
+
 	// --- Dependency Injection ---
 	userRepo := repository.NewPostgresUserRepository(pool)
-	authSvc := auth.NewAuthService(userRepo, cfg) // Pass cfg here
-	authHandler := api.NewAuthHandler(authSvc)
+	magicLinkRepo := repository.NewPostgresMagicLinkRepository(pool)
+	orgRepo := repository.NewPostgresOrgRepository(pool)
+	impRepo := repository.NewPostgresImpersonationRepository(pool)
+	passkeyRepo := repository.NewPostgresPasskeyRepository(pool)
+	erasureRepo := repository.NewPostgresErasureRepository(pool)
+	scimTokenRepo := repository.NewPostgresScimTokenRepository(pool)
+	inviteRepo := repository.NewPostgresRegistrationInviteRepository(pool)
+	loginRepo := repository.NewPostgresLoginHistoryRepository(pool)
+	mail := mailer.New(cfg)
+	webAuthn, err := auth.NewWebAuthn(cfg)
+	if err != nil {
+		fatal("Invalid WebAuthn configuration", err)
+	}
+	signingKey, err := auth.LoadSigningKey(cfg)
+	if err != nil {
+		fatal("Invalid access-token signing key", err)
+	}
+	var directory auth.CredentialBackend // Stays nil (disabled) unless LDAP is configured
+	if cfg.LDAPURL != "" {
+		ldapBackend, err := auth.NewLDAPBackend(cfg)
+		if err != nil {
+			fatal("Invalid LDAP configuration", err)
+		}
+		directory = ldapBackend
+	}
+	var locator geoip.Locator // Stays nil (no impossible-travel checks) without a GeoIP database
+	if cfg.GeoIPDatabase != "" {
+		geoDB, err := geoip.Open(cfg.GeoIPDatabase)
+		if err != nil {
+			fatal("Invalid GeoIP configuration", err)
+		}
+		defer geoDB.Close()
+		locator = geoDB
+	}
+	authSvc := auth.NewAuthService(userRepo, magicLinkRepo, orgRepo, impRepo, passkeyRepo, inviteRepo, loginRepo, mail, webAuthn, signingKey, directory, locator, cfg) // Pass cfg here
+	orgSvc := org.NewOrgService(orgRepo, userRepo, scimTokenRepo, mail, cfg)
+	privacySvc := privacy.NewPrivacyService(userRepo, orgRepo, passkeyRepo, impRepo, loginRepo, erasureRepo, cfg)
+	scimSvc := scim.NewScimService(userRepo, orgRepo)
+	authHandler := api.NewAuthHandler(authSvc, cfg)
+	orgHandler := api.NewOrgHandler(orgSvc)
+	privacyHandler := api.NewPrivacyHandler(privacySvc)
+	scimHandler := api.NewScimHandler(scimSvc, orgSvc)
+
+	// Deliver account deletion events to the other services in the background
+	dispatchCtx, stopDispatcher := context.WithCancel(context.Background())
+	defer stopDispatcher()
+	go privacySvc.RunDispatcher(dispatchCtx)
 
 	// Setup router
-	router := api.NewRouter(authHandler)
+	router := api.NewRouter(authHandler, orgHandler, privacyHandler, scimHandler, cfg, logLevel)
 
 	// Setup HTTP server
 	server := &http.Server{
@@ -60,7 +133,7 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Auth service listening on :%s", cfg.HTTPPort)
+		slog.Info("Auth service listening", "port", cfg.HTTPPort)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			serverErrors <- err
 		}
@@ -69,9 +142,9 @@ func main() {
 	// Block until we receive a signal or server error
 	select {
 	case err := <-serverErrors:
-		log.Fatalf("Error starting server: %v", err)
+		fatal("Error starting server", err)
 	case sig := <-stop:
-		log.Printf("Received signal: %s. Starting graceful shutdown...", sig)
+		slog.Info("Received signal. Starting graceful shutdown...", "signal", sig.String())
 	}
 
 	// Create a context with timeout for shutdown
@@ -79,9 +152,21 @@ func main() {
 	defer cancel()
 
 	// Attempt graceful shutdown
+	stopDispatcher()
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Graceful shutdown failed: %v", err)
+		fatal("Graceful shutdown failed", err)
+	}
+
+	// Flush any spans still buffered in the exporter
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to shutdown tracing", "error", err)
 	}
 
-	log.Println("Server gracefully stopped")
+	slog.Info("Server gracefully stopped")
+}
+
+// fatal logs the error and exits, like log.Fatalf did.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
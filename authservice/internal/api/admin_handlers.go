@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"authservice/internal/auth"
+	"authservice/internal/domain"
+	"authservice/internal/repository"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ImpersonateRequest defines the expected JSON body for starting an impersonation session.
+type ImpersonateRequest struct {
+	Reason string `json:"reason"` // Required for the audit trail, e.g. a ticket number
+}
+
+// ImpersonateResponse is returned when an impersonation session starts.
+type ImpersonateResponse struct {
+	Token   string                       `json:"token"`
+	Session *domain.ImpersonationSession `json:"session"`
+}
+
+// Impersonate issues a short-lived token that lets a staff member act as the user in the URL.
+func (h *AuthHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	targetID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "Reason is required")
+		return
+	}
+
+	token, session, err := h.authService.Impersonate(r.Context(), actorID, targetID, req.Reason, r.RemoteAddr)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			respondWithError(w, http.StatusNotFound, "User not found")
+		case errors.Is(err, auth.ErrForbidden):
+			respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		case errors.Is(err, auth.ErrCannotImpersonate):
+			respondWithError(w, http.StatusForbidden, "This user cannot be impersonated")
+		default:
+			slog.ErrorContext(r.Context(), "Error starting impersonation", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to start impersonation")
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, ImpersonateResponse{Token: token, Session: session})
+}
+
+// ListImpersonations lists recent impersonation sessions (?user_id= filters by actor or target).
+func (h *AuthHandler) ListImpersonations(w http.ResponseWriter, r *http.Request) {
+	var userID int64
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		userID = id
+	}
+
+	sessions, err := h.authService.ListImpersonations(r.Context(), userID, 100)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing impersonation sessions", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to list impersonation sessions")
+		return
+	}
+	if sessions == nil {
+		sessions = []domain.ImpersonationSession{}
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// CreateRegistrationInviteRequest defines the expected JSON body for creating a registration invite.
+type CreateRegistrationInviteRequest struct {
+	Role string `json:"role"` // Platform role of the new account; user if empty
+	Note string `json:"note"` // Who the invite is for, for the audit trail
+}
+
+// CreateRegistrationInviteResponse returns the new invite. The code is only shown once.
+type CreateRegistrationInviteResponse struct {
+	*domain.RegistrationInvite
+	Code string `json:"code"`
+}
+
+// CreateRegistrationInvite creates a single-use registration invite code.
+func (h *AuthHandler) CreateRegistrationInvite(w http.ResponseWriter, r *http.Request) {
+	creatorID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	var req CreateRegistrationInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	invite, code, err := h.authService.CreateRegistrationInvite(r.Context(), creatorID, req.Role, req.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidRole):
+			respondWithError(w, http.StatusBadRequest, "Role must be user, support or admin")
+		case errors.Is(err, auth.ErrForbidden):
+			respondWithError(w, http.StatusForbidden, "Only admins can invite staff")
+		default:
+			slog.ErrorContext(r.Context(), "Error creating registration invite", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to create invite")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, CreateRegistrationInviteResponse{RegistrationInvite: invite, Code: code})
+}
+
+// ListRegistrationInvites lists recent registration invites.
+func (h *AuthHandler) ListRegistrationInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.authService.ListRegistrationInvites(r.Context(), 100)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing registration invites", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to list invites")
+		return
+	}
+	if invites == nil {
+		invites = []domain.RegistrationInvite{}
+	}
+	respondWithJSON(w, http.StatusOK, invites)
+}
+
+// DeleteRegistrationInvite revokes an unused registration invite.
+func (h *AuthHandler) DeleteRegistrationInvite(w http.ResponseWriter, r *http.Request) {
+	inviteID, err := strconv.ParseInt(chi.URLParam(r, "inviteID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invite ID")
+		return
+	}
+
+	if err := h.authService.DeleteRegistrationInvite(r.Context(), inviteID); err != nil {
+		if errors.Is(err, repository.ErrRegistrationInviteInvalid) {
+			respondWithError(w, http.StatusNotFound, "Invite not found or already used")
+		} else {
+			slog.ErrorContext(r.Context(), "Error deleting registration invite", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to delete invite")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
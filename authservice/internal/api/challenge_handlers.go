@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"authservice/internal/auth"
+)
+
+// ProofOfWorkErrorResponse is returned when a registration or login lacks a valid proof
+// of work. It carries a fresh puzzle so the client needn't call GET /challenge again.
+type ProofOfWorkErrorResponse struct {
+	Error     string          `json:"error"`
+	Challenge *auth.Challenge `json:"challenge,omitempty"`
+}
+
+// Challenge issues a proof-of-work puzzle. Solve it and send the challenge and solution
+// as pow_challenge and pow_solution with /register or /login.
+func (h *AuthHandler) Challenge(w http.ResponseWriter, r *http.Request) {
+	challenge, err := h.authService.IssueChallenge(r.Context(), clientIP(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error issuing challenge", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to issue challenge")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, challenge)
+}
+
+func isProofOfWorkError(err error) bool {
+	return errors.Is(err, auth.ErrProofOfWorkRequired) || errors.Is(err, auth.ErrInvalidProofOfWork)
+}
+
+// respondWithProofOfWorkError rejects the request with a new puzzle to solve.
+func (h *AuthHandler) respondWithProofOfWorkError(w http.ResponseWriter, r *http.Request, err error) {
+	message := "Proof of work required"
+	if errors.Is(err, auth.ErrInvalidProofOfWork) {
+		message = "Invalid or expired proof of work"
+	}
+	challenge, err := h.authService.IssueChallenge(r.Context(), clientIP(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error issuing challenge", "error", err)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusForbidden, ProofOfWorkErrorResponse{Error: message, Challenge: challenge})
+}
+
+// clientIP returns the client's address without the port. RealIP has already replaced
+// RemoteAddr with the proxy headers' value for requests from trusted proxies.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
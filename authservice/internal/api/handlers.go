@@ -3,38 +3,58 @@ package api
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"authservice/internal/auth"
+	"authservice/internal/config"
 	"authservice/internal/repository" // For error checking
 )
 
 // AuthHandler handles HTTP requests for authentication.
 type AuthHandler struct {
 	authService auth.AuthService
+	cfg         *config.Config
 }
 
 // NewAuthHandler creates a new AuthHandler.
-func NewAuthHandler(authService auth.AuthService) *AuthHandler {
-	return &AuthHandler{authService: authService}
+func NewAuthHandler(authService auth.AuthService, cfg *config.Config) *AuthHandler {
+	return &AuthHandler{authService: authService, cfg: cfg}
 }
 
 // RegisterRequest defines the expected JSON body for registration.
 type RegisterRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	InviteCode string `json:"invite_code,omitempty"` // Required in invite_only mode
+
+	// Solved puzzle from GET /challenge, when proof of work is enabled
+	PoWChallenge string `json:"pow_challenge,omitempty"`
+	PoWSolution  string `json:"pow_solution,omitempty"`
 }
 
 // LoginRequest defines the expected JSON body for login.
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	UseCookies bool   `json:"use_cookies"`         // Browser session: token in an HttpOnly cookie instead of the body
+	DeviceID   string `json:"device_id,omitempty"` // Stable app-install ID for clients without cookies
+
+	// Solved puzzle from GET /challenge, when proof of work is enabled
+	PoWChallenge string `json:"pow_challenge,omitempty"`
+	PoWSolution  string `json:"pow_solution,omitempty"`
+}
+
+// ChangePasswordRequest defines the expected JSON body for changing the password.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
 }
 
 // LoginResponse defines the JSON response for successful login.
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token     string `json:"token,omitempty"`
+	CSRFToken string `json:"csrf_token,omitempty"` // Only in cookie mode
 }
 
 // ErrorResponse defines the standard JSON error response.
@@ -42,6 +62,12 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// RegistrationErrorResponse is returned when the registration mode rejects a registration.
+type RegistrationErrorResponse struct {
+	Error            string `json:"error"`
+	RegistrationMode string `json:"registration_mode"`
+}
+
 // Register handles user registration requests.
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
@@ -62,12 +88,19 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.authService.Register(r.Context(), req.Email, req.Password)
+	client := auth.ClientInfo{IP: clientIP(r), UserAgent: r.UserAgent()}
+	proof := auth.ProofOfWork{Challenge: req.PoWChallenge, Solution: req.PoWSolution}
+	user, err := h.authService.Register(r.Context(), req.Email, req.Password, req.InviteCode, client, proof)
 	if err != nil {
-		if errors.Is(err, repository.ErrEmailExists) {
+		var regErr *auth.RegistrationError
+		if isProofOfWorkError(err) {
+			h.respondWithProofOfWorkError(w, r, err)
+		} else if errors.As(err, &regErr) {
+			respondWithJSON(w, http.StatusForbidden, RegistrationErrorResponse{Error: regErr.Reason, RegistrationMode: regErr.Mode})
+		} else if errors.Is(err, repository.ErrEmailExists) {
 			respondWithError(w, http.StatusConflict, "Email already exists")
 		} else {
-			log.Printf("Error registering user: %v", err)
+			slog.ErrorContext(r.Context(), "Error registering user", "error", err)
 			respondWithError(w, http.StatusInternalServerError, "Failed to register user")
 		}
 		return
@@ -90,18 +123,35 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.authService.Login(r.Context(), req.Email, req.Password)
+	client, err := h.clientInfo(w, r, req.DeviceID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating device ID", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+	proof := auth.ProofOfWork{Challenge: req.PoWChallenge, Solution: req.PoWSolution}
+	token, err := h.authService.Login(r.Context(), req.Email, req.Password, client, proof)
 	if err != nil {
-		if err.Error() == "invalid credentials" { // Check for specific error string from service
+		var secondFactorErr *auth.SecondFactorRequiredError
+		if isProofOfWorkError(err) {
+			h.respondWithProofOfWorkError(w, r, err)
+		} else if errors.As(err, &secondFactorErr) {
+			respondWithJSON(w, http.StatusUnauthorized, SecondFactorResponse{
+				Error:             "Second factor required",
+				SecondFactorToken: secondFactorErr.Token,
+				Methods:           secondFactorErr.Methods,
+				ExpiresAt:         secondFactorErr.ExpiresAt,
+			})
+		} else if errors.Is(err, auth.ErrInvalidCredentials) {
 			respondWithError(w, http.StatusUnauthorized, "Invalid email or password")
 		} else {
-			log.Printf("Error logging in user: %v", err)
+			slog.ErrorContext(r.Context(), "Error logging in user", "error", err)
 			respondWithError(w, http.StatusInternalServerError, "Failed to login")
 		}
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, LoginResponse{Token: token})
+	h.respondWithSession(w, r, token, req.UseCookies)
 }
 
 // GetUserProfile is a protected handler that retrieves the user ID from the context.
@@ -109,7 +159,7 @@ func (h *AuthHandler) GetUserProfile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(UserIDKey).(int64)
 	if !ok {
 		// This should ideally not happen if middleware is correctly applied
-		log.Printf("Error: User ID not found in context or not an int64")
+		slog.ErrorContext(r.Context(), "User ID not found in context or not an int64")
 		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
 		return
 	}
@@ -120,9 +170,53 @@ func (h *AuthHandler) GetUserProfile(w http.ResponseWriter, r *http.Request) {
 
 	// For now, just return the ID
 	response := map[string]interface{}{"user_id": userID}
+	if orgID, ok := r.Context().Value(OrgIDKey).(int64); ok {
+		response["org_id"] = orgID
+		response["org_role"] = r.Context().Value(OrgRoleKey)
+	}
 	respondWithJSON(w, http.StatusOK, response)
 }
 
+// ChangePassword changes the current user's password.
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	// The current password may be empty for accounts that only have passkeys
+	if req.NewPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "New password is required")
+		return
+	}
+	if len(req.NewPassword) < 6 {
+		respondWithError(w, http.StatusBadRequest, "Password must be at least 6 characters long")
+		return
+	}
+
+	if err := h.authService.ChangePassword(r.Context(), userID, req.CurrentPassword, req.NewPassword); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			respondWithError(w, http.StatusUnauthorized, "Current password is incorrect")
+		case errors.Is(err, auth.ErrPasswordManagedExternally):
+			respondWithError(w, http.StatusConflict, "Password is managed by your directory and can't be changed here")
+		default:
+			slog.ErrorContext(r.Context(), "Error changing password", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to change password")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // respondWithError sends a JSON error response.
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, ErrorResponse{Error: message})
@@ -132,7 +226,7 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON response: %v", err)
+		slog.Error("Error marshalling JSON response", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(`{"error":"Internal Server Error"}`))
 		return
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"authservice/internal/auth"
+	"authservice/internal/domain"
+)
+
+// deviceCookie identifies the browser across logins, so logins from new devices stand out.
+const deviceCookie = "device_id"
+
+// SecondFactorResponse is returned when a suspicious login needs the emailed code.
+type SecondFactorResponse struct {
+	Error             string    `json:"error"`
+	SecondFactorToken string    `json:"second_factor_token"`
+	Methods           []string  `json:"methods"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// VerifyLoginCodeRequest defines the expected JSON body for finishing a suspicious login.
+type VerifyLoginCodeRequest struct {
+	SecondFactorToken string `json:"second_factor_token"`
+	Code              string `json:"code"`
+	DeviceID          string `json:"device_id,omitempty"`
+	UseCookies        bool   `json:"use_cookies"`
+}
+
+// VerifyLoginCode finishes a login held back for a second factor, and responds like Login.
+func (h *AuthHandler) VerifyLoginCode(w http.ResponseWriter, r *http.Request) {
+	var req VerifyLoginCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.SecondFactorToken == "" || req.Code == "" {
+		respondWithError(w, http.StatusBadRequest, "Second factor token and code are required")
+		return
+	}
+
+	client, err := h.clientInfo(w, r, req.DeviceID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating device ID", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+	token, err := h.authService.VerifyLoginCode(r.Context(), req.SecondFactorToken, req.Code, client)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidLoginCode) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired code")
+		} else {
+			slog.ErrorContext(r.Context(), "Error verifying login code", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to login")
+		}
+		return
+	}
+
+	h.respondWithSession(w, r, token, req.UseCookies)
+}
+
+// ListLoginHistory lists the authenticated user's recent logins.
+func (h *AuthHandler) ListLoginHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	events, err := h.authService.ListLoginHistory(r.Context(), userID, 100)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing login history", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to list login history")
+		return
+	}
+	if events == nil {
+		events = []domain.LoginEvent{}
+	}
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+// clientInfo describes the client for login history. Browsers are recognized by a
+// long-lived device cookie, set on their first login; other clients may send their
+// own device ID.
+func (h *AuthHandler) clientInfo(w http.ResponseWriter, r *http.Request, deviceID string) (auth.ClientInfo, error) {
+	if deviceID == "" {
+		if cookie, err := r.Cookie(deviceCookie); err == nil {
+			deviceID = cookie.Value
+		}
+	}
+	if deviceID == "" {
+		var err error
+		if deviceID, err = randomToken(32); err != nil {
+			return auth.ClientInfo{}, err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     deviceCookie,
+			Value:    deviceID,
+			Path:     "/",
+			Domain:   h.cfg.CookieDomain,
+			MaxAge:   int((2 * 365 * 24 * time.Hour).Seconds()),
+			HttpOnly: true,
+			Secure:   h.cfg.CookieSecure,
+			SameSite: sameSite(h.cfg),
+		})
+	}
+	return auth.ClientInfo{IP: clientIP(r), UserAgent: r.UserAgent(), DeviceID: deviceID}, nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"authservice/internal/auth"
+)
+
+// magicLinkNonceCookie binds a magic link to the browser that requested it.
+const magicLinkNonceCookie = "magic_link_nonce"
+
+// MagicLinkRequest defines the expected JSON body for requesting a magic link.
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// RequestMagicLink emails a sign-in link and stores the matching nonce in a cookie.
+// It answers 202 whether or not the email belongs to an account.
+func (h *AuthHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	nonce, err := randomToken(32)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating magic link nonce", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to send magic link")
+		return
+	}
+
+	if err := h.authService.RequestMagicLink(r.Context(), req.Email, nonce); err != nil {
+		if errors.Is(err, auth.ErrRateLimited) {
+			respondWithError(w, http.StatusTooManyRequests, "Too many magic link requests, try again later")
+		} else {
+			slog.ErrorContext(r.Context(), "Error requesting magic link", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to send magic link")
+		}
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkNonceCookie,
+		Value:    nonce,
+		Path:     "/login/magic-link",
+		MaxAge:   int(h.cfg.MagicLinkTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.cfg.CookieSecure,
+		SameSite: http.SameSiteLaxMode, // Lax so the cookie is sent when the link is opened from an email
+	})
+	respondWithJSON(w, http.StatusAccepted, map[string]string{"message": "If the email belongs to an account, a sign-in link has been sent"})
+}
+
+// VerifyMagicLink redeems the link from ?token= and responds like Login.
+// Add ?session=cookie to receive a cookie session instead of a bearer token.
+func (h *AuthHandler) VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	var nonce string
+	if cookie, err := r.Cookie(magicLinkNonceCookie); err == nil {
+		nonce = cookie.Value
+	}
+
+	accessToken, err := h.authService.VerifyMagicLink(r.Context(), token, nonce)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidMagicLink) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired magic link")
+		} else {
+			slog.ErrorContext(r.Context(), "Error verifying magic link", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to login")
+		}
+		return
+	}
+
+	// The nonce is single-use too
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkNonceCookie,
+		Path:     "/login/magic-link",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.cfg.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	h.respondWithSession(w, r, accessToken, r.URL.Query().Get("session") == "cookie")
+}
+
+// randomToken returns n random bytes encoded as unpadded URL-safe base64.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
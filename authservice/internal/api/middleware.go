@@ -2,10 +2,16 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"authservice/internal/auth"
+	"authservice/internal/config"
+	"authservice/internal/logging"
 )
 
 // contextKey is a custom type to avoid context key collisions.
@@ -13,33 +19,128 @@ type contextKey string
 
 const UserIDKey contextKey = "userID"
 
-// AuthMiddleware creates a middleware handler for JWT authentication.
-func AuthMiddleware(authService auth.AuthService) func(http.Handler) http.Handler {
+// ActorIDKey holds the staff member's user ID when the request uses an impersonation
+// token; UserIDKey then holds the impersonated customer.
+const ActorIDKey contextKey = "actorID"
+
+// RoleKey holds the user's platform role (user, support, admin).
+const RoleKey contextKey = "role"
+
+// ClaimsKey holds the verified token claims.
+const ClaimsKey contextKey = "claims"
+
+// cookieAuthKey marks requests authenticated by the session cookie.
+const cookieAuthKey contextKey = "cookieAuth"
+
+// OrgIDKey and OrgRoleKey hold the active organization from the token (absent if the user has none).
+const (
+	OrgIDKey   contextKey = "orgID"
+	OrgRoleKey contextKey = "orgRole"
+)
+
+// RealIP sets RemoteAddr to the client's address from X-Forwarded-For or X-Real-IP, but
+// only for requests from the configured trusted proxies. Anyone else could put any
+// address in those headers, so their requests keep the socket's address.
+func RealIP(cfg *config.Config) func(http.Handler) http.Handler {
+	var trusted []*net.IPNet
+	for _, cidr := range cfg.TrustedProxies {
+		if _, network, err := net.ParseCIDR(strings.TrimSpace(cidr)); err == nil {
+			trusted = append(trusted, network)
+		}
+	}
+	isTrusted := func(ip net.IP) bool {
+		for _, network := range trusted {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				respondWithError(w, http.StatusUnauthorized, "Authorization header required")
+			peer := net.ParseIP(clientIP(r))
+			if peer == nil || !isTrusted(peer) {
+				next.ServeHTTP(w, r)
 				return
 			}
+			// Each proxy appends the address it got the request from, so the client is
+			// the rightmost address that isn't one of our proxies
+			var forwarded []string
+			for _, header := range r.Header.Values("X-Forwarded-For") {
+				forwarded = append(forwarded, strings.Split(header, ",")...)
+			}
+			client := ""
+			for i := len(forwarded) - 1; i >= 0; i-- {
+				ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+				if ip == nil {
+					break
+				}
+				client = ip.String()
+				if !isTrusted(ip) {
+					break
+				}
+			}
+			if client == "" {
+				if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+					client = ip.String()
+				}
+			}
+			if client != "" {
+				r.RemoteAddr = client
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AuthMiddleware creates a middleware handler for JWT authentication.
+// The token is taken from the Authorization header or, when cookie sessions are
+// enabled, from the session cookie (CSRFProtect must then be in the chain).
+func AuthMiddleware(authService auth.AuthService, cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var tokenString string
+			fromCookie := false
 
-			headerParts := strings.Split(authHeader, " ")
-			if len(headerParts) != 2 || strings.ToLower(headerParts[0]) != "bearer" {
-				respondWithError(w, http.StatusUnauthorized, "Invalid authorization header format (must be Bearer token)")
+			authHeader := r.Header.Get("Authorization")
+			if authHeader != "" {
+				headerParts := strings.Split(authHeader, " ")
+				if len(headerParts) != 2 || strings.ToLower(headerParts[0]) != "bearer" {
+					respondWithError(w, http.StatusUnauthorized, "Invalid authorization header format (must be Bearer token)")
+					return
+				}
+				tokenString = headerParts[1]
+			} else if cookie, err := r.Cookie(cfg.SessionCookie); err == nil && cfg.CookieSessions {
+				tokenString = cookie.Value
+				fromCookie = true
+			} else {
+				respondWithError(w, http.StatusUnauthorized, "Authorization header required")
 				return
 			}
 
-			tokenString := headerParts[1]
 			claims, err := authService.VerifyToken(tokenString)
 			if err != nil {
-				// Log the specific error for debugging? Maybe not in production.
-				// log.Printf("Token verification error: %v", err)
+				slog.DebugContext(r.Context(), "Token verification failed", "error", err)
 				respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
 				return
 			}
 
-			// Add user ID to context
+			// Add user ID to context (and to every log line of this request)
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, RoleKey, claims.Role)
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
+			ctx = context.WithValue(ctx, cookieAuthKey, fromCookie)
+			logging.AddAttrs(ctx, slog.Int64("user_id", claims.UserID))
+			if claims.IsImpersonation() {
+				ctx = context.WithValue(ctx, ActorIDKey, claims.ImpersonatorID())
+				logging.AddAttrs(ctx, slog.Int64("actor_id", claims.ImpersonatorID()), slog.String("impersonation_session", claims.ID))
+			}
+			if claims.OrgID != 0 {
+				ctx = context.WithValue(ctx, OrgIDKey, claims.OrgID)
+				ctx = context.WithValue(ctx, OrgRoleKey, claims.OrgRole)
+				logging.AddAttrs(ctx, slog.Int64("org_id", claims.OrgID))
+			}
 			r = r.WithContext(ctx)
 
 			// Call the next handler in the chain
@@ -47,3 +148,78 @@ func AuthMiddleware(authService auth.AuthService) func(http.Handler) http.Handle
 		})
 	}
 }
+
+// RequireRole only lets through users whose platform role is one of roles.
+// Impersonation tokens are always rejected, whatever the impersonated user's role.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isImpersonating(r.Context()) {
+				respondWithError(w, http.StatusForbidden, "Not allowed while impersonating")
+				return
+			}
+			role, _ := r.Context().Value(RoleKey).(string)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		})
+	}
+}
+
+// ForbidImpersonation protects sensitive actions (password change, payments, ...)
+// that staff must never perform on a customer's behalf.
+func ForbidImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isImpersonating(r.Context()) {
+			actorID, _ := r.Context().Value(ActorIDKey).(int64)
+			slog.WarnContext(r.Context(), "Blocked sensitive action during impersonation", "actor_id", actorID, "path", r.URL.Path)
+			respondWithError(w, http.StatusForbidden, "Not allowed while impersonating")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRecentAuth only lets through users who logged in or re-authenticated (POST /reauth)
+// within maxAge. Other requests get a 401 with an RFC 9470 step-up challenge.
+func RequireRecentAuth(maxAge time.Duration) func(http.Handler) http.Handler {
+	challenge := fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age=%d`,
+		int(maxAge.Seconds()))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := claimsFromContext(r.Context())
+			if !ok || !claims.AuthenticatedWithin(maxAge) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				respondWithError(w, http.StatusUnauthorized, "Recent authentication required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func authenticatedByCookie(ctx context.Context) bool {
+	fromCookie, _ := ctx.Value(cookieAuthKey).(bool)
+	return fromCookie
+}
+
+func isImpersonating(ctx context.Context) bool {
+	_, ok := ctx.Value(ActorIDKey).(int64)
+	return ok
+}
+
+// claimsFromContext returns the token claims set by AuthMiddleware.
+func claimsFromContext(ctx context.Context) (*auth.Claims, bool) {
+	claims, ok := ctx.Value(ClaimsKey).(*auth.Claims)
+	return claims, ok
+}
+
+// userIDFromContext returns the authenticated user's ID set by AuthMiddleware.
+func userIDFromContext(ctx context.Context) (int64, bool) {
+	userID, ok := ctx.Value(UserIDKey).(int64)
+	return userID, ok
+}
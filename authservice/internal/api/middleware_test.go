@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"authservice/internal/config"
+)
+
+func TestRealIP(t *testing.T) {
+	cfg := &config.Config{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1/32"}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"no proxy headers", "203.0.113.5:4000", nil, "", "203.0.113.5"},
+		{"untrusted peer with X-Forwarded-For", "203.0.113.5:4000", []string{"198.51.100.7"}, "", "203.0.113.5"},
+		{"untrusted peer with X-Real-IP", "203.0.113.5:4000", nil, "198.51.100.7", "203.0.113.5"},
+		{"trusted peer", "10.1.2.3:4000", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"client-supplied addresses before the client", "10.1.2.3:4000", []string{"1.1.1.1, 198.51.100.7"}, "", "198.51.100.7"},
+		{"chain of trusted proxies", "10.1.2.3:4000", []string{"198.51.100.7, 192.168.1.1", "10.9.9.9"}, "", "198.51.100.7"},
+		{"only proxies forwarded", "10.1.2.3:4000", []string{"10.9.9.9"}, "", "10.9.9.9"},
+		{"trusted peer with X-Real-IP", "10.1.2.3:4000", nil, "198.51.100.7", "198.51.100.7"},
+		{"invalid X-Forwarded-For", "10.1.2.3:4000", []string{"not-an-ip"}, "", "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"authservice/internal/auth"
+)
+
+// Token is the OAuth 2.0 token endpoint. Only the token exchange grant (RFC 8693) is
+// supported: a service authenticates with its client credentials (HTTP Basic or
+// client_id/client_secret form fields) and swaps a user's token for one restricted
+// to another service.
+func (h *AuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, &auth.OAuthError{Code: auth.OAuthInvalidRequest, Description: "Invalid form body"})
+		return
+	}
+	form := r.PostForm
+
+	if form.Get("grant_type") != auth.GrantTypeTokenExchange {
+		respondWithOAuthError(w, http.StatusBadRequest, &auth.OAuthError{Code: auth.OAuthUnsupportedGrantType})
+		return
+	}
+	if len(form["audience"]) > 1 || len(form["resource"]) > 0 {
+		respondWithOAuthError(w, http.StatusBadRequest, &auth.OAuthError{Code: auth.OAuthInvalidTarget, Description: "Exactly one audience is supported"})
+		return
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1: the credentials are form-encoded before Basic encoding
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = form.Get("client_id"), form.Get("client_secret")
+	}
+
+	resp, err := h.authService.ExchangeToken(r.Context(), auth.TokenExchangeRequest{
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		SubjectToken:     form.Get("subject_token"),
+		SubjectTokenType: form.Get("subject_token_type"),
+		Audience:         form.Get("audience"),
+		Scope:            form.Get("scope"),
+	})
+	if err != nil {
+		var oauthErr *auth.OAuthError
+		if !errors.As(err, &oauthErr) {
+			slog.ErrorContext(r.Context(), "Error exchanging token", "error", err)
+			respondWithOAuthError(w, http.StatusInternalServerError, &auth.OAuthError{Code: "server_error"})
+			return
+		}
+		status := http.StatusBadRequest
+		if oauthErr.Code == auth.OAuthInvalidClient {
+			status = http.StatusUnauthorized
+			if basic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			}
+		}
+		respondWithOAuthError(w, status, oauthErr)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// JWKS publishes the public keys that verify access tokens, for other services.
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, h.authService.JWKS())
+}
+
+// respondWithOAuthError sends an RFC 6749 error response.
+func respondWithOAuthError(w http.ResponseWriter, code int, err *auth.OAuthError) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, err)
+}
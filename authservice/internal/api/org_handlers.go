@@ -0,0 +1,336 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"authservice/internal/auth"
+	"authservice/internal/domain"
+	"authservice/internal/org"
+	"authservice/internal/repository"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// OrgHandler handles HTTP requests for organizations.
+type OrgHandler struct {
+	orgService org.OrgService
+}
+
+// NewOrgHandler creates a new OrgHandler.
+func NewOrgHandler(orgService org.OrgService) *OrgHandler {
+	return &OrgHandler{orgService: orgService}
+}
+
+// CreateOrgRequest defines the expected JSON body for creating an organization.
+type CreateOrgRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// InviteRequest defines the expected JSON body for inviting a member.
+type InviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// AcceptInvitationRequest defines the expected JSON body for accepting an invitation.
+type AcceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+// CreateScimTokenRequest defines the expected JSON body for creating a SCIM token.
+type CreateScimTokenRequest struct {
+	Description string `json:"description"`
+}
+
+// CreateScimTokenResponse returns the new token. The plaintext is only shown once.
+type CreateScimTokenResponse struct {
+	*domain.ScimToken
+	Token string `json:"token"`
+}
+
+// CreateOrganization creates an organization owned by the current user.
+func (h *OrgHandler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	var req CreateOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Name == "" || req.Slug == "" {
+		respondWithError(w, http.StatusBadRequest, "Name and slug are required")
+		return
+	}
+
+	created, err := h.orgService.CreateOrganization(r.Context(), userID, req.Name, req.Slug)
+	if err != nil {
+		if errors.Is(err, repository.ErrSlugExists) {
+			respondWithError(w, http.StatusConflict, "Organization slug already exists")
+		} else {
+			slog.ErrorContext(r.Context(), "Error creating organization", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to create organization")
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+// ListOrganizations lists the current user's organizations and roles.
+func (h *OrgHandler) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	memberships, err := h.orgService.ListOrganizations(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing organizations", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to list organizations")
+		return
+	}
+	if memberships == nil {
+		memberships = []domain.Membership{}
+	}
+	respondWithJSON(w, http.StatusOK, memberships)
+}
+
+// ListMembers lists the members of an organization the current user belongs to.
+func (h *OrgHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	orgID, err := strconv.ParseInt(chi.URLParam(r, "orgID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+
+	members, err := h.orgService.ListMembers(r.Context(), orgID, userID)
+	if err != nil {
+		if errors.Is(err, org.ErrForbidden) {
+			respondWithError(w, http.StatusForbidden, "Not a member of this organization")
+		} else {
+			slog.ErrorContext(r.Context(), "Error listing members", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to list members")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// InviteMember emails an invitation to join the organization.
+func (h *OrgHandler) InviteMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	orgID, err := strconv.ParseInt(chi.URLParam(r, "orgID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+
+	var req InviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+	if req.Role == "" {
+		req.Role = domain.OrgRoleMember
+	}
+
+	inv, err := h.orgService.InviteMember(r.Context(), orgID, userID, req.Email, req.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, org.ErrInvalidRole):
+			respondWithError(w, http.StatusBadRequest, "Role must be owner, admin or member")
+		case errors.Is(err, org.ErrForbidden):
+			respondWithError(w, http.StatusForbidden, "Insufficient role to invite members")
+		default:
+			slog.ErrorContext(r.Context(), "Error inviting member", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to invite member")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, inv)
+}
+
+// AcceptInvitation adds the current user to the organization they were invited to.
+func (h *OrgHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	var req AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	membership, err := h.orgService.AcceptInvitation(r.Context(), userID, req.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrInvitationNotFound):
+			respondWithError(w, http.StatusNotFound, "Invitation not found, expired or already accepted")
+		case errors.Is(err, org.ErrInvitationEmailMismatch):
+			respondWithError(w, http.StatusForbidden, "Invitation was sent to a different email address")
+		default:
+			slog.ErrorContext(r.Context(), "Error accepting invitation", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to accept invitation")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, membership)
+}
+
+// CreateScimToken issues a bearer token for the organization's identity provider (owners only).
+func (h *OrgHandler) CreateScimToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	orgID, err := strconv.ParseInt(chi.URLParam(r, "orgID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+
+	var req CreateScimTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	created, token, err := h.orgService.CreateScimToken(r.Context(), orgID, userID, req.Description)
+	if err != nil {
+		if errors.Is(err, org.ErrForbidden) {
+			respondWithError(w, http.StatusForbidden, "Only owners can create SCIM tokens")
+		} else {
+			slog.ErrorContext(r.Context(), "Error creating SCIM token", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to create SCIM token")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, CreateScimTokenResponse{ScimToken: created, Token: token})
+}
+
+// ListScimTokens lists the organization's SCIM tokens (owners and admins).
+func (h *OrgHandler) ListScimTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	orgID, err := strconv.ParseInt(chi.URLParam(r, "orgID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+
+	tokens, err := h.orgService.ListScimTokens(r.Context(), orgID, userID)
+	if err != nil {
+		if errors.Is(err, org.ErrForbidden) {
+			respondWithError(w, http.StatusForbidden, "Insufficient role to manage SCIM tokens")
+		} else {
+			slog.ErrorContext(r.Context(), "Error listing SCIM tokens", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to list SCIM tokens")
+		}
+		return
+	}
+	if tokens == nil {
+		tokens = []domain.ScimToken{}
+	}
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// RevokeScimToken revokes one of the organization's SCIM tokens (owners and admins).
+func (h *OrgHandler) RevokeScimToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	orgID, err := strconv.ParseInt(chi.URLParam(r, "orgID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	if err := h.orgService.RevokeScimToken(r.Context(), orgID, userID, tokenID); err != nil {
+		switch {
+		case errors.Is(err, org.ErrForbidden):
+			respondWithError(w, http.StatusForbidden, "Insufficient role to manage SCIM tokens")
+		case errors.Is(err, repository.ErrScimTokenNotFound):
+			respondWithError(w, http.StatusNotFound, "SCIM token not found or already revoked")
+		default:
+			slog.ErrorContext(r.Context(), "Error revoking SCIM token", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to revoke SCIM token")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SwitchOrganization re-issues the current user's token for another organization.
+func (h *AuthHandler) SwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	orgID, err := strconv.ParseInt(chi.URLParam(r, "orgID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+		return
+	}
+
+	token, err := h.authService.SwitchOrganization(r.Context(), claims, orgID)
+	if err != nil {
+		if errors.Is(err, auth.ErrNotOrgMember) {
+			respondWithError(w, http.StatusForbidden, "Not a member of this organization")
+		} else {
+			slog.ErrorContext(r.Context(), "Error switching organization", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to switch organization")
+		}
+		return
+	}
+	// Keep the session in whatever form the caller is already using
+	h.respondWithSession(w, r, token, authenticatedByCookie(r.Context()))
+}
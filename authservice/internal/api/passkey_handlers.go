@@ -0,0 +1,186 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"authservice/internal/auth"
+	"authservice/internal/domain"
+	"authservice/internal/repository"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// BeginCeremonyResponse carries the WebAuthn options for the browser and the ceremony
+// ID to send back with the authenticator's response.
+type BeginCeremonyResponse struct {
+	CeremonyID string      `json:"ceremony_id"`
+	Options    interface{} `json:"options"` // Pass to navigator.credentials.create() or .get()
+}
+
+// BeginPasskeyRegistration starts registering a passkey for the current user.
+func (h *AuthHandler) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	creation, ceremonyID, err := h.authService.BeginPasskeyRegistration(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error beginning passkey registration", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to begin passkey registration")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, BeginCeremonyResponse{CeremonyID: ceremonyID, Options: creation})
+}
+
+// FinishPasskeyRegistration stores the passkey created by the authenticator. The body is the
+// PublicKeyCredential returned by navigator.credentials.create(); ?ceremony_id= is required
+// and ?name= optionally labels the passkey.
+func (h *AuthHandler) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	ceremonyID := r.URL.Query().Get("ceremony_id")
+	if ceremonyID == "" {
+		respondWithError(w, http.StatusBadRequest, "Ceremony ID is required")
+		return
+	}
+
+	response, err := protocol.ParseCredentialCreationResponseBody(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid credential payload")
+		return
+	}
+	defer r.Body.Close()
+
+	passkey, err := h.authService.FinishPasskeyRegistration(r.Context(), userID, ceremonyID, r.URL.Query().Get("name"), response)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidPasskey):
+			respondWithError(w, http.StatusBadRequest, "Passkey registration failed or expired")
+		case errors.Is(err, repository.ErrPasskeyExists):
+			respondWithError(w, http.StatusConflict, "Passkey already registered")
+		default:
+			slog.ErrorContext(r.Context(), "Error finishing passkey registration", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to register passkey")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, passkey)
+}
+
+// ListPasskeys lists the current user's passkeys.
+func (h *AuthHandler) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	passkeys, err := h.authService.ListPasskeys(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing passkeys", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to list passkeys")
+		return
+	}
+	if passkeys == nil {
+		passkeys = []domain.Passkey{}
+	}
+	respondWithJSON(w, http.StatusOK, passkeys)
+}
+
+// DeletePasskey removes one of the current user's passkeys.
+func (h *AuthHandler) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	passkeyID, err := strconv.ParseInt(chi.URLParam(r, "passkeyID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid passkey ID")
+		return
+	}
+
+	if err := h.authService.DeletePasskey(r.Context(), userID, passkeyID); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrPasskeyNotFound):
+			respondWithError(w, http.StatusNotFound, "Passkey not found")
+		case errors.Is(err, repository.ErrLastCredential):
+			respondWithError(w, http.StatusConflict, "Cannot remove the only passkey of an account without a password")
+		default:
+			slog.ErrorContext(r.Context(), "Error deleting passkey", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to delete passkey")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemovePassword removes the current user's password. The user must have a passkey.
+func (h *AuthHandler) RemovePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	if err := h.authService.RemovePassword(r.Context(), userID); err != nil {
+		if errors.Is(err, repository.ErrLastCredential) {
+			respondWithError(w, http.StatusConflict, "Register a passkey before removing the password")
+		} else {
+			slog.ErrorContext(r.Context(), "Error removing password", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to remove password")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BeginPasskeyLogin starts a passkey login. No email is needed; the authenticator
+// offers the passkeys it holds for this site.
+func (h *AuthHandler) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	assertion, ceremonyID, err := h.authService.BeginPasskeyLogin(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error beginning passkey login", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to begin passkey login")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, BeginCeremonyResponse{CeremonyID: ceremonyID, Options: assertion})
+}
+
+// FinishPasskeyLogin verifies the assertion from navigator.credentials.get() and responds
+// like Login. Add ?session=cookie to receive a cookie session instead of a bearer token.
+func (h *AuthHandler) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	ceremonyID := r.URL.Query().Get("ceremony_id")
+	if ceremonyID == "" {
+		respondWithError(w, http.StatusBadRequest, "Ceremony ID is required")
+		return
+	}
+
+	response, err := protocol.ParseCredentialRequestResponseBody(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid credential payload")
+		return
+	}
+	defer r.Body.Close()
+
+	token, err := h.authService.FinishPasskeyLogin(r.Context(), ceremonyID, response)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPasskey) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid passkey")
+		} else {
+			slog.ErrorContext(r.Context(), "Error finishing passkey login", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to login")
+		}
+		return
+	}
+	h.respondWithSession(w, r, token, r.URL.Query().Get("session") == "cookie")
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"authservice/internal/domain"
+	"authservice/internal/privacy"
+	"authservice/internal/repository"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PrivacyHandler handles data export and erasure requests.
+type PrivacyHandler struct {
+	privacyService privacy.PrivacyService
+}
+
+// NewPrivacyHandler creates a new PrivacyHandler.
+func NewPrivacyHandler(privacyService privacy.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{privacyService: privacyService}
+}
+
+// ExportData returns everything stored about the current user, across all services,
+// as a downloadable JSON file.
+func (h *PrivacyHandler) ExportData(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	export, err := h.privacyService.ExportUserData(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, privacy.ErrServiceUnavailable) {
+			slog.WarnContext(r.Context(), "Data export incomplete", "error", err)
+			respondWithError(w, http.StatusBadGateway, "Could not collect data from all services, try again later")
+		} else {
+			slog.ErrorContext(r.Context(), "Error exporting user data", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to export data")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, userID))
+	respondWithJSON(w, http.StatusOK, export)
+}
+
+// DeleteAccount erases the current user. Local data is removed immediately; the other
+// services are notified in the background and the returned request tracks their progress.
+func (h *PrivacyHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	erasure, err := h.privacyService.RequestErasure(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrErasureExists) {
+			respondWithError(w, http.StatusConflict, "Account has already been deleted")
+		} else {
+			slog.ErrorContext(r.Context(), "Error erasing user", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to delete account")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, erasure)
+}
+
+// ListErasures lists recent erasure requests (staff only).
+func (h *PrivacyHandler) ListErasures(w http.ResponseWriter, r *http.Request) {
+	erasures, err := h.privacyService.ListErasures(r.Context(), 100)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing erasure requests", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to list erasure requests")
+		return
+	}
+	if erasures == nil {
+		erasures = []domain.ErasureRequest{}
+	}
+	respondWithJSON(w, http.StatusOK, erasures)
+}
+
+// GetErasure shows an erasure request with the confirmation status of each service (staff only).
+func (h *PrivacyHandler) GetErasure(w http.ResponseWriter, r *http.Request) {
+	erasureID, err := strconv.ParseInt(chi.URLParam(r, "erasureID"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid erasure request ID")
+		return
+	}
+
+	erasure, err := h.privacyService.GetErasure(r.Context(), erasureID)
+	if err != nil {
+		if errors.Is(err, repository.ErrErasureNotFound) {
+			respondWithError(w, http.StatusNotFound, "Erasure request not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Error getting erasure request", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get erasure request")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, erasure)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"authservice/internal/auth"
+
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// ReauthRequest defines the expected JSON body for re-authenticating with a password.
+type ReauthRequest struct {
+	Password string `json:"password"`
+}
+
+// Reauthenticate upgrades the current session after the user re-enters their password.
+// The response is like Login's, with the session kept in the form the caller uses.
+func (h *AuthHandler) Reauthenticate(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	var req ReauthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required")
+		return
+	}
+
+	token, err := h.authService.Reauthenticate(r.Context(), claims, req.Password)
+	if err != nil {
+		h.respondReauthError(w, r, err)
+		return
+	}
+	h.respondWithSession(w, r, token, authenticatedByCookie(r.Context()))
+}
+
+// BeginPasskeyReauth starts re-authentication with one of the current user's passkeys.
+func (h *AuthHandler) BeginPasskeyReauth(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+
+	assertion, ceremonyID, err := h.authService.BeginPasskeyReauth(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPasskey) {
+			respondWithError(w, http.StatusBadRequest, "No passkey registered")
+		} else {
+			slog.ErrorContext(r.Context(), "Error beginning passkey re-authentication", "error", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to begin re-authentication")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, BeginCeremonyResponse{CeremonyID: ceremonyID, Options: assertion})
+}
+
+// FinishPasskeyReauth upgrades the current session with the assertion from
+// navigator.credentials.get(); ?ceremony_id= is required.
+func (h *AuthHandler) FinishPasskeyReauth(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not retrieve user information")
+		return
+	}
+	ceremonyID := r.URL.Query().Get("ceremony_id")
+	if ceremonyID == "" {
+		respondWithError(w, http.StatusBadRequest, "Ceremony ID is required")
+		return
+	}
+
+	response, err := protocol.ParseCredentialRequestResponseBody(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid credential payload")
+		return
+	}
+	defer r.Body.Close()
+
+	token, err := h.authService.FinishPasskeyReauth(r.Context(), claims, ceremonyID, response)
+	if err != nil {
+		h.respondReauthError(w, r, err)
+		return
+	}
+	h.respondWithSession(w, r, token, authenticatedByCookie(r.Context()))
+}
+
+// respondReauthError maps re-authentication errors to responses.
+func (h *AuthHandler) respondReauthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		respondWithError(w, http.StatusUnauthorized, "Password is incorrect")
+	case errors.Is(err, auth.ErrInvalidPasskey):
+		respondWithError(w, http.StatusUnauthorized, "Invalid passkey")
+	case errors.Is(err, auth.ErrReauthNotAllowed):
+		respondWithError(w, http.StatusForbidden, "Not allowed while impersonating")
+	default:
+		slog.ErrorContext(r.Context(), "Error re-authenticating", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to re-authenticate")
+	}
+}
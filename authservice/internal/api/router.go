@@ -1,26 +1,49 @@
 package api
 
 import (
+	"log/slog"
 	"net/http"
 
+	"authservice/internal/config"
+	"authservice/internal/domain"
+	"authservice/internal/logging"
+	"authservice/internal/telemetry"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
 // NewRouter creates a new chi router and sets up routes.
-func NewRouter(authHandler *AuthHandler) http.Handler {
+func NewRouter(authHandler *AuthHandler, orgHandler *OrgHandler, privacyHandler *PrivacyHandler, scimHandler *ScimHandler, cfg *config.Config, logLevel *slog.LevelVar) http.Handler {
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.Logger)    // Log requests
-	r.Use(middleware.Recoverer) // Recover from panics
+	// Trace requests (first, so the span covers everything below)
+	r.Use(telemetry.Middleware(cfg.ServiceName))
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.StripSlashes) // Strip trailing slashes
+	r.Use(RealIP(cfg))                   // Client addresses from trusted proxies only
+	r.Use(RequestLogger(slog.Default())) // Log requests
+	r.Use(middleware.Recoverer)          // Recover from panics
+	r.Use(middleware.StripSlashes)       // Strip trailing slashes
+	r.Use(CORS(cfg))
+	r.Use(CSRFProtect(cfg)) // Only affects requests carrying the session cookie
 
 	// Public routes
 	r.Post("/register", authHandler.Register)
 	r.Post("/login", authHandler.Login)
+	r.Post("/logout", authHandler.Logout)
+	r.Post("/login/verify", authHandler.VerifyLoginCode) // Second factor for suspicious logins
+	r.Post("/login/magic-link", authHandler.RequestMagicLink)
+	r.Get("/login/magic-link/verify", authHandler.VerifyMagicLink)
+	r.Post("/login/passkey/begin", authHandler.BeginPasskeyLogin)
+	r.Post("/login/passkey/finish", authHandler.FinishPasskeyLogin)
+	if cfg.PoWEnabled {
+		r.Get("/challenge", authHandler.Challenge) // Proof-of-work puzzles for /register and /login
+	}
+
+	// OAuth 2.0 token exchange for services acting on behalf of users (client credentials)
+	r.Post("/oauth/token", authHandler.Token)
+	r.Get("/.well-known/jwks.json", authHandler.JWKS) // Keys for verifying access tokens
 
 	// Example of a protected route (requires VerifyToken implementation in middleware)
 	// r.Group(func(r chi.Router) {
@@ -33,13 +56,83 @@ func NewRouter(authHandler *AuthHandler) http.Handler {
 		w.Write([]byte("Auth service is healthy!"))
 	})
 
+	// Runtime log level, only exposed when an operator token is configured
+	if cfg.LogAdminToken != "" {
+		r.With(requireStaticToken(cfg.LogAdminToken)).Get("/debug/log-level", logging.LevelHandler(logLevel))
+		r.With(requireStaticToken(cfg.LogAdminToken)).Put("/debug/log-level", logging.LevelHandler(logLevel))
+	}
+
+	// SCIM 2.0 provisioning for organizations' identity providers (per-organization tokens)
+	r.Route("/scim/v2", func(r chi.Router) {
+		r.Use(scimHandler.Authenticate)
+		r.Get("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+		r.Get("/Users", scimHandler.ListUsers)
+		r.Post("/Users", scimHandler.CreateUser)
+		r.Get("/Users/{id}", scimHandler.GetUser)
+		r.Put("/Users/{id}", scimHandler.ReplaceUser)
+		r.Patch("/Users/{id}", scimHandler.PatchUser)
+		r.Delete("/Users/{id}", scimHandler.DeleteUser)
+		r.Get("/Groups", scimHandler.ListGroups)
+		r.Post("/Groups", scimHandler.FixedGroups)
+		r.Get("/Groups/{id}", scimHandler.GetGroup)
+		r.Put("/Groups/{id}", scimHandler.ReplaceGroup)
+		r.Patch("/Groups/{id}", scimHandler.PatchGroup)
+		r.Delete("/Groups/{id}", scimHandler.FixedGroups)
+	})
+
 	// Protected routes (require valid JWT)
 	r.Group(func(r chi.Router) {
 		// Apply the AuthMiddleware using the authService from the handler
-		r.Use(AuthMiddleware(authHandler.authService))
+		r.Use(AuthMiddleware(authHandler.authService, cfg))
+
+		// Sensitive actions also need a recent login or re-authentication
+		recentAuth := RequireRecentAuth(cfg.RecentAuthMaxAge)
 
 		// Define protected endpoints here
 		r.Get("/me", authHandler.GetUserProfile)
+		r.With(ForbidImpersonation, recentAuth).Put("/me/password", authHandler.ChangePassword)
+		r.With(ForbidImpersonation, recentAuth).Delete("/me/password", authHandler.RemovePassword)
+
+		// Step-up authentication
+		r.Post("/reauth", authHandler.Reauthenticate)
+		r.Post("/reauth/passkey/begin", authHandler.BeginPasskeyReauth)
+		r.Post("/reauth/passkey/finish", authHandler.FinishPasskeyReauth)
+
+		// Data export and account deletion (GDPR)
+		r.With(ForbidImpersonation, recentAuth).Get("/me/export", privacyHandler.ExportData)
+		r.With(ForbidImpersonation, recentAuth).Delete("/me", privacyHandler.DeleteAccount)
+
+		// Login history (devices and places the account was signed in from)
+		r.Get("/me/logins", authHandler.ListLoginHistory)
+
+		// Passkeys
+		r.Get("/me/passkeys", authHandler.ListPasskeys)
+		r.With(ForbidImpersonation, recentAuth).Post("/me/passkeys/register/begin", authHandler.BeginPasskeyRegistration)
+		r.With(ForbidImpersonation, recentAuth).Post("/me/passkeys/register/finish", authHandler.FinishPasskeyRegistration)
+		r.With(ForbidImpersonation, recentAuth).Delete("/me/passkeys/{passkeyID}", authHandler.DeletePasskey)
+
+		// Organizations (tenants)
+		r.Post("/orgs", orgHandler.CreateOrganization)
+		r.Get("/orgs", orgHandler.ListOrganizations)
+		r.Get("/orgs/{orgID}/members", orgHandler.ListMembers)
+		r.Post("/orgs/{orgID}/invitations", orgHandler.InviteMember)
+		r.Post("/orgs/{orgID}/switch", authHandler.SwitchOrganization)
+		r.With(ForbidImpersonation, recentAuth).Post("/orgs/{orgID}/scim-tokens", orgHandler.CreateScimToken)
+		r.Get("/orgs/{orgID}/scim-tokens", orgHandler.ListScimTokens)
+		r.With(ForbidImpersonation).Delete("/orgs/{orgID}/scim-tokens/{tokenID}", orgHandler.RevokeScimToken)
+		r.Post("/invitations/accept", orgHandler.AcceptInvitation)
+
+		// Staff-only routes
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(RequireRole(domain.RoleAdmin, domain.RoleSupport))
+			r.Post("/impersonate/{userID}", authHandler.Impersonate)
+			r.Get("/impersonations", authHandler.ListImpersonations)
+			r.Get("/erasures", privacyHandler.ListErasures)
+			r.Get("/erasures/{erasureID}", privacyHandler.GetErasure)
+			r.Post("/registration-invites", authHandler.CreateRegistrationInvite)
+			r.Get("/registration-invites", authHandler.ListRegistrationInvites)
+			r.Delete("/registration-invites/{inviteID}", authHandler.DeleteRegistrationInvite)
+		})
 		// Add other protected routes like /change-password, /update-profile etc.
 	})
 
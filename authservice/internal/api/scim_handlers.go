@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"authservice/internal/org"
+	"authservice/internal/repository"
+	"authservice/internal/scim"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// scimOrgIDKey holds the organization whose SCIM token authenticated the request.
+const scimOrgIDKey contextKey = "scimOrgID"
+
+// defaultScimPageSize is used when a list request has no count.
+const defaultScimPageSize = 100
+
+// ScimHandler serves the SCIM 2.0 API used by organizations' identity providers.
+type ScimHandler struct {
+	scimService scim.ScimService
+	orgService  org.OrgService
+}
+
+// NewScimHandler creates a new ScimHandler.
+func NewScimHandler(scimService scim.ScimService, orgService org.OrgService) *ScimHandler {
+	return &ScimHandler{scimService: scimService, orgService: orgService}
+}
+
+// Authenticate is middleware that requires one of an organization's SCIM tokens and
+// scopes the request to that organization.
+func (h *ScimHandler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			respondWithScim(w, http.StatusUnauthorized, scim.NewError(http.StatusUnauthorized, "", "Bearer token required"))
+			return
+		}
+
+		t, err := h.orgService.AuthenticateScimToken(r.Context(), token)
+		if err != nil {
+			if errors.Is(err, repository.ErrScimTokenNotFound) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="scim", error="invalid_token"`)
+				respondWithScim(w, http.StatusUnauthorized, scim.NewError(http.StatusUnauthorized, "", "Invalid or revoked token"))
+			} else {
+				h.respondWithScimError(w, r, err)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), scimOrgIDKey, t.OrgID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ServiceProviderConfig describes the supported SCIM features.
+func (h *ScimHandler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	supported := func(ok bool) map[string]bool { return map[string]bool{"supported": ok} }
+	respondWithScim(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scim.MaxPageSize},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "A SCIM token created by an organization owner",
+		}},
+	})
+}
+
+// ListUsers lists the organization's members, optionally filtered.
+func (h *ScimHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	startIndex, count := scimPaging(r)
+	list, err := h.scimService.ListUsers(r.Context(), scimOrgID(r), r.URL.Query().Get("filter"), startIndex, count)
+	if err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	respondWithScim(w, http.StatusOK, list)
+}
+
+// GetUser returns one member.
+func (h *ScimHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.scimService.GetUser(r.Context(), scimOrgID(r), chi.URLParam(r, "id"))
+	if err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	respondWithScim(w, http.StatusOK, user)
+}
+
+// CreateUser provisions a member.
+func (h *ScimHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var in scim.User
+	if !decodeScim(w, r, &in) {
+		return
+	}
+	user, err := h.scimService.CreateUser(r.Context(), scimOrgID(r), &in)
+	if err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	respondWithScim(w, http.StatusCreated, user)
+}
+
+// ReplaceUser replaces a member's attributes.
+func (h *ScimHandler) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	var in scim.User
+	if !decodeScim(w, r, &in) {
+		return
+	}
+	user, err := h.scimService.ReplaceUser(r.Context(), scimOrgID(r), chi.URLParam(r, "id"), &in)
+	if err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	respondWithScim(w, http.StatusOK, user)
+}
+
+// PatchUser updates some of a member's attributes, typically to deactivate them.
+func (h *ScimHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	var patch scim.PatchRequest
+	if !decodeScim(w, r, &patch) {
+		return
+	}
+	user, err := h.scimService.PatchUser(r.Context(), scimOrgID(r), chi.URLParam(r, "id"), &patch)
+	if err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	respondWithScim(w, http.StatusOK, user)
+}
+
+// DeleteUser removes a member from the organization.
+func (h *ScimHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := h.scimService.DeleteUser(r.Context(), scimOrgID(r), chi.URLParam(r, "id")); err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListGroups lists the organization's role groups, optionally filtered.
+func (h *ScimHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	startIndex, count := scimPaging(r)
+	list, err := h.scimService.ListGroups(r.Context(), scimOrgID(r), r.URL.Query().Get("filter"), startIndex, count)
+	if err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	respondWithScim(w, http.StatusOK, list)
+}
+
+// GetGroup returns one role group.
+func (h *ScimHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := h.scimService.GetGroup(r.Context(), scimOrgID(r), chi.URLParam(r, "id"))
+	if err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	respondWithScim(w, http.StatusOK, group)
+}
+
+// ReplaceGroup sets a role group's members.
+func (h *ScimHandler) ReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	var in scim.Group
+	if !decodeScim(w, r, &in) {
+		return
+	}
+	group, err := h.scimService.ReplaceGroup(r.Context(), scimOrgID(r), chi.URLParam(r, "id"), &in)
+	if err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	respondWithScim(w, http.StatusOK, group)
+}
+
+// PatchGroup adds or removes members of a role group.
+func (h *ScimHandler) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	var patch scim.PatchRequest
+	if !decodeScim(w, r, &patch) {
+		return
+	}
+	group, err := h.scimService.PatchGroup(r.Context(), scimOrgID(r), chi.URLParam(r, "id"), &patch)
+	if err != nil {
+		h.respondWithScimError(w, r, err)
+		return
+	}
+	respondWithScim(w, http.StatusOK, group)
+}
+
+// FixedGroups rejects creating and deleting groups, which are the fixed organization roles.
+func (h *ScimHandler) FixedGroups(w http.ResponseWriter, r *http.Request) {
+	respondWithScim(w, http.StatusForbidden, scim.NewError(http.StatusForbidden, scim.ErrTypeMutability,
+		"Groups are the organization roles owner, admin and member and can't be created or deleted"))
+}
+
+// respondWithScimError sends a SCIM error, logging unexpected ones.
+func (h *ScimHandler) respondWithScimError(w http.ResponseWriter, r *http.Request, err error) {
+	var scimErr *scim.Error
+	if errors.As(err, &scimErr) {
+		respondWithScim(w, scimErr.Status, scimErr)
+		return
+	}
+	slog.ErrorContext(r.Context(), "Error handling SCIM request", "error", err)
+	respondWithScim(w, http.StatusInternalServerError, scim.NewError(http.StatusInternalServerError, "", "Internal server error"))
+}
+
+// respondWithScim sends a SCIM JSON response.
+func respondWithScim(w http.ResponseWriter, code int, payload interface{}) {
+	response, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Error marshalling SCIM response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(code)
+	w.Write(response)
+}
+
+// decodeScim decodes the request body into v, responding with an error if it is invalid.
+func decodeScim(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		respondWithScim(w, http.StatusBadRequest, scim.NewError(http.StatusBadRequest, scim.ErrTypeInvalidSyntax, "Invalid request payload"))
+		return false
+	}
+	return true
+}
+
+// scimPaging reads the 1-based startIndex and count query parameters.
+func scimPaging(r *http.Request) (startIndex, count int) {
+	startIndex, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil {
+		startIndex = 1
+	}
+	count, err = strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil {
+		count = defaultScimPageSize
+	}
+	return startIndex, count
+}
+
+// scimOrgID returns the organization set by Authenticate.
+func scimOrgID(r *http.Request) int64 {
+	orgID, _ := r.Context().Value(scimOrgIDKey).(int64)
+	return orgID
+}
@@ -0,0 +1,617 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"authservice/internal/config"
+	"authservice/internal/domain"
+	"authservice/internal/org"
+	"authservice/internal/repository"
+	"authservice/internal/scim"
+)
+
+// scimDirectory keeps users and memberships in memory for the SCIM service.
+type scimDirectory struct {
+	mu      sync.Mutex
+	users   map[int64]*domain.User
+	members map[int64]map[int64]*domain.OrgMember // org ID -> user ID -> membership
+	nextID  int64
+}
+
+func newScimDirectory() *scimDirectory {
+	return &scimDirectory{users: make(map[int64]*domain.User), members: make(map[int64]map[int64]*domain.OrgMember), nextID: 1}
+}
+
+// add creates a user who belongs to orgID with role.
+func (d *scimDirectory) add(orgID int64, email, role string) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := d.nextID
+	d.nextID++
+	d.users[id] = &domain.User{ID: id, Email: email, Role: domain.RoleUser}
+	if d.members[orgID] == nil {
+		d.members[orgID] = make(map[int64]*domain.OrgMember)
+	}
+	now := time.Now()
+	d.members[orgID][id] = &domain.OrgMember{User: *d.users[id], OrgRole: role, Active: true, JoinedAt: now, ModifiedAt: now}
+	return id
+}
+
+type scimUsers struct {
+	repository.UserRepository
+	*scimDirectory
+}
+
+func (r scimUsers) CreateUser(_ context.Context, user *domain.User) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if u.Email == user.Email {
+			return 0, repository.ErrEmailExists
+		}
+	}
+	user.ID = r.nextID
+	r.nextID++
+	copied := *user
+	r.users[user.ID] = &copied
+	return user.ID, nil
+}
+
+func (r scimUsers) GetUserByEmail(_ context.Context, email string) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if u.Email == email {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r scimUsers) ListUsersByOrg(_ context.Context, orgID int64) ([]domain.OrgMember, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	members := []domain.OrgMember{}
+	for _, m := range r.members[orgID] {
+		members = append(members, *m)
+	}
+	slices.SortFunc(members, func(a, b domain.OrgMember) int { return int(a.ID - b.ID) })
+	return members, nil
+}
+
+func (r scimUsers) GetUserByIDInOrg(_ context.Context, orgID, userID int64) (*domain.OrgMember, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.members[orgID][userID]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	copied := *m
+	return &copied, nil
+}
+
+type scimOrgs struct {
+	repository.OrgRepository
+	*scimDirectory
+}
+
+// externalIDTaken reports whether another member of orgID has externalID.
+func (r scimOrgs) externalIDTaken(orgID, userID int64, externalID string) bool {
+	for id, m := range r.members[orgID] {
+		if id != userID && externalID != "" && m.ExternalID == externalID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r scimOrgs) AddMember(_ context.Context, member *domain.OrgMember, orgID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.members[orgID] == nil {
+		r.members[orgID] = make(map[int64]*domain.OrgMember)
+	}
+	if _, ok := r.members[orgID][member.ID]; ok || r.externalIDTaken(orgID, member.ID, member.ExternalID) {
+		return repository.ErrMembershipExists
+	}
+	member.JoinedAt = time.Now()
+	member.ModifiedAt = member.JoinedAt
+	copied := *member
+	r.members[orgID][member.ID] = &copied
+	return nil
+}
+
+func (r scimOrgs) UpdateMember(_ context.Context, member *domain.OrgMember, orgID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.members[orgID][member.ID]
+	if !ok {
+		return repository.ErrMembershipNotFound
+	}
+	if r.externalIDTaken(orgID, member.ID, member.ExternalID) {
+		return repository.ErrMembershipExists
+	}
+	member.ModifiedAt = time.Now()
+	m.OrgRole, m.Active, m.ExternalID, m.ModifiedAt = member.OrgRole, member.Active, member.ExternalID, member.ModifiedAt
+	return nil
+}
+
+func (r scimOrgs) RemoveMember(_ context.Context, orgID, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.members[orgID][userID]; !ok {
+		return repository.ErrMembershipNotFound
+	}
+	delete(r.members[orgID], userID)
+	return nil
+}
+
+// scimTokens authenticates "org-<id>" as a SCIM token of organization <id>.
+type scimTokens struct {
+	org.OrgService
+}
+
+func (scimTokens) AuthenticateScimToken(_ context.Context, token string) (*domain.ScimToken, error) {
+	var orgID int64
+	if _, err := fmt.Sscanf(token, "org-%d", &orgID); err != nil {
+		return nil, repository.ErrScimTokenNotFound
+	}
+	return &domain.ScimToken{ID: orgID, OrgID: orgID}, nil
+}
+
+// scimClient sends SCIM requests to the router as one organization's identity provider.
+type scimClient struct {
+	t       *testing.T
+	handler http.Handler
+	token   string
+}
+
+// newScimTest returns a client for organization 1, whose owner is owner@acme.test,
+// and the directory. Organization 2 has a member of its own.
+func newScimTest(t *testing.T) (*scimClient, *scimDirectory) {
+	dir := newScimDirectory()
+	dir.add(1, "owner@acme.test", domain.OrgRoleOwner)
+	dir.add(2, "other@globex.test", domain.OrgRoleMember)
+
+	scimHandler := NewScimHandler(scim.NewScimService(scimUsers{scimDirectory: dir}, scimOrgs{scimDirectory: dir}), scimTokens{})
+	router := NewRouter(&AuthHandler{}, nil, nil, scimHandler, &config.Config{ServiceName: "authservice"}, nil)
+	return &scimClient{t: t, handler: router, token: "org-1"}, dir
+}
+
+// do sends a request and decodes the JSON response into out, if not nil. It checks
+// that every response with a body is SCIM JSON.
+func (c *scimClient) do(method, path string, body interface{}, out interface{}) int {
+	c.t.Helper()
+	var reader *strings.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = strings.NewReader("")
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = strings.NewReader(string(data))
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", scim.ContentType)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+
+	if rec.Body.Len() > 0 {
+		if ct := rec.Header().Get("Content-Type"); ct != scim.ContentType {
+			c.t.Errorf("%s %s: Content-Type %q, want %q", method, path, ct, scim.ContentType)
+		}
+		if out != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+				c.t.Fatalf("%s %s: decode %s: %v", method, path, rec.Body, err)
+			}
+		}
+	}
+	return rec.Code
+}
+
+// expectError sends a request that must fail with a SCIM error of status and scimType.
+func (c *scimClient) expectError(method, path string, body interface{}, status int, scimType string) {
+	c.t.Helper()
+	var raw map[string]interface{}
+	if code := c.do(method, path, body, &raw); code != status {
+		c.t.Fatalf("%s %s = %d, want %d", method, path, code, status)
+	}
+	// RFC 7644 §3.12: the error schema, status as a string, and an optional scimType
+	if schemas, _ := raw["schemas"].([]interface{}); len(schemas) != 1 || schemas[0] != scim.SchemaError {
+		c.t.Errorf("%s %s: schemas %v, want [%s]", method, path, raw["schemas"], scim.SchemaError)
+	}
+	if raw["status"] != fmt.Sprint(status) {
+		c.t.Errorf("%s %s: status %#v, want %q", method, path, raw["status"], fmt.Sprint(status))
+	}
+	if got, _ := raw["scimType"].(string); got != scimType {
+		c.t.Errorf("%s %s: scimType %q, want %q", method, path, got, scimType)
+	}
+	if detail, _ := raw["detail"].(string); detail == "" {
+		c.t.Errorf("%s %s: no detail", method, path)
+	}
+}
+
+func (c *scimClient) createUser(userName, externalID string) *scim.User {
+	c.t.Helper()
+	var user scim.User
+	body := map[string]interface{}{"schemas": []string{scim.SchemaUser}, "userName": userName, "externalId": externalID}
+	if code := c.do("POST", "/scim/v2/Users", body, &user); code != http.StatusCreated {
+		c.t.Fatalf("create %s = %d", userName, code)
+	}
+	return &user
+}
+
+// listUsers returns the users matching filter.
+func (c *scimClient) listUsers(filter string) []scim.User {
+	c.t.Helper()
+	var list struct {
+		scim.ListResponse
+		Resources []scim.User `json:"Resources"`
+	}
+	if code := c.do("GET", "/scim/v2/Users?filter="+url.QueryEscape(filter), nil, &list); code != http.StatusOK {
+		c.t.Fatalf("filter %q = %d", filter, code)
+	}
+	if list.TotalResults != len(list.Resources) {
+		c.t.Errorf("filter %q: totalResults %d for %d resources", filter, list.TotalResults, len(list.Resources))
+	}
+	return list.Resources
+}
+
+func userNames(users []scim.User) []string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.UserName
+	}
+	slices.Sort(names)
+	return names
+}
+
+func patchOp(ops ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"schemas": []string{scim.SchemaPatchOp}, "Operations": ops}
+}
+
+func TestScimAuthentication(t *testing.T) {
+	c, _ := newScimTest(t)
+
+	c.token = ""
+	c.expectError("GET", "/scim/v2/Users", nil, http.StatusUnauthorized, "")
+	c.token = "not-a-scim-token"
+	c.expectError("GET", "/scim/v2/Users", nil, http.StatusUnauthorized, "")
+
+	c.token = "org-1"
+	var config map[string]interface{}
+	if code := c.do("GET", "/scim/v2/ServiceProviderConfig", nil, &config); code != http.StatusOK {
+		t.Fatalf("ServiceProviderConfig = %d", code)
+	}
+	if patch, _ := config["patch"].(map[string]interface{}); patch["supported"] != true {
+		t.Errorf("patch support %v, want true", config["patch"])
+	}
+}
+
+func TestScimUserCRUD(t *testing.T) {
+	c, _ := newScimTest(t)
+
+	created := c.createUser("Alice@Acme.test", "okta-1")
+	if created.ID == "" || created.UserName != "alice@acme.test" || created.ExternalID != "okta-1" {
+		t.Fatalf("created %+v", created)
+	}
+	if !slices.Equal(created.Schemas, []string{scim.SchemaUser}) || created.Active == nil || !*created.Active {
+		t.Errorf("created schemas %v, active %v", created.Schemas, created.Active)
+	}
+	if created.Meta == nil || created.Meta.ResourceType != "User" || created.Meta.Created.IsZero() {
+		t.Errorf("created meta %+v", created.Meta)
+	}
+	if len(created.Groups) != 1 || created.Groups[0].Value != domain.OrgRoleMember {
+		t.Errorf("created groups %+v, want member", created.Groups)
+	}
+
+	var got scim.User
+	if code := c.do("GET", "/scim/v2/Users/"+created.ID, nil, &got); code != http.StatusOK || got.UserName != created.UserName {
+		t.Fatalf("get = %d %+v", code, got)
+	}
+
+	// PUT replaces the mutable attributes; absent active means true
+	var replaced scim.User
+	body := map[string]interface{}{"schemas": []string{scim.SchemaUser}, "userName": "alice@acme.test", "externalId": "okta-2", "active": false}
+	if code := c.do("PUT", "/scim/v2/Users/"+created.ID, body, &replaced); code != http.StatusOK {
+		t.Fatalf("replace = %d", code)
+	}
+	if replaced.ExternalID != "okta-2" || *replaced.Active {
+		t.Errorf("replaced %+v", replaced)
+	}
+
+	if code := c.do("DELETE", "/scim/v2/Users/"+created.ID, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("delete = %d", code)
+	}
+	c.expectError("GET", "/scim/v2/Users/"+created.ID, nil, http.StatusNotFound, "")
+	c.expectError("DELETE", "/scim/v2/Users/"+created.ID, nil, http.StatusNotFound, "")
+
+	// Deleting only ended the membership; provisioning again reuses the account
+	if again := c.createUser("alice@acme.test", ""); again.ID != created.ID {
+		t.Errorf("re-provisioned as %s, want the same account %s", again.ID, created.ID)
+	}
+}
+
+func TestScimUserErrors(t *testing.T) {
+	c, _ := newScimTest(t)
+	alice := c.createUser("alice@acme.test", "okta-1")
+
+	c.expectError("POST", "/scim/v2/Users", map[string]interface{}{"userName": "alice@acme.test"}, http.StatusConflict, scim.ErrTypeUniqueness)
+	c.expectError("POST", "/scim/v2/Users", map[string]interface{}{"userName": "bob@acme.test", "externalId": "okta-1"}, http.StatusConflict, scim.ErrTypeUniqueness)
+	c.expectError("POST", "/scim/v2/Users", map[string]interface{}{"userName": "not-an-email"}, http.StatusBadRequest, scim.ErrTypeInvalidValue)
+	c.expectError("POST", "/scim/v2/Users", `{"userName": `, http.StatusBadRequest, scim.ErrTypeInvalidSyntax)
+	c.expectError("PUT", "/scim/v2/Users/"+alice.ID, map[string]interface{}{"userName": "eve@acme.test"}, http.StatusBadRequest, scim.ErrTypeMutability)
+	c.expectError("GET", "/scim/v2/Users/not-a-number", nil, http.StatusNotFound, "")
+	c.expectError("GET", "/scim/v2/Users/999", nil, http.StatusNotFound, "")
+
+	// The last active owner can't be removed or deactivated
+	owner := c.listUsers(`userName eq "owner@acme.test"`)[0]
+	c.expectError("DELETE", "/scim/v2/Users/"+owner.ID, nil, http.StatusConflict, "")
+	c.expectError("PATCH", "/scim/v2/Users/"+owner.ID, patchOp(map[string]interface{}{"op": "replace", "path": "active", "value": false}), http.StatusConflict, "")
+}
+
+func TestScimTenantIsolation(t *testing.T) {
+	c, dir := newScimTest(t)
+	alice := c.createUser("alice@acme.test", "")
+
+	other := &scimClient{t: t, handler: c.handler, token: "org-2"}
+	other.expectError("GET", "/scim/v2/Users/"+alice.ID, nil, http.StatusNotFound, "")
+	other.expectError("DELETE", "/scim/v2/Users/"+alice.ID, nil, http.StatusNotFound, "")
+	if names := userNames(other.listUsers("")); !slices.Equal(names, []string{"other@globex.test"}) {
+		t.Errorf("organization 2 sees %v", names)
+	}
+	if _, ok := dir.members[1][1]; !ok {
+		t.Error("organization 2 changed organization 1's members")
+	}
+}
+
+func TestScimUserFilter(t *testing.T) {
+	c, _ := newScimTest(t)
+	c.createUser("alice@acme.test", "okta-1")
+	bob := c.createUser("bob@acme.test", "okta-2")
+	c.createUser("carol@example.org", "")
+	c.do("PATCH", "/scim/v2/Users/"+bob.ID, patchOp(map[string]interface{}{"op": "replace", "path": "active", "value": false}), nil)
+
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{``, []string{"alice@acme.test", "bob@acme.test", "carol@example.org", "owner@acme.test"}},
+		{`userName eq "ALICE@acme.test"`, []string{"alice@acme.test"}},
+		{`UserName Eq "bob@acme.test"`, []string{"bob@acme.test"}},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bob@acme.test"`, []string{"bob@acme.test"}},
+		{`externalId eq "okta-1"`, []string{"alice@acme.test"}},
+		{`externalId eq "OKTA-1"`, nil}, // externalId is case-exact
+		{`externalId pr`, []string{"alice@acme.test", "bob@acme.test"}},
+		{`not (externalId pr)`, []string{"carol@example.org", "owner@acme.test"}},
+		{`active eq false`, []string{"bob@acme.test"}},
+		{`userName ew "@acme.test" and active eq true`, []string{"alice@acme.test", "owner@acme.test"}},
+		{`userName sw "alice" or userName co "example"`, []string{"alice@acme.test", "carol@example.org"}},
+		{`emails.value eq "carol@example.org"`, []string{"carol@example.org"}},
+		{`groups.value eq "owner"`, []string{"owner@acme.test"}},
+		{`userName eq "nobody@acme.test"`, nil},
+	}
+	for _, tt := range tests {
+		if got := userNames(c.listUsers(tt.filter)); !slices.Equal(got, tt.want) {
+			t.Errorf("filter %q = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+
+	for _, filter := range []string{`userName eq`, `userName xx "a"`, `(userName eq "a"`, `userName eq "a" and`, `emails[type eq "work"]`} {
+		c.expectError("GET", "/scim/v2/Users?filter="+url.QueryEscape(filter), nil, http.StatusBadRequest, scim.ErrTypeInvalidFilter)
+	}
+}
+
+func TestScimUserPagination(t *testing.T) {
+	c, _ := newScimTest(t)
+	for i := 0; i < 4; i++ {
+		c.createUser(fmt.Sprintf("user%d@acme.test", i), "")
+	}
+
+	var list struct {
+		scim.ListResponse
+		Resources []scim.User `json:"Resources"`
+	}
+	if code := c.do("GET", "/scim/v2/Users?startIndex=2&count=2", nil, &list); code != http.StatusOK {
+		t.Fatalf("list = %d", code)
+	}
+	if list.TotalResults != 5 || list.StartIndex != 2 || list.ItemsPerPage != 2 || len(list.Resources) != 2 {
+		t.Errorf("page: total %d, start %d, items %d, resources %d", list.TotalResults, list.StartIndex, list.ItemsPerPage, len(list.Resources))
+	}
+	if !slices.Equal(list.Schemas, []string{scim.SchemaListResponse}) {
+		t.Errorf("schemas %v", list.Schemas)
+	}
+	if list.Resources[0].UserName != "user0@acme.test" {
+		t.Errorf("page starts at %s, want user0@acme.test", list.Resources[0].UserName)
+	}
+
+	if code := c.do("GET", "/scim/v2/Users?startIndex=10", nil, &list); code != http.StatusOK || len(list.Resources) != 0 || list.TotalResults != 5 {
+		t.Errorf("past the end = %d, %d resources of %d", code, len(list.Resources), list.TotalResults)
+	}
+}
+
+func TestScimPatchUser(t *testing.T) {
+	c, _ := newScimTest(t)
+	alice := c.createUser("alice@acme.test", "okta-1")
+	path := "/scim/v2/Users/" + alice.ID
+
+	patch := func(ops ...map[string]interface{}) scim.User {
+		t.Helper()
+		var user scim.User
+		if code := c.do("PATCH", path, patchOp(ops...), &user); code != http.StatusOK {
+			t.Fatalf("patch %v = %d", ops, code)
+		}
+		return user
+	}
+
+	// Azure AD sends capitalized ops and string booleans
+	if u := patch(map[string]interface{}{"op": "Replace", "path": "active", "value": "False"}); *u.Active {
+		t.Error("replace active \"False\" left the user active")
+	}
+	// Okta sends a path-less replace with an object of attributes
+	if u := patch(map[string]interface{}{"op": "replace", "value": map[string]interface{}{"active": true, "externalId": "okta-9"}}); !*u.Active || u.ExternalID != "okta-9" {
+		t.Errorf("path-less replace gave %+v", u)
+	}
+	if u := patch(map[string]interface{}{"op": "remove", "path": "externalId"}); u.ExternalID != "" {
+		t.Errorf("remove externalId left %q", u.ExternalID)
+	}
+	if u := patch(map[string]interface{}{"op": "add", "path": "externalId", "value": "okta-10"}); u.ExternalID != "okta-10" {
+		t.Errorf("add externalId gave %q", u.ExternalID)
+	}
+	// Unchanged userName and read-only attributes are accepted and ignored
+	patch(map[string]interface{}{"op": "replace", "path": "userName", "value": "Alice@acme.test"},
+		map[string]interface{}{"op": "replace", "path": "meta", "value": map[string]interface{}{}})
+
+	c.expectError("PATCH", path, patchOp(map[string]interface{}{"op": "replace", "path": "nickName", "value": "Al"}), http.StatusBadRequest, scim.ErrTypeInvalidPath)
+	c.expectError("PATCH", path, patchOp(map[string]interface{}{"op": "move", "path": "active", "value": true}), http.StatusBadRequest, scim.ErrTypeInvalidSyntax)
+	c.expectError("PATCH", path, patchOp(map[string]interface{}{"op": "replace", "path": "active", "value": 3}), http.StatusBadRequest, scim.ErrTypeInvalidValue)
+	c.expectError("PATCH", path, patchOp(map[string]interface{}{"op": "remove", "path": "active"}), http.StatusBadRequest, scim.ErrTypeMutability)
+	c.expectError("PATCH", path, patchOp(map[string]interface{}{"op": "replace", "path": "userName", "value": "eve@acme.test"}), http.StatusBadRequest, scim.ErrTypeMutability)
+	c.expectError("PATCH", path, patchOp(map[string]interface{}{"op": "remove", "value": map[string]interface{}{"active": true}}), http.StatusBadRequest, scim.ErrTypeInvalidSyntax)
+
+	// A rejected operation leaves the earlier ones in the request unapplied
+	c.expectError("PATCH", path, patchOp(
+		map[string]interface{}{"op": "replace", "path": "externalId", "value": "okta-11"},
+		map[string]interface{}{"op": "replace", "path": "nickName", "value": "Al"},
+	), http.StatusBadRequest, scim.ErrTypeInvalidPath)
+	var got scim.User
+	c.do("GET", path, nil, &got)
+	if got.ExternalID != "okta-10" {
+		t.Errorf("externalId %q after a rejected patch, want okta-10", got.ExternalID)
+	}
+}
+
+// groupMembers returns the user names in the group.
+func (c *scimClient) groupMembers(id string) []string {
+	c.t.Helper()
+	var g scim.Group
+	if code := c.do("GET", "/scim/v2/Groups/"+id, nil, &g); code != http.StatusOK {
+		c.t.Fatalf("get group %s = %d", id, code)
+	}
+	names := make([]string, len(g.Members))
+	for i, m := range g.Members {
+		names[i] = m.Display
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestScimGroups(t *testing.T) {
+	c, _ := newScimTest(t)
+	alice := c.createUser("alice@acme.test", "")
+	bob := c.createUser("bob@acme.test", "")
+
+	var list struct {
+		scim.ListResponse
+		Resources []scim.Group `json:"Resources"`
+	}
+	if code := c.do("GET", "/scim/v2/Groups", nil, &list); code != http.StatusOK || list.TotalResults != 3 {
+		t.Fatalf("list groups = %d, %d groups", code, list.TotalResults)
+	}
+	for i, id := range []string{domain.OrgRoleOwner, domain.OrgRoleAdmin, domain.OrgRoleMember} {
+		if g := list.Resources[i]; g.ID != id || g.DisplayName != id || !slices.Equal(g.Schemas, []string{scim.SchemaGroup}) {
+			t.Errorf("group %d = %+v, want %s", i, g, id)
+		}
+	}
+	c.do("GET", "/scim/v2/Groups?filter="+url.QueryEscape(`displayName eq "Admin"`), nil, &list)
+	if len(list.Resources) != 1 || list.Resources[0].ID != domain.OrgRoleAdmin {
+		t.Errorf("displayName filter gave %+v", list.Resources)
+	}
+	c.do("GET", "/scim/v2/Groups?filter="+url.QueryEscape(fmt.Sprintf(`members.value eq "%s"`, alice.ID)), nil, &list)
+	if len(list.Resources) != 1 || list.Resources[0].ID != domain.OrgRoleMember {
+		t.Errorf("members filter gave %+v", list.Resources)
+	}
+
+	// PATCH add and remove by filter, as Azure AD does
+	ref := func(ids ...string) []map[string]string {
+		refs := make([]map[string]string, len(ids))
+		for i, id := range ids {
+			refs[i] = map[string]string{"value": id}
+		}
+		return refs
+	}
+	if code := c.do("PATCH", "/scim/v2/Groups/admin", patchOp(map[string]interface{}{"op": "add", "path": "members", "value": ref(alice.ID, bob.ID)}), nil); code != http.StatusOK {
+		t.Fatalf("add members = %d", code)
+	}
+	if got := c.groupMembers("admin"); !slices.Equal(got, []string{"alice@acme.test", "bob@acme.test"}) {
+		t.Errorf("admins %v after add", got)
+	}
+	var user scim.User
+	c.do("GET", "/scim/v2/Users/"+alice.ID, nil, &user)
+	if user.Groups[0].Value != domain.OrgRoleAdmin {
+		t.Errorf("alice's groups %+v, want admin", user.Groups)
+	}
+	if code := c.do("PATCH", "/scim/v2/Groups/admin", patchOp(map[string]interface{}{"op": "remove", "path": fmt.Sprintf(`members[value eq "%s"]`, bob.ID)}), nil); code != http.StatusOK {
+		t.Fatalf("remove member = %d", code)
+	}
+	if got := c.groupMembers("admin"); !slices.Equal(got, []string{"alice@acme.test"}) {
+		t.Errorf("admins %v after remove", got)
+	}
+	if got := c.groupMembers("member"); !slices.Equal(got, []string{"bob@acme.test"}) {
+		t.Errorf("members %v, want bob demoted back", got)
+	}
+
+	// Path-less replace, as Okta does, and removing every member
+	c.do("PATCH", "/scim/v2/Groups/admin", patchOp(map[string]interface{}{"op": "replace", "value": map[string]interface{}{"displayName": "admin", "members": ref(bob.ID)}}), nil)
+	if got := c.groupMembers("admin"); !slices.Equal(got, []string{"bob@acme.test"}) {
+		t.Errorf("admins %v after replace", got)
+	}
+	c.do("PATCH", "/scim/v2/Groups/admin", patchOp(map[string]interface{}{"op": "remove", "path": "members"}), nil)
+	if got := c.groupMembers("admin"); len(got) != 0 {
+		t.Errorf("admins %v after removing all", got)
+	}
+
+	// PUT sets the members outright
+	var group scim.Group
+	body := map[string]interface{}{"schemas": []string{scim.SchemaGroup}, "displayName": "owner", "members": ref("1", alice.ID)}
+	if code := c.do("PUT", "/scim/v2/Groups/owner", body, &group); code != http.StatusOK || len(group.Members) != 2 {
+		t.Fatalf("replace owners = %d, %+v", code, group.Members)
+	}
+	// The original owner can now be demoted, since alice is an owner too
+	body["members"] = ref(alice.ID)
+	c.do("PUT", "/scim/v2/Groups/owner", body, nil)
+	if got := c.groupMembers("owner"); !slices.Equal(got, []string{"alice@acme.test"}) {
+		t.Errorf("owners %v", got)
+	}
+}
+
+func TestScimGroupErrors(t *testing.T) {
+	c, _ := newScimTest(t)
+	alice := c.createUser("alice@acme.test", "")
+	add := func(id string) map[string]interface{} {
+		return patchOp(map[string]interface{}{"op": "add", "path": "members", "value": []map[string]string{{"value": id}}})
+	}
+
+	c.expectError("POST", "/scim/v2/Groups", map[string]interface{}{"displayName": "sales"}, http.StatusForbidden, scim.ErrTypeMutability)
+	c.expectError("DELETE", "/scim/v2/Groups/admin", nil, http.StatusForbidden, scim.ErrTypeMutability)
+	c.expectError("GET", "/scim/v2/Groups/sales", nil, http.StatusNotFound, "")
+	c.expectError("PATCH", "/scim/v2/Groups/sales", add(alice.ID), http.StatusNotFound, "")
+	c.expectError("PATCH", "/scim/v2/Groups/admin", add("2"), http.StatusBadRequest, scim.ErrTypeInvalidValue) // Member of organization 2
+	c.expectError("PATCH", "/scim/v2/Groups/admin", add("x"), http.StatusBadRequest, scim.ErrTypeInvalidValue)
+	c.expectError("PATCH", "/scim/v2/Groups/admin", patchOp(map[string]interface{}{"op": "replace", "path": "displayName", "value": "admins"}), http.StatusBadRequest, scim.ErrTypeMutability)
+	c.expectError("PATCH", "/scim/v2/Groups/admin", patchOp(map[string]interface{}{"op": "add", "path": `members[value eq "1"]`}), http.StatusBadRequest, scim.ErrTypeInvalidPath)
+	c.expectError("PATCH", "/scim/v2/Groups/member", patchOp(map[string]interface{}{"op": "remove", "path": "members"}), http.StatusBadRequest, scim.ErrTypeMutability)
+	c.expectError("PUT", "/scim/v2/Groups/owner", map[string]interface{}{"displayName": "owner", "members": []interface{}{}}, http.StatusConflict, "")
+
+	if got := c.groupMembers("owner"); !slices.Equal(got, []string{"owner@acme.test"}) {
+		t.Errorf("owners %v after rejected requests", got)
+	}
+}
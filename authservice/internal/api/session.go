@@ -0,0 +1,173 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"authservice/internal/config"
+)
+
+// CSRFHeader must echo the CSRF cookie on state-changing requests made with a session cookie.
+const CSRFHeader = "X-CSRF-Token"
+
+// sameSite converts the configured SameSite policy into its http constant.
+func sameSite(cfg *config.Config) http.SameSite {
+	switch strings.ToLower(cfg.CookieSameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// setSessionCookies stores the access token in an HttpOnly cookie and issues a fresh
+// CSRF token in a cookie readable by JavaScript. The CSRF token is returned so it can
+// also be sent in the response body.
+func (h *AuthHandler) setSessionCookies(w http.ResponseWriter, token string) (string, error) {
+	csrfToken, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	maxAge := int(h.cfg.TokenTTL.Seconds())
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.cfg.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Domain:   h.cfg.CookieDomain,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.cfg.CookieSecure,
+		SameSite: sameSite(h.cfg),
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.cfg.CSRFCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		Domain:   h.cfg.CookieDomain,
+		MaxAge:   maxAge,
+		HttpOnly: false, // The frontend reads it and echoes it in X-CSRF-Token
+		Secure:   h.cfg.CookieSecure,
+		SameSite: sameSite(h.cfg),
+	})
+	return csrfToken, nil
+}
+
+// clearSessionCookies removes the session and CSRF cookies.
+func (h *AuthHandler) clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{h.cfg.SessionCookie, h.cfg.CSRFCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			Domain:   h.cfg.CookieDomain,
+			MaxAge:   -1,
+			Secure:   h.cfg.CookieSecure,
+			SameSite: sameSite(h.cfg),
+		})
+	}
+}
+
+// respondWithSession answers a successful login. In cookie mode the token only goes
+// into the HttpOnly cookie and the body carries the CSRF token instead.
+func (h *AuthHandler) respondWithSession(w http.ResponseWriter, r *http.Request, token string, useCookies bool) {
+	if !useCookies || !h.cfg.CookieSessions {
+		respondWithJSON(w, http.StatusOK, LoginResponse{Token: token})
+		return
+	}
+
+	csrfToken, err := h.setSessionCookies(w, token)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating CSRF token", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, LoginResponse{CSRFToken: csrfToken})
+}
+
+// Logout clears the session cookies. Bearer tokens are stateless and simply expire.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	h.clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CSRFProtect enforces the double-submit pattern: a state-changing request that is
+// authenticated by the session cookie (rather than an Authorization header) must send
+// the CSRF cookie's value in X-CSRF-Token.
+func CSRFProtect(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Requests without the session cookie, or with an explicit bearer token,
+			// can't be forged by a third-party site.
+			if _, err := r.Cookie(cfg.SessionCookie); err != nil || r.Header.Get("Authorization") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cookie, err := r.Cookie(cfg.CSRFCookie)
+			header := r.Header.Get(CSRFHeader)
+			if err != nil || cookie.Value == "" || header == "" ||
+				subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+				respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CORS applies the configured cross-origin policy and answers preflight requests.
+func CORS(cfg *config.Config) func(http.Handler) http.Handler {
+	allowAny := false
+	allowed := make(map[string]bool, len(cfg.CORSAllowedOrigins))
+	for _, origin := range cfg.CORSAllowedOrigins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			allowAny = true
+		}
+		allowed[origin] = true
+	}
+	maxAge := strconv.Itoa(int(cfg.CORSMaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || len(allowed) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+
+			// A wildcard never grants credentialed access; those origins must be listed explicitly
+			if !allowed[origin] && !(allowAny && !cfg.CORSAllowCredentials) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if cfg.CORSAllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			// Preflight
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-CSRF-Token, X-Request-ID")
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"authservice/internal/domain"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrPasswordManagedExternally is returned when changing the password of an account
+// whose credentials live in a directory.
+var ErrPasswordManagedExternally = errors.New("password is managed by an external directory")
+
+// CredentialBackend checks passwords against wherever an account's credentials live.
+type CredentialBackend interface {
+	// Authenticate verifies the password for the login email. user is the local account,
+	// or nil if there is none yet. It returns the user's attributes as the backend knows
+	// them (at least Email and Role), or ErrInvalidCredentials.
+	Authenticate(ctx context.Context, email, password string, user *domain.User) (*domain.User, error)
+}
+
+// localBackend checks the bcrypt hash stored in the users table.
+type localBackend struct{}
+
+func (localBackend) Authenticate(ctx context.Context, email, password string, user *domain.User) (*domain.User, error) {
+	if user == nil || !user.HasPassword() {
+		return nil, ErrInvalidCredentials // Unknown user or passkey-only account
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("password comparison failed: %w", err)
+	}
+	return user, nil
+}
+
+// checkPassword authenticates with the backend for the user's auth source, or the
+// directory for unknown users when auto-provisioning is enabled. It returns the local
+// user, created or updated to match the directory as needed.
+func (s *authService) checkPassword(ctx context.Context, email, password string, user *domain.User) (*domain.User, error) {
+	source := domain.AuthSourceLocal
+	switch {
+	case user != nil && !user.IsLocal():
+		source = user.AuthSource
+	case user == nil && s.cfg.LDAPAutoProvision && s.backends[domain.AuthSourceLDAP] != nil:
+		source = domain.AuthSourceLDAP
+	}
+
+	backend, ok := s.backends[source]
+	if !ok {
+		slog.WarnContext(ctx, "Login for account with unavailable credential backend", "auth_source", source)
+		return nil, ErrInvalidCredentials
+	}
+	identity, err := backend.Authenticate(ctx, email, password, user)
+	if err != nil {
+		return nil, err
+	}
+	if source == domain.AuthSourceLocal {
+		return user, nil
+	}
+	return s.syncDirectoryUser(ctx, source, user, identity)
+}
+
+// syncDirectoryUser creates the local account for a directory user on first login, and
+// afterwards keeps its platform role in line with the directory's groups.
+func (s *authService) syncDirectoryUser(ctx context.Context, source string, user, identity *domain.User) (*domain.User, error) {
+	if user == nil {
+		id, err := s.userRepo.CreateUser(ctx, &domain.User{Email: identity.Email, Role: identity.Role, AuthSource: source})
+		if err != nil {
+			return nil, fmt.Errorf("failed to provision directory user: %w", err)
+		}
+		slog.InfoContext(ctx, "Provisioned user from directory", "user_id", id, "auth_source", source, "role", identity.Role)
+		return s.userRepo.GetUserByID(ctx, id)
+	}
+
+	if identity.Role != user.Role {
+		if err := s.userRepo.UpdateRole(ctx, user.ID, identity.Role); err != nil {
+			return nil, fmt.Errorf("failed to update role: %w", err)
+		}
+		slog.InfoContext(ctx, "Updated role from directory groups", "user_id", user.ID, "old_role", user.Role, "new_role", identity.Role)
+		user.Role = identity.Role
+	}
+	return user, nil
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+
+	"authservice/internal/config"
+	"authservice/internal/domain"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConn is the part of *ldap.Conn the LDAP backend uses, so a stand-in directory
+// can replace the server.
+type LDAPConn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// LDAPDialer opens a connection to the directory.
+type LDAPDialer func(ctx context.Context) (LDAPConn, error)
+
+// LDAPBackend authenticates users by binding to an LDAP directory as them. Users are
+// found with the service account, so the login email needn't be part of their DN.
+type LDAPBackend struct {
+	cfg           *config.Config
+	dial          LDAPDialer
+	adminGroups   []*ldap.DN
+	supportGroups []*ldap.DN
+}
+
+// NewLDAPBackend creates a backend for the directory at cfg.LDAPURL.
+func NewLDAPBackend(cfg *config.Config) (*LDAPBackend, error) {
+	u, err := url.Parse(cfg.LDAPURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_URL: %w", err)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+
+	return NewLDAPBackendWithDialer(cfg, func(ctx context.Context) (LDAPConn, error) {
+		conn, err := ldap.DialURL(cfg.LDAPURL,
+			ldap.DialWithDialer(&net.Dialer{Timeout: cfg.LDAPTimeout}),
+			ldap.DialWithTLSConfig(tlsConfig),
+		)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetTimeout(cfg.LDAPTimeout)
+		if u.Scheme == "ldap" && cfg.LDAPStartTLS {
+			if err := conn.StartTLS(tlsConfig); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("StartTLS failed: %w", err)
+			}
+		}
+		return conn, nil
+	})
+}
+
+// NewLDAPBackendWithDialer creates a backend that connects with dial.
+func NewLDAPBackendWithDialer(cfg *config.Config, dial LDAPDialer) (*LDAPBackend, error) {
+	if !strings.Contains(cfg.LDAPUserFilter, "%s") {
+		return nil, errors.New("LDAP_USER_FILTER must contain %s for the login email")
+	}
+	adminGroups, err := parseDNs(cfg.LDAPAdminGroups)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_ADMIN_GROUPS: %w", err)
+	}
+	supportGroups, err := parseDNs(cfg.LDAPSupportGroups)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_SUPPORT_GROUPS: %w", err)
+	}
+	return &LDAPBackend{cfg: cfg, dial: dial, adminGroups: adminGroups, supportGroups: supportGroups}, nil
+}
+
+// Authenticate looks the user up by email with the service account, then binds as them.
+func (b *LDAPBackend) Authenticate(ctx context.Context, email, password string, user *domain.User) (*domain.User, error) {
+	if password == "" {
+		return nil, ErrInvalidCredentials // An empty password would be an unauthenticated bind, which succeeds
+	}
+
+	conn, err := b.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP: %w", err)
+	}
+	defer conn.Close()
+
+	if b.cfg.LDAPBindDN != "" {
+		if err := conn.Bind(b.cfg.LDAPBindDN, b.cfg.LDAPBindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP service bind failed: %w", err)
+		}
+	}
+
+	req := ldap.NewSearchRequest(
+		b.cfg.LDAPBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, // Enough to detect ambiguous matches
+		int(b.cfg.LDAPTimeout.Seconds()),
+		false,
+		fmt.Sprintf(b.cfg.LDAPUserFilter, ldap.EscapeFilter(email)),
+		[]string{b.cfg.LDAPEmailAttribute, b.cfg.LDAPGroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("LDAP search failed: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		if result != nil && len(result.Entries) > 1 {
+			slog.WarnContext(ctx, "Login email matches several LDAP entries", "base_dn", b.cfg.LDAPBaseDN)
+		}
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP bind failed: %w", err)
+	}
+
+	mail := entry.GetAttributeValue(b.cfg.LDAPEmailAttribute)
+	if mail == "" {
+		mail = email
+	}
+	return &domain.User{
+		Email:      strings.ToLower(mail),
+		Role:       b.roleFor(entry.GetAttributeValues(b.cfg.LDAPGroupAttribute)),
+		AuthSource: domain.AuthSourceLDAP,
+	}, nil
+}
+
+// roleFor maps the user's group DNs to the highest platform role they grant.
+func (b *LDAPBackend) roleFor(groups []string) string {
+	role := domain.RoleUser
+	for _, group := range groups {
+		dn, err := ldap.ParseDN(group)
+		if err != nil {
+			continue
+		}
+		if containsDN(b.adminGroups, dn) {
+			return domain.RoleAdmin
+		}
+		if containsDN(b.supportGroups, dn) {
+			role = domain.RoleSupport
+		}
+	}
+	return role
+}
+
+func parseDNs(values []string) ([]*ldap.DN, error) {
+	dns := make([]*ldap.DN, 0, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		dn, err := ldap.ParseDN(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", v, err)
+		}
+		dns = append(dns, dn)
+	}
+	return dns, nil
+}
+
+func containsDN(dns []*ldap.DN, dn *ldap.DN) bool {
+	for _, d := range dns {
+		if d.EqualFold(dn) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,423 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"authservice/internal/config"
+	"authservice/internal/domain"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testBaseDN       = "ou=people,dc=example,dc=com"
+	testServiceDN    = "cn=authservice,ou=services,dc=example,dc=com"
+	testServicePass  = "service-secret"
+	testAdminGroup   = "cn=admins,ou=groups,dc=example,dc=com"
+	testSupportGroup = "cn=support,ou=groups,dc=example,dc=com"
+)
+
+// standInDirectory is an in-process LDAP directory. It checks binds against the
+// entries' passwords and evaluates search filters, so the backend runs unchanged
+// against it through LDAPConn.
+type standInDirectory struct {
+	entries   []*ldap.Entry
+	passwords map[string]string // DN -> password
+
+	dialErr   error
+	searchErr error
+	bindErr   error // Returned for user binds, e.g. a server-side failure
+
+	dials, closes int
+	searches      []*ldap.SearchRequest
+}
+
+func newStandInDirectory() *standInDirectory {
+	d := &standInDirectory{passwords: map[string]string{testServiceDN: testServicePass}}
+	d.addPerson("alice", "Alice@Example.com", "alice-pw", testAdminGroup)
+	d.addPerson("sam", "sam@example.com", "sam-pw", "cn=Support, ou=Groups, dc=Example, dc=com", "cn=developers,ou=groups,dc=example,dc=com")
+	d.addPerson("bob", "bob@example.com", "bob-pw", "cn=developers,ou=groups,dc=example,dc=com", "not a DN")
+	return d
+}
+
+func (d *standInDirectory) addPerson(uid, mail, password string, groups ...string) {
+	dn := "uid=" + uid + "," + testBaseDN
+	d.entries = append(d.entries, ldap.NewEntry(dn, map[string][]string{
+		"objectClass": {"top", "person"},
+		"uid":         {uid},
+		"mail":        {mail},
+		"memberOf":    groups,
+	}))
+	d.passwords[dn] = password
+}
+
+// setGroups replaces the groups of the entry with uid.
+func (d *standInDirectory) setGroups(uid string, groups ...string) {
+	for _, entry := range d.entries {
+		if entry.GetAttributeValue("uid") == uid {
+			for _, attr := range entry.Attributes {
+				if attr.Name == "memberOf" {
+					attr.Values = groups
+				}
+			}
+		}
+	}
+}
+
+func (d *standInDirectory) dial(context.Context) (LDAPConn, error) {
+	if d.dialErr != nil {
+		return nil, d.dialErr
+	}
+	d.dials++
+	return &standInConn{dir: d}, nil
+}
+
+type standInConn struct {
+	dir   *standInDirectory
+	bound string
+}
+
+func (c *standInConn) Bind(username, password string) error {
+	if username != testServiceDN && c.dir.bindErr != nil {
+		return c.dir.bindErr
+	}
+	if expected, ok := c.dir.passwords[username]; !ok || password == "" || password != expected {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	c.bound = username
+	return nil
+}
+
+func (c *standInConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.dir.searches = append(c.dir.searches, req)
+	if c.dir.searchErr != nil {
+		return nil, c.dir.searchErr
+	}
+	if c.bound != testServiceDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("bind as the service account first"))
+	}
+	filter, err := ldap.CompileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	base, err := ldap.ParseDN(req.BaseDN)
+	if err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultInvalidDNSyntax, err)
+	}
+
+	result := &ldap.SearchResult{}
+	for _, entry := range c.dir.entries {
+		dn, _ := ldap.ParseDN(entry.DN)
+		if !base.AncestorOfFold(dn) || !matchFilter(filter, entry) {
+			continue
+		}
+		if req.SizeLimit > 0 && len(result.Entries) == req.SizeLimit {
+			return result, ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("size limit exceeded"))
+		}
+		attrs := make(map[string][]string)
+		for _, name := range req.Attributes {
+			if values := entry.GetAttributeValues(name); len(values) > 0 {
+				attrs[name] = values
+			}
+		}
+		result.Entries = append(result.Entries, ldap.NewEntry(entry.DN, attrs))
+	}
+	return result, nil
+}
+
+func (c *standInConn) Close() error {
+	c.dir.closes++
+	return nil
+}
+
+// matchFilter evaluates a compiled filter made of and, or, not, equality and presence.
+func matchFilter(f *ber.Packet, entry *ldap.Entry) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, child := range f.Children {
+			if !matchFilter(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range f.Children {
+			if matchFilter(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matchFilter(f.Children[0], entry)
+	case ldap.FilterPresent:
+		return len(entry.GetEqualFoldAttributeValues(f.Value.(string))) > 0
+	case ldap.FilterEqualityMatch:
+		want := f.Children[1].Value.(string)
+		for _, v := range entry.GetEqualFoldAttributeValues(f.Children[0].Value.(string)) {
+			if strings.EqualFold(v, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return false // Substrings and the rest never match
+}
+
+func newTestLDAPBackend(t *testing.T, dir *standInDirectory) *LDAPBackend {
+	t.Helper()
+	cfg := newTestLDAPConfig()
+	backend, err := NewLDAPBackendWithDialer(cfg, dir.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func newTestLDAPConfig() *config.Config {
+	cfg := newTestConfig()
+	cfg.LDAPURL = "ldap://ldap.example.com"
+	cfg.LDAPBindDN = testServiceDN
+	cfg.LDAPBindPassword = testServicePass
+	cfg.LDAPBaseDN = testBaseDN
+	cfg.LDAPUserFilter = "(&(objectClass=person)(mail=%s))"
+	cfg.LDAPEmailAttribute = "mail"
+	cfg.LDAPGroupAttribute = "memberOf"
+	cfg.LDAPAdminGroups = []string{testAdminGroup}
+	cfg.LDAPSupportGroups = []string{testSupportGroup}
+	cfg.LDAPAutoProvision = true
+	return cfg
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	dir := newStandInDirectory()
+	backend := newTestLDAPBackend(t, dir)
+
+	user, err := backend.Authenticate(context.Background(), "alice@example.com", "alice-pw", nil)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.Email != "alice@example.com" || user.AuthSource != domain.AuthSourceLDAP {
+		t.Errorf("user %+v, want alice@example.com from ldap", user)
+	}
+
+	search := dir.searches[0]
+	if search.BaseDN != testBaseDN || search.Filter != "(&(objectClass=person)(mail=alice@example.com))" {
+		t.Errorf("searched %q under %q", search.Filter, search.BaseDN)
+	}
+	if dir.closes != dir.dials {
+		t.Errorf("%d connections opened, %d closed", dir.dials, dir.closes)
+	}
+}
+
+func TestLDAPGroupMapping(t *testing.T) {
+	tests := []struct {
+		email, password string
+		want            string
+	}{
+		{"alice@example.com", "alice-pw", domain.RoleAdmin},
+		{"sam@example.com", "sam-pw", domain.RoleSupport}, // Group DNs compare case- and space-insensitively
+		{"bob@example.com", "bob-pw", domain.RoleUser},    // Unmapped and malformed groups are ignored
+	}
+	backend := newTestLDAPBackend(t, newStandInDirectory())
+	for _, tt := range tests {
+		user, err := backend.Authenticate(context.Background(), tt.email, tt.password, nil)
+		if err != nil {
+			t.Fatalf("Authenticate %s: %v", tt.email, err)
+		}
+		if user.Role != tt.want {
+			t.Errorf("%s has role %q, want %q", tt.email, user.Role, tt.want)
+		}
+	}
+
+	// Admin wins over support, whatever the order
+	dir := newStandInDirectory()
+	dir.addPerson("root", "root@example.com", "root-pw", testSupportGroup, testAdminGroup)
+	user, err := newTestLDAPBackend(t, dir).Authenticate(context.Background(), "root@example.com", "root-pw", nil)
+	if err != nil || user.Role != domain.RoleAdmin {
+		t.Errorf("support and admin member = %+v, %v; want admin", user, err)
+	}
+}
+
+func TestLDAPAuthenticateFailures(t *testing.T) {
+	serverDown := errors.New("connection refused")
+	tests := []struct {
+		name            string
+		email, password string
+		setup           func(*standInDirectory)
+		wantInvalid     bool // ErrInvalidCredentials, rather than an operational error
+	}{
+		{name: "wrong password", email: "alice@example.com", password: "guess", wantInvalid: true},
+		{name: "empty password", email: "alice@example.com", password: "", wantInvalid: true},
+		{name: "unknown user", email: "mallory@example.com", password: "x", wantInvalid: true},
+		{name: "filter injection", email: "*)(uid=alice", password: "alice-pw", wantInvalid: true},
+		{name: "ambiguous email", email: "alice@example.com", password: "alice-pw", wantInvalid: true, setup: func(d *standInDirectory) {
+			d.addPerson("alice2", "alice@example.com", "alice-pw")
+		}},
+		{name: "outside base DN", email: "eve@example.com", password: "eve-pw", wantInvalid: true, setup: func(d *standInDirectory) {
+			d.entries = append(d.entries, ldap.NewEntry("uid=eve,ou=contractors,dc=example,dc=com", map[string][]string{
+				"objectClass": {"person"}, "mail": {"eve@example.com"},
+			}))
+			d.passwords["uid=eve,ou=contractors,dc=example,dc=com"] = "eve-pw"
+		}},
+		{name: "server unreachable", email: "alice@example.com", password: "alice-pw", setup: func(d *standInDirectory) {
+			d.dialErr = serverDown
+		}},
+		{name: "service account rejected", email: "alice@example.com", password: "alice-pw", setup: func(d *standInDirectory) {
+			d.passwords[testServiceDN] = "rotated"
+		}},
+		{name: "search fails", email: "alice@example.com", password: "alice-pw", setup: func(d *standInDirectory) {
+			d.searchErr = ldap.NewError(ldap.LDAPResultBusy, errors.New("busy"))
+		}},
+		{name: "user bind fails", email: "alice@example.com", password: "alice-pw", setup: func(d *standInDirectory) {
+			d.bindErr = ldap.NewError(ldap.LDAPResultUnavailable, errors.New("unavailable"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newStandInDirectory()
+			if tt.setup != nil {
+				tt.setup(dir)
+			}
+			user, err := newTestLDAPBackend(t, dir).Authenticate(context.Background(), tt.email, tt.password, nil)
+			if err == nil {
+				t.Fatalf("Authenticate = %+v, want an error", user)
+			}
+			if got := errors.Is(err, ErrInvalidCredentials); got != tt.wantInvalid {
+				t.Errorf("Authenticate = %v; invalid credentials %v, want %v", err, got, tt.wantInvalid)
+			}
+			if dir.closes != dir.dials {
+				t.Errorf("%d connections opened, %d closed", dir.dials, dir.closes)
+			}
+		})
+	}
+}
+
+func TestLDAPBackendConfig(t *testing.T) {
+	dir := newStandInDirectory()
+
+	cfg := newTestLDAPConfig()
+	cfg.LDAPUserFilter = "(mail=alice@example.com)"
+	if _, err := NewLDAPBackendWithDialer(cfg, dir.dial); err == nil {
+		t.Errorf("accepted a user filter without %q", "%s")
+	}
+
+	cfg = newTestLDAPConfig()
+	cfg.LDAPAdminGroups = []string{"not a DN"}
+	if _, err := NewLDAPBackendWithDialer(cfg, dir.dial); err == nil {
+		t.Error("accepted an invalid admin group DN")
+	}
+
+	// Without a service account the search runs anonymously, which this directory refuses
+	cfg = newTestLDAPConfig()
+	cfg.LDAPBindDN = ""
+	backend, err := NewLDAPBackendWithDialer(cfg, dir.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Authenticate(context.Background(), "alice@example.com", "alice-pw", nil); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("anonymous search = %v, want an operational error", err)
+	}
+}
+
+// newLDAPTestService returns a service whose directory is dir, with local user 1
+// (carol@example.com, password "carol-pw").
+func newLDAPTestService(t *testing.T, dir *standInDirectory, autoProvision bool) (*authService, *memoryUsers) {
+	t.Helper()
+	cfg := newTestLDAPConfig()
+	cfg.LDAPAutoProvision = autoProvision
+	backend, err := NewLDAPBackendWithDialer(cfg, dir.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey, err := LoadSigningKey(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("carol-pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := &memoryUsers{users: map[int64]*domain.User{
+		1: {ID: 1, Email: "carol@example.com", Password: string(hash), Role: domain.RoleUser, AuthSource: domain.AuthSourceLocal},
+	}}
+	svc := NewAuthService(users, nil, noMemberships{}, nil, nil, nil, &memoryLogins{}, nil, nil, signingKey, backend, nil, cfg)
+	return svc.(*authService), users
+}
+
+func TestLDAPLoginProvisionsAndSyncsRole(t *testing.T) {
+	dir := newStandInDirectory()
+	svc, users := newLDAPTestService(t, dir, true)
+	ctx := context.Background()
+
+	token, err := svc.Login(ctx, "sam@example.com", "sam-pw", ClientInfo{IP: "192.0.2.1"}, ProofOfWork{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	claims, err := svc.VerifyToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provisioned, err := users.GetUserByEmail(ctx, "sam@example.com")
+	if err != nil {
+		t.Fatalf("user not provisioned: %v", err)
+	}
+	if provisioned.AuthSource != domain.AuthSourceLDAP || provisioned.HasPassword() || claims.UserID != provisioned.ID || claims.Role != domain.RoleSupport {
+		t.Errorf("provisioned %+v, token for user %d with role %q", provisioned, claims.UserID, claims.Role)
+	}
+
+	// Leaving the support group in the directory demotes the account on its next login
+	dir.setGroups("sam")
+	token, err = svc.Login(ctx, "sam@example.com", "sam-pw", ClientInfo{IP: "192.0.2.1"}, ProofOfWork{})
+	if err != nil {
+		t.Fatalf("second Login: %v", err)
+	}
+	if claims, _ := svc.VerifyToken(token); claims.Role != domain.RoleUser || claims.UserID != provisioned.ID {
+		t.Errorf("after leaving the group: user %d, role %q; want %d, user", claims.UserID, claims.Role, provisioned.ID)
+	}
+
+	if err := svc.ChangePassword(ctx, provisioned.ID, "sam-pw", "new-password"); !errors.Is(err, ErrPasswordManagedExternally) {
+		t.Errorf("ChangePassword = %v, want ErrPasswordManagedExternally", err)
+	}
+	if _, err := svc.Login(ctx, "sam@example.com", "wrong", ClientInfo{IP: "192.0.2.1"}, ProofOfWork{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login with a wrong password = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLDAPLoginKeepsLocalAccountsLocal(t *testing.T) {
+	dir := newStandInDirectory()
+	dir.addPerson("carol", "carol@example.com", "directory-pw", testAdminGroup)
+	svc, _ := newLDAPTestService(t, dir, true)
+	ctx := context.Background()
+
+	// A local account is never checked against, or promoted by, the directory
+	token, err := svc.Login(ctx, "carol@example.com", "carol-pw", ClientInfo{IP: "192.0.2.1"}, ProofOfWork{})
+	if err != nil {
+		t.Fatalf("Login with the local password: %v", err)
+	}
+	if claims, _ := svc.VerifyToken(token); claims.Role != domain.RoleUser {
+		t.Errorf("local account has role %q, want user", claims.Role)
+	}
+	if _, err := svc.Login(ctx, "carol@example.com", "directory-pw", ClientInfo{IP: "192.0.2.1"}, ProofOfWork{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login with the directory password = %v, want ErrInvalidCredentials", err)
+	}
+	if dir.dials != 0 {
+		t.Errorf("the directory was contacted %d times for a local account", dir.dials)
+	}
+}
+
+func TestLDAPLoginWithoutAutoProvisioning(t *testing.T) {
+	dir := newStandInDirectory()
+	svc, users := newLDAPTestService(t, dir, false)
+
+	if _, err := svc.Login(context.Background(), "alice@example.com", "alice-pw", ClientInfo{IP: "192.0.2.1"}, ProofOfWork{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login = %v, want ErrInvalidCredentials", err)
+	}
+	if len(users.users) != 1 {
+		t.Errorf("%d users, want no account provisioned", len(users.users))
+	}
+}
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"authservice/internal/domain"
+	"authservice/internal/mailer"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidLoginCode is returned for tampered, expired, reused or wrong login codes.
+var ErrInvalidLoginCode = errors.New("invalid or expired login code")
+
+// SecondFactorEmailCode is the only second factor offered for suspicious logins.
+const SecondFactorEmailCode = "email_code"
+
+// ClientInfo describes the client signing in, for bot protection and login history.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	DeviceID  string // Stable per browser or app install; the user agent stands in when empty
+}
+
+// SecondFactorRequiredError is returned by Login when the login looks suspicious and a
+// second factor is required. A code has been emailed to the user; send it with Token to
+// VerifyLoginCode.
+type SecondFactorRequiredError struct {
+	Token     string
+	Methods   []string
+	ExpiresAt time.Time
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return "second factor required"
+}
+
+// loginCodeClaims are the claims of a second-factor token. The user ID is carried in
+// the standard "sub" claim and the token ID in "jti". The code itself is only stored
+// as a MAC, so the token can't be brute-forced offline.
+type loginCodeClaims struct {
+	CodeMAC string   `json:"code_mac"`
+	Flags   []string `json:"flags"`
+	jwt.RegisteredClaims
+}
+
+// assessLogin compares the login with the user's history. The first login of an account
+// is never flagged, as there is nothing to compare with.
+func (s *authService) assessLogin(ctx context.Context, userID int64, client ClientInfo) (*domain.LoginEvent, error) {
+	event := s.newLoginEvent(userID, client)
+
+	familiarity, err := s.loginRepo.GetFamiliarity(ctx, userID, event.DeviceHash, event.Network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check login history: %w", err)
+	}
+	if familiarity.Logins == 0 {
+		return event, nil
+	}
+	if !familiarity.KnownDevice {
+		event.Flags = append(event.Flags, domain.LoginFlagNewDevice)
+	}
+	if !familiarity.KnownNetwork {
+		event.Flags = append(event.Flags, domain.LoginFlagNewNetwork)
+	}
+
+	if event.Latitude != nil {
+		previous, err := s.loginRepo.ListLogins(ctx, userID, 1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get previous login: %w", err)
+		}
+		if len(previous) == 1 && s.impossibleTravel(&previous[0], event, time.Now()) {
+			event.Flags = append(event.Flags, domain.LoginFlagImpossibleTravel)
+		}
+	}
+	return event, nil
+}
+
+// newLoginEvent describes the client's login, locating its IP if a GeoIP database is configured.
+func (s *authService) newLoginEvent(userID int64, client ClientInfo) *domain.LoginEvent {
+	device := client.DeviceID
+	if device == "" {
+		device = "ua:" + client.UserAgent
+	}
+	event := &domain.LoginEvent{
+		UserID:     userID,
+		IPAddress:  client.IP,
+		Network:    ipNetwork(client.IP),
+		DeviceHash: hashNonce(device),
+		UserAgent:  client.UserAgent,
+		Flags:      []string{},
+	}
+	if s.locator != nil {
+		if loc, ok := s.locator.Lookup(net.ParseIP(client.IP)); ok {
+			event.Country, event.City = loc.Country, loc.City
+			event.Latitude, event.Longitude = &loc.Latitude, &loc.Longitude
+		}
+	}
+	return event
+}
+
+// impossibleTravel reports whether getting from the previous login's location to the
+// current one in the time between them would have needed an implausible speed.
+func (s *authService) impossibleTravel(previous, current *domain.LoginEvent, now time.Time) bool {
+	if previous.Latitude == nil || previous.Longitude == nil || current.Latitude == nil || current.Longitude == nil {
+		return false
+	}
+	km := distanceKm(*previous.Latitude, *previous.Longitude, *current.Latitude, *current.Longitude)
+	if km < s.cfg.ImpossibleTravelMinDistance {
+		return false
+	}
+	hours := math.Max(now.Sub(previous.CreatedAt).Hours(), 1.0/60) // At least a minute, against clock skew
+	return km/hours > s.cfg.ImpossibleTravelSpeed
+}
+
+// distanceKm returns the great-circle distance between two coordinates (haversine formula).
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(lat2-lat1), rad(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// ipNetwork returns the IP's /24 (IPv4) or /48 (IPv6), roughly one site or customer.
+func ipNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// recordLogin stores a completed login and tells the user about suspicious ones.
+func (s *authService) recordLogin(ctx context.Context, user *domain.User, event *domain.LoginEvent) error {
+	if _, err := s.loginRepo.RecordLogin(ctx, event); err != nil {
+		return fmt.Errorf("failed to record login: %w", err)
+	}
+	if !event.Suspicious() {
+		return nil
+	}
+
+	slog.WarnContext(ctx, "Suspicious login", "user_id", user.ID, "flags", event.Flags, "ip", event.IPAddress, "country", event.Country)
+	if !s.cfg.NewDeviceNotifications {
+		return nil
+	}
+	err := s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf("Your account was just signed in to from a device or place we haven't seen before.\n\n%s\n"+
+			"If this was you, you can ignore this email. If not, change your password right away.\n", describeLogin(event)),
+	})
+	if err != nil {
+		// The login itself succeeded; failing it now would only lock the user out
+		slog.ErrorContext(ctx, "Error sending new sign-in notification", "user_id", user.ID, "error", err)
+	}
+	return nil
+}
+
+// requireSecondFactor emails a one-time code and returns the error asking for it.
+func (s *authService) requireSecondFactor(ctx context.Context, user *domain.User, event *domain.LoginEvent) error {
+	code, err := randomDigits(6)
+	if err != nil {
+		return fmt.Errorf("failed to generate login code: %w", err)
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	tokenID := hex.EncodeToString(b)
+
+	now := time.Now()
+	expiresAt := now.Add(s.cfg.LoginCodeTTL)
+	claims := &loginCodeClaims{
+		CodeMAC: s.loginCodeMAC(tokenID, code),
+		Flags:   event.Flags,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.derivedKey("login-code"))
+	if err != nil {
+		return fmt.Errorf("failed to sign second-factor token: %w", err)
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in code",
+		Body: fmt.Sprintf("Someone is signing in to your account from a device or place we haven't seen before.\n\n%s\n"+
+			"If this is you, enter this code to finish signing in. It expires in %s.\n\n    %s\n\n"+
+			"If this isn't you, your password is known to someone else: change it right away.\n",
+			describeLogin(event), s.cfg.LoginCodeTTL, code),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send login code: %w", err)
+	}
+	slog.WarnContext(ctx, "Suspicious login held for second factor", "user_id", user.ID, "flags", event.Flags, "ip", event.IPAddress)
+	return &SecondFactorRequiredError{Token: token, Methods: []string{SecondFactorEmailCode}, ExpiresAt: expiresAt}
+}
+
+// VerifyLoginCode checks the emailed code and finishes the login. Each token allows a
+// few attempts and a single success.
+func (s *authService) VerifyLoginCode(ctx context.Context, secondFactorToken, code string, client ClientInfo) (string, error) {
+	claims := &loginCodeClaims{}
+	_, err := jwt.ParseWithClaims(secondFactorToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.derivedKey("login-code"), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", ErrInvalidLoginCode
+	}
+	if !s.loginCodeLimiter.Allow(claims.ID) {
+		return "", ErrInvalidLoginCode
+	}
+	expected := s.loginCodeMAC(claims.ID, strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(claims.CodeMAC)) != 1 {
+		return "", ErrInvalidLoginCode
+	}
+	if !s.loginCodeSeen.markUsed(claims.ID, claims.ExpiresAt.Time) {
+		return "", ErrInvalidLoginCode
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return "", ErrInvalidLoginCode
+	}
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+
+	// The user has confirmed this device, so it's recorded as known without another email
+	event := s.newLoginEvent(user.ID, client)
+	event.Flags = claims.Flags
+	if _, err := s.loginRepo.RecordLogin(ctx, event); err != nil {
+		return "", fmt.Errorf("failed to record login: %w", err)
+	}
+	return s.generateDefaultToken(ctx, user, AMRPassword, AMRLoginCode)
+}
+
+// ListLoginHistory lists the user's recent logins.
+func (s *authService) ListLoginHistory(ctx context.Context, userID int64, limit int) ([]domain.LoginEvent, error) {
+	return s.loginRepo.ListLogins(ctx, userID, limit)
+}
+
+func (s *authService) loginCodeMAC(tokenID, code string) string {
+	mac := hmac.New(sha256.New, s.derivedKey("login-code-mac"))
+	mac.Write([]byte(tokenID + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// describeLogin summarizes a login for emails.
+func describeLogin(event *domain.LoginEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Time: %s\n", time.Now().UTC().Format(time.RFC1123))
+	fmt.Fprintf(&b, "IP address: %s\n", event.IPAddress)
+	if event.Country != "" {
+		location := event.Country
+		if event.City != "" {
+			location = event.City + ", " + event.Country
+		}
+		fmt.Fprintf(&b, "Approximate location: %s\n", location)
+	}
+	if event.UserAgent != "" {
+		fmt.Fprintf(&b, "Device: %s\n", event.UserAgent)
+	}
+	return b.String()
+}
+
+// randomDigits returns n uniformly random decimal digits.
+func randomDigits(n int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, v), nil
+}
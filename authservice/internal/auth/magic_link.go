@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"authservice/internal/domain"
+	"authservice/internal/mailer"
+	"authservice/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrRateLimited is returned when a caller exceeds a request limit.
+	ErrRateLimited = errors.New("too many requests")
+	// ErrInvalidMagicLink is returned for tampered, expired, reused or foreign-browser links.
+	ErrInvalidMagicLink = errors.New("invalid or expired magic link")
+)
+
+// magicLinkClaims are the claims of the token embedded in a magic link.
+// The link ID is carried in the standard "jti" claim.
+type magicLinkClaims struct {
+	NonceHash string `json:"nonce_hash"`
+	jwt.RegisteredClaims
+}
+
+// RequestMagicLink emails a single-use login link to the user. The link only works
+// in the browser holding nonce. Unknown emails are silently ignored so the endpoint
+// can't be used to discover accounts.
+func (s *authService) RequestMagicLink(ctx context.Context, email, nonce string) error {
+	if !s.magicLinkLimiter.Allow(strings.ToLower(email)) {
+		return ErrRateLimited
+	}
+
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if !user.IsLocal() {
+		return nil // Directory accounts sign in with their directory password only
+	}
+
+	nonceHash := hashNonce(nonce)
+	expiresAt := time.Now().Add(s.cfg.MagicLinkTTL)
+	linkID, err := s.magicLinkRepo.CreateMagicLink(ctx, &domain.MagicLink{
+		UserID:    user.ID,
+		NonceHash: nonceHash,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create magic link: %w", err)
+	}
+
+	claims := &magicLinkClaims{
+		NonceHash: nonceHash,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        strconv.FormatInt(linkID, 10),
+			Subject:   strconv.FormatInt(user.ID, 10),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "authservice",
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.derivedKey("magic-link"))
+	if err != nil {
+		return fmt.Errorf("failed to sign magic link: %w", err)
+	}
+
+	link := s.cfg.MagicLinkBaseURL + "?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Click the link below to sign in. It expires in %s and can only be used once, "+
+			"in the browser where you requested it.\n\n%s\n\nIf you didn't request this, you can ignore this email.\n",
+			s.cfg.MagicLinkTTL, link),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send magic link: %w", err)
+	}
+	return nil
+}
+
+// VerifyMagicLink redeems a magic link and returns an access token, exactly like Login.
+func (s *authService) VerifyMagicLink(ctx context.Context, token, nonce string) (string, error) {
+	claims := &magicLinkClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.derivedKey("magic-link"), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return "", ErrInvalidMagicLink
+	}
+
+	// Check the browser binding before consuming, so a prefetching mail scanner
+	// (which has no nonce cookie) can't burn the link.
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(hashNonce(nonce)), []byte(claims.NonceHash)) != 1 {
+		return "", ErrInvalidMagicLink
+	}
+
+	linkID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return "", ErrInvalidMagicLink
+	}
+	link, err := s.magicLinkRepo.ConsumeMagicLink(ctx, linkID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMagicLinkNotFound) {
+			return "", ErrInvalidMagicLink
+		}
+		return "", fmt.Errorf("failed to consume magic link: %w", err)
+	}
+	if link.NonceHash != claims.NonceHash {
+		return "", ErrInvalidMagicLink
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, link.UserID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+	return s.generateDefaultToken(ctx, user, AMRMagicLink)
+}
+
+// derivedKey returns a signing key for a single purpose, so tokens minted for one
+// purpose (e.g. magic links) can never be accepted as access tokens.
+func (s *authService) derivedKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(s.cfg.JWTSecret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"authservice/internal/config"
+	"authservice/internal/domain"
+	"authservice/internal/repository"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// ErrInvalidPasskey is returned when a passkey ceremony can't be completed: unknown or
+// expired ceremony, failed verification, unknown credential or a suspected clone.
+var ErrInvalidPasskey = errors.New("invalid passkey response")
+
+// Ceremony purposes, so a ceremony started for one flow can't be finished in another.
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	ceremonyReauth       = "reauth"
+)
+
+// ceremony is the stored state of a WebAuthn ceremony.
+type ceremony struct {
+	Purpose string               `json:"purpose"`
+	Session webauthn.SessionData `json:"session"`
+}
+
+// NewWebAuthn creates the WebAuthn relying party from the configuration.
+func NewWebAuthn(cfg *config.Config) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     cfg.WebAuthnOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.WebAuthnCeremonyTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.WebAuthnCeremonyTTL},
+		},
+	})
+}
+
+// passkeyUser adapts a user and their passkeys to webauthn.User.
+type passkeyUser struct {
+	user     *domain.User
+	passkeys []domain.Passkey
+}
+
+func (u *passkeyUser) WebAuthnID() []byte          { return u.user.WebAuthnHandle }
+func (u *passkeyUser) WebAuthnName() string        { return u.user.Email }
+func (u *passkeyUser) WebAuthnDisplayName() string { return u.user.Email }
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, 0, len(u.passkeys))
+	for _, p := range u.passkeys {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(p.Transports))
+		for _, t := range p.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		creds = append(creds, webauthn.Credential{
+			ID:              p.CredentialID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: p.BackupEligible,
+				BackupState:    p.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:       p.AAGUID,
+				SignCount:    p.SignCount,
+				CloneWarning: p.CloneWarning,
+			},
+		})
+	}
+	return creds
+}
+
+// loadPasskeyUser loads the user with their passkeys.
+func (s *authService) loadPasskeyUser(ctx context.Context, user *domain.User) (*passkeyUser, error) {
+	passkeys, err := s.passkeyRepo.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	return &passkeyUser{user: user, passkeys: passkeys}, nil
+}
+
+// BeginPasskeyRegistration starts registering a new passkey for the user. It returns the
+// options for navigator.credentials.create() and the ceremony ID to finish with.
+func (s *authService) BeginPasskeyRegistration(ctx context.Context, userID int64) (*protocol.CredentialCreation, string, error) {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.WebAuthnHandle == nil {
+		handle := make([]byte, 32)
+		if _, err := rand.Read(handle); err != nil {
+			return nil, "", fmt.Errorf("failed to generate user handle: %w", err)
+		}
+		if user.WebAuthnHandle, err = s.userRepo.SetWebAuthnHandle(ctx, user.ID, handle); err != nil {
+			return nil, "", fmt.Errorf("failed to set user handle: %w", err)
+		}
+	}
+
+	pu, err := s.loadPasskeyUser(ctx, user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Exclude existing credentials so the same authenticator isn't registered twice,
+	// and ask for a discoverable credential so it can be used without an email.
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(pu.passkeys))
+	for _, c := range pu.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+	creation, session, err := s.webAuthn.BeginRegistration(pu,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin registration: %w", err)
+	}
+
+	ceremonyID, err := s.saveCeremony(ctx, user.ID, ceremonyRegistration, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, ceremonyID, nil
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and stores the new passkey.
+func (s *authService) FinishPasskeyRegistration(ctx context.Context, userID int64, ceremonyID, name string, response *protocol.ParsedCredentialCreationData) (*domain.Passkey, error) {
+	session, err := s.consumeCeremony(ctx, ceremonyID, userID, ceremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	pu, err := s.loadPasskeyUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := s.webAuthn.CreateCredential(pu, *session, response)
+	if err != nil {
+		slog.InfoContext(ctx, "Passkey registration rejected", "error", err)
+		return nil, ErrInvalidPasskey
+	}
+
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+	passkey := &domain.Passkey{
+		UserID:          user.ID,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      transports,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+		Name:            name,
+	}
+	if _, err := s.passkeyRepo.CreatePasskey(ctx, passkey); err != nil {
+		if errors.Is(err, repository.ErrPasskeyExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to store passkey: %w", err)
+	}
+	return passkey, nil
+}
+
+// BeginPasskeyLogin starts a discoverable-credential login: the authenticator picks the
+// account, so no email is needed.
+func (s *authService) BeginPasskeyLogin(ctx context.Context) (*protocol.CredentialAssertion, string, error) {
+	assertion, session, err := s.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin login: %w", err)
+	}
+
+	ceremonyID, err := s.saveCeremony(ctx, 0, ceremonyLogin, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, ceremonyID, nil
+}
+
+// FinishPasskeyLogin verifies the assertion and returns an access token, exactly like Login.
+func (s *authService) FinishPasskeyLogin(ctx context.Context, ceremonyID string, response *protocol.ParsedCredentialAssertionData) (string, error) {
+	session, err := s.consumeCeremony(ctx, ceremonyID, 0, ceremonyLogin)
+	if err != nil {
+		return "", err
+	}
+
+	var pu *passkeyUser
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+		user, err := s.userRepo.GetUserByWebAuthnHandle(ctx, userHandle)
+		if err != nil {
+			return nil, err
+		}
+		if !user.IsLocal() {
+			return nil, ErrInvalidPasskey // Directory accounts sign in with their directory password only
+		}
+		pu, err = s.loadPasskeyUser(ctx, user)
+		return pu, err
+	}
+	cred, err := s.webAuthn.ValidateDiscoverableLogin(lookup, *session, response)
+	if err != nil {
+		slog.InfoContext(ctx, "Passkey login rejected", "error", err)
+		return "", ErrInvalidPasskey
+	}
+
+	if err := s.recordPasskeyUse(ctx, pu, cred, response); err != nil {
+		return "", err
+	}
+	return s.generateDefaultToken(ctx, pu.user, AMRPasskey)
+}
+
+// recordPasskeyUse stores the new sign count of the passkey that produced the assertion
+// and rejects the assertion if the count suggests a cloned authenticator.
+func (s *authService) recordPasskeyUse(ctx context.Context, pu *passkeyUser, cred *webauthn.Credential, response *protocol.ParsedCredentialAssertionData) error {
+	var passkey *domain.Passkey
+	for i := range pu.passkeys {
+		if bytes.Equal(pu.passkeys[i].CredentialID, cred.ID) {
+			passkey = &pu.passkeys[i]
+			break
+		}
+	}
+	if passkey == nil {
+		return ErrInvalidPasskey
+	}
+
+	if err := s.passkeyRepo.UpdatePasskeyUsage(ctx, passkey.ID, cred.Authenticator.SignCount, cred.Authenticator.CloneWarning, cred.Flags.BackupState); err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+	// A sign count that didn't increase means two copies of the private key may exist
+	if cred.Authenticator.CloneWarning {
+		slog.WarnContext(ctx, "Passkey sign count did not increase, possible cloned authenticator",
+			"user_id", pu.user.ID,
+			"passkey_id", passkey.ID,
+			"stored_sign_count", passkey.SignCount,
+			"received_sign_count", response.Response.AuthenticatorData.Counter,
+		)
+		return ErrInvalidPasskey
+	}
+	return nil
+}
+
+// ListPasskeys lists the user's passkeys.
+func (s *authService) ListPasskeys(ctx context.Context, userID int64) ([]domain.Passkey, error) {
+	return s.passkeyRepo.ListPasskeys(ctx, userID)
+}
+
+// DeletePasskey removes one of the user's passkeys.
+func (s *authService) DeletePasskey(ctx context.Context, userID, passkeyID int64) error {
+	return s.passkeyRepo.DeletePasskey(ctx, userID, passkeyID)
+}
+
+// RemovePassword turns the account into a passkey-only account.
+func (s *authService) RemovePassword(ctx context.Context, userID int64) error {
+	return s.userRepo.RemovePassword(ctx, userID)
+}
+
+// saveCeremony stores the ceremony state and returns its ID.
+func (s *authService) saveCeremony(ctx context.Context, userID int64, purpose string, session *webauthn.SessionData) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ceremony ID: %w", err)
+	}
+	ceremonyID := base64.RawURLEncoding.EncodeToString(b)
+
+	data, err := json.Marshal(ceremony{Purpose: purpose, Session: *session})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode ceremony: %w", err)
+	}
+	if err := s.passkeyRepo.CreateCeremony(ctx, ceremonyID, userID, data, time.Now().Add(s.cfg.WebAuthnCeremonyTTL)); err != nil {
+		return "", fmt.Errorf("failed to store ceremony: %w", err)
+	}
+	return ceremonyID, nil
+}
+
+// consumeCeremony loads and deletes a ceremony, checking it was started by userID
+// (0 for login ceremonies) for purpose.
+func (s *authService) consumeCeremony(ctx context.Context, ceremonyID string, userID int64, purpose string) (*webauthn.SessionData, error) {
+	ownerID, data, err := s.passkeyRepo.ConsumeCeremony(ctx, ceremonyID)
+	if err != nil {
+		if errors.Is(err, repository.ErrCeremonyNotFound) {
+			return nil, ErrInvalidPasskey
+		}
+		return nil, fmt.Errorf("failed to load ceremony: %w", err)
+	}
+
+	var c ceremony
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to decode ceremony: %w", err)
+	}
+	if ownerID != userID || c.Purpose != purpose {
+		return nil, ErrInvalidPasskey
+	}
+	return &c.Session, nil
+}
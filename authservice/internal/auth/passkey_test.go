@@ -0,0 +1,452 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"authservice/internal/domain"
+	"authservice/internal/repository"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// softAuthenticator is a software WebAuthn authenticator holding one discoverable
+// P-256 credential. It answers ceremonies the way a browser and platform
+// authenticator would, with "none" attestation and user verification.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credentialID: id, origin: testOrigin}
+}
+
+// Authenticator data flags (WebAuthn §6.1).
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+func (a *softAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(ceremonyType string, challenge protocol.URLEncodedBase64) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge.String(),
+		"origin":    a.origin,
+	})
+	return data
+}
+
+// create answers navigator.credentials.create() and returns the JSON the browser would post.
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	t.Helper()
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(struct {
+		Format       string         `cbor:"fmt"`
+		Statement    map[string]any `cbor:"attStmt"`
+		RawAuthnData []byte         `cbor:"authData"`
+	}{"none", map[string]any{}, a.authenticatorData(flagUserPresent|flagUserVerified|flagAttestedData, attested)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(map[string]any{
+		"clientDataJSON":    b64(a.clientData("webauthn.create", options.Response.Challenge)),
+		"attestationObject": b64(attestation),
+		"transports":        []string{"internal"},
+	})
+}
+
+// get answers navigator.credentials.get() and returns the JSON the browser would post.
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	t.Helper()
+	a.signCount++
+	authData := a.authenticatorData(flagUserPresent|flagUserVerified, nil)
+	clientData := a.clientData("webauthn.get", options.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(slices.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(map[string]any{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) credential(response map[string]any) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	return data
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// memoryPasskeys implements PasskeyRepository in memory.
+type memoryPasskeys struct {
+	mu         sync.Mutex
+	passkeys   []domain.Passkey
+	ceremonies map[string]memoryCeremony
+}
+
+type memoryCeremony struct {
+	userID    int64
+	data      []byte
+	expiresAt time.Time
+}
+
+func (r *memoryPasskeys) CreatePasskey(_ context.Context, p *domain.Passkey) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.passkeys {
+		if bytes.Equal(existing.CredentialID, p.CredentialID) {
+			return 0, repository.ErrPasskeyExists
+		}
+	}
+	p.ID = int64(len(r.passkeys) + 1)
+	p.CreatedAt = time.Now()
+	r.passkeys = append(r.passkeys, *p)
+	return p.ID, nil
+}
+
+func (r *memoryPasskeys) ListPasskeys(_ context.Context, userID int64) ([]domain.Passkey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var passkeys []domain.Passkey
+	for _, p := range r.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (r *memoryPasskeys) UpdatePasskeyUsage(_ context.Context, id int64, signCount uint32, cloneWarning, backupState bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.passkeys {
+		if r.passkeys[i].ID == id {
+			now := time.Now()
+			r.passkeys[i].SignCount = signCount
+			r.passkeys[i].CloneWarning = r.passkeys[i].CloneWarning || cloneWarning
+			r.passkeys[i].BackupState = backupState
+			r.passkeys[i].LastUsedAt = &now
+			return nil
+		}
+	}
+	return repository.ErrPasskeyNotFound
+}
+
+func (r *memoryPasskeys) DeletePasskey(_ context.Context, userID, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.passkeys {
+		if p.ID == id && p.UserID == userID {
+			r.passkeys = slices.Delete(r.passkeys, i, i+1)
+			return nil
+		}
+	}
+	return repository.ErrPasskeyNotFound
+}
+
+func (r *memoryPasskeys) CreateCeremony(_ context.Context, id string, userID int64, data []byte, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ceremonies == nil {
+		r.ceremonies = make(map[string]memoryCeremony)
+	}
+	r.ceremonies[id] = memoryCeremony{userID: userID, data: data, expiresAt: expiresAt}
+	return nil
+}
+
+func (r *memoryPasskeys) ConsumeCeremony(_ context.Context, id string) (int64, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.ceremonies[id]
+	delete(r.ceremonies, id)
+	if !ok || time.Now().After(c.expiresAt) {
+		return 0, nil, repository.ErrCeremonyNotFound
+	}
+	return c.userID, c.data, nil
+}
+
+// newPasskeyTestService returns a service with user 1 (alice, with a password) and
+// user 2 (bob, a directory account).
+func newPasskeyTestService(t *testing.T) (*authService, *memoryPasskeys) {
+	t.Helper()
+	cfg := newTestConfig()
+	webAuthn, err := NewWebAuthn(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey, err := LoadSigningKey(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := &memoryUsers{users: map[int64]*domain.User{
+		1: {ID: 1, Email: "alice@example.com", Password: "hash", Role: domain.RoleUser, AuthSource: domain.AuthSourceLocal},
+		2: {ID: 2, Email: "bob@example.com", Role: domain.RoleSupport, AuthSource: domain.AuthSourceLDAP},
+	}}
+	passkeys := &memoryPasskeys{}
+	svc := NewAuthService(users, nil, noMemberships{}, nil, passkeys, nil, nil, nil, webAuthn, signingKey, nil, nil, cfg)
+	return svc.(*authService), passkeys
+}
+
+// registerPasskey runs a registration ceremony for userID with the authenticator.
+func registerPasskey(t *testing.T, svc *authService, userID int64, authr *softAuthenticator) *domain.Passkey {
+	t.Helper()
+	ctx := context.Background()
+	creation, ceremonyID, err := svc.BeginPasskeyRegistration(ctx, userID)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	response, err := protocol.ParseCredentialCreationResponseBytes(authr.create(t, creation))
+	if err != nil {
+		t.Fatalf("parse creation response: %v", err)
+	}
+	passkey, err := svc.FinishPasskeyRegistration(ctx, userID, ceremonyID, "Laptop", response)
+	if err != nil {
+		t.Fatalf("FinishPasskeyRegistration: %v", err)
+	}
+	return passkey
+}
+
+// loginWithPasskey runs a discoverable login ceremony with the authenticator.
+func loginWithPasskey(t *testing.T, svc *authService, authr *softAuthenticator) (string, error) {
+	t.Helper()
+	ctx := context.Background()
+	assertion, ceremonyID, err := svc.BeginPasskeyLogin(ctx)
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	if len(assertion.Response.AllowedCredentials) != 0 {
+		t.Fatalf("discoverable login lists %d credentials, want none", len(assertion.Response.AllowedCredentials))
+	}
+	response, err := protocol.ParseCredentialRequestResponseBytes(authr.get(t, assertion))
+	if err != nil {
+		t.Fatalf("parse assertion response: %v", err)
+	}
+	return svc.FinishPasskeyLogin(ctx, ceremonyID, response)
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	svc, _ := newPasskeyTestService(t)
+	authr := newSoftAuthenticator(t)
+
+	passkey := registerPasskey(t, svc, 1, authr)
+	if !bytes.Equal(passkey.CredentialID, authr.credentialID) {
+		t.Errorf("stored credential ID %x, want %x", passkey.CredentialID, authr.credentialID)
+	}
+	if passkey.Name != "Laptop" || passkey.UserID != 1 {
+		t.Errorf("stored passkey %+v", passkey)
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := loginWithPasskey(t, svc, authr)
+		if err != nil {
+			t.Fatalf("login %d: %v", i+1, err)
+		}
+		claims, err := svc.VerifyToken(token)
+		if err != nil {
+			t.Fatalf("VerifyToken: %v", err)
+		}
+		if claims.UserID != 1 {
+			t.Errorf("token is for user %d, want 1", claims.UserID)
+		}
+		if !slices.Equal(claims.AMR, []string{AMRPasskey}) || claims.AuthTime == nil {
+			t.Errorf("token amr %v, auth_time %v; want [%s] and a time", claims.AMR, claims.AuthTime, AMRPasskey)
+		}
+	}
+}
+
+func TestPasskeyRegistrationExcludesExistingCredentials(t *testing.T) {
+	svc, _ := newPasskeyTestService(t)
+	authr := newSoftAuthenticator(t)
+	registerPasskey(t, svc, 1, authr)
+
+	creation, _, err := svc.BeginPasskeyRegistration(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	excluded := creation.Response.CredentialExcludeList
+	if len(excluded) != 1 || !bytes.Equal(excluded[0].CredentialID, authr.credentialID) {
+		t.Errorf("exclude list %+v, want the registered credential", excluded)
+	}
+	if creation.Response.AuthenticatorSelection.ResidentKey != protocol.ResidentKeyRequirementRequired {
+		t.Errorf("resident key %q, want required", creation.Response.AuthenticatorSelection.ResidentKey)
+	}
+}
+
+func TestPasskeyRegistrationRejectsWrongOrigin(t *testing.T) {
+	svc, passkeys := newPasskeyTestService(t)
+	authr := newSoftAuthenticator(t)
+	authr.origin = "https://phishing.example"
+
+	ctx := context.Background()
+	creation, ceremonyID, err := svc.BeginPasskeyRegistration(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := protocol.ParseCredentialCreationResponseBytes(authr.create(t, creation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.FinishPasskeyRegistration(ctx, 1, ceremonyID, "Laptop", response); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("FinishPasskeyRegistration = %v, want ErrInvalidPasskey", err)
+	}
+	if len(passkeys.passkeys) != 0 {
+		t.Errorf("%d passkeys stored, want none", len(passkeys.passkeys))
+	}
+}
+
+func TestPasskeyCeremonyIsSingleUseAndBoundToUser(t *testing.T) {
+	svc, _ := newPasskeyTestService(t)
+	authr := newSoftAuthenticator(t)
+	ctx := context.Background()
+
+	creation, ceremonyID, err := svc.BeginPasskeyRegistration(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := protocol.ParseCredentialCreationResponseBytes(authr.create(t, creation))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another user can't finish alice's ceremony, and trying consumes it
+	if _, err := svc.FinishPasskeyRegistration(ctx, 2, ceremonyID, "Laptop", response); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("finish as another user = %v, want ErrInvalidPasskey", err)
+	}
+	if _, err := svc.FinishPasskeyRegistration(ctx, 1, ceremonyID, "Laptop", response); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("finish a consumed ceremony = %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestPasskeyLoginRejectsRegistrationCeremony(t *testing.T) {
+	svc, _ := newPasskeyTestService(t)
+	authr := newSoftAuthenticator(t)
+	registerPasskey(t, svc, 1, authr)
+	ctx := context.Background()
+
+	// A login assertion posted against a registration ceremony must not sign in
+	_, registrationID, err := svc.BeginPasskeyRegistration(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion, _, err := svc.BeginPasskeyLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := protocol.ParseCredentialRequestResponseBytes(authr.get(t, assertion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.FinishPasskeyLogin(ctx, registrationID, response); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("FinishPasskeyLogin = %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestPasskeyLoginRejectsUnknownCredential(t *testing.T) {
+	svc, _ := newPasskeyTestService(t)
+	registerPasskey(t, svc, 1, newSoftAuthenticator(t))
+
+	// Same user handle, but a key the service has never seen
+	stranger := newSoftAuthenticator(t)
+	user, _ := svc.userRepo.GetUserByID(context.Background(), 1)
+	stranger.userHandle = user.WebAuthnHandle
+	if _, err := loginWithPasskey(t, svc, stranger); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("login = %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestPasskeyLoginDetectsClonedAuthenticator(t *testing.T) {
+	svc, passkeys := newPasskeyTestService(t)
+	authr := newSoftAuthenticator(t)
+	registerPasskey(t, svc, 1, authr)
+
+	authr.signCount = 10
+	if _, err := loginWithPasskey(t, svc, authr); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	// A copy of the key whose counter lags behind the original
+	clone := *authr
+	clone.signCount = 3
+	if _, err := loginWithPasskey(t, svc, &clone); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("login with clone = %v, want ErrInvalidPasskey", err)
+	}
+	stored, _ := passkeys.ListPasskeys(context.Background(), 1)
+	if !stored[0].CloneWarning {
+		t.Error("passkey not flagged as possibly cloned")
+	}
+}
+
+func TestPasskeyLoginRejectsDirectoryAccounts(t *testing.T) {
+	svc, _ := newPasskeyTestService(t)
+	authr := newSoftAuthenticator(t)
+	registerPasskey(t, svc, 2, authr)
+
+	if _, err := loginWithPasskey(t, svc, authr); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("login = %v, want ErrInvalidPasskey", err)
+	}
+}
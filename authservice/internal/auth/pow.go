@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrProofOfWorkRequired is returned when proof of work is enabled and the request carries none.
+	ErrProofOfWorkRequired = errors.New("proof of work required")
+	// ErrInvalidProofOfWork is returned for tampered, expired, reused, foreign-IP, too easy
+	// or wrong solutions.
+	ErrInvalidProofOfWork = errors.New("invalid proof of work")
+)
+
+// PoWAlgorithm names the puzzle: find a solution such that
+// sha256(challenge + ":" + solution) starts with difficulty zero bits.
+const PoWAlgorithm = "sha256-leading-zero-bits"
+
+// maxPoWSolutionLength bounds the solution so verification stays cheap.
+const maxPoWSolutionLength = 64
+
+// Challenge is a signed proof-of-work puzzle.
+type Challenge struct {
+	Challenge  string    `json:"challenge"`
+	Algorithm  string    `json:"algorithm"`
+	Difficulty int       `json:"difficulty"` // Leading zero bits required
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ProofOfWork is a solved challenge sent with a registration or login. It's ignored
+// when proof of work is disabled.
+type ProofOfWork struct {
+	Challenge string
+	Solution  string
+}
+
+// powClaims are the claims of a challenge token. The challenge ID is carried in the
+// standard "jti" claim.
+type powClaims struct {
+	Difficulty int    `json:"difficulty"`
+	IPHash     string `json:"ip_hash"` // Challenges only work from the IP they were issued to
+	jwt.RegisteredClaims
+}
+
+// IssueChallenge creates a puzzle for the client. IPs that have made too many
+// registration or login attempts get harder puzzles until their window resets.
+func (s *authService) IssueChallenge(ctx context.Context, clientIP string) (*Challenge, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge ID: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.cfg.PoWChallengeTTL)
+	difficulty := s.powDifficulty(clientIP)
+	claims := &powClaims{
+		Difficulty: difficulty,
+		IPHash:     hashNonce(clientIP),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(b),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.derivedKey("proof-of-work"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign challenge: %w", err)
+	}
+	return &Challenge{Challenge: token, Algorithm: PoWAlgorithm, Difficulty: difficulty, ExpiresAt: expiresAt}, nil
+}
+
+// checkProofOfWork verifies the solution, if proof of work is enabled. It runs before
+// any database or bcrypt work, so unsolved requests are cheap to reject. Every attempt
+// counts towards the client IP's limit, whether or not the solution is valid.
+func (s *authService) checkProofOfWork(ctx context.Context, clientIP string, proof ProofOfWork) error {
+	if !s.cfg.PoWEnabled {
+		return nil
+	}
+	required := s.powDifficulty(clientIP)
+	if !s.powLimiter.Allow(clientIP) && required < s.cfg.PoWRaisedDifficulty {
+		slog.WarnContext(ctx, "Raising proof-of-work difficulty for client", "client_ip", clientIP)
+	}
+
+	if proof.Challenge == "" || proof.Solution == "" {
+		return ErrProofOfWorkRequired
+	}
+	if len(proof.Solution) > maxPoWSolutionLength {
+		return ErrInvalidProofOfWork
+	}
+
+	claims := &powClaims{}
+	_, err := jwt.ParseWithClaims(proof.Challenge, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.derivedKey("proof-of-work"), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return ErrInvalidProofOfWork
+	}
+	// A challenge issued before the IP's difficulty was raised no longer counts
+	if claims.IPHash != hashNonce(clientIP) || claims.Difficulty < required {
+		return ErrInvalidProofOfWork
+	}
+
+	sum := sha256.Sum256([]byte(proof.Challenge + ":" + proof.Solution))
+	if leadingZeroBits(sum[:]) < claims.Difficulty {
+		return ErrInvalidProofOfWork
+	}
+	if !s.powSeen.markUsed(claims.ID, claims.ExpiresAt.Time) {
+		return ErrInvalidProofOfWork
+	}
+	return nil
+}
+
+// powDifficulty returns the difficulty required from the client IP.
+func (s *authService) powDifficulty(clientIP string) int {
+	if s.powLimiter.Exceeded(clientIP) {
+		return s.cfg.PoWRaisedDifficulty
+	}
+	return s.cfg.PoWDifficulty
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
+
+// usedChallenges remembers redeemed challenge IDs until they expire, so each solution
+// works once. It is in-memory, so with several replicas a solution can be replayed
+// once per replica.
+type usedChallenges struct {
+	mu  sync.Mutex
+	ids map[string]time.Time // Challenge ID to expiry
+}
+
+func newUsedChallenges() *usedChallenges {
+	return &usedChallenges{ids: make(map[string]time.Time)}
+}
+
+// markUsed records the challenge ID and reports whether it was unused.
+func (u *usedChallenges) markUsed(id string, expiresAt time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	if len(u.ids) >= 1024 {
+		for id, exp := range u.ids {
+			if now.After(exp) {
+				delete(u.ids, id)
+			}
+		}
+	}
+	if _, ok := u.ids[id]; ok {
+		return false
+	}
+	u.ids[id] = expiresAt
+	return true
+}
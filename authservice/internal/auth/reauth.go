@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"authservice/internal/domain"
+	"authservice/internal/repository"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Authentication methods for the amr claim (RFC 8176 values where one exists).
+const (
+	AMRPassword  = "pwd"
+	AMRPasskey   = "hwk" // Proof of possession of a hardware-bound key
+	AMRMagicLink = "email"
+	AMRLoginCode = "otp" // One-time code emailed for a suspicious login
+)
+
+// Assurance levels for the acr claim, after NIST SP 800-63B.
+const (
+	ACRSingleFactor = "aal1"
+	ACRMultiFactor  = "aal2" // Passkeys with user verification, or a password and a login code
+)
+
+// ErrReauthNotAllowed is returned when the token can't be upgraded, e.g. during impersonation.
+var ErrReauthNotAllowed = errors.New("re-authentication not allowed for this token")
+
+// setAuthentication records that the user authenticated at t using methods.
+func (c *Claims) setAuthentication(t time.Time, methods []string) {
+	c.AuthTime = jwt.NewNumericDate(t)
+	c.AMR = methods
+	c.ACR = ACRSingleFactor
+	if slices.Contains(methods, AMRPasskey) || (slices.Contains(methods, AMRPassword) && slices.Contains(methods, AMRLoginCode)) {
+		c.ACR = ACRMultiFactor
+	}
+}
+
+// AuthenticatedWithin reports whether the user proved their identity no longer than maxAge ago.
+func (c *Claims) AuthenticatedWithin(maxAge time.Duration) bool {
+	return c.AuthTime != nil && time.Since(c.AuthTime.Time) <= maxAge
+}
+
+// Reauthenticate checks the user's password again and returns a token for the same
+// session with a fresh auth_time.
+func (s *authService) Reauthenticate(ctx context.Context, current *Claims, password string) (string, error) {
+	if current.Act != nil {
+		return "", ErrReauthNotAllowed
+	}
+	user, err := s.userRepo.GetUserByID(ctx, current.UserID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+	user, err = s.checkPassword(ctx, user.Email, password, user)
+	if err != nil {
+		return "", err
+	}
+	return s.upgradeToken(ctx, user, current, AMRPassword)
+}
+
+// BeginPasskeyReauth starts a passkey assertion limited to the current user's passkeys.
+func (s *authService) BeginPasskeyReauth(ctx context.Context, userID int64) (*protocol.CredentialAssertion, string, error) {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get user: %w", err)
+	}
+	pu, err := s.loadPasskeyUser(ctx, user)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(pu.passkeys) == 0 {
+		return nil, "", ErrInvalidPasskey
+	}
+
+	assertion, session, err := s.webAuthn.BeginLogin(pu, webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin re-authentication: %w", err)
+	}
+	ceremonyID, err := s.saveCeremony(ctx, user.ID, ceremonyReauth, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, ceremonyID, nil
+}
+
+// FinishPasskeyReauth verifies the assertion and returns a token for the same session
+// with a fresh auth_time and multi-factor assurance.
+func (s *authService) FinishPasskeyReauth(ctx context.Context, current *Claims, ceremonyID string, response *protocol.ParsedCredentialAssertionData) (string, error) {
+	if current.Act != nil {
+		return "", ErrReauthNotAllowed
+	}
+	session, err := s.consumeCeremony(ctx, ceremonyID, current.UserID, ceremonyReauth)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, current.UserID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+	pu, err := s.loadPasskeyUser(ctx, user)
+	if err != nil {
+		return "", err
+	}
+
+	cred, err := s.webAuthn.ValidateLogin(pu, *session, response)
+	if err != nil {
+		return "", ErrInvalidPasskey
+	}
+	if err := s.recordPasskeyUse(ctx, pu, cred, response); err != nil {
+		return "", err
+	}
+	return s.upgradeToken(ctx, user, current, AMRPasskey)
+}
+
+// upgradeToken issues a token for the current organization, authenticated now with method.
+func (s *authService) upgradeToken(ctx context.Context, user *domain.User, current *Claims, method string) (string, error) {
+	var membership *domain.Membership
+	if current.OrgID != 0 {
+		m, err := s.orgRepo.GetMembership(ctx, current.OrgID, user.ID)
+		if err != nil && !errors.Is(err, repository.ErrMembershipNotFound) {
+			return "", fmt.Errorf("failed to get membership: %w", err)
+		}
+		membership = m
+	}
+	if membership == nil {
+		// No longer a member of the session's organization
+		m, err := s.defaultMembership(ctx, user.ID)
+		if err != nil {
+			return "", err
+		}
+		membership = m
+	}
+
+	claims := s.newClaims(user, membership)
+	claims.setAuthentication(time.Now(), []string{method})
+	return s.signToken(claims)
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"authservice/internal/domain"
+)
+
+// ErrInvalidRole is returned for unknown platform roles.
+var ErrInvalidRole = errors.New("invalid role")
+
+// RegistrationError is returned when the registration mode doesn't allow a registration.
+type RegistrationError struct {
+	Mode   string // The mode that rejected the request, see the domain.Registration constants
+	Reason string
+}
+
+func (e *RegistrationError) Error() string {
+	return fmt.Sprintf("registration rejected in %s mode: %s", e.Mode, e.Reason)
+}
+
+// checkRegistrationAllowed applies the registration mode to a registration without an
+// invite code. Invite codes are checked when they are redeemed.
+func (s *authService) checkRegistrationAllowed(email, inviteCode string) error {
+	mode := s.cfg.RegistrationMode
+	switch {
+	case mode == domain.RegistrationClosed:
+		return &RegistrationError{Mode: mode, Reason: "Registration is closed"}
+	case inviteCode != "":
+		return nil
+	case mode == domain.RegistrationInviteOnly:
+		return &RegistrationError{Mode: mode, Reason: "An invite code is required to register"}
+	case mode == domain.RegistrationDomain && !s.allowedEmailDomain(email):
+		return &RegistrationError{
+			Mode:   mode,
+			Reason: "Registration is limited to addresses at " + strings.Join(s.cfg.RegistrationAllowedDomains, ", ") + ", or requires an invite code",
+		}
+	}
+	return nil
+}
+
+// allowedEmailDomain reports whether the email's domain is one of the allowed domains
+// (exact match, so subdomains must be listed separately).
+func (s *authService) allowedEmailDomain(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	emailDomain := strings.ToLower(email[at+1:])
+	for _, allowed := range s.cfg.RegistrationAllowedDomains {
+		if strings.ToLower(strings.TrimPrefix(strings.TrimSpace(allowed), "@")) == emailDomain {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateRegistrationInvite creates a single-use invite code. Only admins may create
+// invites for staff roles. The code is returned once and only its hash is stored.
+func (s *authService) CreateRegistrationInvite(ctx context.Context, creatorID int64, role, note string) (*domain.RegistrationInvite, string, error) {
+	switch role {
+	case "":
+		role = domain.RoleUser
+	case domain.RoleUser, domain.RoleSupport, domain.RoleAdmin:
+	default:
+		return nil, "", ErrInvalidRole
+	}
+
+	// Re-check the creator's role from the database rather than trusting the token
+	creator, err := s.userRepo.GetUserByID(ctx, creatorID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get creator: %w", err)
+	}
+	if !creator.IsStaff() || (role != domain.RoleUser && creator.Role != domain.RoleAdmin) {
+		return nil, "", ErrForbidden
+	}
+
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("failed to generate invite code: %w", err)
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	invite := &domain.RegistrationInvite{
+		CodeHash:  hashNonce(code),
+		Role:      role,
+		Note:      note,
+		CreatedBy: creatorID,
+		ExpiresAt: time.Now().Add(s.cfg.RegistrationInviteTTL),
+	}
+	if _, err := s.inviteRepo.CreateInvite(ctx, invite); err != nil {
+		return nil, "", fmt.Errorf("failed to create invite: %w", err)
+	}
+	return invite, code, nil
+}
+
+// ListRegistrationInvites lists recent invites.
+func (s *authService) ListRegistrationInvites(ctx context.Context, limit int) ([]domain.RegistrationInvite, error) {
+	return s.inviteRepo.ListInvites(ctx, limit)
+}
+
+// DeleteRegistrationInvite revokes an unused invite.
+func (s *authService) DeleteRegistrationInvite(ctx context.Context, id int64) error {
+	return s.inviteRepo.DeleteInvite(ctx, id)
+}
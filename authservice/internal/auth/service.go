@@ -4,41 +4,154 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"authservice/internal/config"
 	"authservice/internal/domain"
+	"authservice/internal/geoip"
+	"authservice/internal/mailer"
+	"authservice/internal/ratelimit"
 	"authservice/internal/repository"
 
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials is returned when the email or password is wrong.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// tokenIssuer is the iss claim of every access token.
+const tokenIssuer = "authservice"
+
+// ErrNotOrgMember is returned when switching to an organization the user doesn't belong to.
+var ErrNotOrgMember = errors.New("user is not a member of the organization")
+
 // Claims represents the JWT claims.
 type Claims struct {
-	UserID int64 `json:"user_id"`
+	UserID  int64  `json:"user_id"`
+	Role    string `json:"role,omitempty"`     // Platform role (user, support, admin)
+	OrgID   int64  `json:"org_id,omitempty"`   // Active organization (tenant), if the user belongs to any
+	OrgRole string `json:"org_role,omitempty"` // Role in the active organization
+	Act     *Actor `json:"act,omitempty"`      // Who is actually acting, when not the subject (RFC 8693)
+	Scope   string `json:"scope,omitempty"`    // Space-separated; empty for user tokens, which are unrestricted
+
+	// How and when the user last proved their identity (OpenID Connect Core §2)
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	ACR      string           `json:"acr,omitempty"` // Assurance level, see ACRSingleFactor and ACRMultiFactor
+	AMR      []string         `json:"amr,omitempty"` // Methods used, see the AMR constants
 	jwt.RegisteredClaims
 }
 
 // AuthService provides authentication related functionalities.
 type AuthService interface {
-	Register(ctx context.Context, email, password string) (*domain.User, error)
-	Login(ctx context.Context, email, password string) (string, error)
+	// Register creates a local account, if the registration mode allows it. inviteCode
+	// may be empty; a valid one also sets the account's role.
+	Register(ctx context.Context, email, password, inviteCode string, client ClientInfo, proof ProofOfWork) (*domain.User, error)
+	// Login checks the password and returns an access token, or a *SecondFactorRequiredError
+	// when the login looks suspicious and a second factor is configured.
+	Login(ctx context.Context, email, password string, client ClientInfo, proof ProofOfWork) (string, error)
+	// VerifyLoginCode finishes a login held back by Login with the emailed code.
+	VerifyLoginCode(ctx context.Context, secondFactorToken, code string, client ClientInfo) (string, error)
+	ListLoginHistory(ctx context.Context, userID int64, limit int) ([]domain.LoginEvent, error)
+	// IssueChallenge creates a proof-of-work puzzle for Register and Login.
+	IssueChallenge(ctx context.Context, clientIP string) (*Challenge, error)
 	VerifyToken(tokenString string) (*Claims, error)
+	// JWKS returns the public keys other services verify access tokens with.
+	JWKS() *JWKS
+	RequestMagicLink(ctx context.Context, email, nonce string) error
+	VerifyMagicLink(ctx context.Context, token, nonce string) (string, error)
+	SwitchOrganization(ctx context.Context, current *Claims, orgID int64) (string, error)
+	ChangePassword(ctx context.Context, userID int64, currentPassword, newPassword string) error
+	Impersonate(ctx context.Context, actorID, targetID int64, reason, ipAddress string) (string, *domain.ImpersonationSession, error)
+	ListImpersonations(ctx context.Context, userID int64, limit int) ([]domain.ImpersonationSession, error)
+
+	// Passkeys
+	BeginPasskeyRegistration(ctx context.Context, userID int64) (*protocol.CredentialCreation, string, error)
+	FinishPasskeyRegistration(ctx context.Context, userID int64, ceremonyID, name string, response *protocol.ParsedCredentialCreationData) (*domain.Passkey, error)
+	BeginPasskeyLogin(ctx context.Context) (*protocol.CredentialAssertion, string, error)
+	FinishPasskeyLogin(ctx context.Context, ceremonyID string, response *protocol.ParsedCredentialAssertionData) (string, error)
+	ListPasskeys(ctx context.Context, userID int64) ([]domain.Passkey, error)
+	DeletePasskey(ctx context.Context, userID, passkeyID int64) error
+	RemovePassword(ctx context.Context, userID int64) error
+
+	// Step-up authentication
+	Reauthenticate(ctx context.Context, current *Claims, password string) (string, error)
+	BeginPasskeyReauth(ctx context.Context, userID int64) (*protocol.CredentialAssertion, string, error)
+	FinishPasskeyReauth(ctx context.Context, current *Claims, ceremonyID string, response *protocol.ParsedCredentialAssertionData) (string, error)
+
+	// Registration invites (staff only)
+	CreateRegistrationInvite(ctx context.Context, creatorID int64, role, note string) (*domain.RegistrationInvite, string, error)
+	ListRegistrationInvites(ctx context.Context, limit int) ([]domain.RegistrationInvite, error)
+	DeleteRegistrationInvite(ctx context.Context, id int64) error
+
+	// ExchangeToken implements RFC 8693 token exchange for services acting on behalf of users.
+	ExchangeToken(ctx context.Context, req TokenExchangeRequest) (*TokenExchangeResponse, error)
 }
 
 type authService struct {
-	userRepo repository.UserRepository
-	cfg      *config.Config
+	userRepo      repository.UserRepository
+	magicLinkRepo repository.MagicLinkRepository
+	orgRepo       repository.OrgRepository
+	impRepo       repository.ImpersonationRepository
+	passkeyRepo   repository.PasskeyRepository
+	inviteRepo    repository.RegistrationInviteRepository
+	loginRepo     repository.LoginHistoryRepository
+	mailer        mailer.Mailer
+	webAuthn      *webauthn.WebAuthn
+	signingKey    *SigningKey
+	backends      map[string]CredentialBackend // Keyed by domain.User.AuthSource
+	locator       geoip.Locator                // nil without a GeoIP database
+	cfg           *config.Config
+
+	magicLinkLimiter *ratelimit.Limiter
+	powLimiter       *ratelimit.Limiter // Registration and login attempts per client IP
+	powSeen          *usedChallenges
+	loginCodeLimiter *ratelimit.Limiter // Attempts per second-factor token
+	loginCodeSeen    *usedChallenges
 }
 
-// NewAuthService creates a new AuthService.
-func NewAuthService(userRepo repository.UserRepository, cfg *config.Config) AuthService {
-	return &authService{userRepo: userRepo, cfg: cfg}
+// NewAuthService creates a new AuthService. directory checks the passwords of LDAP
+// accounts; nil disables directory logins. locator places logins for impossible-travel
+// checks; nil disables them.
+func NewAuthService(userRepo repository.UserRepository, magicLinkRepo repository.MagicLinkRepository, orgRepo repository.OrgRepository, impRepo repository.ImpersonationRepository, passkeyRepo repository.PasskeyRepository, inviteRepo repository.RegistrationInviteRepository, loginRepo repository.LoginHistoryRepository, mailer mailer.Mailer, webAuthn *webauthn.WebAuthn, signingKey *SigningKey, directory CredentialBackend, locator geoip.Locator, cfg *config.Config) AuthService {
+	backends := map[string]CredentialBackend{domain.AuthSourceLocal: localBackend{}}
+	if directory != nil {
+		backends[domain.AuthSourceLDAP] = directory
+	}
+	return &authService{
+		userRepo:         userRepo,
+		magicLinkRepo:    magicLinkRepo,
+		orgRepo:          orgRepo,
+		impRepo:          impRepo,
+		passkeyRepo:      passkeyRepo,
+		inviteRepo:       inviteRepo,
+		loginRepo:        loginRepo,
+		mailer:           mailer,
+		webAuthn:         webAuthn,
+		signingKey:       signingKey,
+		backends:         backends,
+		locator:          locator,
+		cfg:              cfg,
+		magicLinkLimiter: ratelimit.New(cfg.MagicLinkRateLimit, cfg.MagicLinkRateWindow),
+		powLimiter:       ratelimit.New(cfg.PoWRateLimit, cfg.PoWRateWindow),
+		powSeen:          newUsedChallenges(),
+		loginCodeLimiter: ratelimit.New(cfg.LoginCodeMaxAttempts, cfg.LoginCodeTTL),
+		loginCodeSeen:    newUsedChallenges(),
+	}
 }
 
 // Register creates a new user.
-func (s *authService) Register(ctx context.Context, email, password string) (*domain.User, error) {
+func (s *authService) Register(ctx context.Context, email, password, inviteCode string, client ClientInfo, proof ProofOfWork) (*domain.User, error) {
+	if err := s.checkProofOfWork(ctx, client.IP, proof); err != nil {
+		return nil, err
+	}
+	if err := s.checkRegistrationAllowed(email, inviteCode); err != nil {
+		return nil, err
+	}
+
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -48,14 +161,23 @@ func (s *authService) Register(ctx context.Context, email, password string) (*do
 	user := &domain.User{
 		Email:    email,
 		Password: string(hashedPassword),
+		Role:     domain.RoleUser,
 	}
 
-	userID, err := s.userRepo.CreateUser(ctx, user)
+	var userID int64
+	if inviteCode != "" {
+		userID, err = s.inviteRepo.CreateUserWithInvite(ctx, user, hashNonce(inviteCode))
+	} else {
+		userID, err = s.userRepo.CreateUser(ctx, user)
+	}
 	if err != nil {
 		// Handle potential duplicate email error from repository
 		if errors.Is(err, repository.ErrEmailExists) {
 			return nil, err // Return the specific error
 		}
+		if errors.Is(err, repository.ErrRegistrationInviteInvalid) {
+			return nil, &RegistrationError{Mode: s.cfg.RegistrationMode, Reason: "Invite code is invalid, expired or already used"}
+		}
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
@@ -64,40 +186,153 @@ func (s *authService) Register(ctx context.Context, email, password string) (*do
 	return user, nil
 }
 
-// Login authenticates a user and returns a JWT token.
-func (s *authService) Login(ctx context.Context, email, password string) (string, error) {
+// Login authenticates a user and returns a JWT token. The password is checked locally
+// or against the directory, depending on where the account's credentials live.
+func (s *authService) Login(ctx context.Context, email, password string, client ClientInfo, proof ProofOfWork) (string, error) {
+	if err := s.checkProofOfWork(ctx, client.IP, proof); err != nil {
+		return "", err
+	}
+
 	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+
+	user, err = s.checkPassword(ctx, email, password, user)
+	if err != nil {
+		return "", err // ErrInvalidCredentials is generic, for security
+	}
+
+	event, err := s.assessLogin(ctx, user.ID, client)
+	if err != nil {
+		return "", err
+	}
+	if event.Suspicious() && s.cfg.SuspiciousLoginSecondFactor {
+		return "", s.requireSecondFactor(ctx, user, event)
+	}
+	if err := s.recordLogin(ctx, user, event); err != nil {
+		return "", err
+	}
+	return s.generateDefaultToken(ctx, user, AMRPassword)
+}
+
+// ChangePassword replaces the user's password after checking the current one.
+// Passkey-only accounts can set a password without a current one.
+func (s *authService) ChangePassword(ctx context.Context, userID int64, currentPassword, newPassword string) error {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if !user.IsLocal() {
+		return ErrPasswordManagedExternally
+	}
+
+	if user.HasPassword() {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return ErrInvalidCredentials
+			}
+			return fmt.Errorf("password comparison failed: %w", err)
+		}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	if err := s.userRepo.UpdatePassword(ctx, userID, string(hashedPassword)); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return nil
+}
+
+// SwitchOrganization re-issues a token scoped to another organization the user belongs to.
+// Everything else about the current token (authentication time and methods, impersonation)
+// carries over, so switching can't be used to refresh a session or to turn a short-lived
+// impersonation token into an ordinary one; switches while impersonating are audited.
+func (s *authService) SwitchOrganization(ctx context.Context, current *Claims, orgID int64) (string, error) {
+	membership, err := s.orgRepo.GetMembership(ctx, orgID, current.UserID)
 	if err != nil {
-		if errors.Is(err, repository.ErrUserNotFound) {
-			return "", fmt.Errorf("invalid credentials") // Generic error for security
+		if errors.Is(err, repository.ErrMembershipNotFound) {
+			return "", ErrNotOrgMember
 		}
+		return "", fmt.Errorf("failed to get membership: %w", err)
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, current.UserID)
+	if err != nil {
 		return "", fmt.Errorf("failed to get user: %w", err)
 	}
 
-	// Compare the provided password with the stored hash
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	claims := s.newClaims(user, membership)
+	claims.AuthTime = current.AuthTime
+	claims.ACR = current.ACR
+	claims.AMR = current.AMR
+	if current.Act != nil {
+		claims.Act = current.Act
+		claims.ID = current.ID
+		claims.ExpiresAt = current.ExpiresAt
+		slog.WarnContext(ctx, "Impersonation switched organization",
+			"impersonation_session", current.ID,
+			"actor_id", current.ImpersonatorID(),
+			"target_id", current.UserID,
+			"org_id", orgID,
+		)
+	}
+	return s.signToken(claims)
+}
+
+// generateDefaultToken issues a token scoped to the user's oldest membership
+// (or to no organization if the user has none), for a user who just authenticated
+// with methods.
+func (s *authService) generateDefaultToken(ctx context.Context, user *domain.User, methods ...string) (string, error) {
+	membership, err := s.defaultMembership(ctx, user.ID)
 	if err != nil {
-		// If passwords don't match
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return "", fmt.Errorf("invalid credentials")
-		}
-		// Other errors during comparison
-		return "", fmt.Errorf("password comparison failed: %w", err)
+		return "", err
 	}
 
-	// Generate JWT token
+	claims := s.newClaims(user, membership)
+	claims.setAuthentication(time.Now(), methods)
+	return s.signToken(claims)
+}
+
+// defaultMembership returns the user's oldest membership, or nil if they have none.
+func (s *authService) defaultMembership(ctx context.Context, userID int64) (*domain.Membership, error) {
+	memberships, err := s.orgRepo.ListMemberships(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list memberships: %w", err)
+	}
+	if len(memberships) == 0 {
+		return nil, nil
+	}
+	return &memberships[0], nil
+}
+
+// newClaims builds the standard access-token claims for the user.
+func (s *authService) newClaims(user *domain.User, membership *domain.Membership) *Claims {
 	expirationTime := time.Now().Add(s.cfg.TokenTTL)
 	claims := &Claims{
 		UserID: user.ID,
+		Role:   user.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{s.cfg.TokenAudience},
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "authservice", // Optional: identify the issuer
+			Issuer:    tokenIssuer,
 		},
 	}
+	if membership != nil {
+		claims.OrgID = membership.OrgID
+		claims.OrgRole = membership.Role
+	}
+	return claims
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.cfg.JWTSecret))
+// signToken signs access-token claims.
+func (s *authService) signToken(claims *Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = s.signingKey.KeyID
+	tokenString, err := token.SignedString(s.signingKey.private)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -105,28 +340,20 @@ func (s *authService) Login(ctx context.Context, email, password string) (string
 	return tokenString, nil
 }
 
-// VerifyToken validates the JWT token and returns the claims.
+// VerifyToken validates the JWT token and returns the claims. Only user access tokens
+// are accepted; tokens exchanged for other services have a different audience.
 func (s *authService) VerifyToken(tokenString string) (*Claims, error) {
-	claims := &Claims{}
-
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Make sure the signing method is what we expect
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.cfg.JWTSecret), nil
-	})
-
+	claims, err := s.parseToken(tokenString, s.cfg.TokenAudience)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, fmt.Errorf("token has expired")
 		}
 		return nil, fmt.Errorf("invalid token: %w", err)
 	}
-
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
-	}
-
 	return claims, nil
 }
+
+// JWKS returns the public key set that verifies access tokens.
+func (s *authService) JWKS() *JWKS {
+	return s.signingKey.JWKS()
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"authservice/internal/config"
+	"authservice/internal/domain"
+	"authservice/internal/repository"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:8080"
+)
+
+// newTestConfig returns the configuration's defaults that the service depends on.
+func newTestConfig() *config.Config {
+	return &config.Config{
+		JWTSecret:            "test-secret",
+		TokenTTL:             time.Hour,
+		TokenAudience:        "api",
+		WebAuthnRPID:         testRPID,
+		WebAuthnRPName:       "AuthService",
+		WebAuthnOrigins:      []string{testOrigin},
+		WebAuthnCeremonyTTL:  time.Minute,
+		PoWRateLimit:         10,
+		PoWRateWindow:        time.Minute,
+		MagicLinkRateLimit:   3,
+		MagicLinkRateWindow:  time.Minute,
+		LoginCodeMaxAttempts: 5,
+		LoginCodeTTL:         time.Minute,
+	}
+}
+
+// memoryUsers is the part of UserRepository that logins and passkeys use.
+type memoryUsers struct {
+	repository.UserRepository
+	mu    sync.Mutex
+	users map[int64]*domain.User
+}
+
+func (r *memoryUsers) CreateUser(_ context.Context, user *domain.User) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, user.Email) {
+			return 0, repository.ErrEmailExists
+		}
+	}
+	user.ID = int64(len(r.users) + 1)
+	copied := *user
+	r.users[user.ID] = &copied
+	return user.ID, nil
+}
+
+func (r *memoryUsers) GetUserByEmail(_ context.Context, email string) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, email) {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r *memoryUsers) UpdateRole(_ context.Context, id int64, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	user.Role = role
+	return nil
+}
+
+func (r *memoryUsers) GetUserByID(_ context.Context, id int64) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (r *memoryUsers) GetUserByWebAuthnHandle(_ context.Context, handle []byte) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if handle != nil && bytes.Equal(user.WebAuthnHandle, handle) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r *memoryUsers) SetWebAuthnHandle(_ context.Context, id int64, handle []byte) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	if user.WebAuthnHandle == nil {
+		user.WebAuthnHandle = handle
+	}
+	return user.WebAuthnHandle, nil
+}
+
+// noMemberships is an OrgRepository for users that belong to no organization.
+type noMemberships struct {
+	repository.OrgRepository
+}
+
+func (noMemberships) ListMemberships(context.Context, int64) ([]domain.Membership, error) {
+	return nil, nil
+}
+
+// memoryLogins is a LoginHistoryRepository that only counts logins, so none is suspicious.
+type memoryLogins struct {
+	repository.LoginHistoryRepository
+	mu     sync.Mutex
+	logins []domain.LoginEvent
+}
+
+func (r *memoryLogins) RecordLogin(_ context.Context, event *domain.LoginEvent) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logins = append(r.logins, *event)
+	return int64(len(r.logins)), nil
+}
+
+func (r *memoryLogins) GetFamiliarity(context.Context, int64, string, string) (*domain.LoginFamiliarity, error) {
+	return &domain.LoginFamiliarity{}, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"authservice/internal/config"
+)
+
+// SigningKey signs access tokens with Ed25519 (EdDSA). Other services verify them with
+// the public key published at /.well-known/jwks.json, so no secret leaves authservice.
+type SigningKey struct {
+	private ed25519.PrivateKey
+	KeyID   string // The kid header of tokens signed with the key
+}
+
+// LoadSigningKey reads the PEM (PKCS #8) Ed25519 key in JWT_PRIVATE_KEY_FILE. Without
+// one, the key is derived from JWT_SECRET, so every replica signs with the same key.
+func LoadSigningKey(cfg *config.Config) (*SigningKey, error) {
+	if cfg.JWTPrivateKeyFile == "" {
+		mac := hmac.New(sha256.New, []byte(cfg.JWTSecret))
+		mac.Write([]byte("access-token-signing-key"))
+		return newSigningKey(ed25519.NewKeyFromSeed(mac.Sum(nil))), nil
+	}
+
+	data, err := os.ReadFile(cfg.JWTPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT_PRIVATE_KEY_FILE: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("JWT_PRIVATE_KEY_FILE is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT_PRIVATE_KEY_FILE: %w", err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE holds a %T, not an Ed25519 key", key)
+	}
+	return newSigningKey(private), nil
+}
+
+func newSigningKey(private ed25519.PrivateKey) *SigningKey {
+	// The kid is the key's thumbprint, so rotating the key changes it
+	sum := sha256.Sum256(private.Public().(ed25519.PublicKey))
+	return &SigningKey{private: private, KeyID: base64.RawURLEncoding.EncodeToString(sum[:12])}
+}
+
+// Public returns the key that verifies the signatures.
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517, RFC 8037 for Ed25519).
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the key set that verifies access tokens.
+func (k *SigningKey) JWKS() *JWKS {
+	return &JWKS{Keys: []JWK{{
+		KeyType: "OKP",
+		Curve:   "Ed25519",
+		X:       base64.RawURLEncoding.EncodeToString(k.private.Public().(ed25519.PublicKey)),
+		KeyID:   k.KeyID,
+		Use:     "sig",
+		Alg:     "EdDSA",
+	}}}
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token exchange identifiers (RFC 8693).
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// OAuth error codes (RFC 6749 section 5.2, RFC 8693 section 2.2.2).
+const (
+	OAuthInvalidRequest       = "invalid_request"
+	OAuthInvalidClient        = "invalid_client"
+	OAuthInvalidGrant         = "invalid_grant"
+	OAuthUnsupportedGrantType = "unsupported_grant_type"
+	OAuthInvalidScope         = "invalid_scope"
+	OAuthInvalidTarget        = "invalid_target"
+)
+
+// OAuthError is an error response from the token endpoint.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// TokenExchangeRequest is a token exchange request from an authenticated client.
+type TokenExchangeRequest struct {
+	ClientID         string
+	ClientSecret     string
+	SubjectToken     string
+	SubjectTokenType string
+	Audience         string
+	Scope            string // Space-separated; empty keeps the subject token's scope, which must then have one
+}
+
+// TokenExchangeResponse is the token endpoint's response.
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// ExchangeToken swaps a user's access token, presented by a service acting on their
+// behalf, for a short-lived token that only the audience service accepts. The new token
+// names the service in its act claim and can't outlive or out-scope the original. It
+// always carries a scope, so exchanging an unscoped user token needs one requested.
+func (s *authService) ExchangeToken(ctx context.Context, req TokenExchangeRequest) (*TokenExchangeResponse, error) {
+	if !s.authenticateClient(req.ClientID, req.ClientSecret) {
+		return nil, &OAuthError{Code: OAuthInvalidClient, Description: "Client authentication failed"}
+	}
+	if req.SubjectToken == "" {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "subject_token is required"}
+	}
+	if req.SubjectTokenType != TokenTypeAccessToken && req.SubjectTokenType != TokenTypeJWT {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "Unsupported subject_token_type"}
+	}
+	if req.Audience == "" {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "audience is required"}
+	}
+	if !slices.Contains(s.cfg.TokenExchangeAudiences, req.Audience) {
+		return nil, &OAuthError{Code: OAuthInvalidTarget, Description: "Unknown audience"}
+	}
+
+	// The client may exchange user access tokens and tokens addressed to itself
+	subject, err := s.parseToken(req.SubjectToken, s.cfg.TokenAudience, req.ClientID)
+	if err != nil {
+		return nil, &OAuthError{Code: OAuthInvalidGrant, Description: "Invalid or expired subject_token"}
+	}
+
+	scope := subject.Scope
+	if req.Scope != "" {
+		if !scopeAllows(subject.Scope, req.Scope) {
+			return nil, &OAuthError{Code: OAuthInvalidScope, Description: "Requested scope exceeds the subject token's"}
+		}
+		scope = req.Scope
+	}
+	if scope == "" {
+		// An unscoped user token would otherwise be passed on with all of its rights
+		return nil, &OAuthError{Code: OAuthInvalidScope, Description: "scope is required"}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.cfg.ExchangedTokenTTL)
+	if subject.ExpiresAt != nil && subject.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = subject.ExpiresAt.Time
+	}
+
+	claims := &Claims{
+		UserID:   subject.UserID,
+		Role:     subject.Role,
+		OrgID:    subject.OrgID,
+		OrgRole:  subject.OrgRole,
+		Scope:    scope,
+		Act:      &Actor{Subject: req.ClientID, Act: subject.Act},
+		AuthTime: subject.AuthTime,
+		ACR:      subject.ACR,
+		AMR:      subject.AMR,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{req.Audience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    tokenIssuer,
+		},
+	}
+	token, err := s.signToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Token exchanged",
+		"client_id", req.ClientID,
+		"user_id", subject.UserID,
+		"audience", req.Audience,
+		"scope", scope,
+	)
+	return &TokenExchangeResponse{
+		AccessToken:     token,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(time.Until(expiresAt).Seconds()),
+		Scope:           scope,
+	}, nil
+}
+
+// authenticateClient checks a client's secret in constant time.
+func (s *authService) authenticateClient(clientID, secret string) bool {
+	var expected string
+	for _, client := range s.cfg.OAuthClients {
+		if id, secret, ok := strings.Cut(client, ":"); ok && id == clientID {
+			expected = secret
+		}
+	}
+	if clientID == "" || expected == "" {
+		return false
+	}
+	a, b := sha256.Sum256([]byte(secret)), sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// scopeAllows reports whether every scope in requested is in granted. An empty granted
+// scope is unrestricted.
+func scopeAllows(granted, requested string) bool {
+	if granted == "" {
+		return true
+	}
+	have := strings.Fields(granted)
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(have, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// errWrongAudience is returned for tokens minted for another service.
+var errWrongAudience = errors.New("token is not intended for this service")
+
+// parseToken validates a token's signature, expiry and issuer, and that its audience
+// includes one of audiences.
+func (s *authService) parseToken(tokenString string, audiences ...string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if kid, _ := token.Header["kid"].(string); kid != s.signingKey.KeyID {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return s.signingKey.Public(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}), jwt.WithIssuer(tokenIssuer))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, aud := range claims.Audience {
+		if slices.Contains(audiences, aud) {
+			return claims, nil
+		}
+	}
+	return nil, errWrongAudience
+}
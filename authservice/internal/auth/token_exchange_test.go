@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newExchangeTestService(t *testing.T) *authService {
+	t.Helper()
+	cfg := newTestConfig()
+	cfg.OAuthClients = []string{"orderservice:client-secret"}
+	cfg.TokenExchangeAudiences = []string{"productservice"}
+	cfg.ExchangedTokenTTL = 5 * time.Minute
+	signingKey, err := LoadSigningKey(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewAuthService(nil, nil, noMemberships{}, nil, nil, nil, nil, nil, nil, signingKey, nil, nil, cfg)
+	return svc.(*authService)
+}
+
+// userToken signs an access token for user 1 with the given scope.
+func userToken(t *testing.T, svc *authService, scope string) string {
+	t.Helper()
+	token, err := svc.signToken(&Claims{
+		UserID: 1,
+		Scope:  scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{"api"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Issuer:    tokenIssuer,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestExchangeTokenScope(t *testing.T) {
+	svc := newExchangeTestService(t)
+	tests := []struct {
+		name         string
+		subjectScope string
+		requested    string
+		want         string // Scope of the exchanged token; empty when the exchange is rejected
+	}{
+		{name: "unscoped token needs a requested scope", subjectScope: "", requested: "", want: ""},
+		{name: "unscoped token narrowed to the request", subjectScope: "", requested: "products:read", want: "products:read"},
+		{name: "scoped token keeps its scope", subjectScope: "products:read orders:read", requested: "", want: "products:read orders:read"},
+		{name: "scoped token narrowed to the request", subjectScope: "products:read orders:read", requested: "products:read", want: "products:read"},
+		{name: "request can't widen the scope", subjectScope: "products:read", requested: "products:write", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := svc.ExchangeToken(context.Background(), TokenExchangeRequest{
+				ClientID:         "orderservice",
+				ClientSecret:     "client-secret",
+				SubjectToken:     userToken(t, svc, tt.subjectScope),
+				SubjectTokenType: TokenTypeAccessToken,
+				Audience:         "productservice",
+				Scope:            tt.requested,
+			})
+			if tt.want == "" {
+				var oauthErr *OAuthError
+				if !errors.As(err, &oauthErr) || oauthErr.Code != OAuthInvalidScope {
+					t.Fatalf("ExchangeToken() error = %v, want %s", err, OAuthInvalidScope)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExchangeToken() error = %v", err)
+			}
+			claims, err := svc.parseToken(resp.AccessToken, "productservice")
+			if err != nil {
+				t.Fatalf("exchanged token doesn't verify: %v", err)
+			}
+			if claims.Scope != tt.want || resp.Scope != tt.want {
+				t.Errorf("scope = %q (response %q), want %q", claims.Scope, resp.Scope, tt.want)
+			}
+			if claims.Act == nil || claims.Act.Subject != "orderservice" {
+				t.Errorf("act = %+v, want the client", claims.Act)
+			}
+		})
+	}
+}
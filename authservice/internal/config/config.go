@@ -1,7 +1,11 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
@@ -14,21 +18,144 @@ type Config struct {
 	DBUser     string        `env:"DB_USER,required"`
 	DBPassword string        `env:"DB_PASSWORD,required"`
 	DBName     string        `env:"DB_NAME,required"`
-	JWTSecret  string        `env:"JWT_SECRET,required"`       // Secret key for signing JWT tokens
+	JWTSecret  string        `env:"JWT_SECRET,required"`       // Signs internal tokens (magic links, ...); also the default access-token key's seed
 	TokenTTL   time.Duration `env:"TOKEN_TTL" envDefault:"1h"` // Token time-to-live
 	HTTPPort   string        `env:"HTTP_PORT" envDefault:"8080"`
+
+	// PEM (PKCS #8) Ed25519 key signing access tokens; derived from JWT_SECRET when empty.
+	// Services verify tokens with the public key from /.well-known/jwks.json.
+	JWTPrivateKeyFile string `env:"JWT_PRIVATE_KEY_FILE"`
+
+	// Registration
+	RegistrationMode           string        `env:"REGISTRATION_MODE" envDefault:"open"`           // open, invite_only, domain or closed
+	RegistrationAllowedDomains []string      `env:"REGISTRATION_ALLOWED_DOMAINS" envSeparator:","` // Email domains that may register in domain mode
+	RegistrationInviteTTL      time.Duration `env:"REGISTRATION_INVITE_TTL" envDefault:"168h"`
+
+	// Proof-of-work bot protection for /register and /login (puzzles from GET /challenge)
+	PoWEnabled          bool          `env:"POW_ENABLED" envDefault:"false"`
+	PoWDifficulty       int           `env:"POW_DIFFICULTY" envDefault:"18"`        // Leading zero bits of sha256(challenge:solution)
+	PoWRaisedDifficulty int           `env:"POW_RAISED_DIFFICULTY" envDefault:"22"` // Required from IPs over the attempt limit
+	PoWChallengeTTL     time.Duration `env:"POW_CHALLENGE_TTL" envDefault:"5m"`
+	PoWRateLimit        int           `env:"POW_RATE_LIMIT" envDefault:"10"` // Attempts per IP per window before the difficulty is raised
+	PoWRateWindow       time.Duration `env:"POW_RATE_WINDOW" envDefault:"15m"`
+
+	// Suspicious-login detection: logins from new devices or impossible locations are flagged
+	GeoIPDatabase               string        `env:"GEOIP_DATABASE"`                                     // Path to a MaxMind City .mmdb file; empty disables impossible-travel checks
+	ImpossibleTravelSpeed       float64       `env:"IMPOSSIBLE_TRAVEL_SPEED_KMH" envDefault:"900"`       // Faster than this between logins is impossible
+	ImpossibleTravelMinDistance float64       `env:"IMPOSSIBLE_TRAVEL_MIN_DISTANCE_KM" envDefault:"300"` // Closer than this is within GeoIP's error
+	NewDeviceNotifications      bool          `env:"NEW_DEVICE_NOTIFICATIONS" envDefault:"true"`         // Email the user about flagged logins
+	SuspiciousLoginSecondFactor bool          `env:"SUSPICIOUS_LOGIN_SECOND_FACTOR" envDefault:"false"`  // Require an emailed code to finish flagged logins
+	LoginCodeTTL                time.Duration `env:"LOGIN_CODE_TTL" envDefault:"10m"`
+	LoginCodeMaxAttempts        int           `env:"LOGIN_CODE_MAX_ATTEMPTS" envDefault:"5"`
+
+	// Step-up authentication: sensitive endpoints require a login or /reauth this recent
+	RecentAuthMaxAge time.Duration `env:"RECENT_AUTH_MAX_AGE" envDefault:"10m"`
+
+	// Cookies
+	CookieSecure   bool   `env:"COOKIE_SECURE" envDefault:"true"`            // Set the Secure flag; disable only for local HTTP
+	CookieSessions bool   `env:"COOKIE_SESSIONS_ENABLED" envDefault:"false"` // Allow Login to set HttpOnly session cookies
+	CookieDomain   string `env:"COOKIE_DOMAIN"`
+	CookieSameSite string `env:"COOKIE_SAMESITE" envDefault:"lax"` // lax, strict or none
+	SessionCookie  string `env:"SESSION_COOKIE_NAME" envDefault:"session"`
+	CSRFCookie     string `env:"CSRF_COOKIE_NAME" envDefault:"csrf_token"`
+
+	// CORS
+	CORSAllowedOrigins   []string      `env:"CORS_ALLOWED_ORIGINS" envSeparator:","` // Empty disables CORS; "*" allows any origin
+	CORSAllowCredentials bool          `env:"CORS_ALLOW_CREDENTIALS" envDefault:"false"`
+	CORSMaxAge           time.Duration `env:"CORS_MAX_AGE" envDefault:"10m"`
+
+	// Reverse proxies: X-Forwarded-For and X-Real-IP are only believed from these CIDRs
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:","`
+
+	// Email (messages are only logged when SMTP_HOST is empty)
+	SMTPHost     string `env:"SMTP_HOST"`
+	SMTPPort     int    `env:"SMTP_PORT" envDefault:"587"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD"`
+	MailFrom     string `env:"MAIL_FROM" envDefault:"no-reply@localhost"`
+
+	// Magic-link login
+	MagicLinkBaseURL    string        `env:"MAGIC_LINK_BASE_URL" envDefault:"http://localhost:8080/login/magic-link/verify"`
+	MagicLinkTTL        time.Duration `env:"MAGIC_LINK_TTL" envDefault:"15m"`
+	MagicLinkRateLimit  int           `env:"MAGIC_LINK_RATE_LIMIT" envDefault:"3"` // Links per email per window
+	MagicLinkRateWindow time.Duration `env:"MAGIC_LINK_RATE_WINDOW" envDefault:"15m"`
+
+	// Passkeys (WebAuthn)
+	WebAuthnRPID        string        `env:"WEBAUTHN_RP_ID" envDefault:"localhost"` // Domain the passkeys are bound to
+	WebAuthnRPName      string        `env:"WEBAUTHN_RP_NAME" envDefault:"AuthService"`
+	WebAuthnOrigins     []string      `env:"WEBAUTHN_ORIGINS" envSeparator:"," envDefault:"http://localhost:8080"` // Origins of the pages running the ceremonies
+	WebAuthnCeremonyTTL time.Duration `env:"WEBAUTHN_CEREMONY_TTL" envDefault:"5m"`
+
+	// LDAP directory for staff accounts (disabled when LDAP_URL is empty)
+	LDAPURL            string        `env:"LDAP_URL"`                         // ldap://host:389 or ldaps://host:636
+	LDAPStartTLS       bool          `env:"LDAP_START_TLS" envDefault:"true"` // Upgrade ldap:// connections with StartTLS
+	LDAPBindDN         string        `env:"LDAP_BIND_DN"`                     // Service account used to look users up
+	LDAPBindPassword   string        `env:"LDAP_BIND_PASSWORD"`
+	LDAPBaseDN         string        `env:"LDAP_BASE_DN"`
+	LDAPUserFilter     string        `env:"LDAP_USER_FILTER" envDefault:"(&(objectClass=person)(mail=%s))"` // %s is the escaped login email
+	LDAPEmailAttribute string        `env:"LDAP_EMAIL_ATTRIBUTE" envDefault:"mail"`
+	LDAPGroupAttribute string        `env:"LDAP_GROUP_ATTRIBUTE" envDefault:"memberOf"`
+	LDAPAdminGroups    []string      `env:"LDAP_ADMIN_GROUPS" envSeparator:";"`    // Group DNs granting the admin role
+	LDAPSupportGroups  []string      `env:"LDAP_SUPPORT_GROUPS" envSeparator:";"`  // Group DNs granting the support role
+	LDAPAutoProvision  bool          `env:"LDAP_AUTO_PROVISION" envDefault:"true"` // Create accounts for directory users on first login
+	LDAPTimeout        time.Duration `env:"LDAP_TIMEOUT" envDefault:"5s"`
+
+	// Organizations
+	InvitationBaseURL string        `env:"INVITATION_BASE_URL" envDefault:"http://localhost:8080/invitations/accept"`
+	InvitationTTL     time.Duration `env:"INVITATION_TTL" envDefault:"168h"`
+
+	// Support-staff impersonation
+	ImpersonationTTL time.Duration `env:"IMPERSONATION_TTL" envDefault:"15m"`
+
+	// Other services, for data export and erasure (GDPR)
+	OrderServiceURL      string        `env:"ORDER_SERVICE_URL" envDefault:"http://orderservice:8081"`
+	ProductServiceURL    string        `env:"PRODUCT_SERVICE_URL" envDefault:"http://productservice:8082"`
+	InternalAPIToken     string        `env:"INTERNAL_API_TOKEN"` // Bearer token for the services' /internal endpoints
+	ErasureRetryInterval time.Duration `env:"ERASURE_RETRY_INTERVAL" envDefault:"30s"`
+
+	// OAuth 2.0 token exchange (RFC 8693): services swap a user's token for one restricted to another service
+	TokenAudience          string        `env:"TOKEN_AUDIENCE" envDefault:"api"`                                                    // aud of user access tokens, accepted by every service's public API
+	OAuthClients           []string      `env:"OAUTH_CLIENTS" envSeparator:","`                                                     // client_id:secret pairs
+	TokenExchangeAudiences []string      `env:"TOKEN_EXCHANGE_AUDIENCES" envSeparator:"," envDefault:"orderservice,productservice"` // Services tokens can be exchanged for
+	ExchangedTokenTTL      time.Duration `env:"EXCHANGED_TOKEN_TTL" envDefault:"5m"`
+
+	// Logging
+	LogLevel      string `env:"LOG_LEVEL" envDefault:"info"` // debug, info, warn or error
+	LogAdminToken string `env:"LOG_ADMIN_TOKEN"`             // Enables PUT /debug/log-level when set
+
+	// Tracing
+	ServiceName   string `env:"OTEL_SERVICE_NAME" envDefault:"authservice"`
+	TraceExporter string `env:"TRACE_EXPORTER" envDefault:"none"`     // none, stdout, file or otlp
+	TraceFile     string `env:"TRACE_FILE" envDefault:"traces.jsonl"` // Used when TRACE_EXPORTER=file
 }
 
 // LoadConfig loads configuration from environment variables.
 func LoadConfig() (*Config, error) {
 	cfg := &Config{}
 	if err := env.Parse(cfg); err != nil {
-		log.Printf("Error parsing config from environment variables: %v", err)
+		slog.Error("Error parsing config from environment variables", "error", err)
 		return nil, err
 	}
 	// Ensure JWTSecret is set, as it's crucial for security
 	if cfg.JWTSecret == "" {
-		log.Fatal("FATAL: JWT_SECRET environment variable is not set.")
+		return nil, errors.New("JWT_SECRET environment variable is not set")
+	}
+	switch cfg.RegistrationMode {
+	case "open", "invite_only", "closed":
+	case "domain":
+		if len(cfg.RegistrationAllowedDomains) == 0 {
+			return nil, errors.New("REGISTRATION_ALLOWED_DOMAINS must be set in domain registration mode")
+		}
+	default:
+		return nil, fmt.Errorf("unknown REGISTRATION_MODE %q", cfg.RegistrationMode)
+	}
+	for _, cidr := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", cidr, err)
+		}
+	}
+	if cfg.PoWDifficulty < 1 || cfg.PoWRaisedDifficulty < cfg.PoWDifficulty || cfg.PoWRaisedDifficulty > 32 {
+		return nil, errors.New("POW_DIFFICULTY must be at least 1, and POW_RAISED_DIFFICULTY between it and 32")
 	}
 	return cfg, nil
 }
@@ -0,0 +1,15 @@
+ALTER TABLE users
+    ADD COLUMN role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'support', 'admin'));
+
+CREATE TABLE impersonation_sessions (
+    id         BIGSERIAL PRIMARY KEY,
+    actor_id   BIGINT      NOT NULL REFERENCES users (id),
+    target_id  BIGINT      NOT NULL REFERENCES users (id),
+    reason     TEXT        NOT NULL,
+    ip_address TEXT        NOT NULL DEFAULT '',
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX impersonation_sessions_actor_id_idx ON impersonation_sessions (actor_id);
+CREATE INDEX impersonation_sessions_target_id_idx ON impersonation_sessions (target_id);
@@ -0,0 +1,32 @@
+-- Passkey-only accounts have no password
+ALTER TABLE users
+    ALTER COLUMN password_hash DROP NOT NULL,
+    ADD COLUMN webauthn_handle BYTEA UNIQUE; -- Opaque WebAuthn user handle, set on first passkey registration
+
+CREATE TABLE webauthn_credentials (
+    id               BIGSERIAL PRIMARY KEY,
+    user_id          BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    credential_id    BYTEA       NOT NULL UNIQUE,
+    public_key       BYTEA       NOT NULL,
+    attestation_type TEXT        NOT NULL DEFAULT '',
+    transports       TEXT[]      NOT NULL DEFAULT '{}',
+    aaguid           BYTEA,
+    sign_count       BIGINT      NOT NULL DEFAULT 0,
+    clone_warning    BOOLEAN     NOT NULL DEFAULT false,
+    backup_eligible  BOOLEAN     NOT NULL DEFAULT false,
+    backup_state     BOOLEAN     NOT NULL DEFAULT false,
+    name             TEXT        NOT NULL DEFAULT '',
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at     TIMESTAMPTZ
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+-- State of registration and login ceremonies between the begin and finish requests
+CREATE TABLE webauthn_ceremonies (
+    id         TEXT PRIMARY KEY,
+    user_id    BIGINT REFERENCES users (id) ON DELETE CASCADE, -- NULL for discoverable login
+    data       JSONB       NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
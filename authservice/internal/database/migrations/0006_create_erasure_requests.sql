@@ -0,0 +1,22 @@
+CREATE TABLE erasure_requests (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT      NOT NULL REFERENCES users (id),
+    status       TEXT        NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'completed')),
+    requested_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX erasure_requests_user_id_idx ON erasure_requests (user_id);
+
+-- One row per downstream service that must confirm it deleted the user's data
+CREATE TABLE erasure_confirmations (
+    erasure_id      BIGINT      NOT NULL REFERENCES erasure_requests (id) ON DELETE CASCADE,
+    service         TEXT        NOT NULL,
+    attempts        INT         NOT NULL DEFAULT 0,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    confirmed_at    TIMESTAMPTZ,
+    PRIMARY KEY (erasure_id, service)
+);
+
+CREATE INDEX erasure_confirmations_pending_idx ON erasure_confirmations (next_attempt_at) WHERE confirmed_at IS NULL;
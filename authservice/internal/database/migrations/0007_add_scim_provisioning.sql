@@ -0,0 +1,21 @@
+-- Memberships managed by a customer's directory (SCIM) can be suspended without removing them
+ALTER TABLE memberships
+    ADD COLUMN active      BOOLEAN     NOT NULL DEFAULT true,
+    ADD COLUMN external_id TEXT, -- The directory's identifier for the user
+    ADD COLUMN updated_at  TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE UNIQUE INDEX memberships_org_external_id_idx ON memberships (org_id, external_id) WHERE external_id IS NOT NULL;
+
+-- Bearer tokens a tenant's directory uses to call /scim/v2
+CREATE TABLE scim_tokens (
+    id           BIGSERIAL PRIMARY KEY,
+    org_id       BIGINT      NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    token_hash   TEXT        NOT NULL UNIQUE,
+    description  TEXT        NOT NULL DEFAULT '',
+    created_by   BIGINT      REFERENCES users (id) ON DELETE SET NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX scim_tokens_org_id_idx ON scim_tokens (org_id);
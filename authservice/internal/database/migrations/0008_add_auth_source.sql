@@ -0,0 +1,3 @@
+-- Where a user's password is checked: 'local' (bcrypt hash) or 'ldap' (bind against the directory)
+ALTER TABLE users
+    ADD COLUMN auth_source TEXT NOT NULL DEFAULT 'local' CHECK (auth_source IN ('local', 'ldap'));
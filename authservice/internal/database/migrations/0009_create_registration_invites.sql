@@ -0,0 +1,12 @@
+-- Single-use codes that let someone register when registration isn't open to everyone
+CREATE TABLE registration_invites (
+    id         BIGSERIAL PRIMARY KEY,
+    code_hash  TEXT        NOT NULL UNIQUE,
+    role       TEXT        NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'support', 'admin')), -- Given to the new account
+    note       TEXT        NOT NULL DEFAULT '',
+    created_by BIGINT      REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    used_by    BIGINT      REFERENCES users (id) ON DELETE SET NULL
+);
@@ -0,0 +1,19 @@
+-- Successful password logins, to recognize the devices and networks a user signs in from
+CREATE TABLE login_history (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     BIGINT           NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    ip_address  TEXT             NOT NULL,
+    network     TEXT             NOT NULL, -- The IP's /24 (IPv4) or /48 (IPv6)
+    device_hash TEXT             NOT NULL, -- SHA-256 of the device identifier
+    user_agent  TEXT             NOT NULL DEFAULT '',
+    country     TEXT             NOT NULL DEFAULT '',
+    city        TEXT             NOT NULL DEFAULT '',
+    latitude    DOUBLE PRECISION,
+    longitude   DOUBLE PRECISION,
+    flags       TEXT[]           NOT NULL DEFAULT '{}',
+    created_at  TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
+
+CREATE INDEX login_history_user_id_created_at_idx ON login_history (user_id, created_at DESC);
+CREATE INDEX login_history_user_id_device_hash_idx ON login_history (user_id, device_hash);
+CREATE INDEX login_history_user_id_network_idx ON login_history (user_id, network);
@@ -3,11 +3,12 @@ package database
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"authservice/internal/config"
 
+	"github.com/exaring/otelpgx"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -18,7 +19,7 @@ func NewPostgresPool(cfg *config.Config) (*pgxpool.Pool, error) {
 
 	poolConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		log.Printf("Unable to parse DSN: %v\n", err)
+		slog.Error("Unable to parse DSN", "error", err)
 		return nil, err
 	}
 
@@ -28,7 +29,10 @@ func NewPostgresPool(cfg *config.Config) (*pgxpool.Pool, error) {
 	poolConfig.MaxConnLifetime = time.Hour
 	poolConfig.MaxConnIdleTime = 30 * time.Minute
 
-	log.Println("Connecting to database...")
+	// Create a span for every query
+	poolConfig.ConnConfig.Tracer = otelpgx.NewTracer()
+
+	slog.Info("Connecting to database...")
 	var pool *pgxpool.Pool
 	// err is already declared above by poolConfig, err := ...
 	// Retry connection logic (useful for docker-compose startup order)
@@ -40,19 +44,19 @@ func NewPostgresPool(cfg *config.Config) (*pgxpool.Pool, error) {
 			// Check the connection
 			pingErr := tempPool.Ping(context.Background())
 			if pingErr == nil {
-				log.Println("Database connection established successfully.")
+				slog.Info("Database connection established successfully.")
 				pool = tempPool // Assign to the outer pool variable on success
 				return pool, nil
 			}
-			log.Printf("Database ping failed: %v. Retrying... (%d/5)", pingErr, i+1)
+			slog.Warn("Database ping failed. Retrying...", "error", pingErr, "attempt", i+1, "max_attempts", 5)
 			tempPool.Close() // Close the temporary pool if ping fails
 		} else {
-			log.Printf("Unable to create connection pool: %v. Retrying... (%d/5)", err, i+1)
+			slog.Warn("Unable to create connection pool. Retrying...", "error", err, "attempt", i+1, "max_attempts", 5)
 		}
 		time.Sleep(2 * time.Second)
 	}
 
-	log.Printf("Failed to connect to database after multiple retries.")
+	slog.Error("Failed to connect to database after multiple retries.")
 	// Return the last error encountered (could be from NewWithConfig or Ping)
 	return nil, fmt.Errorf("failed to connect to database after retries: %w", err)
 }
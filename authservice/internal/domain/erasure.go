@@ -0,0 +1,30 @@
+package domain
+
+import "time"
+
+// Erasure request statuses.
+const (
+	ErasureStatusPending   = "pending"   // Waiting for downstream services to confirm
+	ErasureStatusCompleted = "completed" // Every service confirmed the deletion
+)
+
+// ErasureRequest tracks a user's right-to-erasure request across all services.
+type ErasureRequest struct {
+	ID            int64                 `json:"id"`
+	UserID        int64                 `json:"user_id"`
+	Status        string                `json:"status"`
+	RequestedAt   time.Time             `json:"requested_at"`
+	CompletedAt   *time.Time            `json:"completed_at,omitempty"`
+	Confirmations []ErasureConfirmation `json:"confirmations"`
+}
+
+// ErasureConfirmation is the deletion status of one downstream service.
+type ErasureConfirmation struct {
+	ErasureID     int64      `json:"-"`
+	UserID        int64      `json:"-"`
+	Service       string     `json:"service"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	ConfirmedAt   *time.Time `json:"confirmed_at,omitempty"`
+}
@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// ImpersonationSession is the audit record of a staff member acting as a customer.
+type ImpersonationSession struct {
+	ID        int64     `json:"id"`
+	ActorID   int64     `json:"actor_id"`
+	TargetID  int64     `json:"target_id"`
+	Reason    string    `json:"reason"`
+	IPAddress string    `json:"ip_address"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,45 @@
+package domain
+
+import "time"
+
+// Reasons a login is flagged, see LoginEvent.Flags.
+const (
+	LoginFlagNewDevice        = "new_device"        // The device has never signed in to the account
+	LoginFlagNewNetwork       = "new_network"       // Nor has the IP's network
+	LoginFlagImpossibleTravel = "impossible_travel" // Too far from the previous login's location for the time since
+)
+
+// LoginEvent is a successful password login, kept to recognize the user's usual devices
+// and networks.
+type LoginEvent struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	IPAddress  string    `json:"ip_address"`
+	Network    string    `json:"network"` // The IP's /24 (IPv4) or /48 (IPv6)
+	DeviceHash string    `json:"-"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Country    string    `json:"country,omitempty"` // ISO 3166-1 alpha-2, from the GeoIP database
+	City       string    `json:"city,omitempty"`
+	Latitude   *float64  `json:"latitude,omitempty"`
+	Longitude  *float64  `json:"longitude,omitempty"`
+	Flags      []string  `json:"flags"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Suspicious reports whether the login came from an unknown device or an impossible
+// location. A new network alone (e.g. a phone switching carriers) isn't suspicious.
+func (e *LoginEvent) Suspicious() bool {
+	for _, flag := range e.Flags {
+		if flag == LoginFlagNewDevice || flag == LoginFlagImpossibleTravel {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginFamiliarity summarizes a user's login history with respect to a new login.
+type LoginFamiliarity struct {
+	Logins       int  // Previous logins recorded
+	KnownDevice  bool // The device has signed in before
+	KnownNetwork bool // The network has signed in before
+}
@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+// MagicLink is a single-use passwordless login link sent by email.
+type MagicLink struct {
+	ID        int64
+	UserID    int64
+	NonceHash string // SHA-256 of the nonce stored in the requesting browser's cookie
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
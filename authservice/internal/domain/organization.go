@@ -0,0 +1,59 @@
+package domain
+
+import "time"
+
+// Roles a user can hold inside an organization.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// ValidOrgRole reports whether role is a known organization role.
+func ValidOrgRole(role string) bool {
+	switch role {
+	case OrgRoleOwner, OrgRoleAdmin, OrgRoleMember:
+		return true
+	}
+	return false
+}
+
+// Organization is a tenant (e.g. one storefront) that users belong to.
+type Organization struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership links a user to an organization with a per-organization role.
+type Membership struct {
+	OrgID     int64     `json:"org_id"`
+	OrgName   string    `json:"org_name,omitempty"`
+	UserID    int64     `json:"user_id"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invitation is a pending, emailed invitation to join an organization.
+type Invitation struct {
+	ID         int64      `json:"id"`
+	OrgID      int64      `json:"org_id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	TokenHash  string     `json:"-"`
+	InvitedBy  int64      `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// OrgMember is a user as seen from inside an organization.
+type OrgMember struct {
+	User
+	OrgRole    string    `json:"org_role"`
+	Active     bool      `json:"active"`                // False while suspended by the organization's directory
+	ExternalID string    `json:"external_id,omitempty"` // Set by SCIM provisioning
+	JoinedAt   time.Time `json:"joined_at"`
+	ModifiedAt time.Time `json:"modified_at"` // Last change to the membership
+}
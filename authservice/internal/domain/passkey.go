@@ -0,0 +1,22 @@
+package domain
+
+import "time"
+
+// Passkey is a WebAuthn credential registered by a user. It can be used instead of,
+// or in addition to, the user's password.
+type Passkey struct {
+	ID              int64      `json:"id"`
+	UserID          int64      `json:"-"`
+	CredentialID    []byte     `json:"credential_id"`
+	PublicKey       []byte     `json:"-"` // COSE-encoded
+	AttestationType string     `json:"-"`
+	Transports      []string   `json:"transports"`
+	AAGUID          []byte     `json:"aaguid,omitempty"` // Identifies the authenticator model
+	SignCount       uint32     `json:"-"`
+	CloneWarning    bool       `json:"clone_warning"` // The sign count went backwards at some point
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"` // Synced passkey
+	Name            string     `json:"name"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+}
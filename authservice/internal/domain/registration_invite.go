@@ -0,0 +1,24 @@
+package domain
+
+import "time"
+
+// Registration modes, see config.RegistrationMode.
+const (
+	RegistrationOpen       = "open"        // Anyone may register
+	RegistrationInviteOnly = "invite_only" // A registration invite code is required
+	RegistrationDomain     = "domain"      // Only addresses in the allowed email domains, or with an invite code
+	RegistrationClosed     = "closed"      // Nobody may register
+)
+
+// RegistrationInvite is a single-use code allowing one person to register.
+type RegistrationInvite struct {
+	ID        int64      `json:"id"`
+	CodeHash  string     `json:"-"`
+	Role      string     `json:"role"` // Platform role of the new account
+	Note      string     `json:"note,omitempty"`
+	CreatedBy int64      `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	UsedBy    int64      `json:"used_by,omitempty"`
+}
@@ -0,0 +1,15 @@
+package domain
+
+import "time"
+
+// ScimToken authorizes a tenant's identity provider to provision users over SCIM.
+type ScimToken struct {
+	ID          int64      `json:"id"`
+	OrgID       int64      `json:"org_id"`
+	TokenHash   string     `json:"-"`
+	Description string     `json:"description"`
+	CreatedBy   int64      `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
@@ -2,11 +2,45 @@ package domain
 
 import "time"
 
+// Platform-wide roles. Organization roles are separate (see Membership).
+const (
+	RoleUser    = "user"
+	RoleSupport = "support" // Support staff, may impersonate customers
+	RoleAdmin   = "admin"
+)
+
+// Where a user's password is checked.
+const (
+	AuthSourceLocal = "local" // Bcrypt hash in the users table
+	AuthSourceLDAP  = "ldap"  // Bind against the configured LDAP directory
+)
+
 // User represents a user in the system.
 type User struct {
 	ID        int64     `json:"id"`
 	Email     string    `json:"email"`
-	Password  string    `json:"-"` // Store hash, not plaintext; exclude from JSON output
+	Password  string    `json:"-"` // Store hash, not plaintext; exclude from JSON output. Empty for passkey-only accounts
+	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// WebAuthnHandle is the opaque user handle given to authenticators; nil until the first passkey
+	WebAuthnHandle []byte `json:"-"`
+	// AuthSource is where the password is checked; directory accounts have no local password
+	AuthSource string `json:"auth_source"`
+}
+
+// IsStaff reports whether the user belongs to the support or admin staff.
+func (u *User) IsStaff() bool {
+	return u.Role == RoleSupport || u.Role == RoleAdmin
+}
+
+// HasPassword reports whether the user can sign in with a password.
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
+// IsLocal reports whether the user's credentials are managed by this service.
+func (u *User) IsLocal() bool {
+	return u.AuthSource == "" || u.AuthSource == AuthSourceLocal
 }
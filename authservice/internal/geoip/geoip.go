@@ -0,0 +1,78 @@
+// Package geoip locates IP addresses with an offline MaxMind DB file, such as
+// GeoLite2-City.mmdb or a compatible database. Nothing is sent to a third party.
+package geoip
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location is where an IP address is, as precisely as the database knows.
+type Location struct {
+	Country        string // ISO 3166-1 alpha-2
+	City           string // English name, if known
+	Latitude       float64
+	Longitude      float64
+	AccuracyRadius float64 // Kilometers
+}
+
+// Locator looks up IP addresses.
+type Locator interface {
+	// Lookup returns the IP's location, or false if it isn't in the database or has
+	// no coordinates (private ranges, anycast, ...).
+	Lookup(ip net.IP) (*Location, bool)
+}
+
+// cityRecord is the part of a City database record that is read.
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude       *float64 `maxminddb:"latitude"`
+		Longitude      *float64 `maxminddb:"longitude"`
+		AccuracyRadius uint16   `maxminddb:"accuracy_radius"`
+	} `maxminddb:"location"`
+}
+
+// DB is a Locator backed by a MaxMind DB file. It is safe for concurrent use.
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+// Open memory-maps the database file at path.
+func Open(path string) (*DB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Lookup implements Locator.
+func (db *DB) Lookup(ip net.IP) (*Location, bool) {
+	var record cityRecord
+	if ip == nil || db.reader.Lookup(ip, &record) != nil {
+		return nil, false
+	}
+	if record.Location.Latitude == nil || record.Location.Longitude == nil {
+		return nil, false
+	}
+	return &Location{
+		Country:        record.Country.ISOCode,
+		City:           record.City.Names["en"],
+		Latitude:       *record.Location.Latitude,
+		Longitude:      *record.Location.Longitude,
+		AccuracyRadius: float64(record.Location.AccuracyRadius),
+	}, true
+}
+
+// Close unmaps the database.
+func (db *DB) Close() error {
+	return db.reader.Close()
+}
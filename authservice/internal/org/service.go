@@ -0,0 +1,240 @@
+package org
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"authservice/internal/config"
+	"authservice/internal/domain"
+	"authservice/internal/mailer"
+	"authservice/internal/repository"
+)
+
+var (
+	// ErrForbidden is returned when the user's role in the organization doesn't allow the action.
+	ErrForbidden = errors.New("insufficient organization role")
+	// ErrInvalidRole is returned for unknown roles.
+	ErrInvalidRole = errors.New("invalid organization role")
+	// ErrInvitationEmailMismatch is returned when an invitation is accepted by a different account.
+	ErrInvitationEmailMismatch = errors.New("invitation was sent to a different email address")
+)
+
+// OrgService manages organizations, memberships and invitations.
+type OrgService interface {
+	CreateOrganization(ctx context.Context, userID int64, name, slug string) (*domain.Organization, error)
+	ListOrganizations(ctx context.Context, userID int64) ([]domain.Membership, error)
+	ListMembers(ctx context.Context, orgID, requesterID int64) ([]domain.OrgMember, error)
+	InviteMember(ctx context.Context, orgID, inviterID int64, email, role string) (*domain.Invitation, error)
+	AcceptInvitation(ctx context.Context, userID int64, token string) (*domain.Membership, error)
+
+	// SCIM provisioning tokens. CreateScimToken returns the plaintext token, which is not stored.
+	CreateScimToken(ctx context.Context, orgID, requesterID int64, description string) (*domain.ScimToken, string, error)
+	ListScimTokens(ctx context.Context, orgID, requesterID int64) ([]domain.ScimToken, error)
+	RevokeScimToken(ctx context.Context, orgID, requesterID, tokenID int64) error
+	// AuthenticateScimToken returns the active token matching a directory's bearer token.
+	AuthenticateScimToken(ctx context.Context, token string) (*domain.ScimToken, error)
+}
+
+type orgService struct {
+	orgRepo       repository.OrgRepository
+	userRepo      repository.UserRepository
+	scimTokenRepo repository.ScimTokenRepository
+	mailer        mailer.Mailer
+	cfg           *config.Config
+}
+
+// NewOrgService creates a new OrgService.
+func NewOrgService(orgRepo repository.OrgRepository, userRepo repository.UserRepository, scimTokenRepo repository.ScimTokenRepository, mailer mailer.Mailer, cfg *config.Config) OrgService {
+	return &orgService{orgRepo: orgRepo, userRepo: userRepo, scimTokenRepo: scimTokenRepo, mailer: mailer, cfg: cfg}
+}
+
+// CreateOrganization creates an organization owned by userID.
+func (s *orgService) CreateOrganization(ctx context.Context, userID int64, name, slug string) (*domain.Organization, error) {
+	org := &domain.Organization{Name: name, Slug: strings.ToLower(slug)}
+	id, err := s.orgRepo.CreateOrganization(ctx, org, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrSlugExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to create organization: %w", err)
+	}
+	return s.orgRepo.GetOrganizationByID(ctx, id)
+}
+
+// ListOrganizations lists the organizations the user belongs to.
+func (s *orgService) ListOrganizations(ctx context.Context, userID int64) ([]domain.Membership, error) {
+	return s.orgRepo.ListMemberships(ctx, userID)
+}
+
+// ListMembers lists an organization's members. Any member may list them.
+func (s *orgService) ListMembers(ctx context.Context, orgID, requesterID int64) ([]domain.OrgMember, error) {
+	if _, err := s.requireRole(ctx, orgID, requesterID); err != nil {
+		return nil, err
+	}
+	return s.userRepo.ListUsersByOrg(ctx, orgID)
+}
+
+// InviteMember emails an invitation. Owners and admins may invite; only owners may invite owners.
+func (s *orgService) InviteMember(ctx context.Context, orgID, inviterID int64, email, role string) (*domain.Invitation, error) {
+	if !domain.ValidOrgRole(role) {
+		return nil, ErrInvalidRole
+	}
+	inviter, err := s.requireRole(ctx, orgID, inviterID, domain.OrgRoleOwner, domain.OrgRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if role == domain.OrgRoleOwner && inviter.Role != domain.OrgRoleOwner {
+		return nil, ErrForbidden
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate invitation token: %w", err)
+	}
+
+	inv := &domain.Invitation{
+		OrgID:     orgID,
+		Email:     strings.ToLower(email),
+		Role:      role,
+		TokenHash: hashToken(token),
+		InvitedBy: inviterID,
+		ExpiresAt: time.Now().Add(s.cfg.InvitationTTL),
+	}
+	inv.ID, err = s.orgRepo.CreateInvitation(ctx, inv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	link := s.cfg.InvitationBaseURL + "?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("You've been invited to join %s", inviter.OrgName),
+		Body: fmt.Sprintf("You've been invited to join %s as %s.\n\nSign in (or create an account with this email address) "+
+			"and open the link below to accept. It expires on %s.\n\n%s\n",
+			inviter.OrgName, role, inv.ExpiresAt.Format(time.RFC1123), link),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send invitation: %w", err)
+	}
+	return inv, nil
+}
+
+// AcceptInvitation adds the user to the invitation's organization. The invitation
+// must have been sent to the user's email address.
+func (s *orgService) AcceptInvitation(ctx context.Context, userID int64, token string) (*domain.Membership, error) {
+	inv, err := s.orgRepo.GetInvitationByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if !strings.EqualFold(user.Email, inv.Email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+
+	return s.orgRepo.AcceptInvitation(ctx, inv.ID, userID)
+}
+
+// CreateScimToken issues a token for the organization's identity provider. Only owners may
+// create tokens, since SCIM can grant any organization role.
+func (s *orgService) CreateScimToken(ctx context.Context, orgID, requesterID int64, description string) (*domain.ScimToken, string, error) {
+	if _, err := s.requireRole(ctx, orgID, requesterID, domain.OrgRoleOwner); err != nil {
+		return nil, "", err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate SCIM token: %w", err)
+	}
+	token = scimTokenPrefix + token
+
+	t := &domain.ScimToken{
+		OrgID:       orgID,
+		TokenHash:   hashToken(token),
+		Description: description,
+		CreatedBy:   requesterID,
+	}
+	if _, err := s.scimTokenRepo.CreateToken(ctx, t); err != nil {
+		return nil, "", fmt.Errorf("failed to create SCIM token: %w", err)
+	}
+	return t, token, nil
+}
+
+// ListScimTokens lists the organization's SCIM tokens to owners and admins.
+func (s *orgService) ListScimTokens(ctx context.Context, orgID, requesterID int64) ([]domain.ScimToken, error) {
+	if _, err := s.requireRole(ctx, orgID, requesterID, domain.OrgRoleOwner, domain.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.scimTokenRepo.ListTokens(ctx, orgID)
+}
+
+// RevokeScimToken revokes a token. Owners and admins may revoke tokens.
+func (s *orgService) RevokeScimToken(ctx context.Context, orgID, requesterID, tokenID int64) error {
+	if _, err := s.requireRole(ctx, orgID, requesterID, domain.OrgRoleOwner, domain.OrgRoleAdmin); err != nil {
+		return err
+	}
+	return s.scimTokenRepo.RevokeToken(ctx, orgID, tokenID)
+}
+
+// AuthenticateScimToken looks the token up by hash and records its use.
+func (s *orgService) AuthenticateScimToken(ctx context.Context, token string) (*domain.ScimToken, error) {
+	if !strings.HasPrefix(token, scimTokenPrefix) {
+		return nil, repository.ErrScimTokenNotFound
+	}
+	t, err := s.scimTokenRepo.GetTokenByHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.scimTokenRepo.TouchToken(ctx, t.ID); err != nil {
+		return nil, fmt.Errorf("failed to record SCIM token use: %w", err)
+	}
+	return t, nil
+}
+
+// requireRole returns the user's membership if it has one of roles (any role if none given).
+// Non-members get ErrForbidden as well, so organization IDs can't be probed.
+func (s *orgService) requireRole(ctx context.Context, orgID, userID int64, roles ...string) (*domain.Membership, error) {
+	m, err := s.orgRepo.GetMembership(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMembershipNotFound) {
+			return nil, ErrForbidden
+		}
+		return nil, fmt.Errorf("failed to get membership: %w", err)
+	}
+	if len(roles) == 0 {
+		return m, nil
+	}
+	for _, role := range roles {
+		if m.Role == role {
+			return m, nil
+		}
+	}
+	return nil, ErrForbidden
+}
+
+// scimTokenPrefix makes SCIM tokens recognizable, e.g. to secret scanners.
+const scimTokenPrefix = "scim_"
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,281 @@
+// Package privacy implements data-subject requests (GDPR): exporting everything known
+// about a user and erasing it across all services.
+//
+// Other services take part through two internal endpoints, authenticated with
+// INTERNAL_API_TOKEN:
+//
+//	GET  /internal/users/{userID}/export  returns the service's data about the user as JSON
+//	POST /internal/user-deletions         {"erasure_id": 1, "user_id": "42"}, idempotent; 2xx confirms
+package privacy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"authservice/internal/config"
+	"authservice/internal/domain"
+	"authservice/internal/repository"
+	"authservice/internal/telemetry"
+)
+
+// ErrServiceUnavailable is returned when a service's data can't be fetched for an export.
+var ErrServiceUnavailable = errors.New("service unavailable")
+
+// maxRetryDelay caps the backoff between deletion attempts.
+const maxRetryDelay = time.Hour
+
+// Export is the archive returned to a user who asks for their data.
+type Export struct {
+	ExportedAt            time.Time                     `json:"exported_at"`
+	User                  *domain.User                  `json:"user"`
+	Memberships           []domain.Membership           `json:"memberships"`
+	Passkeys              []domain.Passkey              `json:"passkeys"`
+	ImpersonationSessions []domain.ImpersonationSession `json:"impersonation_sessions"`
+	LoginHistory          []domain.LoginEvent           `json:"login_history"`
+	Services              map[string]json.RawMessage    `json:"services"` // Keyed by service name
+}
+
+// DeletionEvent is sent to every service when a user is erased.
+type DeletionEvent struct {
+	ErasureID int64  `json:"erasure_id"`
+	UserID    string `json:"user_id"` // Other services store user IDs as strings
+}
+
+// PrivacyService handles data export and erasure requests.
+type PrivacyService interface {
+	ExportUserData(ctx context.Context, userID int64) (*Export, error)
+	RequestErasure(ctx context.Context, userID int64) (*domain.ErasureRequest, error)
+	GetErasure(ctx context.Context, id int64) (*domain.ErasureRequest, error)
+	ListErasures(ctx context.Context, limit int) ([]domain.ErasureRequest, error)
+	// RunDispatcher delivers deletion events until ctx is cancelled, retrying failures with backoff.
+	RunDispatcher(ctx context.Context)
+}
+
+type privacyService struct {
+	userRepo    repository.UserRepository
+	orgRepo     repository.OrgRepository
+	passkeyRepo repository.PasskeyRepository
+	impRepo     repository.ImpersonationRepository
+	loginRepo   repository.LoginHistoryRepository
+	erasureRepo repository.ErasureRepository
+	client      *http.Client
+	services    map[string]string // Service name -> base URL
+	cfg         *config.Config
+}
+
+// NewPrivacyService creates a new PrivacyService. Services with an empty URL are left out.
+func NewPrivacyService(userRepo repository.UserRepository, orgRepo repository.OrgRepository, passkeyRepo repository.PasskeyRepository, impRepo repository.ImpersonationRepository, loginRepo repository.LoginHistoryRepository, erasureRepo repository.ErasureRepository, cfg *config.Config) PrivacyService {
+	services := make(map[string]string)
+	if cfg.OrderServiceURL != "" {
+		services["orderservice"] = cfg.OrderServiceURL
+	}
+	if cfg.ProductServiceURL != "" {
+		services["productservice"] = cfg.ProductServiceURL
+	}
+	return &privacyService{
+		userRepo:    userRepo,
+		orgRepo:     orgRepo,
+		passkeyRepo: passkeyRepo,
+		impRepo:     impRepo,
+		loginRepo:   loginRepo,
+		erasureRepo: erasureRepo,
+		client:      telemetry.NewHTTPClient(10 * time.Second),
+		services:    services,
+		cfg:         cfg,
+	}
+}
+
+// ExportUserData collects the user's data from this service and every other service.
+// It fails rather than returning an incomplete archive.
+func (s *privacyService) ExportUserData(ctx context.Context, userID int64) (*Export, error) {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	memberships, err := s.orgRepo.ListMemberships(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list memberships: %w", err)
+	}
+	passkeys, err := s.passkeyRepo.ListPasskeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	sessions, err := s.impRepo.ListSessions(ctx, userID, 1000)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list impersonation sessions: %w", err)
+	}
+	logins, err := s.loginRepo.ListLogins(ctx, userID, 1000)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list login history: %w", err)
+	}
+
+	export := &Export{
+		ExportedAt:            time.Now().UTC(),
+		User:                  user,
+		Memberships:           memberships,
+		Passkeys:              passkeys,
+		ImpersonationSessions: sessions,
+		LoginHistory:          logins,
+		Services:              make(map[string]json.RawMessage, len(s.services)),
+	}
+	for _, name := range s.serviceNames() {
+		data, err := s.fetchExport(ctx, s.services[name], userID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrServiceUnavailable, name, err)
+		}
+		export.Services[name] = data
+	}
+	return export, nil
+}
+
+// fetchExport gets a service's export for the user.
+func (s *privacyService) fetchExport(ctx context.Context, baseURL string, userID int64) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/internal/users/"+strconv.FormatInt(userID, 10)+"/export", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.cfg.InternalAPIToken)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, errors.New("response is not valid JSON")
+	}
+	return body, nil
+}
+
+// RequestErasure anonymizes the user locally and queues a deletion event for every service.
+func (s *privacyService) RequestErasure(ctx context.Context, userID int64) (*domain.ErasureRequest, error) {
+	req, err := s.erasureRepo.EraseUser(ctx, userID, s.serviceNames())
+	if err != nil {
+		if errors.Is(err, repository.ErrErasureExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to erase user: %w", err)
+	}
+	slog.InfoContext(ctx, "User erased, notifying services", "erasure_id", req.ID, "user_id", userID)
+	return req, nil
+}
+
+// GetErasure returns an erasure request with its per-service status.
+func (s *privacyService) GetErasure(ctx context.Context, id int64) (*domain.ErasureRequest, error) {
+	return s.erasureRepo.GetErasure(ctx, id)
+}
+
+// ListErasures lists recent erasure requests.
+func (s *privacyService) ListErasures(ctx context.Context, limit int) ([]domain.ErasureRequest, error) {
+	return s.erasureRepo.ListErasures(ctx, limit)
+}
+
+// RunDispatcher polls for due deletion events every ErasureRetryInterval.
+func (s *privacyService) RunDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.ErasureRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.dispatchDue(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Error dispatching deletion events", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchDue sends every deletion event that is due and records the outcome.
+func (s *privacyService) dispatchDue(ctx context.Context) error {
+	due, err := s.erasureRepo.ListDueConfirmations(ctx, 100)
+	if err != nil {
+		return fmt.Errorf("failed to list pending deletions: %w", err)
+	}
+
+	for _, c := range due {
+		err := s.sendDeletion(ctx, c)
+		if err == nil {
+			if err := s.erasureRepo.ConfirmDeletion(ctx, c.ErasureID, c.Service); err != nil {
+				return fmt.Errorf("failed to confirm deletion: %w", err)
+			}
+			slog.InfoContext(ctx, "Service confirmed user deletion", "erasure_id", c.ErasureID, "service", c.Service)
+			continue
+		}
+
+		delay := s.cfg.ErasureRetryInterval << min(c.Attempts, 16)
+		if delay > maxRetryDelay || delay <= 0 {
+			delay = maxRetryDelay
+		}
+		slog.WarnContext(ctx, "User deletion not confirmed, will retry",
+			"erasure_id", c.ErasureID,
+			"service", c.Service,
+			"attempts", c.Attempts+1,
+			"retry_in", delay.String(),
+			"error", err,
+		)
+		if err := s.erasureRepo.RecordDeletionFailure(ctx, c.ErasureID, c.Service, err.Error(), time.Now().Add(delay)); err != nil {
+			return fmt.Errorf("failed to record deletion failure: %w", err)
+		}
+	}
+	return nil
+}
+
+// sendDeletion posts the deletion event to one service.
+func (s *privacyService) sendDeletion(ctx context.Context, c domain.ErasureConfirmation) error {
+	baseURL, ok := s.services[c.Service]
+	if !ok {
+		return fmt.Errorf("service %q is not configured", c.Service)
+	}
+
+	body, err := json.Marshal(DeletionEvent{ErasureID: c.ErasureID, UserID: strconv.FormatInt(c.UserID, 10)})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/internal/user-deletions", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.cfg.InternalAPIToken)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// serviceNames returns the configured service names in a stable order.
+func (s *privacyService) serviceNames() []string {
+	names := make([]string, 0, len(s.services))
+	for name := range s.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
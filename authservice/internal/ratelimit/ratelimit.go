@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is an in-memory fixed-window rate limiter keyed by an arbitrary string
+// (an email address, an IP, ...). It is safe for concurrent use.
+type Limiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	start time.Time
+	count int
+}
+
+// New creates a limiter that allows limit events per key in every window.
+func New(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		window:  window,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow records an event for key and reports whether it is within the limit.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+
+	w, ok := l.buckets[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &bucket{start: now}
+		l.buckets[key] = w
+	}
+	w.count++
+	return w.count <= l.limit
+}
+
+// cleanup drops expired buckets so the map doesn't grow without bound.
+// Called with l.mu held.
+func (l *Limiter) cleanup(now time.Time) {
+	if len(l.buckets) < 1024 {
+		return
+	}
+	for key, w := range l.buckets {
+		if now.Sub(w.start) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Exceeded reports whether key is over the limit in its current window, without
+// recording an event.
+func (l *Limiter) Exceeded(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.buckets[key]
+	return ok && time.Since(w.start) < l.window && w.count > l.limit
+}
@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"authservice/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrErasureNotFound = errors.New("erasure request not found")
+var ErrErasureExists = errors.New("user has already been erased")
+
+// ErasureRepository stores right-to-erasure requests and their per-service confirmations.
+type ErasureRepository interface {
+	// EraseUser anonymizes the user's row, deletes their local data and records an erasure
+	// request awaiting confirmation from each of services, all in one transaction.
+	EraseUser(ctx context.Context, userID int64, services []string) (*domain.ErasureRequest, error)
+	GetErasure(ctx context.Context, id int64) (*domain.ErasureRequest, error)
+	ListErasures(ctx context.Context, limit int) ([]domain.ErasureRequest, error)
+
+	// ListDueConfirmations returns unconfirmed deletions whose next attempt is due.
+	ListDueConfirmations(ctx context.Context, limit int) ([]domain.ErasureConfirmation, error)
+	// ConfirmDeletion marks the service as done and completes the request once all services are.
+	ConfirmDeletion(ctx context.Context, erasureID int64, service string) error
+	RecordDeletionFailure(ctx context.Context, erasureID int64, service, lastError string, nextAttemptAt time.Time) error
+}
+
+// postgresErasureRepository implements ErasureRepository for PostgreSQL.
+type postgresErasureRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresErasureRepository creates a new PostgreSQL erasure repository.
+func NewPostgresErasureRepository(pool *pgxpool.Pool) ErasureRepository {
+	return &postgresErasureRepository{pool: pool}
+}
+
+// EraseUser removes everything that identifies the user. The users row itself is kept,
+// anonymized, so foreign keys from audit records (impersonation sessions, the erasure
+// request) stay valid.
+func (r *postgresErasureRepository) EraseUser(ctx context.Context, userID int64, services []string) (*domain.ErasureRequest, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var email string
+	err = tx.QueryRow(ctx, `SELECT email FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM erasure_requests WHERE user_id = $1)`, userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrErasureExists
+	}
+
+	statements := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{`UPDATE users SET email = 'erased-' || id || '@erased.invalid', password_hash = NULL,
+		  webauthn_handle = NULL, role = 'user', updated_at = now()
+		  WHERE id = $1`, []interface{}{userID}},
+		{`DELETE FROM webauthn_credentials WHERE user_id = $1`, []interface{}{userID}},
+		{`DELETE FROM webauthn_ceremonies WHERE user_id = $1`, []interface{}{userID}},
+		{`DELETE FROM magic_links WHERE user_id = $1`, []interface{}{userID}},
+		{`DELETE FROM memberships WHERE user_id = $1`, []interface{}{userID}},
+		{`DELETE FROM login_history WHERE user_id = $1`, []interface{}{userID}},
+		{`DELETE FROM org_invitations WHERE lower(email) = lower($1)`, []interface{}{email}},
+		{`UPDATE org_invitations SET invited_by = NULL WHERE invited_by = $1`, []interface{}{userID}},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(ctx, stmt.sql, stmt.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	req := &domain.ErasureRequest{UserID: userID, Status: domain.ErasureStatusPending}
+	if len(services) == 0 {
+		req.Status = domain.ErasureStatusCompleted
+	}
+	query := `INSERT INTO erasure_requests (user_id, status, completed_at)
+			  VALUES ($1, $2, CASE WHEN $2 = 'completed' THEN now() END)
+			  RETURNING id, requested_at, completed_at`
+	if err := tx.QueryRow(ctx, query, userID, req.Status).Scan(&req.ID, &req.RequestedAt, &req.CompletedAt); err != nil {
+		return nil, err
+	}
+
+	for _, service := range services {
+		c := domain.ErasureConfirmation{ErasureID: req.ID, UserID: userID, Service: service}
+		err := tx.QueryRow(ctx, `INSERT INTO erasure_confirmations (erasure_id, service) VALUES ($1, $2) RETURNING next_attempt_at`,
+			req.ID, service).Scan(&c.NextAttemptAt)
+		if err != nil {
+			return nil, err
+		}
+		req.Confirmations = append(req.Confirmations, c)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// GetErasure retrieves an erasure request with its confirmations.
+func (r *postgresErasureRepository) GetErasure(ctx context.Context, id int64) (*domain.ErasureRequest, error) {
+	query := `SELECT id, user_id, status, requested_at, completed_at FROM erasure_requests WHERE id = $1`
+	req := &domain.ErasureRequest{}
+	err := r.pool.QueryRow(ctx, query, id).Scan(&req.ID, &req.UserID, &req.Status, &req.RequestedAt, &req.CompletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrErasureNotFound
+		}
+		return nil, err
+	}
+
+	query = `SELECT erasure_id, service, attempts, last_error, next_attempt_at, confirmed_at
+			 FROM erasure_confirmations
+			 WHERE erasure_id = $1
+			 ORDER BY service`
+	rows, err := r.pool.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := domain.ErasureConfirmation{UserID: req.UserID}
+		if err := rows.Scan(&c.ErasureID, &c.Service, &c.Attempts, &c.LastError, &c.NextAttemptAt, &c.ConfirmedAt); err != nil {
+			return nil, err
+		}
+		req.Confirmations = append(req.Confirmations, c)
+	}
+	return req, rows.Err()
+}
+
+// ListErasures lists the most recent erasure requests, without confirmations.
+func (r *postgresErasureRepository) ListErasures(ctx context.Context, limit int) ([]domain.ErasureRequest, error) {
+	query := `SELECT id, user_id, status, requested_at, completed_at
+			  FROM erasure_requests
+			  ORDER BY requested_at DESC, id DESC
+			  LIMIT $1`
+	rows, err := r.pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []domain.ErasureRequest
+	for rows.Next() {
+		var req domain.ErasureRequest
+		if err := rows.Scan(&req.ID, &req.UserID, &req.Status, &req.RequestedAt, &req.CompletedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// ListDueConfirmations lists pending deletions that should be (re)sent now. Several
+// instances may pick up the same row; the services' deletion endpoints are idempotent.
+func (r *postgresErasureRepository) ListDueConfirmations(ctx context.Context, limit int) ([]domain.ErasureConfirmation, error) {
+	query := `SELECT c.erasure_id, e.user_id, c.service, c.attempts, c.last_error, c.next_attempt_at
+			  FROM erasure_confirmations c JOIN erasure_requests e ON e.id = c.erasure_id
+			  WHERE c.confirmed_at IS NULL AND c.next_attempt_at <= now()
+			  ORDER BY c.next_attempt_at
+			  LIMIT $1`
+	rows, err := r.pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var confirmations []domain.ErasureConfirmation
+	for rows.Next() {
+		var c domain.ErasureConfirmation
+		if err := rows.Scan(&c.ErasureID, &c.UserID, &c.Service, &c.Attempts, &c.LastError, &c.NextAttemptAt); err != nil {
+			return nil, err
+		}
+		confirmations = append(confirmations, c)
+	}
+	return confirmations, rows.Err()
+}
+
+// ConfirmDeletion records a service's confirmation.
+func (r *postgresErasureRepository) ConfirmDeletion(ctx context.Context, erasureID int64, service string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE erasure_confirmations
+			  SET confirmed_at = COALESCE(confirmed_at, now()), attempts = attempts + 1, last_error = ''
+			  WHERE erasure_id = $1 AND service = $2`
+	tag, err := tx.Exec(ctx, query, erasureID, service)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrErasureNotFound
+	}
+
+	query = `UPDATE erasure_requests SET status = 'completed', completed_at = now()
+			 WHERE id = $1 AND status = 'pending'
+			   AND NOT EXISTS (SELECT 1 FROM erasure_confirmations WHERE erasure_id = $1 AND confirmed_at IS NULL)`
+	if _, err := tx.Exec(ctx, query, erasureID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RecordDeletionFailure records a failed attempt and when to retry.
+func (r *postgresErasureRepository) RecordDeletionFailure(ctx context.Context, erasureID int64, service, lastError string, nextAttemptAt time.Time) error {
+	query := `UPDATE erasure_confirmations
+			  SET attempts = attempts + 1, last_error = $3, next_attempt_at = $4
+			  WHERE erasure_id = $1 AND service = $2 AND confirmed_at IS NULL`
+	_, err := r.pool.Exec(ctx, query, erasureID, service, lastError, nextAttemptAt)
+	return err
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+
+	"authservice/internal/domain"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ImpersonationRepository stores the audit trail of impersonation sessions.
+type ImpersonationRepository interface {
+	CreateSession(ctx context.Context, session *domain.ImpersonationSession) (int64, error)
+	// ListSessions returns the most recent sessions first. A zero userID lists sessions
+	// of all users, otherwise only sessions where the user was actor or target.
+	ListSessions(ctx context.Context, userID int64, limit int) ([]domain.ImpersonationSession, error)
+}
+
+// postgresImpersonationRepository implements ImpersonationRepository for PostgreSQL.
+type postgresImpersonationRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresImpersonationRepository creates a new PostgreSQL impersonation repository.
+func NewPostgresImpersonationRepository(pool *pgxpool.Pool) ImpersonationRepository {
+	return &postgresImpersonationRepository{pool: pool}
+}
+
+// CreateSession records the start of an impersonation session.
+func (r *postgresImpersonationRepository) CreateSession(ctx context.Context, session *domain.ImpersonationSession) (int64, error) {
+	query := `INSERT INTO impersonation_sessions (actor_id, target_id, reason, ip_address, expires_at)
+			  VALUES ($1, $2, $3, $4, $5)
+			  RETURNING id, started_at`
+	err := r.pool.QueryRow(ctx, query, session.ActorID, session.TargetID, session.Reason, session.IPAddress, session.ExpiresAt).
+		Scan(&session.ID, &session.StartedAt)
+	if err != nil {
+		return 0, err
+	}
+	return session.ID, nil
+}
+
+// ListSessions lists recorded impersonation sessions.
+func (r *postgresImpersonationRepository) ListSessions(ctx context.Context, userID int64, limit int) ([]domain.ImpersonationSession, error) {
+	query := `SELECT id, actor_id, target_id, reason, ip_address, started_at, expires_at
+			  FROM impersonation_sessions
+			  WHERE $1 = 0 OR actor_id = $1 OR target_id = $1
+			  ORDER BY started_at DESC, id DESC
+			  LIMIT $2`
+	rows, err := r.pool.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []domain.ImpersonationSession
+	for rows.Next() {
+		var s domain.ImpersonationSession
+		if err := rows.Scan(&s.ID, &s.ActorID, &s.TargetID, &s.Reason, &s.IPAddress, &s.StartedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
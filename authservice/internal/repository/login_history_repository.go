@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+
+	"authservice/internal/domain"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LoginHistoryRepository stores users' successful logins.
+type LoginHistoryRepository interface {
+	RecordLogin(ctx context.Context, event *domain.LoginEvent) (int64, error)
+	// ListLogins returns the user's most recent logins first.
+	ListLogins(ctx context.Context, userID int64, limit int) ([]domain.LoginEvent, error)
+	// GetFamiliarity reports whether the device and network have signed in to the account before.
+	GetFamiliarity(ctx context.Context, userID int64, deviceHash, network string) (*domain.LoginFamiliarity, error)
+}
+
+// postgresLoginHistoryRepository implements LoginHistoryRepository for PostgreSQL.
+type postgresLoginHistoryRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresLoginHistoryRepository creates a new PostgreSQL login history repository.
+func NewPostgresLoginHistoryRepository(pool *pgxpool.Pool) LoginHistoryRepository {
+	return &postgresLoginHistoryRepository{pool: pool}
+}
+
+// RecordLogin stores a login.
+func (r *postgresLoginHistoryRepository) RecordLogin(ctx context.Context, e *domain.LoginEvent) (int64, error) {
+	if e.Flags == nil {
+		e.Flags = []string{}
+	}
+	query := `INSERT INTO login_history
+			  (user_id, ip_address, network, device_hash, user_agent, country, city, latitude, longitude, flags)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			  RETURNING id, created_at`
+	err := r.pool.QueryRow(ctx, query, e.UserID, e.IPAddress, e.Network, e.DeviceHash, e.UserAgent, e.Country, e.City,
+		e.Latitude, e.Longitude, e.Flags).Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return e.ID, nil
+}
+
+// ListLogins lists the user's recorded logins.
+func (r *postgresLoginHistoryRepository) ListLogins(ctx context.Context, userID int64, limit int) ([]domain.LoginEvent, error) {
+	query := `SELECT id, user_id, ip_address, network, device_hash, user_agent, country, city, latitude, longitude, flags, created_at
+			  FROM login_history
+			  WHERE user_id = $1
+			  ORDER BY created_at DESC, id DESC
+			  LIMIT $2`
+	rows, err := r.pool.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []domain.LoginEvent
+	for rows.Next() {
+		var e domain.LoginEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.IPAddress, &e.Network, &e.DeviceHash, &e.UserAgent, &e.Country, &e.City,
+			&e.Latitude, &e.Longitude, &e.Flags, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// GetFamiliarity compares a login's device and network with the user's history.
+func (r *postgresLoginHistoryRepository) GetFamiliarity(ctx context.Context, userID int64, deviceHash, network string) (*domain.LoginFamiliarity, error) {
+	query := `SELECT count(*),
+			  coalesce(bool_or(device_hash = $2), false),
+			  coalesce(bool_or(network = $3), false)
+			  FROM login_history
+			  WHERE user_id = $1`
+	var f domain.LoginFamiliarity
+	if err := r.pool.QueryRow(ctx, query, userID, deviceHash, network).Scan(&f.Logins, &f.KnownDevice, &f.KnownNetwork); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
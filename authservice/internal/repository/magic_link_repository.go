@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"authservice/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrMagicLinkNotFound = errors.New("magic link not found, expired or already used")
+
+// MagicLinkRepository defines the interface for magic-link data operations.
+type MagicLinkRepository interface {
+	CreateMagicLink(ctx context.Context, link *domain.MagicLink) (int64, error)
+	// ConsumeMagicLink marks an unused, unexpired link as used and returns it.
+	ConsumeMagicLink(ctx context.Context, id int64) (*domain.MagicLink, error)
+}
+
+// postgresMagicLinkRepository implements MagicLinkRepository for PostgreSQL.
+type postgresMagicLinkRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresMagicLinkRepository creates a new PostgreSQL magic-link repository.
+func NewPostgresMagicLinkRepository(pool *pgxpool.Pool) MagicLinkRepository {
+	return &postgresMagicLinkRepository{pool: pool}
+}
+
+// CreateMagicLink stores a new magic link.
+func (r *postgresMagicLinkRepository) CreateMagicLink(ctx context.Context, link *domain.MagicLink) (int64, error) {
+	query := `INSERT INTO magic_links (user_id, nonce_hash, expires_at)
+			  VALUES ($1, $2, $3)
+			  RETURNING id`
+	var id int64
+	err := r.pool.QueryRow(ctx, query, link.UserID, link.NonceHash, link.ExpiresAt).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ConsumeMagicLink atomically marks the link as used so it can't be redeemed twice.
+func (r *postgresMagicLinkRepository) ConsumeMagicLink(ctx context.Context, id int64) (*domain.MagicLink, error) {
+	query := `UPDATE magic_links SET used_at = now()
+			  WHERE id = $1 AND used_at IS NULL AND expires_at > now()
+			  RETURNING id, user_id, nonce_hash, expires_at, used_at, created_at`
+	link := &domain.MagicLink{}
+	err := r.pool.QueryRow(ctx, query, id).Scan(&link.ID, &link.UserID, &link.NonceHash, &link.ExpiresAt, &link.UsedAt, &link.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMagicLinkNotFound
+		}
+		return nil, err
+	}
+	return link, nil
+}
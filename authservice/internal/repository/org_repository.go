@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"authservice/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrOrgNotFound         = errors.New("organization not found")
+	ErrSlugExists          = errors.New("organization slug already exists")
+	ErrMembershipNotFound  = errors.New("membership not found")
+	ErrMembershipExists    = errors.New("user is already a member or external ID is taken")
+	ErrInvitationNotFound  = errors.New("invitation not found, expired or already accepted")
+	ErrInvitationDuplicate = errors.New("invitation token already exists")
+)
+
+// OrgRepository defines the interface for organization, membership and invitation data operations.
+type OrgRepository interface {
+	// CreateOrganization inserts the organization and makes ownerID its owner.
+	CreateOrganization(ctx context.Context, org *domain.Organization, ownerID int64) (int64, error)
+	GetOrganizationByID(ctx context.Context, id int64) (*domain.Organization, error)
+	// ListMemberships returns the user's active memberships, oldest first.
+	ListMemberships(ctx context.Context, userID int64) ([]domain.Membership, error)
+	// GetMembership returns the user's membership if it is active.
+	GetMembership(ctx context.Context, orgID, userID int64) (*domain.Membership, error)
+
+	// Membership management for directory provisioning (SCIM)
+	AddMember(ctx context.Context, member *domain.OrgMember, orgID int64) error
+	UpdateMember(ctx context.Context, member *domain.OrgMember, orgID int64) error
+	RemoveMember(ctx context.Context, orgID, userID int64) error
+
+	CreateInvitation(ctx context.Context, inv *domain.Invitation) (int64, error)
+	GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*domain.Invitation, error)
+	// AcceptInvitation marks the invitation accepted and adds the user to the organization.
+	// An existing membership is left untouched.
+	AcceptInvitation(ctx context.Context, invitationID, userID int64) (*domain.Membership, error)
+}
+
+// postgresOrgRepository implements OrgRepository for PostgreSQL.
+type postgresOrgRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresOrgRepository creates a new PostgreSQL organization repository.
+func NewPostgresOrgRepository(pool *pgxpool.Pool) OrgRepository {
+	return &postgresOrgRepository{pool: pool}
+}
+
+// CreateOrganization inserts a new organization together with its owner membership.
+func (r *postgresOrgRepository) CreateOrganization(ctx context.Context, org *domain.Organization, ownerID int64) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) // No-op after Commit
+
+	var orgID int64
+	err = tx.QueryRow(ctx, `INSERT INTO organizations (name, slug) VALUES ($1, $2) RETURNING id`,
+		org.Name, org.Slug).Scan(&orgID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrSlugExists
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO memberships (org_id, user_id, role) VALUES ($1, $2, $3)`,
+		orgID, ownerID, domain.OrgRoleOwner)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return orgID, nil
+}
+
+// GetOrganizationByID retrieves an organization by its ID.
+func (r *postgresOrgRepository) GetOrganizationByID(ctx context.Context, id int64) (*domain.Organization, error) {
+	query := `SELECT id, name, slug, created_at FROM organizations WHERE id = $1`
+	org := &domain.Organization{}
+	err := r.pool.QueryRow(ctx, query, id).Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOrgNotFound
+		}
+		return nil, err
+	}
+	return org, nil
+}
+
+// ListMemberships lists all organizations the user belongs to.
+func (r *postgresOrgRepository) ListMemberships(ctx context.Context, userID int64) ([]domain.Membership, error) {
+	query := `SELECT m.org_id, o.name, m.user_id, m.role, m.created_at
+			  FROM memberships m JOIN organizations o ON o.id = m.org_id
+			  WHERE m.user_id = $1 AND m.active
+			  ORDER BY m.created_at, m.org_id`
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memberships []domain.Membership
+	for rows.Next() {
+		var m domain.Membership
+		if err := rows.Scan(&m.OrgID, &m.OrgName, &m.UserID, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+// GetMembership retrieves the user's membership in one organization.
+func (r *postgresOrgRepository) GetMembership(ctx context.Context, orgID, userID int64) (*domain.Membership, error) {
+	query := `SELECT m.org_id, o.name, m.user_id, m.role, m.created_at
+			  FROM memberships m JOIN organizations o ON o.id = m.org_id
+			  WHERE m.org_id = $1 AND m.user_id = $2 AND m.active`
+	m := &domain.Membership{}
+	err := r.pool.QueryRow(ctx, query, orgID, userID).Scan(&m.OrgID, &m.OrgName, &m.UserID, &m.Role, &m.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMembershipNotFound
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddMember adds an existing user to the organization with member's role, status and external ID.
+func (r *postgresOrgRepository) AddMember(ctx context.Context, member *domain.OrgMember, orgID int64) error {
+	query := `INSERT INTO memberships (org_id, user_id, role, active, external_id)
+			  VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+			  RETURNING created_at, updated_at`
+	err := r.pool.QueryRow(ctx, query, orgID, member.ID, member.OrgRole, member.Active, member.ExternalID).
+		Scan(&member.JoinedAt, &member.ModifiedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrMembershipExists
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateMember replaces the membership's role, status and external ID.
+func (r *postgresOrgRepository) UpdateMember(ctx context.Context, member *domain.OrgMember, orgID int64) error {
+	query := `UPDATE memberships SET role = $3, active = $4, external_id = NULLIF($5, ''), updated_at = now()
+			  WHERE org_id = $1 AND user_id = $2
+			  RETURNING updated_at`
+	err := r.pool.QueryRow(ctx, query, orgID, member.ID, member.OrgRole, member.Active, member.ExternalID).Scan(&member.ModifiedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrMembershipNotFound
+		}
+		if isUniqueViolation(err) {
+			return ErrMembershipExists
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveMember removes the user from the organization.
+func (r *postgresOrgRepository) RemoveMember(ctx context.Context, orgID, userID int64) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM memberships WHERE org_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrMembershipNotFound
+	}
+	return nil
+}
+
+// CreateInvitation stores a new invitation.
+func (r *postgresOrgRepository) CreateInvitation(ctx context.Context, inv *domain.Invitation) (int64, error) {
+	query := `INSERT INTO org_invitations (org_id, email, role, token_hash, invited_by, expires_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)
+			  RETURNING id`
+	var id int64
+	err := r.pool.QueryRow(ctx, query, inv.OrgID, inv.Email, inv.Role, inv.TokenHash, inv.InvitedBy, inv.ExpiresAt).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrInvitationDuplicate
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetInvitationByTokenHash retrieves a pending, unexpired invitation.
+func (r *postgresOrgRepository) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*domain.Invitation, error) {
+	query := `SELECT id, org_id, email, role, token_hash, COALESCE(invited_by, 0), expires_at, accepted_at, created_at
+			  FROM org_invitations
+			  WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > now()`
+	inv := &domain.Invitation{}
+	err := r.pool.QueryRow(ctx, query, tokenHash).Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role,
+		&inv.TokenHash, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, err
+	}
+	return inv, nil
+}
+
+// AcceptInvitation consumes the invitation and creates the membership in one transaction.
+func (r *postgresOrgRepository) AcceptInvitation(ctx context.Context, invitationID, userID int64) (*domain.Membership, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after Commit
+
+	var orgID int64
+	var role string
+	err = tx.QueryRow(ctx, `UPDATE org_invitations SET accepted_at = now()
+			  WHERE id = $1 AND accepted_at IS NULL AND expires_at > now()
+			  RETURNING org_id, role`, invitationID).Scan(&orgID, &role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO memberships (org_id, user_id, role) VALUES ($1, $2, $3)
+			  ON CONFLICT (org_id, user_id) DO NOTHING`, orgID, userID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &domain.Membership{}
+	err = tx.QueryRow(ctx, `SELECT m.org_id, o.name, m.user_id, m.role, m.created_at
+			  FROM memberships m JOIN organizations o ON o.id = m.org_id
+			  WHERE m.org_id = $1 AND m.user_id = $2`, orgID, userID).
+		Scan(&m.OrgID, &m.OrgName, &m.UserID, &m.Role, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique_violation (23505).
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"authservice/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrPasskeyNotFound = errors.New("passkey not found")
+var ErrPasskeyExists = errors.New("passkey already registered")
+var ErrCeremonyNotFound = errors.New("webauthn ceremony not found or expired")
+
+// PasskeyRepository defines the interface for WebAuthn credential and ceremony storage.
+type PasskeyRepository interface {
+	CreatePasskey(ctx context.Context, passkey *domain.Passkey) (int64, error)
+	ListPasskeys(ctx context.Context, userID int64) ([]domain.Passkey, error)
+	// UpdatePasskeyUsage records a successful assertion.
+	UpdatePasskeyUsage(ctx context.Context, id int64, signCount uint32, cloneWarning, backupState bool) error
+	// DeletePasskey removes a passkey unless it is the user's only way to sign in.
+	DeletePasskey(ctx context.Context, userID, id int64) error
+
+	// CreateCeremony stores the state of a registration or login ceremony. userID is 0 for discoverable login.
+	CreateCeremony(ctx context.Context, id string, userID int64, data []byte, expiresAt time.Time) error
+	// ConsumeCeremony deletes an unexpired ceremony and returns its state, so each can only be finished once.
+	ConsumeCeremony(ctx context.Context, id string) (userID int64, data []byte, err error)
+}
+
+// postgresPasskeyRepository implements PasskeyRepository for PostgreSQL.
+type postgresPasskeyRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresPasskeyRepository creates a new PostgreSQL passkey repository.
+func NewPostgresPasskeyRepository(pool *pgxpool.Pool) PasskeyRepository {
+	return &postgresPasskeyRepository{pool: pool}
+}
+
+// CreatePasskey stores a newly registered credential.
+func (r *postgresPasskeyRepository) CreatePasskey(ctx context.Context, p *domain.Passkey) (int64, error) {
+	query := `INSERT INTO webauthn_credentials
+			  (user_id, credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, name)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			  RETURNING id, created_at`
+	err := r.pool.QueryRow(ctx, query, p.UserID, p.CredentialID, p.PublicKey, p.AttestationType, p.Transports, p.AAGUID,
+		int64(p.SignCount), p.BackupEligible, p.BackupState, p.Name).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrPasskeyExists
+		}
+		return 0, err
+	}
+	return p.ID, nil
+}
+
+// ListPasskeys lists the user's passkeys, oldest first.
+func (r *postgresPasskeyRepository) ListPasskeys(ctx context.Context, userID int64) ([]domain.Passkey, error) {
+	query := `SELECT id, user_id, credential_id, public_key, attestation_type, transports, aaguid, sign_count,
+			  clone_warning, backup_eligible, backup_state, name, created_at, last_used_at
+			  FROM webauthn_credentials
+			  WHERE user_id = $1
+			  ORDER BY created_at, id`
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []domain.Passkey
+	for rows.Next() {
+		var p domain.Passkey
+		var signCount int64
+		if err := rows.Scan(&p.ID, &p.UserID, &p.CredentialID, &p.PublicKey, &p.AttestationType, &p.Transports, &p.AAGUID, &signCount,
+			&p.CloneWarning, &p.BackupEligible, &p.BackupState, &p.Name, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			return nil, err
+		}
+		p.SignCount = uint32(signCount)
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, rows.Err()
+}
+
+// UpdatePasskeyUsage stores the new sign count and flags after a login. A clone
+// warning, once raised, is never cleared.
+func (r *postgresPasskeyRepository) UpdatePasskeyUsage(ctx context.Context, id int64, signCount uint32, cloneWarning, backupState bool) error {
+	query := `UPDATE webauthn_credentials
+			  SET sign_count = $2, clone_warning = clone_warning OR $3, backup_state = $4, last_used_at = now()
+			  WHERE id = $1`
+	tag, err := r.pool.Exec(ctx, query, id, int64(signCount), cloneWarning, backupState)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// DeletePasskey removes one of the user's passkeys. Passkey-only accounts must keep at least one.
+func (r *postgresPasskeyRepository) DeletePasskey(ctx context.Context, userID, id int64) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the user row so concurrent deletions can't both pass the check below
+	var hasPassword bool
+	err = tx.QueryRow(ctx, `SELECT password_hash IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&hasPassword)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	var remaining int
+	err = tx.QueryRow(ctx, `SELECT count(*) FROM webauthn_credentials WHERE user_id = $1 AND id <> $2`, userID, id).Scan(&remaining)
+	if err != nil {
+		return err
+	}
+	if !hasPassword && remaining == 0 {
+		return ErrLastCredential
+	}
+
+	tag, err := tx.Exec(ctx, `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPasskeyNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+// CreateCeremony stores ceremony state until the client finishes it.
+func (r *postgresPasskeyRepository) CreateCeremony(ctx context.Context, id string, userID int64, data []byte, expiresAt time.Time) error {
+	query := `INSERT INTO webauthn_ceremonies (id, user_id, data, expires_at)
+			  VALUES ($1, NULLIF($2, 0), $3, $4)`
+	_, err := r.pool.Exec(ctx, query, id, userID, data, expiresAt)
+	return err
+}
+
+// ConsumeCeremony atomically removes and returns the ceremony state. Expired
+// ceremonies are cleaned up on the way.
+func (r *postgresPasskeyRepository) ConsumeCeremony(ctx context.Context, id string) (int64, []byte, error) {
+	if _, err := r.pool.Exec(ctx, `DELETE FROM webauthn_ceremonies WHERE expires_at <= now()`); err != nil {
+		return 0, nil, err
+	}
+
+	query := `DELETE FROM webauthn_ceremonies
+			  WHERE id = $1
+			  RETURNING COALESCE(user_id, 0), data`
+	var userID int64
+	var data []byte
+	err := r.pool.QueryRow(ctx, query, id).Scan(&userID, &data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil, ErrCeremonyNotFound
+		}
+		return 0, nil, err
+	}
+	return userID, data, nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"authservice/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrRegistrationInviteInvalid is returned for unknown, expired or used invite codes.
+var ErrRegistrationInviteInvalid = errors.New("registration invite is invalid, expired or already used")
+
+// RegistrationInviteRepository stores registration invite codes.
+type RegistrationInviteRepository interface {
+	CreateInvite(ctx context.Context, invite *domain.RegistrationInvite) (int64, error)
+	ListInvites(ctx context.Context, limit int) ([]domain.RegistrationInvite, error)
+	// DeleteInvite deletes an invite that hasn't been used yet.
+	DeleteInvite(ctx context.Context, id int64) error
+	// CreateUserWithInvite creates the user with the invite's role and marks the invite
+	// used, in one transaction, so a code can't be redeemed twice.
+	CreateUserWithInvite(ctx context.Context, user *domain.User, codeHash string) (int64, error)
+}
+
+// postgresRegistrationInviteRepository implements RegistrationInviteRepository for PostgreSQL.
+type postgresRegistrationInviteRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresRegistrationInviteRepository creates a new PostgreSQL registration invite repository.
+func NewPostgresRegistrationInviteRepository(pool *pgxpool.Pool) RegistrationInviteRepository {
+	return &postgresRegistrationInviteRepository{pool: pool}
+}
+
+// CreateInvite stores a new invite.
+func (r *postgresRegistrationInviteRepository) CreateInvite(ctx context.Context, invite *domain.RegistrationInvite) (int64, error) {
+	query := `INSERT INTO registration_invites (code_hash, role, note, created_by, expires_at)
+			  VALUES ($1, $2, $3, NULLIF($4, 0), $5)
+			  RETURNING id, created_at`
+	err := r.pool.QueryRow(ctx, query, invite.CodeHash, invite.Role, invite.Note, invite.CreatedBy, invite.ExpiresAt).
+		Scan(&invite.ID, &invite.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrInvitationDuplicate
+		}
+		return 0, err
+	}
+	return invite.ID, nil
+}
+
+// ListInvites lists the most recent invites first.
+func (r *postgresRegistrationInviteRepository) ListInvites(ctx context.Context, limit int) ([]domain.RegistrationInvite, error) {
+	query := `SELECT id, role, note, COALESCE(created_by, 0), created_at, expires_at, used_at, COALESCE(used_by, 0)
+			  FROM registration_invites
+			  ORDER BY created_at DESC, id DESC
+			  LIMIT $1`
+	rows, err := r.pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []domain.RegistrationInvite
+	for rows.Next() {
+		var inv domain.RegistrationInvite
+		if err := rows.Scan(&inv.ID, &inv.Role, &inv.Note, &inv.CreatedBy, &inv.CreatedAt, &inv.ExpiresAt, &inv.UsedAt, &inv.UsedBy); err != nil {
+			return nil, err
+		}
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+// DeleteInvite revokes an unused invite.
+func (r *postgresRegistrationInviteRepository) DeleteInvite(ctx context.Context, id int64) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM registration_invites WHERE id = $1 AND used_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRegistrationInviteInvalid
+	}
+	return nil
+}
+
+// CreateUserWithInvite redeems the invite and creates the user.
+func (r *postgresRegistrationInviteRepository) CreateUserWithInvite(ctx context.Context, user *domain.User, codeHash string) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var inviteID int64
+	query := `SELECT id, role FROM registration_invites
+			  WHERE code_hash = $1 AND used_at IS NULL AND expires_at > now()
+			  FOR UPDATE`
+	if err := tx.QueryRow(ctx, query, codeHash).Scan(&inviteID, &user.Role); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrRegistrationInviteInvalid
+		}
+		return 0, err
+	}
+
+	var userID int64
+	query = `INSERT INTO users (email, password_hash, role, created_at, updated_at)
+			 VALUES ($1, NULLIF($2, ''), $3, now(), now())
+			 RETURNING id`
+	if err := tx.QueryRow(ctx, query, user.Email, user.Password, user.Role).Scan(&userID); err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrEmailExists
+		}
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE registration_invites SET used_at = now(), used_by = $2 WHERE id = $1`, inviteID, userID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"authservice/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrScimTokenNotFound = errors.New("SCIM token not found or revoked")
+
+// ScimTokenRepository stores the bearer tokens organizations' directories use for SCIM.
+type ScimTokenRepository interface {
+	CreateToken(ctx context.Context, token *domain.ScimToken) (int64, error)
+	// GetTokenByHash returns the token unless it has been revoked.
+	GetTokenByHash(ctx context.Context, tokenHash string) (*domain.ScimToken, error)
+	ListTokens(ctx context.Context, orgID int64) ([]domain.ScimToken, error)
+	RevokeToken(ctx context.Context, orgID, id int64) error
+	// TouchToken records that the token was just used.
+	TouchToken(ctx context.Context, id int64) error
+}
+
+// postgresScimTokenRepository implements ScimTokenRepository for PostgreSQL.
+type postgresScimTokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresScimTokenRepository creates a new PostgreSQL SCIM token repository.
+func NewPostgresScimTokenRepository(pool *pgxpool.Pool) ScimTokenRepository {
+	return &postgresScimTokenRepository{pool: pool}
+}
+
+// scimTokenColumns are the columns read by scanScimToken.
+const scimTokenColumns = `id, org_id, token_hash, description, COALESCE(created_by, 0), created_at, last_used_at, revoked_at`
+
+// scanScimToken scans a row selected with scimTokenColumns.
+func scanScimToken(row pgx.Row) (*domain.ScimToken, error) {
+	t := &domain.ScimToken{}
+	err := row.Scan(&t.ID, &t.OrgID, &t.TokenHash, &t.Description, &t.CreatedBy, &t.CreatedAt, &t.LastUsedAt, &t.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// CreateToken stores a new token.
+func (r *postgresScimTokenRepository) CreateToken(ctx context.Context, token *domain.ScimToken) (int64, error) {
+	query := `INSERT INTO scim_tokens (org_id, token_hash, description, created_by)
+			  VALUES ($1, $2, $3, NULLIF($4, 0))
+			  RETURNING id, created_at`
+	err := r.pool.QueryRow(ctx, query, token.OrgID, token.TokenHash, token.Description, token.CreatedBy).
+		Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return token.ID, nil
+}
+
+// GetTokenByHash retrieves an active token by its hash.
+func (r *postgresScimTokenRepository) GetTokenByHash(ctx context.Context, tokenHash string) (*domain.ScimToken, error) {
+	query := `SELECT ` + scimTokenColumns + ` FROM scim_tokens WHERE token_hash = $1 AND revoked_at IS NULL`
+	t, err := scanScimToken(r.pool.QueryRow(ctx, query, tokenHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrScimTokenNotFound
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListTokens lists the organization's tokens, including revoked ones, newest first.
+func (r *postgresScimTokenRepository) ListTokens(ctx context.Context, orgID int64) ([]domain.ScimToken, error) {
+	query := `SELECT ` + scimTokenColumns + ` FROM scim_tokens WHERE org_id = $1 ORDER BY created_at DESC, id DESC`
+	rows, err := r.pool.Query(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []domain.ScimToken
+	for rows.Next() {
+		t, err := scanScimToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeToken revokes one of the organization's tokens.
+func (r *postgresScimTokenRepository) RevokeToken(ctx context.Context, orgID, id int64) error {
+	query := `UPDATE scim_tokens SET revoked_at = now() WHERE org_id = $1 AND id = $2 AND revoked_at IS NULL`
+	tag, err := r.pool.Exec(ctx, query, orgID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrScimTokenNotFound
+	}
+	return nil
+}
+
+// TouchToken updates the token's last use time.
+func (r *postgresScimTokenRepository) TouchToken(ctx context.Context, id int64) error {
+	_, err := r.pool.Exec(ctx, `UPDATE scim_tokens SET last_used_at = now() WHERE id = $1`, id)
+	return err
+}
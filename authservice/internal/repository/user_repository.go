@@ -14,11 +14,29 @@ import (
 var ErrUserNotFound = errors.New("user not found")
 var ErrEmailExists = errors.New("email already exists")
 
+// ErrLastCredential is returned when removing a password or passkey would leave the
+// account without any way to sign in.
+var ErrLastCredential = errors.New("cannot remove the last sign-in method")
+
 // UserRepository defines the interface for user data operations.
 type UserRepository interface {
 	CreateUser(ctx context.Context, user *domain.User) (int64, error)
 	GetUserByEmail(ctx context.Context, email string) (*domain.User, error)
 	GetUserByID(ctx context.Context, id int64) (*domain.User, error)
+	UpdatePassword(ctx context.Context, id int64, passwordHash string) error
+	// UpdateRole sets the user's platform role, e.g. as mapped from directory groups.
+	UpdateRole(ctx context.Context, id int64, role string) error
+	// RemovePassword clears the password, provided the user has at least one passkey.
+	RemovePassword(ctx context.Context, id int64) error
+
+	// WebAuthn user handles
+	GetUserByWebAuthnHandle(ctx context.Context, handle []byte) (*domain.User, error)
+	// SetWebAuthnHandle sets the handle unless the user already has one, and returns the handle in effect.
+	SetWebAuthnHandle(ctx context.Context, id int64, handle []byte) ([]byte, error)
+
+	// Tenant-scoped queries: only users that are members of orgID are visible.
+	ListUsersByOrg(ctx context.Context, orgID int64) ([]domain.OrgMember, error)
+	GetUserByIDInOrg(ctx context.Context, orgID, userID int64) (*domain.OrgMember, error)
 }
 
 // postgresUserRepository implements UserRepository for PostgreSQL.
@@ -31,23 +49,18 @@ func NewPostgresUserRepository(pool *pgxpool.Pool) UserRepository {
 	return &postgresUserRepository{pool: pool}
 }
 
-// CreateUser inserts a new user into the database.
+// CreateUser inserts a new user into the database. An empty password creates
+// an account that signs in by magic link or passkey only; an empty role or auth
+// source means a regular, local user.
 func (r *postgresUserRepository) CreateUser(ctx context.Context, user *domain.User) (int64, error) {
-	query := `INSERT INTO users (email, password_hash, created_at, updated_at)
-			  VALUES ($1, $2, $3, $4)
+	query := `INSERT INTO users (email, password_hash, role, auth_source, created_at, updated_at)
+			  VALUES ($1, NULLIF($2, ''), COALESCE(NULLIF($3, ''), 'user'), COALESCE(NULLIF($4, ''), 'local'), $5, $6)
 			  RETURNING id`
 	now := time.Now()
 	var userID int64
-	err := r.pool.QueryRow(ctx, query, user.Email, user.Password, now, now).Scan(&userID)
+	err := r.pool.QueryRow(ctx, query, user.Email, user.Password, user.Role, user.AuthSource, now, now).Scan(&userID)
 	if err != nil {
-		// Basic check for unique constraint violation (adjust if needed based on specific error code/message)
-		if errors.Is(err, pgx.ErrNoRows) { // Check pgx specific errors if necessary
-			// Handle specific errors, maybe check constraint violation for email
-		}
-		// A more robust check might involve checking the PostgreSQL error code (e.g., 23505 for unique_violation)
-		// For simplicity, we'll assume other errors are potential conflicts for now
-		// This check is basic and might need refinement based on actual DB errors
-		if err.Error() == "unique constraint violation" { // Very basic check
+		if isUniqueViolation(err) {
 			return 0, ErrEmailExists
 		}
 		return 0, err
@@ -55,11 +68,13 @@ func (r *postgresUserRepository) CreateUser(ctx context.Context, user *domain.Us
 	return userID, nil
 }
 
-// GetUserByEmail retrieves a user by their email address.
-func (r *postgresUserRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
-	query := `SELECT id, email, password_hash, created_at, updated_at FROM users WHERE email = $1`
+// userColumns are the columns read by scanUser. Passkey-only users have no password hash.
+const userColumns = `id, email, COALESCE(password_hash, ''), role, created_at, updated_at, webauthn_handle, auth_source`
+
+// scanUser scans a row selected with userColumns.
+func scanUser(row pgx.Row) (*domain.User, error) {
 	user := &domain.User{}
-	err := r.pool.QueryRow(ctx, query, email).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	err := row.Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.CreatedAt, &user.UpdatedAt, &user.WebAuthnHandle, &user.AuthSource)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrUserNotFound
@@ -69,16 +84,130 @@ func (r *postgresUserRepository) GetUserByEmail(ctx context.Context, email strin
 	return user, nil
 }
 
+// GetUserByEmail retrieves a user by their email address.
+func (r *postgresUserRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+	return scanUser(r.pool.QueryRow(ctx, query, email))
+}
+
 // GetUserByID retrieves a user by their ID.
 func (r *postgresUserRepository) GetUserByID(ctx context.Context, id int64) (*domain.User, error) {
-	query := `SELECT id, email, password_hash, created_at, updated_at FROM users WHERE id = $1`
-	user := &domain.User{}
-	err := r.pool.QueryRow(ctx, query, id).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	return scanUser(r.pool.QueryRow(ctx, query, id))
+}
+
+// GetUserByWebAuthnHandle retrieves the user an authenticator's user handle belongs to.
+func (r *postgresUserRepository) GetUserByWebAuthnHandle(ctx context.Context, handle []byte) (*domain.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE webauthn_handle = $1`
+	return scanUser(r.pool.QueryRow(ctx, query, handle))
+}
+
+// SetWebAuthnHandle assigns the user's WebAuthn handle once; concurrent callers all get the first one.
+func (r *postgresUserRepository) SetWebAuthnHandle(ctx context.Context, id int64, handle []byte) ([]byte, error) {
+	query := `UPDATE users SET webauthn_handle = COALESCE(webauthn_handle, $2)
+			  WHERE id = $1
+			  RETURNING webauthn_handle`
+	var current []byte
+	err := r.pool.QueryRow(ctx, query, id, handle).Scan(&current)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
-	return user, nil
+	return current, nil
+}
+
+// UpdatePassword replaces the user's password hash.
+func (r *postgresUserRepository) UpdatePassword(ctx context.Context, id int64, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $2, updated_at = now() WHERE id = $1`
+	tag, err := r.pool.Exec(ctx, query, id, passwordHash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdateRole replaces the user's platform role.
+func (r *postgresUserRepository) UpdateRole(ctx context.Context, id int64, role string) error {
+	query := `UPDATE users SET role = $2, updated_at = now() WHERE id = $1`
+	tag, err := r.pool.Exec(ctx, query, id, role)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// RemovePassword clears the password hash. The check for a passkey is part of the
+// same statement so the account can't end up without credentials.
+func (r *postgresUserRepository) RemovePassword(ctx context.Context, id int64) error {
+	query := `UPDATE users SET password_hash = NULL, updated_at = now()
+			  WHERE id = $1 AND EXISTS (SELECT 1 FROM webauthn_credentials WHERE user_id = $1)`
+	tag, err := r.pool.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLastCredential
+	}
+	return nil
+}
+
+// ListUsersByOrg lists the members of an organization together with their role.
+func (r *postgresUserRepository) ListUsersByOrg(ctx context.Context, orgID int64) ([]domain.OrgMember, error) {
+	query := `SELECT ` + orgMemberColumns + `
+			  FROM users u JOIN memberships m ON m.user_id = u.id
+			  WHERE m.org_id = $1
+			  ORDER BY u.id`
+	rows, err := r.pool.Query(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []domain.OrgMember
+	for rows.Next() {
+		m, err := scanOrgMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *m)
+	}
+	return members, rows.Err()
+}
+
+// GetUserByIDInOrg retrieves a user only if they belong to the organization.
+func (r *postgresUserRepository) GetUserByIDInOrg(ctx context.Context, orgID, userID int64) (*domain.OrgMember, error) {
+	query := `SELECT ` + orgMemberColumns + `
+			  FROM users u JOIN memberships m ON m.user_id = u.id
+			  WHERE m.org_id = $1 AND u.id = $2`
+	m, err := scanOrgMember(r.pool.QueryRow(ctx, query, orgID, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// orgMemberColumns are the columns read by scanOrgMember, from users u joined with memberships m.
+const orgMemberColumns = `u.id, u.email, u.role, u.created_at, u.updated_at,
+			  m.role, m.active, COALESCE(m.external_id, ''), m.created_at, m.updated_at`
+
+// scanOrgMember scans a row selected with orgMemberColumns.
+func scanOrgMember(row pgx.Row) (*domain.OrgMember, error) {
+	m := &domain.OrgMember{}
+	err := row.Scan(&m.ID, &m.Email, &m.Role, &m.CreatedAt, &m.UpdatedAt,
+		&m.OrgRole, &m.Active, &m.ExternalID, &m.JoinedAt, &m.ModifiedAt)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
 }
@@ -0,0 +1,331 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Filter is a parsed filter expression (RFC 7644 section 3.4.2.2), e.g.
+//
+//	userName eq "alice@example.com" and active eq true
+//
+// Comparison operators, pr, and, or, not and parentheses are supported; value paths
+// such as emails[type eq "work"] are not.
+type Filter interface {
+	match(r resource) bool
+}
+
+// resource is implemented by the resources that can be filtered.
+type resource interface {
+	// attribute returns the values of the attribute at path, which is lower-case
+	// and has no schema prefix (e.g. "emails.value").
+	attribute(path string) []interface{}
+}
+
+// caseExact lists the attributes compared case-sensitively; all others are case-insensitive.
+var caseExact = map[string]bool{"id": true, "externalid": true}
+
+// ParseFilter parses a filter. An empty string matches everything.
+func ParseFilter(s string) (Filter, error) {
+	if strings.TrimSpace(s) == "" {
+		return matchAll{}, nil
+	}
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidFilter("unexpected %q", p.tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+func invalidFilter(format string, args ...interface{}) *Error {
+	return NewError(http.StatusBadRequest, ErrTypeInvalidFilter, "Invalid filter: "+format, args...)
+}
+
+type matchAll struct{}
+
+func (matchAll) match(resource) bool { return true }
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) match(r resource) bool { return f.left.match(r) && f.right.match(r) }
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) match(r resource) bool { return f.left.match(r) || f.right.match(r) }
+
+type notFilter struct{ inner Filter }
+
+func (f notFilter) match(r resource) bool { return !f.inner.match(r) }
+
+// compareFilter is "attribute op value", or "attribute pr" when op is "pr".
+type compareFilter struct {
+	path  string
+	op    string
+	value interface{} // string, bool, float64 or nil
+}
+
+func (f compareFilter) match(r resource) bool {
+	values := r.attribute(f.path)
+	switch {
+	case f.op == "pr":
+		return len(values) > 0
+	case f.value == nil && f.op == "eq":
+		return len(values) == 0
+	case f.value == nil && f.op == "ne":
+		return len(values) > 0
+	case f.op == "ne":
+		// A multi-valued attribute is "not equal" only if none of its values is equal
+		for _, v := range values {
+			if compare(v, "eq", f.value, caseExact[f.path]) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		if compare(v, f.op, f.value, caseExact[f.path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies op to an attribute value and a filter value of the same type.
+func compare(attr interface{}, op string, value interface{}, exact bool) bool {
+	switch a := attr.(type) {
+	case bool:
+		b, ok := value.(bool)
+		return ok && op == "eq" && a == b
+	case string:
+		b, ok := value.(string)
+		if !ok {
+			return false
+		}
+		if !exact {
+			a, b = strings.ToLower(a), strings.ToLower(b)
+		}
+		switch op {
+		case "eq":
+			return a == b
+		case "co":
+			return strings.Contains(a, b)
+		case "sw":
+			return strings.HasPrefix(a, b)
+		case "ew":
+			return strings.HasSuffix(a, b)
+		case "gt":
+			return a > b
+		case "ge":
+			return a >= b
+		case "lt":
+			return a < b
+		case "le":
+			return a <= b
+		}
+	}
+	return false
+}
+
+var comparisonOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+type token struct {
+	text   string
+	quoted bool // A string literal; text is the decoded value
+}
+
+// tokenize splits a filter into words, parentheses and string literals.
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, invalidFilter("unterminated string")
+			}
+			var text string
+			if err := json.Unmarshal([]byte(s[i:end+1]), &text); err != nil {
+				return nil, invalidFilter("bad string %s", s[i:end+1])
+			}
+			tokens = append(tokens, token{text: text, quoted: true})
+			i = end + 1
+		case c == '[' || c == ']':
+			return nil, invalidFilter("value paths are not supported")
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t()[]\"", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{text: s[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// filterParser is a recursive-descent parser over the tokens:
+//
+//	or      = and *("or" and)
+//	and     = factor *("and" factor)
+//	factor  = "not" "(" or ")" / "(" or ")" / attrPath "pr" / attrPath compareOp value
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+// keyword consumes the next token if it is the unquoted keyword kw.
+func (p *filterParser) keyword(kw string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) next() (token, error) {
+	if p.pos >= len(p.tokens) {
+		return token{}, invalidFilter("unexpected end of filter")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseFactor() (Filter, error) {
+	if p.keyword("not") {
+		if !p.keyword("(") {
+			return nil, invalidFilter(`"not" must be followed by "("`)
+		}
+		inner, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{inner}, nil
+	}
+	if p.keyword("(") {
+		return p.parseGroup()
+	}
+
+	attr, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if attr.quoted {
+		return nil, invalidFilter("expected attribute, got string %q", attr.text)
+	}
+	path := normalizePath(attr.text)
+
+	opToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op := strings.ToLower(opToken.text)
+	if op == "pr" && !opToken.quoted {
+		return compareFilter{path: path, op: op}, nil
+	}
+	if opToken.quoted || !comparisonOps[op] {
+		return nil, invalidFilter("unknown operator %q", opToken.text)
+	}
+
+	valueToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := filterValue(valueToken)
+	if err != nil {
+		return nil, err
+	}
+	return compareFilter{path: path, op: op, value: value}, nil
+}
+
+// parseGroup parses the rest of a parenthesized expression.
+func (p *filterParser) parseGroup() (Filter, error) {
+	inner, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.keyword(")") {
+		return nil, invalidFilter(`missing ")"`)
+	}
+	return inner, nil
+}
+
+// filterValue converts a comparison value: a string, true, false, null or a number.
+func filterValue(t token) (interface{}, error) {
+	if t.quoted {
+		return t.text, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(t.text), &v); err != nil {
+		return nil, invalidFilter("bad value %q", t.text)
+	}
+	switch v.(type) {
+	case nil, bool, float64:
+		return v, nil
+	}
+	return nil, invalidFilter("bad value %q", t.text)
+}
+
+// normalizePath lower-cases an attribute path and strips a schema URN prefix, e.g.
+// "urn:ietf:params:scim:schemas:core:2.0:User:userName" becomes "username".
+func normalizePath(path string) string {
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		if i := strings.LastIndex(path, ":"); i >= 0 {
+			path = path[i+1:]
+		}
+	}
+	return strings.ToLower(path)
+}
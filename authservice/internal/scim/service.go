@@ -0,0 +1,623 @@
+package scim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"authservice/internal/domain"
+	"authservice/internal/repository"
+)
+
+// MaxPageSize caps the count of a list request.
+const MaxPageSize = 1000
+
+// groupRoles are the groups, in listing order.
+var groupRoles = []string{domain.OrgRoleOwner, domain.OrgRoleAdmin, domain.OrgRoleMember}
+
+// ScimService provisions an organization's members and roles.
+type ScimService interface {
+	// ListUsers returns the page of members matching filter; startIndex is 1-based.
+	ListUsers(ctx context.Context, orgID int64, filter string, startIndex, count int) (*ListResponse, error)
+	GetUser(ctx context.Context, orgID int64, id string) (*User, error)
+	// CreateUser adds the user with the given email to the organization as a member,
+	// creating a password-less account if there is none.
+	CreateUser(ctx context.Context, orgID int64, in *User) (*User, error)
+	ReplaceUser(ctx context.Context, orgID int64, id string, in *User) (*User, error)
+	PatchUser(ctx context.Context, orgID int64, id string, patch *PatchRequest) (*User, error)
+	// DeleteUser removes the user from the organization; the account itself is kept.
+	DeleteUser(ctx context.Context, orgID int64, id string) error
+
+	ListGroups(ctx context.Context, orgID int64, filter string, startIndex, count int) (*ListResponse, error)
+	GetGroup(ctx context.Context, orgID int64, id string) (*Group, error)
+	// ReplaceGroup gives the listed users the group's role; users left out are demoted to member.
+	ReplaceGroup(ctx context.Context, orgID int64, id string, in *Group) (*Group, error)
+	PatchGroup(ctx context.Context, orgID int64, id string, patch *PatchRequest) (*Group, error)
+}
+
+type scimService struct {
+	userRepo repository.UserRepository
+	orgRepo  repository.OrgRepository
+}
+
+// NewScimService creates a new ScimService.
+func NewScimService(userRepo repository.UserRepository, orgRepo repository.OrgRepository) ScimService {
+	return &scimService{userRepo: userRepo, orgRepo: orgRepo}
+}
+
+// ListUsers filters the organization's members in memory; organizations are small
+// enough, and filters can't be pushed down to SQL safely in general.
+func (s *scimService) ListUsers(ctx context.Context, orgID int64, filter string, startIndex, count int) (*ListResponse, error) {
+	f, err := ParseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.userRepo.ListUsersByOrg(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+
+	var users []*User
+	for i := range members {
+		if u := toUser(&members[i]); f.match(u) {
+			users = append(users, u)
+		}
+	}
+	return page(users, startIndex, count), nil
+}
+
+// GetUser returns a member of the organization.
+func (s *scimService) GetUser(ctx context.Context, orgID int64, id string) (*User, error) {
+	m, err := s.member(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	return toUser(m), nil
+}
+
+// CreateUser adds a user to the organization.
+func (s *scimService) CreateUser(ctx context.Context, orgID int64, in *User) (*User, error) {
+	email := strings.ToLower(strings.TrimSpace(in.UserName))
+	if !strings.Contains(email, "@") {
+		return nil, NewError(http.StatusBadRequest, ErrTypeInvalidValue, "userName must be an email address")
+	}
+
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		// Provisioned accounts have no password; the user signs in by magic link or passkey
+		_, err = s.userRepo.CreateUser(ctx, &domain.User{Email: email})
+		if err != nil && !errors.Is(err, repository.ErrEmailExists) { // Created concurrently
+			return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		user, err = s.userRepo.GetUserByEmail(ctx, email)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	m := &domain.OrgMember{User: *user, OrgRole: domain.OrgRoleMember, Active: activeOrDefault(in.Active), ExternalID: in.ExternalID}
+	if err := s.orgRepo.AddMember(ctx, m, orgID); err != nil {
+		if errors.Is(err, repository.ErrMembershipExists) {
+			return nil, NewError(http.StatusConflict, ErrTypeUniqueness, "User is already a member, or externalId is taken")
+		}
+		return nil, fmt.Errorf("failed to add member: %w", err)
+	}
+	return toUser(m), nil
+}
+
+// ReplaceUser replaces the member's active flag and externalId. userName can't change.
+func (s *scimService) ReplaceUser(ctx context.Context, orgID int64, id string, in *User) (*User, error) {
+	m, err := s.member(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	if in.UserName != "" && !strings.EqualFold(strings.TrimSpace(in.UserName), m.Email) {
+		return nil, NewError(http.StatusBadRequest, ErrTypeMutability, "userName can't be changed")
+	}
+
+	wasOwner := isActiveOwner(m)
+	m.Active = activeOrDefault(in.Active)
+	m.ExternalID = in.ExternalID
+	if err := s.updateMember(ctx, orgID, m, wasOwner); err != nil {
+		return nil, err
+	}
+	return toUser(m), nil
+}
+
+// PatchUser applies add, replace and remove operations on active and externalId.
+func (s *scimService) PatchUser(ctx context.Context, orgID int64, id string, patch *PatchRequest) (*User, error) {
+	m, err := s.member(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	wasOwner := isActiveOwner(m)
+
+	for _, op := range patch.Operations {
+		kind, err := opKind(op)
+		if err != nil {
+			return nil, err
+		}
+		if op.Path != "" {
+			if err := applyUserAttribute(m, kind, normalizePath(op.Path), op.Value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Without a path the value is an object of attributes
+		attrs, ok := op.Value.(map[string]interface{})
+		if !ok || kind == "remove" {
+			return nil, NewError(http.StatusBadRequest, ErrTypeInvalidSyntax, "Operation without path needs an object value")
+		}
+		for name, value := range attrs {
+			if err := applyUserAttribute(m, kind, normalizePath(name), value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := s.updateMember(ctx, orgID, m, wasOwner); err != nil {
+		return nil, err
+	}
+	return toUser(m), nil
+}
+
+// applyUserAttribute applies one patch operation to a member.
+func applyUserAttribute(m *domain.OrgMember, kind, path string, value interface{}) error {
+	switch path {
+	case "active":
+		if kind == "remove" {
+			return NewError(http.StatusBadRequest, ErrTypeMutability, "active can't be removed")
+		}
+		active, err := parseBool(value)
+		if err != nil {
+			return NewError(http.StatusBadRequest, ErrTypeInvalidValue, "active must be a boolean")
+		}
+		m.Active = active
+	case "externalid":
+		if kind == "remove" {
+			m.ExternalID = ""
+			return nil
+		}
+		externalID, ok := value.(string)
+		if !ok {
+			return NewError(http.StatusBadRequest, ErrTypeInvalidValue, "externalId must be a string")
+		}
+		m.ExternalID = externalID
+	case "username":
+		userName, ok := value.(string)
+		if kind == "remove" || !ok || !strings.EqualFold(strings.TrimSpace(userName), m.Email) {
+			return NewError(http.StatusBadRequest, ErrTypeMutability, "userName can't be changed")
+		}
+	case "schemas", "id", "meta", "groups", "emails":
+		// Read-only or derived from userName; identity providers often send them anyway
+	default:
+		return NewError(http.StatusBadRequest, ErrTypeInvalidPath, "Attribute %q is not supported", path)
+	}
+	return nil
+}
+
+// DeleteUser removes the member from the organization.
+func (s *scimService) DeleteUser(ctx context.Context, orgID int64, id string) error {
+	m, err := s.member(ctx, orgID, id)
+	if err != nil {
+		return err
+	}
+	if isActiveOwner(m) {
+		if err := s.ensureOwnerRemains(ctx, orgID, m.ID); err != nil {
+			return err
+		}
+	}
+	if err := s.orgRepo.RemoveMember(ctx, orgID, m.ID); err != nil {
+		if errors.Is(err, repository.ErrMembershipNotFound) {
+			return errNotFound("User", id)
+		}
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	return nil
+}
+
+// ListGroups lists the role groups matching filter.
+func (s *scimService) ListGroups(ctx context.Context, orgID int64, filter string, startIndex, count int) (*ListResponse, error) {
+	f, err := ParseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.userRepo.ListUsersByOrg(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+
+	var groups []*Group
+	for _, role := range groupRoles {
+		if g := toGroup(role, members); f.match(g) {
+			groups = append(groups, g)
+		}
+	}
+	return page(groups, startIndex, count), nil
+}
+
+// GetGroup returns a role group with its members.
+func (s *scimService) GetGroup(ctx context.Context, orgID int64, id string) (*Group, error) {
+	if !domain.ValidOrgRole(id) {
+		return nil, errNotFound("Group", id)
+	}
+	members, err := s.userRepo.ListUsersByOrg(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	return toGroup(id, members), nil
+}
+
+// ReplaceGroup sets the group's members.
+func (s *scimService) ReplaceGroup(ctx context.Context, orgID int64, id string, in *Group) (*Group, error) {
+	if !domain.ValidOrgRole(id) {
+		return nil, errNotFound("Group", id)
+	}
+	if in.DisplayName != "" && !strings.EqualFold(in.DisplayName, id) {
+		return nil, NewError(http.StatusBadRequest, ErrTypeMutability, "displayName can't be changed")
+	}
+
+	target := make(map[int64]bool)
+	for _, ref := range in.Members {
+		userID, err := parseMemberID(ref.Value)
+		if err != nil {
+			return nil, err
+		}
+		target[userID] = true
+	}
+	return s.setGroupMembers(ctx, orgID, id, func(map[int64]bool) (map[int64]bool, error) {
+		return target, nil
+	})
+}
+
+// memberFilterPath matches a path selecting one member, e.g. members[value eq "42"].
+var memberFilterPath = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]*)"\s*\]$`)
+
+// PatchGroup adds, removes or replaces members.
+func (s *scimService) PatchGroup(ctx context.Context, orgID int64, id string, patch *PatchRequest) (*Group, error) {
+	if !domain.ValidOrgRole(id) {
+		return nil, errNotFound("Group", id)
+	}
+	return s.setGroupMembers(ctx, orgID, id, func(current map[int64]bool) (map[int64]bool, error) {
+		for _, op := range patch.Operations {
+			kind, err := opKind(op)
+			if err != nil {
+				return nil, err
+			}
+
+			if match := memberFilterPath.FindStringSubmatch(strings.TrimSpace(op.Path)); match != nil {
+				if kind != "remove" {
+					return nil, NewError(http.StatusBadRequest, ErrTypeInvalidPath, "Only remove is supported on a member filter")
+				}
+				userID, err := parseMemberID(match[1])
+				if err != nil {
+					return nil, err
+				}
+				delete(current, userID)
+				continue
+			}
+
+			value := op.Value
+			switch normalizePath(op.Path) {
+			case "members":
+			case "displayname":
+				if name, ok := value.(string); !ok || !strings.EqualFold(name, id) {
+					return nil, NewError(http.StatusBadRequest, ErrTypeMutability, "displayName can't be changed")
+				}
+				continue
+			case "":
+				// Without a path the value is an object of attributes
+				attrs, ok := value.(map[string]interface{})
+				if !ok {
+					return nil, NewError(http.StatusBadRequest, ErrTypeInvalidSyntax, "Operation without path needs an object value")
+				}
+				if name, ok := attrs["displayName"]; ok && !strings.EqualFold(fmt.Sprint(name), id) {
+					return nil, NewError(http.StatusBadRequest, ErrTypeMutability, "displayName can't be changed")
+				}
+				if value, ok = attrs["members"]; !ok {
+					continue
+				}
+			default:
+				return nil, NewError(http.StatusBadRequest, ErrTypeInvalidPath, "Attribute %q is not supported", op.Path)
+			}
+
+			var ids []int64
+			if value != nil {
+				if ids, err = memberIDs(value); err != nil {
+					return nil, err
+				}
+			}
+			switch kind {
+			case "add":
+				for _, userID := range ids {
+					current[userID] = true
+				}
+			case "replace":
+				current = make(map[int64]bool)
+				for _, userID := range ids {
+					current[userID] = true
+				}
+			case "remove":
+				if value == nil {
+					current = make(map[int64]bool) // Remove all members
+				}
+				for _, userID := range ids {
+					delete(current, userID)
+				}
+			}
+		}
+		return current, nil
+	})
+}
+
+// setGroupMembers computes the group's new members from its current ones with change,
+// then gives new members the role and demotes removed ones to member.
+func (s *scimService) setGroupMembers(ctx context.Context, orgID int64, role string, change func(current map[int64]bool) (map[int64]bool, error)) (*Group, error) {
+	members, err := s.userRepo.ListUsersByOrg(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	current := make(map[int64]bool)
+	for _, m := range members {
+		if m.OrgRole == role {
+			current[m.ID] = true
+		}
+	}
+
+	target, err := change(current)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*domain.OrgMember, len(members))
+	for i := range members {
+		byID[members[i].ID] = &members[i]
+	}
+	for userID := range target {
+		if byID[userID] == nil {
+			return nil, NewError(http.StatusBadRequest, ErrTypeInvalidValue, "User %d is not a member of the organization", userID)
+		}
+	}
+
+	// Work out every change before applying any, so a rejected request changes nothing
+	var changed []*domain.OrgMember
+	for _, m := range byID {
+		switch {
+		case target[m.ID] && m.OrgRole != role:
+			m.OrgRole = role
+		case !target[m.ID] && m.OrgRole == role:
+			if role == domain.OrgRoleMember {
+				return nil, NewError(http.StatusBadRequest, ErrTypeMutability, "Users can't leave the member group; delete the user instead")
+			}
+			m.OrgRole = domain.OrgRoleMember
+		default:
+			continue
+		}
+		changed = append(changed, m)
+	}
+	if !hasActiveOwner(members) {
+		return nil, NewError(http.StatusConflict, "", "Organization must keep at least one active owner")
+	}
+
+	for _, m := range changed {
+		if err := s.orgRepo.UpdateMember(ctx, m, orgID); err != nil {
+			return nil, fmt.Errorf("failed to update member: %w", err)
+		}
+	}
+	return toGroup(role, members), nil
+}
+
+// member returns the organization's member with the SCIM id.
+func (s *scimService) member(ctx context.Context, orgID int64, id string) (*domain.OrgMember, error) {
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, errNotFound("User", id)
+	}
+	m, err := s.userRepo.GetUserByIDInOrg(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, errNotFound("User", id)
+		}
+		return nil, fmt.Errorf("failed to get member: %w", err)
+	}
+	return m, nil
+}
+
+// updateMember saves the member, unless that would deactivate the last active owner.
+func (s *scimService) updateMember(ctx context.Context, orgID int64, m *domain.OrgMember, wasOwner bool) error {
+	if wasOwner && !isActiveOwner(m) {
+		if err := s.ensureOwnerRemains(ctx, orgID, m.ID); err != nil {
+			return err
+		}
+	}
+	if err := s.orgRepo.UpdateMember(ctx, m, orgID); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrMembershipExists):
+			return NewError(http.StatusConflict, ErrTypeUniqueness, "externalId is already taken")
+		case errors.Is(err, repository.ErrMembershipNotFound):
+			return errNotFound("User", strconv.FormatInt(m.ID, 10))
+		}
+		return fmt.Errorf("failed to update member: %w", err)
+	}
+	return nil
+}
+
+// ensureOwnerRemains fails unless someone other than userID is an active owner.
+func (s *scimService) ensureOwnerRemains(ctx context.Context, orgID, userID int64) error {
+	members, err := s.userRepo.ListUsersByOrg(ctx, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to list members: %w", err)
+	}
+	for i := range members {
+		if members[i].ID != userID && isActiveOwner(&members[i]) {
+			return nil
+		}
+	}
+	return NewError(http.StatusConflict, "", "Organization must keep at least one active owner")
+}
+
+func isActiveOwner(m *domain.OrgMember) bool {
+	return m.Active && m.OrgRole == domain.OrgRoleOwner
+}
+
+func hasActiveOwner(members []domain.OrgMember) bool {
+	for i := range members {
+		if isActiveOwner(&members[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// toUser converts a member to a SCIM user.
+func toUser(m *domain.OrgMember) *User {
+	active := m.Active
+	return &User{
+		Schemas:    []string{SchemaUser},
+		ID:         strconv.FormatInt(m.ID, 10),
+		ExternalID: m.ExternalID,
+		UserName:   m.Email,
+		Active:     &active,
+		Emails:     []Email{{Value: m.Email, Type: "work", Primary: true}},
+		Groups:     []Ref{{Value: m.OrgRole, Display: m.OrgRole}},
+		Meta:       &Meta{ResourceType: "User", Created: m.JoinedAt, LastModified: m.ModifiedAt},
+	}
+}
+
+// toGroup builds the role's group from the organization's members.
+func toGroup(role string, members []domain.OrgMember) *Group {
+	g := &Group{Schemas: []string{SchemaGroup}, ID: role, DisplayName: role, Members: []Ref{}}
+	for _, m := range members {
+		if m.OrgRole == role {
+			g.Members = append(g.Members, Ref{Value: strconv.FormatInt(m.ID, 10), Display: m.Email})
+		}
+	}
+	return g
+}
+
+func (u *User) attribute(path string) []interface{} {
+	switch path {
+	case "id":
+		return []interface{}{u.ID}
+	case "externalid":
+		if u.ExternalID == "" {
+			return nil
+		}
+		return []interface{}{u.ExternalID}
+	case "username":
+		return []interface{}{u.UserName}
+	case "active":
+		return []interface{}{activeOrDefault(u.Active)}
+	case "emails", "emails.value":
+		values := make([]interface{}, len(u.Emails))
+		for i, e := range u.Emails {
+			values[i] = e.Value
+		}
+		return values
+	case "groups", "groups.value":
+		values := make([]interface{}, len(u.Groups))
+		for i, g := range u.Groups {
+			values[i] = g.Value
+		}
+		return values
+	case "meta.created":
+		return []interface{}{u.Meta.Created.UTC().Format(time.RFC3339)}
+	case "meta.lastmodified":
+		return []interface{}{u.Meta.LastModified.UTC().Format(time.RFC3339)}
+	}
+	return nil
+}
+
+func (g *Group) attribute(path string) []interface{} {
+	switch path {
+	case "id":
+		return []interface{}{g.ID}
+	case "displayname":
+		return []interface{}{g.DisplayName}
+	case "members", "members.value":
+		values := make([]interface{}, len(g.Members))
+		for i, m := range g.Members {
+			values[i] = m.Value
+		}
+		return values
+	}
+	return nil
+}
+
+// page returns the resources from the 1-based startIndex, at most count of them.
+func page[T any](resources []T, startIndex, count int) *ListResponse {
+	total := len(resources)
+	startIndex = max(startIndex, 1)
+	count = min(max(count, 0), MaxPageSize)
+
+	from := min(startIndex-1, total)
+	to := min(from+count, total)
+	items := append([]T{}, resources[from:to]...)
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(items),
+		Resources:    items,
+	}
+}
+
+// opKind validates a patch operation's op and returns it lower-cased.
+func opKind(op PatchOperation) (string, error) {
+	kind := strings.ToLower(op.Op)
+	switch kind {
+	case "add", "replace", "remove":
+		return kind, nil
+	}
+	return "", NewError(http.StatusBadRequest, ErrTypeInvalidSyntax, "Unknown patch operation %q", op.Op)
+}
+
+// memberIDs extracts the user IDs from a members value: an array of {"value": "42"}.
+func memberIDs(value interface{}) ([]int64, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+	ids := make([]int64, 0, len(items))
+	for _, item := range items {
+		ref, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, NewError(http.StatusBadRequest, ErrTypeInvalidValue, "Members must be objects with a value")
+		}
+		id, err := parseMemberID(fmt.Sprint(ref["value"]))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func parseMemberID(value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, NewError(http.StatusBadRequest, ErrTypeInvalidValue, "Unknown member %q", value)
+	}
+	return id, nil
+}
+
+// parseBool accepts a JSON boolean or a string such as "False", which some identity
+// providers send.
+func parseBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(strings.ToLower(v))
+	}
+	return false, errors.New("not a boolean")
+}
+
+func activeOrDefault(active *bool) bool {
+	return active == nil || *active
+}
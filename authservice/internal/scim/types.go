@@ -0,0 +1,129 @@
+// Package scim implements SCIM 2.0 (RFC 7643/7644) provisioning, so an organization's
+// identity provider can manage its members.
+//
+// Users are the organization's members: id is the user ID and userName the email
+// address. Deleting a user removes the membership, not the account, which may belong
+// to other organizations. Groups are the fixed organization roles (owner, admin,
+// member); a user is in exactly one of them.
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Schema URIs.
+const (
+	SchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Meta describes a resource.
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Email is one of a user's email addresses.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Ref references another resource, e.g. a group's member or a user's group.
+type Ref struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// User is a SCIM user resource.
+type User struct {
+	Schemas    []string `json:"schemas"`
+	ID         string   `json:"id,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName"`
+	Active     *bool    `json:"active,omitempty"` // Absent means true in requests
+	Emails     []Email  `json:"emails,omitempty"`
+	Groups     []Ref    `json:"groups,omitempty"` // Read-only
+	Meta       *Meta    `json:"meta,omitempty"`
+}
+
+// Group is a SCIM group resource.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Ref    `json:"members"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse is the response to a list or filter query.
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// PatchRequest is a PATCH request body.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is a single PATCH operation. Value is decoded lazily since its
+// shape depends on the path.
+type PatchOperation struct {
+	Op    string      `json:"op"` // add, replace or remove (case-insensitive)
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Error types (scimType) from RFC 7644 section 3.12.
+const (
+	ErrTypeInvalidFilter = "invalidFilter"
+	ErrTypeUniqueness    = "uniqueness"
+	ErrTypeMutability    = "mutability"
+	ErrTypeInvalidSyntax = "invalidSyntax"
+	ErrTypeInvalidPath   = "invalidPath"
+	ErrTypeInvalidValue  = "invalidValue"
+	ErrTypeNoTarget      = "noTarget"
+)
+
+// Error is a SCIM error response. It is also returned as an error by ScimService for
+// problems with the request.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+	Status   int      `json:"status,string"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("scim: %d %s: %s", e.Status, e.ScimType, e.Detail)
+}
+
+// NewError creates an Error.
+func NewError(status int, scimType, format string, args ...interface{}) *Error {
+	return &Error{
+		Schemas:  []string{SchemaError},
+		ScimType: scimType,
+		Detail:   fmt.Sprintf(format, args...),
+		Status:   status,
+	}
+}
+
+// errNotFound is returned for users and groups that don't exist in the organization.
+func errNotFound(resourceType, id string) *Error {
+	return NewError(http.StatusNotFound, "", "%s %q not found", resourceType, id)
+}
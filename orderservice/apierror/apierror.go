@@ -0,0 +1,20 @@
+// Package apierror writes the JSON error responses of the public API, so middleware and
+// handlers report errors the same way.
+package apierror
+
+import (
+    "encoding/json"
+    "net/http"
+)
+
+// Response is the body of an error response.
+type Response struct {
+    Error string `json:"error"`
+}
+
+// Write responds with status and msg as a JSON error.
+func Write(w http.ResponseWriter, status int, msg string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(Response{Error: msg})
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "orderservice/telemetry"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Token exchange identifiers (RFC 8693).
+const (
+    grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+    tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// exchangeMargin is how long before expiry an exchanged token stops being reused, so it
+// doesn't expire on its way to the other service.
+const exchangeMargin = 30 * time.Second
+
+// Exchanger swaps users' access tokens at authservice's token endpoint for tokens that
+// let this service call another one on their behalf, restricted to that service and a
+// scope. Exchanged tokens are reused until shortly before they expire.
+type Exchanger struct {
+    tokenURL     string
+    clientID     string
+    clientSecret string
+    client       *http.Client
+
+    mu    sync.Mutex
+    cache map[string]exchangedToken // Keyed by a hash of the subject token, audience and scope
+}
+
+type exchangedToken struct {
+    token     string
+    expiresAt time.Time
+}
+
+// NewExchanger creates an Exchanger authenticating to the token endpoint at tokenURL
+// with the service's client credentials.
+func NewExchanger(tokenURL, clientID, clientSecret string) *Exchanger {
+    return &Exchanger{
+        tokenURL:     tokenURL,
+        clientID:     clientID,
+        clientSecret: clientSecret,
+        client:       telemetry.NewHTTPClient(5 * time.Second),
+        cache:        make(map[string]exchangedToken),
+    }
+}
+
+// Exchange returns a token for audience with scope, acting for the user of subjectToken.
+func (e *Exchanger) Exchange(ctx context.Context, subjectToken, audience, scope string) (string, error) {
+    sum := sha256.Sum256([]byte(subjectToken + "\x00" + audience + "\x00" + scope))
+    key := hex.EncodeToString(sum[:])
+    now := time.Now()
+    e.mu.Lock()
+    cached, ok := e.cache[key]
+    e.mu.Unlock()
+    if ok && now.Before(cached.expiresAt) {
+        return cached.token, nil
+    }
+
+    form := url.Values{}
+    form.Set("grant_type", grantTypeTokenExchange)
+    form.Set("subject_token", subjectToken)
+    form.Set("subject_token_type", tokenTypeAccessToken)
+    form.Set("audience", audience)
+    form.Set("scope", scope)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.tokenURL, strings.NewReader(form.Encode()))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.SetBasicAuth(url.QueryEscape(e.clientID), url.QueryEscape(e.clientSecret))
+
+    resp, err := e.client.Do(req)
+    if err != nil {
+        return "", fmt.Errorf("failed to reach token endpoint: %w", err)
+    }
+    defer resp.Body.Close()
+    var body struct {
+        AccessToken      string `json:"access_token"`
+        ExpiresIn        int64  `json:"expires_in"`
+        Error            string `json:"error"`
+        ErrorDescription string `json:"error_description"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        return "", fmt.Errorf("invalid response from token endpoint (%s): %w", resp.Status, err)
+    }
+    if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+        return "", fmt.Errorf("token exchange for %s failed with %s: %s %s", audience, resp.Status, body.Error, body.ErrorDescription)
+    }
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    for k, t := range e.cache {
+        if now.After(t.expiresAt) {
+            delete(e.cache, k)
+        }
+    }
+    e.cache[key] = exchangedToken{token: body.AccessToken, expiresAt: now.Add(time.Duration(body.ExpiresIn)*time.Second - exchangeMargin)}
+    return body.AccessToken, nil
+}
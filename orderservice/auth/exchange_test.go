@@ -0,0 +1,84 @@
+package auth
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "sync/atomic"
+    "testing"
+)
+
+// tokenEndpoint stands in for authservice's token endpoint, counting exchanges.
+func tokenEndpoint(t *testing.T, calls *atomic.Int32) *httptest.Server {
+    t.Helper()
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        calls.Add(1)
+        id, secret, _ := r.BasicAuth()
+        id, _ = url.QueryUnescape(id)
+        secret, _ = url.QueryUnescape(secret)
+        r.ParseForm()
+        w.Header().Set("Content-Type", "application/json")
+        if id != "orderservice" || secret != "s3cret:+" {
+            w.WriteHeader(http.StatusUnauthorized)
+            json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+            return
+        }
+        if r.PostForm.Get("grant_type") != grantTypeTokenExchange || r.PostForm.Get("subject_token_type") != tokenTypeAccessToken || r.PostForm.Get("scope") == "" {
+            w.WriteHeader(http.StatusBadRequest)
+            json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+            return
+        }
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "access_token": "exchanged:" + r.PostForm.Get("subject_token") + ":" + r.PostForm.Get("audience") + ":" + r.PostForm.Get("scope"),
+            "expires_in":   300,
+        })
+    }))
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+func TestExchange(t *testing.T) {
+    var calls atomic.Int32
+    srv := tokenEndpoint(t, &calls)
+    e := NewExchanger(srv.URL, "orderservice", "s3cret:+")
+    ctx := context.Background()
+
+    token, err := e.Exchange(ctx, "user-token", "productservice", "products:read")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if token != "exchanged:user-token:productservice:products:read" {
+        t.Errorf("token = %q", token)
+    }
+    if again, err := e.Exchange(ctx, "user-token", "productservice", "products:read"); err != nil || again != token {
+        t.Errorf("second exchange = %q, %v; want the cached token", again, err)
+    }
+    if calls.Load() != 1 {
+        t.Errorf("token endpoint called %d times, want 1", calls.Load())
+    }
+    if other, _ := e.Exchange(ctx, "other-user-token", "productservice", "products:read"); other == token {
+        t.Error("another user's token was reused")
+    }
+}
+
+func TestExchangeErrors(t *testing.T) {
+    var calls atomic.Int32
+    srv := tokenEndpoint(t, &calls)
+    ctx := context.Background()
+
+    _, err := NewExchanger(srv.URL, "orderservice", "wrong").Exchange(ctx, "user-token", "productservice", "products:read")
+    if err == nil || !strings.Contains(err.Error(), "invalid_client") {
+        t.Errorf("wrong secret: error = %v, want invalid_client", err)
+    }
+    _, err = NewExchanger(srv.URL, "orderservice", "s3cret:+").Exchange(ctx, "user-token", "productservice", "")
+    if err == nil || !strings.Contains(err.Error(), "invalid_request") {
+        t.Errorf("no scope: error = %v, want invalid_request", err)
+    }
+    srv.Close()
+    if _, err := NewExchanger(srv.URL, "orderservice", "s3cret:+").Exchange(ctx, "user-token", "productservice", "products:read"); err == nil {
+        t.Error("unreachable token endpoint: no error")
+    }
+}
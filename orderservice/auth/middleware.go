@@ -0,0 +1,85 @@
+package auth
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+    "orderservice/apierror"
+    "orderservice/logging"
+    "strings"
+)
+
+type contextKey struct{}
+
+// tokenKey holds the request's bearer token, for exchanging it.
+type tokenKey struct{}
+
+// Middleware rejects requests without a valid bearer token and makes the token's
+// claims available through FromContext. The user is added to the access log.
+func Middleware(v *Verifier) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+            if !ok || token == "" {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="orderservice"`)
+                apierror.Write(w, http.StatusUnauthorized, "missing bearer token")
+                return
+            }
+            claims, err := v.Verify(r.Context(), token)
+            if err != nil {
+                slog.DebugContext(r.Context(), "Rejected access token", "error", err)
+                w.Header().Set("WWW-Authenticate", `Bearer realm="orderservice", error="invalid_token"`)
+                apierror.Write(w, http.StatusUnauthorized, "invalid or expired token")
+                return
+            }
+            logging.AddAttrs(r.Context(), slog.Int64("user_id", claims.UserID))
+            if claims.Act != nil {
+                logging.AddAttrs(r.Context(), slog.String("actor", claims.Act.Subject))
+            }
+            ctx := context.WithValue(r.Context(), contextKey{}, claims)
+            next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, tokenKey{}, token)))
+        })
+    }
+}
+
+// RequireScope rejects tokens that don't allow scope. It must run after Middleware.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            claims, _ := FromContext(r.Context())
+            if !claims.HasScope(scope) {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="orderservice", error="insufficient_scope", scope="`+scope+`"`)
+                apierror.Write(w, http.StatusForbidden, "token lacks the "+scope+" scope")
+                return
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// RejectDelegated rejects tokens with an act claim, for requests only users may make
+// themselves: neither staff impersonating them nor a service holding an exchanged token
+// may move their money. It must run after Middleware.
+func RejectDelegated(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        claims, _ := FromContext(r.Context())
+        if claims.Act != nil {
+            slog.WarnContext(r.Context(), "Rejected delegated token", "actor", claims.Act.Subject, "actor_user_id", claims.Act.UserID)
+            apierror.Write(w, http.StatusForbidden, "not allowed with a delegated or impersonation token")
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// FromContext returns the claims of the request's verified token.
+func FromContext(ctx context.Context) (*Claims, bool) {
+    claims, ok := ctx.Value(contextKey{}).(*Claims)
+    return claims, ok
+}
+
+// TokenFromContext returns the request's verified bearer token.
+func TokenFromContext(ctx context.Context) (string, bool) {
+    token, ok := ctx.Value(tokenKey{}).(string)
+    return token, ok
+}
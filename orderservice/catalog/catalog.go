@@ -0,0 +1,90 @@
+// Package catalog looks up products in productservice, the source of truth for
+// product names and prices.
+package catalog
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "net/http"
+    "net/url"
+    "orderservice/auth"
+    "orderservice/telemetry"
+    "strings"
+    "time"
+)
+
+var ErrProductNotFound = errors.New("product not found")
+
+// Product is a product's current name and price.
+type Product struct {
+    ID         string
+    Name       string
+    PriceCents int64
+}
+
+// Catalog looks up products.
+type Catalog interface {
+    GetProduct(ctx context.Context, id string) (*Product, error)
+}
+
+// Audience and scope of the tokens productservice is called with.
+const (
+    audience = "productservice"
+    scope    = "products:read"
+)
+
+// client calls productservice's public API.
+type client struct {
+    baseURL   string
+    http      *http.Client
+    exchanger *auth.Exchanger
+}
+
+// New creates a Catalog backed by the productservice at baseURL. With an exchanger, it
+// calls productservice on behalf of the request's user, with their token exchanged for
+// one that only productservice accepts; the user's own token is never passed on.
+func New(baseURL string, exchanger *auth.Exchanger) Catalog {
+    return &client{baseURL: strings.TrimRight(baseURL, "/"), http: telemetry.NewHTTPClient(5 * time.Second), exchanger: exchanger}
+}
+
+func (c *client) GetProduct(ctx context.Context, id string) (*Product, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/products/"+url.PathEscape(id), nil)
+    if err != nil {
+        return nil, err
+    }
+    if userToken, ok := auth.TokenFromContext(ctx); ok && c.exchanger != nil {
+        token, err := c.exchanger.Exchange(ctx, userToken, audience, scope)
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to reach productservice: %w", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusNotFound {
+        return nil, ErrProductNotFound
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("productservice returned %s", resp.Status)
+    }
+
+    var p struct {
+        ID    string  `json:"id"`
+        Name  string  `json:"name"`
+        Price float64 `json:"price"` // In currency units
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+        return nil, fmt.Errorf("invalid product from productservice: %w", err)
+    }
+    if p.Price < 0 || math.IsNaN(p.Price) || math.IsInf(p.Price, 0) {
+        return nil, fmt.Errorf("invalid price %v for product %s", p.Price, id)
+    }
+    return &Product{ID: p.ID, Name: p.Name, PriceCents: int64(math.Round(p.Price * 100))}, nil
+}
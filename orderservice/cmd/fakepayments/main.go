@@ -0,0 +1,39 @@
+// Command fakepayments runs the fake payment provider on its own, for integration tests
+// and local setups that run orderservice with PAYMENT_API_URL pointing at it. With
+// FAKE_PAYMENTS_WEBHOOK_URL set, it delivers webhooks there, signed with
+// FAKE_PAYMENTS_WEBHOOK_SECRET.
+package main
+
+import (
+    "log/slog"
+    "net/http"
+    "orderservice/payments"
+    "os"
+    "time"
+)
+
+func main() {
+    addr := os.Getenv("FAKE_PAYMENTS_ADDR")
+    if addr == "" {
+        addr = ":12111"
+    }
+    key := os.Getenv("FAKE_PAYMENTS_KEY")
+    if key == "" {
+        key = "sk_test_fake"
+    }
+
+    fake := payments.NewFakeServer(key)
+    if url := os.Getenv("FAKE_PAYMENTS_WEBHOOK_URL"); url != "" {
+        secret := os.Getenv("FAKE_PAYMENTS_WEBHOOK_SECRET")
+        if secret == "" {
+            secret = "whsec_fake"
+        }
+        fake.SendWebhooks(url, secret, &http.Client{Timeout: 10 * time.Second})
+    }
+
+    slog.Info("Fake payment provider started", "addr", addr)
+    if err := http.ListenAndServe(addr, fake); err != nil {
+        slog.Error("Server failed", "error", err)
+        os.Exit(1)
+    }
+}
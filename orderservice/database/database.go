@@ -0,0 +1,106 @@
+// Package database connects to PostgreSQL and keeps its schema up to date.
+package database
+
+import (
+    "context"
+    "embed"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/exaring/otelpgx"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Connect creates a connection pool for the database at url (a postgres:// URL or
+// key=value DSN), retrying for a while so the service can start alongside the database.
+func Connect(ctx context.Context, url string) (*pgxpool.Pool, error) {
+    poolConfig, err := pgxpool.ParseConfig(url)
+    if err != nil {
+        return nil, fmt.Errorf("invalid DATABASE_URL: %w", err)
+    }
+    poolConfig.MaxConns = 10
+    poolConfig.MaxConnLifetime = time.Hour
+    poolConfig.MaxConnIdleTime = 30 * time.Minute
+    poolConfig.ConnConfig.Tracer = otelpgx.NewTracer() // A span for every query
+
+    const attempts = 5
+    for i := 1; ; i++ {
+        pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+        if err == nil {
+            if err = pool.Ping(ctx); err == nil {
+                return pool, nil
+            }
+            pool.Close()
+        }
+        if i == attempts {
+            return nil, fmt.Errorf("failed to connect to database: %w", err)
+        }
+        slog.Warn("Database not reachable, retrying", "error", err, "attempt", i, "max_attempts", attempts)
+        select {
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        case <-time.After(2 * time.Second):
+        }
+    }
+}
+
+// Migrate applies every migration in migrations/ that has not been applied yet.
+// Files are applied in lexical order, each in its own transaction.
+func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
+    _, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+        version    TEXT PRIMARY KEY,
+        applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+    )`)
+    if err != nil {
+        return fmt.Errorf("failed to create schema_migrations table: %w", err)
+    }
+
+    names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+    if err != nil {
+        return err
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+
+        var applied bool
+        err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied)
+        if err != nil {
+            return fmt.Errorf("failed to check migration %s: %w", version, err)
+        }
+        if applied {
+            continue
+        }
+
+        sql, err := migrationFiles.ReadFile(name)
+        if err != nil {
+            return err
+        }
+
+        tx, err := pool.Begin(ctx)
+        if err != nil {
+            return err
+        }
+        if _, err := tx.Exec(ctx, string(sql)); err != nil {
+            tx.Rollback(ctx)
+            return fmt.Errorf("failed to apply migration %s: %w", version, err)
+        }
+        if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+            tx.Rollback(ctx)
+            return fmt.Errorf("failed to record migration %s: %w", version, err)
+        }
+        if err := tx.Commit(ctx); err != nil {
+            return err
+        }
+        slog.Info("Applied database migration", "version", version)
+    }
+    return nil
+}
@@ -0,0 +1,10 @@
+CREATE TABLE orders (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT        NOT NULL DEFAULT '', -- Empty once the user has been erased
+    product    TEXT        NOT NULL DEFAULT '',
+    status     TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX orders_user_id_idx ON orders (user_id);
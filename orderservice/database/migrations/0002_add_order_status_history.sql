@@ -0,0 +1,19 @@
+-- Orders follow a fixed lifecycle (see models.CanTransition); statuses set freely
+-- before it existed are reset to pending
+UPDATE orders SET status = 'pending'
+WHERE status NOT IN ('pending', 'awaiting_payment', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded');
+
+ALTER TABLE orders ALTER COLUMN status SET DEFAULT 'pending';
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'awaiting_payment', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded'));
+
+CREATE TABLE order_status_history (
+    id          BIGSERIAL PRIMARY KEY,
+    order_id    TEXT        NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    from_status TEXT        NOT NULL DEFAULT '', -- Empty for the order's creation
+    to_status   TEXT        NOT NULL,
+    actor       TEXT        NOT NULL DEFAULT '',
+    changed_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX order_status_history_order_id_idx ON order_status_history (order_id, changed_at, id);
@@ -0,0 +1,19 @@
+-- Orders hold line items with price snapshots instead of a free-text product
+CREATE TABLE order_items (
+    order_id         TEXT    NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    position         INT     NOT NULL, -- Order of the items as placed
+    product_id       TEXT    NOT NULL,
+    name             TEXT    NOT NULL DEFAULT '', -- Snapshot of the product's name
+    quantity         INT     NOT NULL CHECK (quantity > 0),
+    unit_price_cents BIGINT  NOT NULL CHECK (unit_price_cents >= 0), -- Snapshot of the product's price
+    PRIMARY KEY (order_id, position)
+);
+
+ALTER TABLE orders ADD COLUMN subtotal_cents BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN total_cents    BIGINT NOT NULL DEFAULT 0;
+
+-- Existing orders keep their product as a single unpriced item
+INSERT INTO order_items (order_id, position, product_id, name, quantity, unit_price_cents)
+SELECT id, 0, product, product, 1, 0 FROM orders WHERE product <> '';
+
+ALTER TABLE orders DROP COLUMN product;
@@ -0,0 +1,6 @@
+-- Indexes for listing orders (see OrderRepository.ListOrders); the user's index now
+-- also covers the default sort
+DROP INDEX orders_user_id_idx;
+CREATE INDEX orders_user_id_created_at_idx ON orders (user_id, created_at, id);
+CREATE INDEX orders_created_at_idx ON orders (created_at, id);
+CREATE INDEX order_items_product_id_idx ON order_items (product_id);
@@ -0,0 +1,2 @@
+-- Orders are paid through a payment provider; this is the provider's payment intent
+ALTER TABLE orders ADD COLUMN payment_intent_id TEXT NOT NULL DEFAULT '';
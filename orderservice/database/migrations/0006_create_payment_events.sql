@@ -0,0 +1,24 @@
+-- Payments can be disputed after the fact (see models.CanTransition)
+ALTER TABLE orders DROP CONSTRAINT orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'awaiting_payment', 'paid', 'shipped', 'delivered', 'disputed', 'cancelled', 'refunded'));
+
+CREATE INDEX orders_payment_intent_id_idx ON orders (payment_intent_id) WHERE payment_intent_id <> '';
+
+-- Webhook events from the payment provider, kept until and after they're applied
+CREATE TABLE payment_events (
+    id             TEXT PRIMARY KEY, -- The provider's event ID, so redelivered events are stored once
+    type           TEXT        NOT NULL,
+    intent_id      TEXT        NOT NULL DEFAULT '',
+    order_id       TEXT        NOT NULL DEFAULT '',
+    amount_cents   BIGINT      NOT NULL DEFAULT 0,
+    currency       TEXT        NOT NULL DEFAULT '',
+    dispute_status TEXT        NOT NULL DEFAULT '',
+    payload        JSONB       NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL, -- When the provider created the event
+    received_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    processed_at   TIMESTAMPTZ,          -- NULL while waiting to be applied
+    result         TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX payment_events_unprocessed_idx ON payment_events (intent_id, created_at) WHERE processed_at IS NULL;
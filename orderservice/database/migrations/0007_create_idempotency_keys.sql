@@ -0,0 +1,13 @@
+-- Requests made with an Idempotency-Key and their responses, replayed to retries
+CREATE TABLE idempotency_keys (
+    key          TEXT PRIMARY KEY, -- Scoped to the user who made the request
+    fingerprint  TEXT        NOT NULL,
+    completed    BOOLEAN     NOT NULL DEFAULT false,
+    status_code  INT         NOT NULL DEFAULT 0,
+    content_type TEXT        NOT NULL DEFAULT '',
+    body         BYTEA,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,28 @@
+-- Orders can be refunded in part (see models.CanTransition)
+ALTER TABLE orders DROP CONSTRAINT orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'awaiting_payment', 'paid', 'shipped', 'delivered', 'disputed',
+                      'cancelled', 'partially_refunded', 'refunded'));
+
+ALTER TABLE orders ADD COLUMN refunded_cents BIGINT NOT NULL DEFAULT 0; -- Sum of the successful refunds
+
+CREATE TABLE refunds (
+    id                 TEXT PRIMARY KEY,
+    order_id           TEXT        NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    amount_cents       BIGINT      NOT NULL CHECK (amount_cents > 0),
+    reason             TEXT        NOT NULL DEFAULT '',
+    status             TEXT        NOT NULL CHECK (status IN ('pending', 'succeeded', 'failed')),
+    provider_refund_id TEXT        NOT NULL DEFAULT '',
+    actor              TEXT        NOT NULL DEFAULT '',
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX refunds_order_id_idx ON refunds (order_id, created_at);
+
+CREATE TABLE refund_items (
+    refund_id    TEXT   NOT NULL REFERENCES refunds (id) ON DELETE CASCADE,
+    product_id   TEXT   NOT NULL,
+    quantity     INT    NOT NULL CHECK (quantity > 0),
+    amount_cents BIGINT NOT NULL CHECK (amount_cents >= 0),
+    PRIMARY KEY (refund_id, product_id)
+);
@@ -0,0 +1,2 @@
+-- Pending refunds are retried until the provider reports their outcome
+CREATE INDEX refunds_pending_idx ON refunds (created_at, id) WHERE status = 'pending';
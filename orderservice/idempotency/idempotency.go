@@ -0,0 +1,161 @@
+// Package idempotency makes retrying mutating requests safe. A request with an
+// Idempotency-Key header is run once; retries with the same key get the original
+// response replayed, with an Idempotent-Replayed header, instead of repeating the work.
+// Keys are scoped to the authenticated user and expire after a configurable window.
+package idempotency
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "io"
+    "log/slog"
+    "net/http"
+    "orderservice/apierror"
+    "orderservice/auth"
+    "orderservice/repository"
+    "time"
+)
+
+const (
+    // maxKeyLength bounds keys; UUIDs, the usual choice, are 36 characters.
+    maxKeyLength = 255
+    // maxBodyBytes bounds the requests fingerprinted; larger ones are rejected.
+    maxBodyBytes = 1 << 20
+    // lockTimeout is how long a request may hold its key. A key held longer belongs to
+    // a request whose server died, and is given to the next retry.
+    lockTimeout = time.Minute
+)
+
+// Middleware runs requests with an Idempotency-Key through keys, remembering them for
+// ttl. Requests without the header are passed through. It must run after auth.Middleware.
+func Middleware(keys repository.IdempotencyRepository, ttl time.Duration) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            key := r.Header.Get("Idempotency-Key")
+            if key == "" {
+                next.ServeHTTP(w, r)
+                return
+            }
+            if len(key) > maxKeyLength || !printable(key) {
+                apierror.Write(w, http.StatusBadRequest, "Idempotency-Key must be 1 to 255 printable ASCII characters")
+                return
+            }
+
+            body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+            if err != nil {
+                apierror.Write(w, http.StatusRequestEntityTooLarge, "request body too large")
+                return
+            }
+            r.Body = io.NopCloser(bytes.NewReader(body))
+
+            var subject string
+            if claims, ok := auth.FromContext(r.Context()); ok {
+                subject = claims.Subject()
+            }
+            scoped := subject + ":" + key
+            fingerprint := fingerprint(r, body)
+
+            now := time.Now()
+            record, err := keys.ClaimKey(r.Context(), scoped, fingerprint, now.Add(ttl), now.Add(-lockTimeout))
+            if err != nil {
+                slog.ErrorContext(r.Context(), "Error claiming idempotency key", "error", err)
+                apierror.Write(w, http.StatusInternalServerError, "internal server error")
+                return
+            }
+            if record != nil {
+                switch {
+                case record.Fingerprint != fingerprint:
+                    apierror.Write(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+                case !record.Completed:
+                    apierror.Write(w, http.StatusConflict, "a request with this Idempotency-Key is in progress")
+                default:
+                    if record.ContentType != "" {
+                        w.Header().Set("Content-Type", record.ContentType)
+                    }
+                    w.Header().Set("Idempotent-Replayed", "true")
+                    w.WriteHeader(record.StatusCode)
+                    w.Write(record.Body)
+                }
+                return
+            }
+
+            rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+            defer func() {
+                // Server errors and panics leave nothing to replay: the retry runs again
+                ctx := context.WithoutCancel(r.Context())
+                if p := recover(); p != nil {
+                    keys.ReleaseKey(ctx, scoped)
+                    panic(p)
+                }
+                if rec.status >= http.StatusInternalServerError {
+                    if err := keys.ReleaseKey(ctx, scoped); err != nil {
+                        slog.ErrorContext(ctx, "Error releasing idempotency key", "error", err)
+                    }
+                    return
+                }
+                if err := keys.CompleteKey(ctx, scoped, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes()); err != nil {
+                    slog.ErrorContext(ctx, "Error storing idempotent response", "error", err)
+                }
+            }()
+            next.ServeHTTP(rec, r)
+        })
+    }
+}
+
+// RunCleanup deletes expired keys every interval until ctx is done.
+func RunCleanup(ctx context.Context, keys repository.IdempotencyRepository, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if n, err := keys.DeleteExpiredKeys(ctx); err != nil {
+                slog.ErrorContext(ctx, "Error deleting expired idempotency keys", "error", err)
+            } else if n > 0 {
+                slog.DebugContext(ctx, "Deleted expired idempotency keys", "count", n)
+            }
+        }
+    }
+}
+
+// fingerprint identifies the request, so a key can't be reused for a different one.
+func fingerprint(r *http.Request, body []byte) string {
+    h := sha256.New()
+    io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+    h.Write(body)
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+func printable(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if s[i] < 0x20 || s[i] > 0x7e {
+            return false
+        }
+    }
+    return true
+}
+
+// recorder passes a response through while keeping a copy of it.
+type recorder struct {
+    http.ResponseWriter
+    status      int
+    body        bytes.Buffer
+    wroteHeader bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+    if !r.wroteHeader {
+        r.status, r.wroteHeader = status, true
+    }
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+    r.wroteHeader = true
+    r.body.Write(b)
+    return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "orderservice/repository"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// counting responds with how many times it has run, failing when the body asks it to.
+func counting(calls *atomic.Int32) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        n := calls.Add(1)
+        body, _ := io.ReadAll(r.Body)
+        w.Header().Set("Content-Type", "application/json")
+        if string(body) == "fail" {
+            w.WriteHeader(http.StatusInternalServerError)
+        } else {
+            w.WriteHeader(http.StatusCreated)
+        }
+        json.NewEncoder(w).Encode(map[string]int32{"call": n})
+    })
+}
+
+func send(h http.Handler, key, path, body string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+    if key != "" {
+        req.Header.Set("Idempotency-Key", key)
+    }
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    return rec
+}
+
+func TestMiddleware(t *testing.T) {
+    var calls atomic.Int32
+    h := Middleware(repository.NewMemoryIdempotencyRepository(), time.Hour)(counting(&calls))
+
+    first := send(h, "key-1", "/orders", "order")
+    if first.Code != http.StatusCreated {
+        t.Fatalf("first request returned %d", first.Code)
+    }
+    replay := send(h, "key-1", "/orders", "order")
+    if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() || replay.Header().Get("Idempotent-Replayed") != "true" {
+        t.Errorf("retry = %d %q, want the first response replayed", replay.Code, replay.Body.String())
+    }
+    if replay.Header().Get("Content-Type") != "application/json" {
+        t.Errorf("replayed Content-Type = %q", replay.Header().Get("Content-Type"))
+    }
+    if calls.Load() != 1 {
+        t.Errorf("handler ran %d times, want 1", calls.Load())
+    }
+
+    if rec := send(h, "key-1", "/orders", "other order"); rec.Code != http.StatusUnprocessableEntity {
+        t.Errorf("reusing the key for another body returned %d, want 422", rec.Code)
+    }
+    if rec := send(h, "key-1", "/payments", "order"); rec.Code != http.StatusUnprocessableEntity {
+        t.Errorf("reusing the key for another path returned %d, want 422", rec.Code)
+    }
+
+    send(h, "", "/orders", "order")
+    send(h, "", "/orders", "order")
+    if calls.Load() != 3 {
+        t.Errorf("requests without a key ran %d times in total, want 3", calls.Load())
+    }
+}
+
+func TestMiddlewareRetriesServerErrors(t *testing.T) {
+    var calls atomic.Int32
+    h := Middleware(repository.NewMemoryIdempotencyRepository(), time.Hour)(counting(&calls))
+
+    send(h, "key-1", "/orders", "fail")
+    if rec := send(h, "key-1", "/orders", "fail"); rec.Header().Get("Idempotent-Replayed") != "" {
+        t.Error("server error was replayed")
+    }
+    if calls.Load() != 2 {
+        t.Errorf("handler ran %d times, want 2", calls.Load())
+    }
+}
+
+func TestMiddlewareRejectsConcurrentRequests(t *testing.T) {
+    keys := repository.NewMemoryIdempotencyRepository()
+    release := make(chan struct{})
+    started := make(chan struct{})
+    h := Middleware(keys, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        close(started)
+        <-release
+    }))
+
+    done := make(chan struct{})
+    go func() {
+        send(h, "key-1", "/orders", "order")
+        close(done)
+    }()
+    <-started
+    if rec := send(h, "key-1", "/orders", "order"); rec.Code != http.StatusConflict {
+        t.Errorf("request while the first is in progress returned %d, want 409", rec.Code)
+    }
+    close(release)
+    <-done
+}
+
+func TestMiddlewareRejectsInvalidKeys(t *testing.T) {
+    h := Middleware(repository.NewMemoryIdempotencyRepository(), time.Hour)(http.NotFoundHandler())
+    for _, key := range []string{strings.Repeat("k", 256), "key\x01"} {
+        rec := send(h, key, "/orders", "order")
+        if rec.Code != http.StatusBadRequest {
+            t.Errorf("key %q returned %d, want 400", key, rec.Code)
+        }
+        var body struct {
+            Error string `json:"error"`
+        }
+        if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error == "" {
+            t.Errorf("key %q: error body isn't JSON: %v", key, err)
+        }
+    }
+}
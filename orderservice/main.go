@@ -1,13 +1,172 @@
 package main
 
 import (
-    "log"
+    "context"
+    "fmt"
+    "log/slog"
     "net/http"
+    "orderservice/auth"
+    "orderservice/catalog"
+    "orderservice/cors"
+    "orderservice/database"
+    "orderservice/idempotency"
+    "orderservice/logging"
+    "orderservice/payments"
+    "orderservice/reconcile"
+    "orderservice/repository"
     "orderservice/routes"
+    "orderservice/telemetry"
+    "os"
+    "os/signal"
+    "strings"
+    "syscall"
+    "time"
 )
 
 func main() {
-    router := routes.SetupRouter()
-    log.Println("OrderService started on :8081")
-    log.Fatal(http.ListenAndServe(":8081", router))
+    logLevel := new(slog.LevelVar)
+    slog.SetDefault(logging.New(os.Stdout, logLevel))
+    if lvl := os.Getenv("LOG_LEVEL"); lvl != "" {
+        level, err := logging.ParseLevel(lvl)
+        if err != nil {
+            fatal("Invalid LOG_LEVEL", err)
+        }
+        logLevel.Set(level)
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    shutdownTracing, err := telemetry.Setup(ctx, "orderservice")
+    if err != nil {
+        fatal("Failed to setup tracing", err)
+    }
+
+    // Orders live in PostgreSQL when DATABASE_URL is set, otherwise only in memory
+    var orders repository.OrderRepository
+    var paymentEvents repository.PaymentEventRepository
+    var idempotencyKeys repository.IdempotencyRepository
+    if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
+        pool, err := database.Connect(ctx, dbURL)
+        if err != nil {
+            fatal("Failed to connect to database", err)
+        }
+        defer pool.Close()
+        if err := database.Migrate(ctx, pool); err != nil {
+            fatal("Failed to migrate database", err)
+        }
+        orders = repository.NewPostgresOrderRepository(pool)
+        paymentEvents = repository.NewPostgresPaymentEventRepository(pool)
+        idempotencyKeys = repository.NewPostgresIdempotencyRepository(pool)
+    } else {
+        slog.Warn("DATABASE_URL not set, orders are kept in memory and lost on restart")
+        orders = repository.NewMemoryOrderRepository()
+        paymentEvents = repository.NewMemoryPaymentEventRepository()
+        idempotencyKeys = repository.NewMemoryIdempotencyRepository()
+    }
+
+    productServiceURL := os.Getenv("PRODUCT_SERVICE_URL")
+    if productServiceURL == "" {
+        productServiceURL = "http://productservice:8082"
+    }
+
+    // Access tokens are verified with authservice's published public keys
+    jwksURL := os.Getenv("AUTH_JWKS_URL")
+    if jwksURL == "" {
+        jwksURL = "http://authservice:8080/.well-known/jwks.json"
+    }
+    audiences := []string{"api", "orderservice"} // User tokens, and tokens exchanged for this service
+    if aud := os.Getenv("AUTH_AUDIENCES"); aud != "" {
+        audiences = strings.Split(aud, ",")
+    }
+    verifier := auth.NewVerifier(auth.NewKeySet(jwksURL), "authservice", audiences)
+
+    // Calls to productservice carry the user's token exchanged at AUTH_TOKEN_URL, with
+    // the service's AUTH_CLIENT_ID and AUTH_CLIENT_SECRET
+    var exchanger *auth.Exchanger
+    if clientID := os.Getenv("AUTH_CLIENT_ID"); clientID != "" {
+        tokenURL := os.Getenv("AUTH_TOKEN_URL")
+        if tokenURL == "" {
+            tokenURL = "http://authservice:8080/oauth/token"
+        }
+        exchanger = auth.NewExchanger(tokenURL, clientID, os.Getenv("AUTH_CLIENT_SECRET"))
+    } else {
+        slog.Warn("AUTH_CLIENT_ID not set, productservice is called without the user's delegated token")
+    }
+
+    // Payments go through the Stripe-style API at PAYMENT_API_URL, which confirms them with
+    // webhooks signed with PAYMENT_WEBHOOK_SECRET. Both secrets are required; for local
+    // setups, point PAYMENT_API_URL at cmd/fakepayments.
+    currency := os.Getenv("PAYMENT_CURRENCY")
+    if currency == "" {
+        currency = "usd"
+    }
+    key := os.Getenv("PAYMENT_API_KEY")
+    if key == "" {
+        fatal("Missing payment provider configuration", fmt.Errorf("PAYMENT_API_KEY is not set"))
+    }
+    webhookSecret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+    if webhookSecret == "" {
+        fatal("Missing payment provider configuration", fmt.Errorf("PAYMENT_WEBHOOK_SECRET is not set"))
+    }
+    apiURL := os.Getenv("PAYMENT_API_URL")
+    if apiURL == "" {
+        apiURL = "https://api.stripe.com"
+    }
+    provider := payments.NewStripe(apiURL, key, webhookSecret, telemetry.NewHTTPClient(10*time.Second))
+    processor := reconcile.New(orders, paymentEvents, strings.ToLower(currency))
+    go processor.Run(ctx, time.Minute)
+    refunds := reconcile.NewRefunds(orders, provider)
+    go refunds.Run(ctx, time.Minute)
+
+    // Idempotency keys are remembered for IDEMPOTENCY_KEY_TTL, e.g. "24h" (default)
+    keyTTL := 24 * time.Hour
+    if ttl := os.Getenv("IDEMPOTENCY_KEY_TTL"); ttl != "" {
+        keyTTL, err = time.ParseDuration(ttl)
+        if err == nil && keyTTL <= 0 {
+            err = fmt.Errorf("%s is not positive", ttl)
+        }
+        if err != nil {
+            fatal("Invalid IDEMPOTENCY_KEY_TTL", err)
+        }
+    }
+    go idempotency.RunCleanup(ctx, idempotencyKeys, time.Hour)
+
+    router := routes.SetupRouter(orders, catalog.New(productServiceURL, exchanger), provider, refunds, strings.ToLower(currency), verifier, idempotency.Middleware(idempotencyKeys, keyTTL))
+    routes.RegisterWebhooks(router, provider, processor)
+    if token := os.Getenv("INTERNAL_API_TOKEN"); token != "" {
+        routes.RegisterInternal(router, token, orders)
+    }
+    if token := os.Getenv("LOG_ADMIN_TOKEN"); token != "" {
+        router.Handle("/debug/log-level", logging.RequireToken(token, logging.LevelHandler(logLevel))).Methods("GET", "PUT")
+    }
+
+    server := &http.Server{Addr: ":8081", Handler: telemetry.Middleware("orderservice", logging.Middleware(slog.Default(), cors.Middleware(cors.FromEnv(), router)))}
+    // ListenAndServe returns as soon as Shutdown starts, so wait for in-flight
+    // requests to finish and the last spans to be flushed before exiting
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        if err := server.Shutdown(shutdownCtx); err != nil {
+            slog.Error("Graceful shutdown failed", "error", err)
+        }
+        if err := shutdownTracing(shutdownCtx); err != nil {
+            slog.Error("Failed to shutdown tracing", "error", err)
+        }
+    }()
+
+    slog.Info("OrderService started", "addr", server.Addr)
+    if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+        fatal("Server failed", err)
+    }
+    <-done
+    slog.Info("Server gracefully stopped")
+}
+
+func fatal(msg string, err error) {
+    slog.Error(msg, "error", err)
+    os.Exit(1)
 }
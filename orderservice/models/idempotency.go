@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is a request made with an Idempotency-Key and, once it has finished,
+// its response, which is replayed to retries.
+type IdempotencyRecord struct {
+    Key         string
+    Fingerprint string // Hash of the request, so the key can't be reused for a different one
+    Completed   bool
+    StatusCode  int
+    ContentType string
+    Body        []byte
+    CreatedAt   time.Time
+    ExpiresAt   time.Time
+}
@@ -1 +1,52 @@
-go\npackage models\n\ntype Order struct {\n ID string `json:"id"`\n UserID string `json:"user_id"`\n Product string `json:"product"`\n Status string `json:"status"`\n}
+package models
+
+import (
+    "time"
+
+    "github.com/oklog/ulid/v2"
+)
+
+// NewOrderID returns a new order ID, a ULID: unique, and sorted by creation time.
+func NewOrderID() string {
+    return ulid.Make().String()
+}
+
+// NewRefundID returns a new refund ID, a ULID like order IDs.
+func NewRefundID() string {
+    return ulid.Make().String()
+}
+
+// Order amounts are in cents (minor currency units), so they add up exactly.
+type Order struct {
+    ID              string     `json:"id"`
+    UserID          string     `json:"user_id"`
+    Items           []LineItem `json:"items"`
+    SubtotalCents   int64      `json:"subtotal_cents"` // Sum of the line totals
+    TotalCents      int64      `json:"total_cents"`    // What the customer pays
+    RefundedCents   int64      `json:"refunded_cents"` // Sum of the successful refunds
+    Status          string     `json:"status"`
+    PaymentIntentID string     `json:"payment_intent_id,omitempty"` // The provider's payment, once the customer starts paying
+    CreatedAt       time.Time  `json:"created_at"`
+    UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// LineItem is a product in an order. Its name and price are snapshots taken when the
+// order was placed, so later catalog changes don't alter the order.
+type LineItem struct {
+    ProductID      string `json:"product_id"`
+    Name           string `json:"name"`
+    Quantity       int    `json:"quantity"`
+    UnitPriceCents int64  `json:"unit_price_cents"`
+    LineTotalCents int64  `json:"line_total_cents"`
+}
+
+// CalculateTotals sets the line totals and the order's subtotal and total.
+func (o *Order) CalculateTotals() {
+    o.SubtotalCents = 0
+    for i := range o.Items {
+        item := &o.Items[i]
+        item.LineTotalCents = item.UnitPriceCents * int64(item.Quantity)
+        o.SubtotalCents += item.LineTotalCents
+    }
+    o.TotalCents = o.SubtotalCents
+}
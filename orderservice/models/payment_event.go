@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// PaymentEvent is a webhook event from the payment provider. Events are stored as they
+// arrive and applied to their order once it's in a state they apply to, as providers
+// don't deliver them in order.
+type PaymentEvent struct {
+    ID            string     `json:"id"` // The provider's event ID
+    Type          string     `json:"type"`
+    IntentID      string     `json:"intent_id"`
+    OrderID       string     `json:"order_id,omitempty"` // From the intent's metadata, if the event carries it
+    AmountCents   int64      `json:"amount_cents"`
+    Currency      string     `json:"currency"`
+    DisputeStatus string     `json:"dispute_status,omitempty"`
+    Payload       []byte     `json:"-"`          // The event as received
+    CreatedAt     time.Time  `json:"created_at"` // When the provider created the event
+    ReceivedAt    time.Time  `json:"received_at"`
+    ProcessedAt   *time.Time `json:"processed_at,omitempty"` // Nil until applied or given up on
+    Result        string     `json:"result"`                 // What processing did, or why it's waiting
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Refund statuses. A pending refund is being sent to the payment provider; its amount
+// already counts as refunded, so concurrent refunds can't exceed the payment.
+const (
+    RefundPending   = "pending"
+    RefundSucceeded = "succeeded"
+    RefundFailed    = "failed"
+)
+
+// Refund gives back some or all of an order's payment.
+type Refund struct {
+    ID               string       `json:"id"`
+    OrderID          string       `json:"order_id"`
+    Items            []RefundItem `json:"items"`
+    AmountCents      int64        `json:"amount_cents"` // Sum of the items' amounts
+    Reason           string       `json:"reason,omitempty"`
+    Status           string       `json:"status"`
+    ProviderRefundID string       `json:"provider_refund_id,omitempty"`
+    Actor            string       `json:"actor"` // Who issued the refund
+    CreatedAt        time.Time    `json:"created_at"`
+}
+
+// RefundItem is the refunded quantity of one of the order's line items, at the price
+// it was bought for.
+type RefundItem struct {
+    ProductID   string `json:"product_id"`
+    Quantity    int    `json:"quantity"`
+    AmountCents int64  `json:"amount_cents"`
+}
+
+// Refundable reports whether an order in status can be refunded. Disputed payments are
+// settled by the dispute instead.
+func Refundable(status string) bool {
+    switch status {
+    case StatusPaid, StatusShipped, StatusDelivered, StatusPartiallyRefunded:
+        return true
+    }
+    return false
+}
+
+// RefundStatus returns the status of an order once refundedCents of totalCents are refunded.
+func RefundStatus(refundedCents, totalCents int64) string {
+    if refundedCents >= totalCents {
+        return StatusRefunded
+    }
+    return StatusPartiallyRefunded
+}
@@ -0,0 +1,74 @@
+package models
+
+import "time"
+
+// Order statuses. An order moves through them as allowed by CanTransition.
+const (
+    StatusPending           = "pending"
+    StatusAwaitingPayment   = "awaiting_payment"
+    StatusPaid              = "paid"
+    StatusShipped           = "shipped"
+    StatusDelivered         = "delivered"
+    StatusDisputed          = "disputed" // The customer disputed the payment with their bank
+    StatusPartiallyRefunded = "partially_refunded"
+    StatusCancelled         = "cancelled"
+    StatusRefunded          = "refunded"
+)
+
+// statusTransitions lists the statuses each status may move to. Cancelled and
+// refunded orders are final. A disputed order goes back to where it was when the
+// dispute is won, and is refunded when it's lost. A partially refunded order can still
+// be shipped and delivered, and further refunds keep it partially refunded until
+// everything is refunded. Statuses in providerStatuses are only reached through
+// payments, refunds and reconciliation.
+var statusTransitions = map[string][]string{
+    StatusPending:           {StatusAwaitingPayment, StatusCancelled},
+    StatusAwaitingPayment:   {StatusPaid, StatusCancelled},
+    StatusPaid:              {StatusShipped, StatusPartiallyRefunded, StatusRefunded, StatusDisputed},
+    StatusShipped:           {StatusDelivered, StatusPartiallyRefunded, StatusRefunded, StatusDisputed},
+    StatusDelivered:         {StatusPartiallyRefunded, StatusRefunded, StatusDisputed},
+    StatusPartiallyRefunded: {StatusShipped, StatusDelivered, StatusRefunded, StatusDisputed},
+    StatusDisputed:          {StatusPaid, StatusShipped, StatusDelivered, StatusPartiallyRefunded, StatusRefunded},
+    StatusCancelled:         {},
+    StatusRefunded:          {},
+}
+
+// providerStatuses follow from what the payment provider reports, so they're only set
+// by payments, refunds and reconciliation, never by hand.
+var providerStatuses = map[string]bool{
+    StatusPaid:              true,
+    StatusDisputed:          true,
+    StatusPartiallyRefunded: true,
+    StatusRefunded:          true,
+}
+
+// SetByProvider reports whether an order only reaches status through the payment provider.
+func SetByProvider(status string) bool {
+    return providerStatuses[status]
+}
+
+// ValidStatus reports whether status is a known order status.
+func ValidStatus(status string) bool {
+    _, ok := statusTransitions[status]
+    return ok
+}
+
+// CanTransition reports whether an order may move from one status to another.
+func CanTransition(from, to string) bool {
+    for _, next := range statusTransitions[from] {
+        if next == to {
+            return true
+        }
+    }
+    return false
+}
+
+// StatusChange is an entry in an order's status history. From is empty for the
+// order's creation.
+type StatusChange struct {
+    OrderID   string    `json:"order_id"`
+    From      string    `json:"from"`
+    To        string    `json:"to"`
+    Actor     string    `json:"actor"` // User ID or service that made the change
+    ChangedAt time.Time `json:"changed_at"`
+}
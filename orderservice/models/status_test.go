@@ -0,0 +1,64 @@
+package models
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+    tests := []struct {
+        from, to string
+        want     bool
+    }{
+        {StatusPending, StatusAwaitingPayment, true},
+        {StatusPending, StatusPaid, false},
+        {StatusAwaitingPayment, StatusPaid, true},
+        {StatusPaid, StatusShipped, true},
+        {StatusPaid, StatusPending, false},
+        {StatusDelivered, StatusShipped, false},
+        {StatusPartiallyRefunded, StatusShipped, true},
+        {StatusPartiallyRefunded, StatusDelivered, true},
+        {StatusPartiallyRefunded, StatusRefunded, true},
+        {StatusPartiallyRefunded, StatusDisputed, true},
+        {StatusDisputed, StatusShipped, true},
+        {StatusDisputed, StatusRefunded, true},
+        {StatusDisputed, StatusCancelled, false},
+        {StatusRefunded, StatusPaid, false},
+        {StatusCancelled, StatusPending, false},
+        {"unknown", StatusPaid, false},
+    }
+    for _, tt := range tests {
+        if got := CanTransition(tt.from, tt.to); got != tt.want {
+            t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+        }
+    }
+}
+
+func TestEveryTransitionIsToAKnownStatus(t *testing.T) {
+    for from, next := range statusTransitions {
+        for _, to := range next {
+            if !ValidStatus(to) {
+                t.Errorf("%s moves to unknown status %s", from, to)
+            }
+        }
+    }
+}
+
+func TestSetByProvider(t *testing.T) {
+    for _, status := range []string{StatusPaid, StatusDisputed, StatusPartiallyRefunded, StatusRefunded} {
+        if !SetByProvider(status) {
+            t.Errorf("SetByProvider(%s) = false, want true", status)
+        }
+    }
+    for _, status := range []string{StatusPending, StatusAwaitingPayment, StatusShipped, StatusDelivered, StatusCancelled} {
+        if SetByProvider(status) {
+            t.Errorf("SetByProvider(%s) = true, want false", status)
+        }
+    }
+}
+
+func TestRefundStatus(t *testing.T) {
+    if got := RefundStatus(500, 1000); got != StatusPartiallyRefunded {
+        t.Errorf("RefundStatus(500, 1000) = %s", got)
+    }
+    if got := RefundStatus(1000, 1000); got != StatusRefunded {
+        t.Errorf("RefundStatus(1000, 1000) = %s", got)
+    }
+}
@@ -0,0 +1,367 @@
+package payments
+
+import (
+    "bytes"
+    "crypto/rand"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// Test payment methods accepted by FakeServer.
+const (
+    FakeCardSucceeds = "pm_card_visa"
+    FakeCardDeclined = "pm_card_chargeDeclined"
+    FakeCardDisputed = "pm_card_createDispute" // Succeeds, then the customer disputes the payment
+)
+
+// fakeWinningEvidence wins a dispute when submitted as its evidence.
+const fakeWinningEvidence = "winning_evidence"
+
+// FakeServer is a stand-in for the provider's API, for local development and tests. It
+// keeps intents in memory and implements the Stripe-style endpoints the adapter uses,
+// plus confirming an intent with a test payment method:
+//
+//	POST /v1/payment_intents/{id}/confirm  payment_method=pm_card_visa&client_secret=...
+//
+// Confirming is what the customer's browser does with the real provider, so it accepts
+// the intent's client secret as well as the secret key. Unpaid intents are canceled with
+//
+//	POST /v1/payment_intents/{id}/cancel
+//
+// and disputes are closed with
+//
+//	POST /v1/disputes/{id}                 evidence[uncategorized_text]=winning_evidence (won)
+//	POST /v1/disputes/{id}/close           (lost)
+//
+// With SendWebhooks, it also delivers signed webhook events like the real provider.
+type FakeServer struct {
+    secretKey string
+    router    *mux.Router
+
+    mu          sync.Mutex
+    intents     map[string]*stripeIntent
+    disputes    map[string]*stripeDispute
+    refunds     map[string]*stripeRefund
+    refunded    map[string]int64  // Intent ID to amount refunded
+    idempotency map[string]string // Idempotency key to intent or refund ID
+    webhooks    *fakeWebhooks
+}
+
+// fakeWebhooks is where FakeServer delivers events.
+type fakeWebhooks struct {
+    url    string
+    secret string
+    client *http.Client
+}
+
+// NewFakeServer creates a fake provider that accepts requests with the secret key.
+func NewFakeServer(secretKey string) *FakeServer {
+    s := &FakeServer{
+        secretKey:   secretKey,
+        router:      mux.NewRouter(),
+        intents:     make(map[string]*stripeIntent),
+        disputes:    make(map[string]*stripeDispute),
+        refunds:     make(map[string]*stripeRefund),
+        refunded:    make(map[string]int64),
+        idempotency: make(map[string]string),
+    }
+    s.router.HandleFunc("/v1/payment_intents", s.createIntent).Methods("POST")
+    s.router.HandleFunc("/v1/payment_intents/{id}", s.getIntent).Methods("GET")
+    s.router.HandleFunc("/v1/payment_intents/{id}/confirm", s.confirmIntent).Methods("POST")
+    s.router.HandleFunc("/v1/payment_intents/{id}/cancel", s.cancelIntent).Methods("POST")
+    s.router.HandleFunc("/v1/refunds", s.createRefund).Methods("POST")
+    s.router.HandleFunc("/v1/refunds/{id}", s.getRefund).Methods("GET")
+    s.router.HandleFunc("/v1/disputes/{id}", s.updateDispute).Methods("POST")
+    s.router.HandleFunc("/v1/disputes/{id}/close", s.closeDispute).Methods("POST")
+    return s
+}
+
+// SendWebhooks makes the fake deliver events to url, signed with the webhook secret.
+// Deliveries are asynchronous, and retried a few times if they fail.
+func (s *FakeServer) SendWebhooks(url, secret string, client *http.Client) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.webhooks = &fakeWebhooks{url: url, secret: secret, client: client}
+}
+
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    s.router.ServeHTTP(w, r)
+}
+
+func (s *FakeServer) createIntent(w http.ResponseWriter, r *http.Request) {
+    if !s.authorized(r) {
+        fakeError(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API key provided")
+        return
+    }
+    amount, err := strconv.ParseInt(r.PostFormValue("amount"), 10, 64)
+    if err != nil || amount < 1 {
+        fakeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid_integer", "amount must be a positive integer")
+        return
+    }
+    currency := strings.ToLower(r.PostFormValue("currency"))
+    if currency == "" {
+        fakeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "Missing required param: currency")
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    key := r.Header.Get("Idempotency-Key")
+    if id, ok := s.idempotency[key]; ok && key != "" {
+        json.NewEncoder(w).Encode(s.intents[id])
+        return
+    }
+    id := "pi_" + randomHex(12)
+    intent := &stripeIntent{
+        ID:           id,
+        Amount:       amount,
+        Currency:     currency,
+        Status:       StatusRequiresPaymentMethod,
+        ClientSecret: id + "_secret_" + randomHex(12),
+        Metadata:     map[string]string{"order_id": r.PostFormValue("metadata[order_id]")},
+    }
+    s.intents[id] = intent
+    if key != "" {
+        s.idempotency[key] = id
+    }
+    json.NewEncoder(w).Encode(intent)
+}
+
+func (s *FakeServer) getIntent(w http.ResponseWriter, r *http.Request) {
+    if !s.authorized(r) {
+        fakeError(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API key provided")
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    intent, ok := s.intents[mux.Vars(r)["id"]]
+    if !ok {
+        fakeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such payment_intent")
+        return
+    }
+    json.NewEncoder(w).Encode(intent)
+}
+
+func (s *FakeServer) confirmIntent(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    intent, ok := s.intents[mux.Vars(r)["id"]]
+    if !ok {
+        fakeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such payment_intent")
+        return
+    }
+    secret := r.PostFormValue("client_secret")
+    if !s.authorized(r) && subtle.ConstantTimeCompare([]byte(secret), []byte(intent.ClientSecret)) != 1 {
+        fakeError(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API key or client secret provided")
+        return
+    }
+    if intent.Status != StatusRequiresPaymentMethod {
+        fakeError(w, http.StatusBadRequest, "invalid_request_error", "payment_intent_unexpected_state", "This PaymentIntent's status is "+intent.Status)
+        return
+    }
+
+    switch r.PostFormValue("payment_method") {
+    case FakeCardSucceeds:
+        intent.Status = StatusSucceeded
+        s.emit(EventPaymentSucceeded, intent)
+    case FakeCardDisputed:
+        intent.Status = StatusSucceeded
+        dispute := &stripeDispute{ID: "dp_" + randomHex(12), PaymentIntent: intent.ID, Amount: intent.Amount, Currency: intent.Currency, Status: "needs_response"}
+        s.disputes[dispute.ID] = dispute
+        s.emit(EventPaymentSucceeded, intent)
+        s.emit(EventDisputeCreated, dispute)
+    case FakeCardDeclined:
+        s.emit(EventPaymentFailed, intent)
+        fakeError(w, http.StatusPaymentRequired, "card_error", "card_declined", "Your card was declined.")
+        return
+    default:
+        fakeError(w, http.StatusBadRequest, "invalid_request_error", "resource_missing", "No such PaymentMethod")
+        return
+    }
+    json.NewEncoder(w).Encode(intent)
+}
+
+// cancelIntent cancels an intent that hasn't been paid.
+func (s *FakeServer) cancelIntent(w http.ResponseWriter, r *http.Request) {
+    if !s.authorized(r) {
+        fakeError(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API key provided")
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    intent, ok := s.intents[mux.Vars(r)["id"]]
+    if !ok {
+        fakeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such payment_intent")
+        return
+    }
+    if intent.Status != StatusRequiresPaymentMethod {
+        fakeError(w, http.StatusBadRequest, "invalid_request_error", "payment_intent_unexpected_state", "This PaymentIntent's status is "+intent.Status)
+        return
+    }
+    intent.Status = StatusCanceled
+    json.NewEncoder(w).Encode(intent)
+}
+
+// createRefund refunds part of a succeeded intent, up to what's left of it.
+func (s *FakeServer) createRefund(w http.ResponseWriter, r *http.Request) {
+    if !s.authorized(r) {
+        fakeError(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API key provided")
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    key := r.Header.Get("Idempotency-Key")
+    if id, ok := s.idempotency[key]; ok && key != "" {
+        json.NewEncoder(w).Encode(s.refunds[id])
+        return
+    }
+
+    intent, ok := s.intents[r.PostFormValue("payment_intent")]
+    if !ok {
+        fakeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such payment_intent")
+        return
+    }
+    if intent.Status != StatusSucceeded {
+        fakeError(w, http.StatusBadRequest, "invalid_request_error", "charge_not_refundable", "This PaymentIntent has not succeeded")
+        return
+    }
+    amount := intent.Amount - s.refunded[intent.ID]
+    if v := r.PostFormValue("amount"); v != "" {
+        var err error
+        if amount, err = strconv.ParseInt(v, 10, 64); err != nil || amount < 1 {
+            fakeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid_integer", "amount must be a positive integer")
+            return
+        }
+    }
+    if amount > intent.Amount-s.refunded[intent.ID] {
+        fakeError(w, http.StatusBadRequest, "invalid_request_error", "amount_too_large", "Refund amount is greater than unrefunded amount on charge")
+        return
+    }
+
+    refund := &stripeRefund{
+        ID:            "re_" + randomHex(12),
+        PaymentIntent: intent.ID,
+        Amount:        amount,
+        Currency:      intent.Currency,
+        Status:        RefundSucceeded,
+        Metadata:      map[string]string{"order_id": r.PostFormValue("metadata[order_id]"), "refund_id": r.PostFormValue("metadata[refund_id]")},
+    }
+    s.refunds[refund.ID] = refund
+    s.refunded[intent.ID] += amount
+    if key != "" {
+        s.idempotency[key] = refund.ID
+    }
+    json.NewEncoder(w).Encode(refund)
+}
+
+func (s *FakeServer) getRefund(w http.ResponseWriter, r *http.Request) {
+    if !s.authorized(r) {
+        fakeError(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API key provided")
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    refund, ok := s.refunds[mux.Vars(r)["id"]]
+    if !ok {
+        fakeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such refund")
+        return
+    }
+    json.NewEncoder(w).Encode(refund)
+}
+
+// updateDispute submits evidence, which settles the dispute at once: it's won with the
+// winning evidence and lost otherwise.
+func (s *FakeServer) updateDispute(w http.ResponseWriter, r *http.Request) {
+    status := DisputeLost
+    if r.PostFormValue("evidence[uncategorized_text]") == fakeWinningEvidence {
+        status = DisputeWon
+    }
+    s.settleDispute(w, r, status)
+}
+
+// closeDispute accepts the dispute, losing it.
+func (s *FakeServer) closeDispute(w http.ResponseWriter, r *http.Request) {
+    s.settleDispute(w, r, DisputeLost)
+}
+
+func (s *FakeServer) settleDispute(w http.ResponseWriter, r *http.Request, status string) {
+    if !s.authorized(r) {
+        fakeError(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API key provided")
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    dispute, ok := s.disputes[mux.Vars(r)["id"]]
+    if !ok {
+        fakeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such dispute")
+        return
+    }
+    if dispute.Status == DisputeWon || dispute.Status == DisputeLost {
+        fakeError(w, http.StatusBadRequest, "invalid_request_error", "dispute_already_closed", "This dispute is already closed")
+        return
+    }
+    dispute.Status = status
+    s.emit(EventDisputeClosed, dispute)
+    json.NewEncoder(w).Encode(dispute)
+}
+
+// emit delivers an event about object, if webhooks are set up. As with the real
+// provider, events may arrive out of order. It must be called with s.mu held.
+func (s *FakeServer) emit(eventType string, object interface{}) {
+    if s.webhooks == nil {
+        return
+    }
+    data, _ := json.Marshal(object)
+    event := stripeEvent{ID: "evt_" + randomHex(12), Type: eventType, Created: time.Now().Unix()}
+    event.Data.Object = data
+    payload, _ := json.Marshal(event)
+
+    hooks := s.webhooks
+    go func() {
+        for attempt := 0; attempt < 3; attempt++ {
+            time.Sleep(time.Duration(attempt) * time.Second)
+            req, err := http.NewRequest(http.MethodPost, hooks.url, bytes.NewReader(payload))
+            if err != nil {
+                return
+            }
+            req.Header.Set("Content-Type", "application/json")
+            req.Header.Set("Stripe-Signature", SignWebhook(payload, hooks.secret, time.Now()))
+            resp, err := hooks.client.Do(req)
+            if err == nil {
+                resp.Body.Close()
+                if resp.StatusCode < 300 {
+                    return
+                }
+            }
+        }
+        slog.Warn("Fake payment provider failed to deliver webhook", "event_id", event.ID, "type", event.Type)
+    }()
+}
+
+func (s *FakeServer) authorized(r *http.Request) bool {
+    token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.secretKey)) == 1
+}
+
+func fakeError(w http.ResponseWriter, status int, typ, code, message string) {
+    var e stripeError
+    e.Error.Type, e.Error.Code, e.Error.Message = typ, code, message
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(e)
+}
+
+func randomHex(n int) string {
+    b := make([]byte, n)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
@@ -0,0 +1,100 @@
+// Package payments takes payments for orders through a payment provider.
+package payments
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+)
+
+var (
+    ErrIntentNotFound = errors.New("payment intent not found")
+    // ErrRejected is returned when the provider refused a request, so it certainly didn't
+    // act on it. Other errors leave it unknown whether the request was carried out.
+    ErrRejected = errors.New("payment provider rejected the request")
+)
+
+// Payment intent statuses, as reported by the provider. A declined payment goes back to
+// StatusRequiresPaymentMethod, so the customer can try another card.
+const (
+    StatusRequiresPaymentMethod = "requires_payment_method"
+    StatusProcessing            = "processing"
+    StatusSucceeded             = "succeeded"
+    StatusCanceled              = "canceled"
+)
+
+// Intent is a payment the customer completes with the provider, typically in their
+// browser with ClientSecret. Amounts are in cents (minor currency units).
+type Intent struct {
+    ID           string `json:"id"`
+    OrderID      string `json:"order_id"`
+    AmountCents  int64  `json:"amount_cents"`
+    Currency     string `json:"currency"`
+    Status       string `json:"status"`
+    ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// Refund statuses. Pending refunds are accepted by the provider and complete later.
+const (
+    RefundSucceeded = "succeeded"
+    RefundPending   = "pending"
+    RefundFailed    = "failed"
+)
+
+// Refund gives back some or all of a payment intent's amount.
+type Refund struct {
+    ID          string `json:"id"`
+    IntentID    string `json:"intent_id"`
+    AmountCents int64  `json:"amount_cents"`
+    Currency    string `json:"currency"`
+    Status      string `json:"status"`
+}
+
+// RefundRequest asks for a refund of part of a payment. RefundID is our own ID for the
+// refund; repeating a request with the same one refunds only once.
+type RefundRequest struct {
+    RefundID    string
+    IntentID    string
+    OrderID     string
+    AmountCents int64
+}
+
+// IntentRequest asks for a payment of an order's total. Replaces is the order's previous
+// intent, when it was canceled or lost, so the new one isn't mistaken for a repeat of the
+// request that created it.
+type IntentRequest struct {
+    OrderID     string
+    AmountCents int64
+    Currency    string
+    Replaces    string
+}
+
+// PaymentProvider takes payments.
+type PaymentProvider interface {
+    // CreateIntent starts a payment. Repeating the request for the same order and
+    // previous intent returns the same intent rather than charging twice.
+    CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+    // GetIntent returns the intent's current state, or ErrIntentNotFound.
+    GetIntent(ctx context.Context, id string) (*Intent, error)
+    // Refund gives back part of a succeeded payment. Refunds of more than is left of the
+    // payment are rejected by the provider.
+    Refund(ctx context.Context, req RefundRequest) (*Refund, error)
+    // GetRefund returns the refund's current state, by the provider's ID.
+    GetRefund(ctx context.Context, id string) (*Refund, error)
+    // ParseWebhook verifies a webhook request's signature and returns its event, or
+    // ErrInvalidSignature.
+    ParseWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+// CheckPays returns an error unless the intent is for the order and exactly its total,
+// so a payment for one order can't be used to mark another one paid.
+func (i *Intent) CheckPays(orderID string, amountCents int64, currency string) error {
+    if i.OrderID != orderID {
+        return fmt.Errorf("payment intent %s is for order %q, not %q", i.ID, i.OrderID, orderID)
+    }
+    if i.AmountCents != amountCents || i.Currency != currency {
+        return fmt.Errorf("payment intent %s is for %d %s, not %d %s", i.ID, i.AmountCents, i.Currency, amountCents, currency)
+    }
+    return nil
+}
@@ -0,0 +1,222 @@
+package payments
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// stripeProvider calls a Stripe-style payment intents API, such as Stripe's own or
+// FakeServer.
+type stripeProvider struct {
+    baseURL       string
+    secretKey     string
+    webhookSecret string
+    http          *http.Client
+}
+
+// stripeIntent is a payment intent as returned by the API.
+type stripeIntent struct {
+    ID           string            `json:"id"`
+    Amount       int64             `json:"amount"`
+    Currency     string            `json:"currency"`
+    Status       string            `json:"status"`
+    ClientSecret string            `json:"client_secret"`
+    Metadata     map[string]string `json:"metadata"`
+}
+
+func (si *stripeIntent) intent() *Intent {
+    return &Intent{
+        ID:           si.ID,
+        OrderID:      si.Metadata["order_id"],
+        AmountCents:  si.Amount,
+        Currency:     si.Currency,
+        Status:       si.Status,
+        ClientSecret: si.ClientSecret,
+    }
+}
+
+// stripeRefund is a refund as returned by the API.
+type stripeRefund struct {
+    ID            string            `json:"id"`
+    PaymentIntent string            `json:"payment_intent"`
+    Amount        int64             `json:"amount"`
+    Currency      string            `json:"currency"`
+    Status        string            `json:"status"`
+    Metadata      map[string]string `json:"metadata"`
+}
+
+func (sr *stripeRefund) refund() *Refund {
+    return &Refund{ID: sr.ID, IntentID: sr.PaymentIntent, AmountCents: sr.Amount, Currency: sr.Currency, Status: sr.Status}
+}
+
+// stripeError is the body of an error response.
+type stripeError struct {
+    Error struct {
+        Type    string `json:"type"`
+        Code    string `json:"code"`
+        Message string `json:"message"`
+    } `json:"error"`
+}
+
+// stripeEvent is a webhook event. Its object is a payment intent or a dispute,
+// depending on the type.
+type stripeEvent struct {
+    ID      string `json:"id"`
+    Type    string `json:"type"`
+    Created int64  `json:"created"`
+    Data    struct {
+        Object json.RawMessage `json:"object"`
+    } `json:"data"`
+}
+
+// stripeDispute is a dispute, the object of charge.dispute.* events.
+type stripeDispute struct {
+    ID            string `json:"id"`
+    PaymentIntent string `json:"payment_intent"`
+    Amount        int64  `json:"amount"`
+    Currency      string `json:"currency"`
+    Status        string `json:"status"`
+}
+
+// NewStripe creates a PaymentProvider for the Stripe-style API at baseURL (for Stripe,
+// https://api.stripe.com), authenticating with the secret key. Webhooks are verified
+// with the webhook signing secret.
+func NewStripe(baseURL, secretKey, webhookSecret string, httpClient *http.Client) PaymentProvider {
+    return &stripeProvider{baseURL: strings.TrimRight(baseURL, "/"), secretKey: secretKey, webhookSecret: webhookSecret, http: httpClient}
+}
+
+func (p *stripeProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+    form := url.Values{}
+    form.Set("amount", strconv.FormatInt(req.AmountCents, 10))
+    form.Set("currency", req.Currency)
+    form.Set("metadata[order_id]", req.OrderID)
+    form.Set("automatic_payment_methods[enabled]", "true")
+    // The provider replays the first response for a repeated key, so each attempt at
+    // paying the order gets its own
+    key := "order-" + req.OrderID
+    if req.Replaces != "" {
+        key += "-after-" + req.Replaces
+    }
+    var si stripeIntent
+    if err := p.do(ctx, http.MethodPost, "/v1/payment_intents", form, key, &si); err != nil {
+        return nil, err
+    }
+    return si.intent(), nil
+}
+
+func (p *stripeProvider) GetIntent(ctx context.Context, id string) (*Intent, error) {
+    var si stripeIntent
+    if err := p.do(ctx, http.MethodGet, "/v1/payment_intents/"+url.PathEscape(id), nil, "", &si); err != nil {
+        return nil, err
+    }
+    return si.intent(), nil
+}
+
+func (p *stripeProvider) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+    form := url.Values{}
+    form.Set("payment_intent", req.IntentID)
+    form.Set("amount", strconv.FormatInt(req.AmountCents, 10))
+    form.Set("metadata[order_id]", req.OrderID)
+    form.Set("metadata[refund_id]", req.RefundID)
+
+    var sr stripeRefund
+    if err := p.do(ctx, http.MethodPost, "/v1/refunds", form, "refund-"+req.RefundID, &sr); err != nil {
+        return nil, err
+    }
+    return sr.refund(), nil
+}
+
+func (p *stripeProvider) GetRefund(ctx context.Context, id string) (*Refund, error) {
+    var sr stripeRefund
+    err := p.do(ctx, http.MethodGet, "/v1/refunds/"+url.PathEscape(id), nil, "", &sr)
+    if errors.Is(err, ErrIntentNotFound) {
+        return nil, fmt.Errorf("refund %s not found", id)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return sr.refund(), nil
+}
+
+// do calls the API and decodes the response into out.
+func (p *stripeProvider) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out interface{}) error {
+    var body io.Reader
+    if form != nil {
+        body = strings.NewReader(form.Encode())
+    }
+    req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, body)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Bearer "+p.secretKey)
+    if form != nil {
+        req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    }
+    if idempotencyKey != "" {
+        req.Header.Set("Idempotency-Key", idempotencyKey)
+    }
+
+    resp, err := p.http.Do(req)
+    if err != nil {
+        return fmt.Errorf("failed to reach payment provider: %w", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        var e stripeError
+        json.NewDecoder(resp.Body).Decode(&e)
+        if resp.StatusCode == http.StatusNotFound && e.Error.Code == "resource_missing" {
+            return ErrIntentNotFound
+        }
+        // Client errors mean the request wasn't carried out, except for conflicting
+        // requests with the same idempotency key and rate limiting, which can be retried
+        if resp.StatusCode < 500 && resp.StatusCode != http.StatusConflict && resp.StatusCode != http.StatusTooManyRequests {
+            return fmt.Errorf("%w with %s: %s %s", ErrRejected, resp.Status, e.Error.Code, e.Error.Message)
+        }
+        return fmt.Errorf("payment provider returned %s: %s %s", resp.Status, e.Error.Code, e.Error.Message)
+    }
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+        return fmt.Errorf("invalid response from payment provider: %w", err)
+    }
+    return nil
+}
+
+func (p *stripeProvider) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+    if p.webhookSecret == "" {
+        return nil, ErrInvalidSignature
+    }
+    if err := verifyWebhookSignature(payload, header.Get("Stripe-Signature"), p.webhookSecret, time.Now()); err != nil {
+        return nil, err
+    }
+
+    var se stripeEvent
+    if err := json.Unmarshal(payload, &se); err != nil || se.ID == "" || se.Type == "" {
+        return nil, fmt.Errorf("invalid webhook event: %v", err)
+    }
+    event := &Event{ID: se.ID, Type: se.Type, CreatedAt: time.Unix(se.Created, 0).UTC(), Payload: payload}
+    switch {
+    case strings.HasPrefix(se.Type, "payment_intent."):
+        var si stripeIntent
+        if err := json.Unmarshal(se.Data.Object, &si); err != nil {
+            return nil, fmt.Errorf("invalid payment intent in webhook event %s: %w", se.ID, err)
+        }
+        event.IntentID, event.OrderID = si.ID, si.Metadata["order_id"]
+        event.AmountCents, event.Currency = si.Amount, si.Currency
+    case strings.HasPrefix(se.Type, "charge.dispute."):
+        var sd stripeDispute
+        if err := json.Unmarshal(se.Data.Object, &sd); err != nil {
+            return nil, fmt.Errorf("invalid dispute in webhook event %s: %w", se.ID, err)
+        }
+        event.IntentID, event.DisputeStatus = sd.PaymentIntent, sd.Status
+        event.AmountCents, event.Currency = sd.Amount, sd.Currency
+    }
+    return event, nil
+}
@@ -0,0 +1,212 @@
+package payments
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+const (
+    testKey           = "sk_test_key"
+    testWebhookSecret = "whsec_test"
+)
+
+// newFakeProvider starts a FakeServer and returns a provider calling it.
+func newFakeProvider(t *testing.T) (PaymentProvider, *httptest.Server) {
+    t.Helper()
+    srv := httptest.NewServer(NewFakeServer(testKey))
+    t.Cleanup(srv.Close)
+    return NewStripe(srv.URL, testKey, testWebhookSecret, srv.Client()), srv
+}
+
+// pay confirms the intent with a test payment method, as the customer's browser would.
+func pay(t *testing.T, srv *httptest.Server, intent *Intent, method string) int {
+    t.Helper()
+    form := url.Values{"payment_method": {method}, "client_secret": {intent.ClientSecret}}
+    resp, err := srv.Client().PostForm(srv.URL+"/v1/payment_intents/"+intent.ID+"/confirm", form)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    return resp.StatusCode
+}
+
+func TestCreateIntent(t *testing.T) {
+    provider, srv := newFakeProvider(t)
+    ctx := context.Background()
+    req := IntentRequest{OrderID: "order-1", AmountCents: 1000, Currency: "usd"}
+
+    intent, err := provider.CreateIntent(ctx, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := intent.CheckPays("order-1", 1000, "usd"); err != nil {
+        t.Error(err)
+    }
+    if intent.Status != StatusRequiresPaymentMethod || intent.ClientSecret == "" {
+        t.Errorf("intent = %+v, want one awaiting payment with a client secret", intent)
+    }
+    if err := intent.CheckPays("order-2", 1000, "usd"); err == nil {
+        t.Error("intent pays another order")
+    }
+
+    again, err := provider.CreateIntent(ctx, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if again.ID != intent.ID {
+        t.Errorf("repeated request created intent %s, want %s", again.ID, intent.ID)
+    }
+
+    // Once canceled, the intent is replaced by a new one rather than replayed
+    cancel, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/payment_intents/"+intent.ID+"/cancel", nil)
+    cancel.Header.Set("Authorization", "Bearer "+testKey)
+    resp, err := srv.Client().Do(cancel)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    req.Replaces = intent.ID
+    replacement, err := provider.CreateIntent(ctx, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if replacement.ID == intent.ID || replacement.Status != StatusRequiresPaymentMethod {
+        t.Errorf("replacement = %+v, want a new intent awaiting payment", replacement)
+    }
+
+    if code := pay(t, srv, replacement, FakeCardSucceeds); code != http.StatusOK {
+        t.Fatalf("paying returned %d", code)
+    }
+    paid, err := provider.GetIntent(ctx, replacement.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if paid.Status != StatusSucceeded {
+        t.Errorf("status after paying = %s", paid.Status)
+    }
+    if _, err := provider.GetIntent(ctx, "pi_missing"); !errors.Is(err, ErrIntentNotFound) {
+        t.Errorf("missing intent: err = %v, want ErrIntentNotFound", err)
+    }
+}
+
+func TestWrongKeyIsRejected(t *testing.T) {
+    _, srv := newFakeProvider(t)
+    provider := NewStripe(srv.URL, "sk_test_wrong", testWebhookSecret, srv.Client())
+    _, err := provider.CreateIntent(context.Background(), IntentRequest{OrderID: "order-1", AmountCents: 1000, Currency: "usd"})
+    if !errors.Is(err, ErrRejected) {
+        t.Errorf("err = %v, want ErrRejected", err)
+    }
+}
+
+func TestRefund(t *testing.T) {
+    provider, srv := newFakeProvider(t)
+    ctx := context.Background()
+    intent, err := provider.CreateIntent(ctx, IntentRequest{OrderID: "order-1", AmountCents: 1000, Currency: "usd"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    req := RefundRequest{RefundID: "refund-1", IntentID: intent.ID, OrderID: "order-1", AmountCents: 600}
+    if _, err := provider.Refund(ctx, req); !errors.Is(err, ErrRejected) {
+        t.Errorf("refunding an unpaid intent: err = %v, want ErrRejected", err)
+    }
+    pay(t, srv, intent, FakeCardSucceeds)
+
+    refund, err := provider.Refund(ctx, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if refund.Status != RefundSucceeded || refund.AmountCents != 600 {
+        t.Errorf("refund = %+v, want 600 cents succeeded", refund)
+    }
+    // Retrying with the same refund ID refunds only once
+    again, err := provider.Refund(ctx, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if again.ID != refund.ID {
+        t.Errorf("retried refund is %s, want %s", again.ID, refund.ID)
+    }
+    if got, err := provider.GetRefund(ctx, refund.ID); err != nil || got.Status != RefundSucceeded {
+        t.Errorf("GetRefund = %+v, %v", got, err)
+    }
+
+    req.RefundID = "refund-2"
+    if _, err := provider.Refund(ctx, req); !errors.Is(err, ErrRejected) {
+        t.Errorf("refunding more than is left: err = %v, want ErrRejected", err)
+    }
+}
+
+func TestParseWebhook(t *testing.T) {
+    provider, _ := newFakeProvider(t)
+    payload := []byte(`{"id":"evt_1","type":"payment_intent.succeeded","created":1700000000,"data":{"object":{"id":"pi_1","amount":1000,"currency":"usd","metadata":{"order_id":"order-1"}}}}`)
+    header := func(signature string) http.Header {
+        return http.Header{"Stripe-Signature": {signature}}
+    }
+
+    event, err := provider.ParseWebhook(payload, header(SignWebhook(payload, testWebhookSecret, time.Now())))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if event.ID != "evt_1" || event.Type != EventPaymentSucceeded || event.IntentID != "pi_1" || event.OrderID != "order-1" || event.AmountCents != 1000 {
+        t.Errorf("event = %+v", event)
+    }
+
+    tests := []struct {
+        name      string
+        signature string
+    }{
+        {"no signature", ""},
+        {"wrong secret", SignWebhook(payload, "whsec_other", time.Now())},
+        {"too old", SignWebhook(payload, testWebhookSecret, time.Now().Add(-10*time.Minute))},
+        {"other payload", SignWebhook([]byte(`{}`), testWebhookSecret, time.Now())},
+    }
+    for _, tt := range tests {
+        if _, err := provider.ParseWebhook(payload, header(tt.signature)); !errors.Is(err, ErrInvalidSignature) {
+            t.Errorf("%s: err = %v, want ErrInvalidSignature", tt.name, err)
+        }
+    }
+}
+
+func TestFakeSendsWebhooks(t *testing.T) {
+    received := make(chan *Event, 10)
+    provider, srv := newFakeProvider(t)
+    hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        payload, _ := io.ReadAll(r.Body)
+        event, err := provider.ParseWebhook(payload, r.Header)
+        if err != nil {
+            w.WriteHeader(http.StatusBadRequest)
+            return
+        }
+        received <- event
+    }))
+    defer hooks.Close()
+    srv.Config.Handler.(*FakeServer).SendWebhooks(hooks.URL, testWebhookSecret, hooks.Client())
+
+    intent, err := provider.CreateIntent(context.Background(), IntentRequest{OrderID: "order-1", AmountCents: 1000, Currency: "usd"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    pay(t, srv, intent, FakeCardDisputed)
+
+    var types []string
+    for len(types) < 2 {
+        select {
+        case event := <-received:
+            types = append(types, event.Type)
+        case <-time.After(5 * time.Second):
+            t.Fatalf("received %v, want a payment and a dispute", types)
+        }
+    }
+    got := strings.Join(types, " ")
+    if !strings.Contains(got, EventPaymentSucceeded) || !strings.Contains(got, EventDisputeCreated) {
+        t.Errorf("received %v, want a payment and a dispute", types)
+    }
+}
@@ -0,0 +1,246 @@
+// Package reconcile applies the payment provider's webhook events to orders. Providers
+// deliver events late, repeatedly and out of order, so every event is stored first and
+// applied once its order is in a state it applies to. Refunds are likewise retried until
+// the provider reports their outcome.
+package reconcile
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "orderservice/models"
+    "orderservice/payments"
+    "orderservice/repository"
+    "time"
+)
+
+const (
+    // actor is recorded in the history for status changes made by payment events.
+    actor = "payments"
+    // maxWait is how long an event may wait to be applied before it's given up on.
+    maxWait = 72 * time.Hour
+    // batchSize bounds the events each reconciliation run looks at.
+    batchSize = 100
+)
+
+// outcome is the result of trying to apply an event. Events that are not done wait for
+// the next attempt.
+type outcome struct {
+    result string
+    done   bool
+}
+
+func applied(format string, args ...interface{}) outcome {
+    return outcome{result: "applied: " + fmt.Sprintf(format, args...), done: true}
+}
+
+func ignored(format string, args ...interface{}) outcome {
+    return outcome{result: "ignored: " + fmt.Sprintf(format, args...), done: true}
+}
+
+func waiting(format string, args ...interface{}) outcome {
+    return outcome{result: "waiting: " + fmt.Sprintf(format, args...)}
+}
+
+// Processor stores and applies payment events.
+type Processor struct {
+    orders   repository.OrderRepository
+    events   repository.PaymentEventRepository
+    currency string
+}
+
+// New creates a Processor for orders priced in currency.
+func New(orders repository.OrderRepository, events repository.PaymentEventRepository, currency string) *Processor {
+    return &Processor{orders: orders, events: events, currency: currency}
+}
+
+// Handle stores a newly received event and applies it, along with its payment intent's
+// events that were waiting. It reports false for events already received. Once the
+// event is stored, failing to apply it isn't an error: Run retries it.
+func (p *Processor) Handle(ctx context.Context, e *payments.Event) (bool, error) {
+    event := &models.PaymentEvent{
+        ID:            e.ID,
+        Type:          e.Type,
+        IntentID:      e.IntentID,
+        OrderID:       e.OrderID,
+        AmountCents:   e.AmountCents,
+        Currency:      e.Currency,
+        DisputeStatus: e.DisputeStatus,
+        Payload:       e.Payload,
+        CreatedAt:     e.CreatedAt,
+        Result:        "received",
+    }
+    isNew, err := p.events.SaveEvent(ctx, event)
+    if err != nil {
+        return false, fmt.Errorf("failed to store payment event: %w", err)
+    }
+    if !isNew {
+        return false, nil
+    }
+    if err := p.reconcileIntent(ctx, e.IntentID); err != nil {
+        slog.ErrorContext(ctx, "Error applying payment event, will retry", "event_id", e.ID, "error", err)
+    }
+    return true, nil
+}
+
+// Run applies waiting events every interval until ctx is done.
+func (p *Processor) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := p.Reconcile(ctx); err != nil {
+                slog.ErrorContext(ctx, "Error reconciling payment events", "error", err)
+            }
+        }
+    }
+}
+
+// Reconcile tries once more to apply waiting events.
+func (p *Processor) Reconcile(ctx context.Context) error {
+    events, err := p.events.ListUnprocessed(ctx, "", batchSize)
+    if err != nil {
+        return fmt.Errorf("failed to list waiting payment events: %w", err)
+    }
+    seen := make(map[string]bool)
+    for _, e := range events {
+        if seen[e.IntentID] {
+            continue
+        }
+        seen[e.IntentID] = true
+        if err := p.reconcileIntent(ctx, e.IntentID); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// reconcileIntent applies the payment intent's waiting events in the order the provider
+// created them. Applying one can unblock an earlier one that arrived out of order, so it
+// goes over them again while that happens.
+func (p *Processor) reconcileIntent(ctx context.Context, intentID string) error {
+    for pass := 0; pass < 3; pass++ {
+        events, err := p.events.ListUnprocessed(ctx, intentID, batchSize)
+        if err != nil {
+            return fmt.Errorf("failed to list waiting payment events: %w", err)
+        }
+        progressed := false
+        for i := range events {
+            e := &events[i]
+            out, err := p.apply(ctx, e)
+            if err != nil {
+                return fmt.Errorf("failed to apply payment event %s: %w", e.ID, err)
+            }
+            if !out.done && time.Since(e.ReceivedAt) > maxWait {
+                slog.ErrorContext(ctx, "Gave up on payment event", "event_id", e.ID, "type", e.Type, "intent_id", e.IntentID, "reason", out.result)
+                out = outcome{result: "gave up: " + out.result, done: true}
+            }
+            if err := p.events.SetResult(ctx, e.ID, out.result, out.done); err != nil {
+                return fmt.Errorf("failed to record payment event result: %w", err)
+            }
+            progressed = progressed || out.done
+        }
+        if !progressed {
+            return nil
+        }
+    }
+    return nil
+}
+
+// apply moves the event's order through its lifecycle as the event calls for.
+func (p *Processor) apply(ctx context.Context, e *models.PaymentEvent) (outcome, error) {
+    switch e.Type {
+    case payments.EventPaymentSucceeded, payments.EventPaymentFailed, payments.EventDisputeCreated, payments.EventDisputeClosed:
+    default:
+        return ignored("unhandled event type"), nil
+    }
+
+    order, err := p.orders.GetOrderByPaymentIntent(ctx, e.IntentID)
+    if errors.Is(err, repository.ErrOrderNotFound) {
+        // The event can beat the order's update when the payment was just started
+        return waiting("no order has payment intent %q", e.IntentID), nil
+    }
+    if err != nil {
+        return outcome{}, err
+    }
+
+    switch e.Type {
+    case payments.EventPaymentSucceeded:
+        intent := payments.Intent{ID: e.IntentID, OrderID: e.OrderID, AmountCents: e.AmountCents, Currency: e.Currency}
+        if err := intent.CheckPays(order.ID, order.TotalCents, p.currency); err != nil {
+            slog.ErrorContext(ctx, "Payment doesn't match order", "event_id", e.ID, "order_id", order.ID, "error", err)
+            return ignored("%v", err), nil
+        }
+        if order.Status != models.StatusAwaitingPayment {
+            return ignored("order %s is already %s", order.ID, order.Status), nil
+        }
+        return p.transition(ctx, order, models.StatusPaid)
+
+    case payments.EventPaymentFailed:
+        // The customer can try again with the same intent, so the order keeps waiting
+        slog.InfoContext(ctx, "Payment failed", "order_id", order.ID, "intent_id", e.IntentID)
+        return ignored("payment failed, order %s is %s", order.ID, order.Status), nil
+
+    case payments.EventDisputeCreated:
+        switch order.Status {
+        case models.StatusDisputed:
+            return ignored("order %s is already disputed", order.ID), nil
+        case models.StatusAwaitingPayment:
+            return waiting("order %s isn't paid yet", order.ID), nil
+        }
+        if !models.CanTransition(order.Status, models.StatusDisputed) {
+            return ignored("order %s is %s", order.ID, order.Status), nil
+        }
+        slog.WarnContext(ctx, "Payment disputed", "order_id", order.ID, "intent_id", e.IntentID)
+        return p.transition(ctx, order, models.StatusDisputed)
+
+    case payments.EventDisputeClosed:
+        if order.Status != models.StatusDisputed {
+            if order.Status == models.StatusAwaitingPayment || models.CanTransition(order.Status, models.StatusDisputed) {
+                return waiting("order %s isn't disputed yet", order.ID), nil
+            }
+            return ignored("order %s is %s", order.ID, order.Status), nil
+        }
+        if e.DisputeStatus == payments.DisputeLost {
+            // The charge is taken back, so everything counts as refunded
+            return p.transition(ctx, order, models.StatusRefunded)
+        }
+        previous, err := p.statusBeforeDispute(ctx, order.ID)
+        if err != nil {
+            return outcome{}, err
+        }
+        return p.transition(ctx, order, previous)
+    }
+    return ignored("unhandled event type"), nil
+}
+
+// transition moves the order to status. If the order changed in the meantime, the
+// event waits to be looked at again.
+func (p *Processor) transition(ctx context.Context, order *models.Order, status string) (outcome, error) {
+    _, err := p.orders.UpdateStatus(ctx, order.ID, status, actor)
+    if errors.Is(err, repository.ErrInvalidTransition) {
+        return waiting("%v", err), nil
+    }
+    if err != nil {
+        return outcome{}, err
+    }
+    return applied("order %s is %s", order.ID, status), nil
+}
+
+// statusBeforeDispute returns the status the order had when it was disputed.
+func (p *Processor) statusBeforeDispute(ctx context.Context, orderID string) (string, error) {
+    history, err := p.orders.ListStatusHistory(ctx, orderID)
+    if err != nil {
+        return "", err
+    }
+    for i := len(history) - 1; i >= 0; i-- {
+        if history[i].To == models.StatusDisputed {
+            return history[i].From, nil
+        }
+    }
+    return models.StatusPaid, nil
+}
@@ -0,0 +1,242 @@
+package reconcile
+
+import (
+    "context"
+    "errors"
+    "orderservice/models"
+    "orderservice/payments"
+    "orderservice/repository"
+    "testing"
+    "time"
+)
+
+// awaitingPayment stores an order of 1000 cents waiting for payment intent pi_1.
+func awaitingPayment(t *testing.T, orders repository.OrderRepository) *models.Order {
+    t.Helper()
+    ctx := context.Background()
+    order := &models.Order{ID: models.NewOrderID(), UserID: "7", Status: models.StatusPending, Items: []models.LineItem{{ProductID: "a", Quantity: 1, UnitPriceCents: 1000}}}
+    order.CalculateTotals()
+    if err := orders.CreateOrder(ctx, order); err != nil {
+        t.Fatal(err)
+    }
+    order, err := orders.StartPayment(ctx, order.ID, "pi_1", "7")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return order
+}
+
+func event(id, typ string, order *models.Order, created time.Time) *payments.Event {
+    return &payments.Event{ID: id, Type: typ, CreatedAt: created, IntentID: order.PaymentIntentID, OrderID: order.ID, AmountCents: order.TotalCents, Currency: "usd"}
+}
+
+func status(t *testing.T, orders repository.OrderRepository, id string) string {
+    t.Helper()
+    order, err := orders.GetOrder(context.Background(), id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return order.Status
+}
+
+func TestHandle(t *testing.T) {
+    orders := repository.NewMemoryOrderRepository()
+    p := New(orders, repository.NewMemoryPaymentEventRepository(), "usd")
+    ctx := context.Background()
+    order := awaitingPayment(t, orders)
+
+    isNew, err := p.Handle(ctx, event("evt_1", payments.EventPaymentSucceeded, order, time.Now()))
+    if err != nil || !isNew {
+        t.Fatalf("Handle = %v, %v; want a new event", isNew, err)
+    }
+    if got := status(t, orders, order.ID); got != models.StatusPaid {
+        t.Errorf("status = %s, want paid", got)
+    }
+    if isNew, err := p.Handle(ctx, event("evt_1", payments.EventPaymentSucceeded, order, time.Now())); err != nil || isNew {
+        t.Errorf("redelivery = %v, %v; want a duplicate", isNew, err)
+    }
+}
+
+func TestHandleIgnoresPaymentsForOtherAmounts(t *testing.T) {
+    orders := repository.NewMemoryOrderRepository()
+    p := New(orders, repository.NewMemoryPaymentEventRepository(), "usd")
+    order := awaitingPayment(t, orders)
+
+    e := event("evt_1", payments.EventPaymentSucceeded, order, time.Now())
+    e.AmountCents = 1
+    if _, err := p.Handle(context.Background(), e); err != nil {
+        t.Fatal(err)
+    }
+    if got := status(t, orders, order.ID); got != models.StatusAwaitingPayment {
+        t.Errorf("status = %s, want awaiting payment", got)
+    }
+}
+
+func TestHandleOutOfOrder(t *testing.T) {
+    orders := repository.NewMemoryOrderRepository()
+    p := New(orders, repository.NewMemoryPaymentEventRepository(), "usd")
+    ctx := context.Background()
+    order := awaitingPayment(t, orders)
+    created := time.Now()
+
+    // The dispute closes and opens before the payment is reported
+    lost := event("evt_3", payments.EventDisputeClosed, order, created.Add(2*time.Second))
+    lost.DisputeStatus = payments.DisputeLost
+    for _, e := range []*payments.Event{lost, event("evt_2", payments.EventDisputeCreated, order, created.Add(time.Second))} {
+        if _, err := p.Handle(ctx, e); err != nil {
+            t.Fatal(err)
+        }
+        if got := status(t, orders, order.ID); got != models.StatusAwaitingPayment {
+            t.Fatalf("status after %s = %s, want awaiting payment", e.Type, got)
+        }
+    }
+    if _, err := p.Handle(ctx, event("evt_1", payments.EventPaymentSucceeded, order, created)); err != nil {
+        t.Fatal(err)
+    }
+    updated, err := orders.GetOrder(ctx, order.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if updated.Status != models.StatusRefunded || updated.RefundedCents != updated.TotalCents {
+        t.Errorf("order = %s with %d cents refunded, want refunded in full", updated.Status, updated.RefundedCents)
+    }
+}
+
+func TestWonDisputeRestoresStatus(t *testing.T) {
+    orders := repository.NewMemoryOrderRepository()
+    p := New(orders, repository.NewMemoryPaymentEventRepository(), "usd")
+    ctx := context.Background()
+    order := awaitingPayment(t, orders)
+    created := time.Now()
+
+    p.Handle(ctx, event("evt_1", payments.EventPaymentSucceeded, order, created))
+    if _, err := orders.UpdateStatus(ctx, order.ID, models.StatusShipped, "1"); err != nil {
+        t.Fatal(err)
+    }
+    p.Handle(ctx, event("evt_2", payments.EventDisputeCreated, order, created.Add(time.Second)))
+    if got := status(t, orders, order.ID); got != models.StatusDisputed {
+        t.Fatalf("status = %s, want disputed", got)
+    }
+    won := event("evt_3", payments.EventDisputeClosed, order, created.Add(2*time.Second))
+    won.DisputeStatus = payments.DisputeWon
+    p.Handle(ctx, won)
+    if got := status(t, orders, order.ID); got != models.StatusShipped {
+        t.Errorf("status = %s, want shipped again", got)
+    }
+}
+
+// flakyProvider fails refunds with err until it's cleared, then passes them to the
+// wrapped provider.
+type flakyProvider struct {
+    payments.PaymentProvider
+    err    error
+    status string // Status of the refunds it makes
+    calls  int
+}
+
+func (f *flakyProvider) Refund(ctx context.Context, req payments.RefundRequest) (*payments.Refund, error) {
+    f.calls++
+    if f.err != nil {
+        return nil, f.err
+    }
+    return &payments.Refund{ID: "re_" + req.RefundID, IntentID: req.IntentID, AmountCents: req.AmountCents, Status: f.status}, nil
+}
+
+func (f *flakyProvider) GetRefund(ctx context.Context, id string) (*payments.Refund, error) {
+    return &payments.Refund{ID: id, Status: f.status}, nil
+}
+
+// pendingRefund pays the order and reserves a refund of all of it.
+func pendingRefund(t *testing.T, orders repository.OrderRepository) *models.Refund {
+    t.Helper()
+    ctx := context.Background()
+    order := awaitingPayment(t, orders)
+    if _, err := orders.UpdateStatus(ctx, order.ID, models.StatusPaid, actor); err != nil {
+        t.Fatal(err)
+    }
+    refund := &models.Refund{ID: models.NewRefundID(), OrderID: order.ID}
+    if err := orders.CreateRefund(ctx, refund); err != nil {
+        t.Fatal(err)
+    }
+    return refund
+}
+
+func TestRefundRetriedWhenOutcomeUnknown(t *testing.T) {
+    orders := repository.NewMemoryOrderRepository()
+    provider := &flakyProvider{err: errors.New("connection reset"), status: payments.RefundSucceeded}
+    rf := NewRefunds(orders, provider)
+    ctx := context.Background()
+    refund := pendingRefund(t, orders)
+
+    order, err := rf.Send(ctx, refund, "pi_1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if refund.Status != models.RefundPending || order.Status != models.StatusPaid {
+        t.Fatalf("refund %s, order %s; want the refund pending", refund.Status, order.Status)
+    }
+
+    // Too recent to be retried yet
+    if err := rf.RetryPending(ctx); err != nil {
+        t.Fatal(err)
+    }
+    if provider.calls != 1 {
+        t.Fatalf("provider called %d times, want 1", provider.calls)
+    }
+
+    provider.err = nil
+    if err := rf.retryPending(ctx, time.Now().Add(time.Second)); err != nil {
+        t.Fatal(err)
+    }
+    updated, err := orders.GetOrder(ctx, refund.OrderID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if updated.Status != models.StatusRefunded || updated.RefundedCents != updated.TotalCents {
+        t.Errorf("order = %s with %d cents refunded, want refunded in full", updated.Status, updated.RefundedCents)
+    }
+}
+
+func TestRejectedRefundFreesItsAmount(t *testing.T) {
+    orders := repository.NewMemoryOrderRepository()
+    rf := NewRefunds(orders, &flakyProvider{err: payments.ErrRejected})
+    ctx := context.Background()
+    refund := pendingRefund(t, orders)
+
+    if _, err := rf.Send(ctx, refund, "pi_1"); err != nil {
+        t.Fatal(err)
+    }
+    if refund.Status != models.RefundFailed {
+        t.Errorf("refund is %s, want failed", refund.Status)
+    }
+    again := &models.Refund{ID: models.NewRefundID(), OrderID: refund.OrderID}
+    if err := orders.CreateRefund(ctx, again); err != nil {
+        t.Errorf("refunding again after the failure: %v", err)
+    }
+}
+
+func TestPendingRefundLookedUpLater(t *testing.T) {
+    orders := repository.NewMemoryOrderRepository()
+    provider := &flakyProvider{status: payments.RefundPending}
+    rf := NewRefunds(orders, provider)
+    ctx := context.Background()
+    refund := pendingRefund(t, orders)
+
+    if _, err := rf.Send(ctx, refund, "pi_1"); err != nil {
+        t.Fatal(err)
+    }
+    if refund.Status != models.RefundPending || refund.ProviderRefundID == "" {
+        t.Fatalf("refund = %+v, want it pending with the provider's ID", refund)
+    }
+
+    provider.status = payments.RefundSucceeded
+    if err := rf.retryPending(ctx, time.Now().Add(time.Second)); err != nil {
+        t.Fatal(err)
+    }
+    if provider.calls != 1 {
+        t.Errorf("refund sent %d times, want once", provider.calls)
+    }
+    if got := status(t, orders, refund.OrderID); got != models.StatusRefunded {
+        t.Errorf("status = %s, want refunded", got)
+    }
+}
@@ -0,0 +1,146 @@
+package reconcile
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "orderservice/models"
+    "orderservice/payments"
+    "orderservice/repository"
+    "time"
+)
+
+const (
+    // retryAfter is how old a pending refund must be before it's retried, so retries
+    // don't race the request that created it.
+    retryAfter = time.Minute
+    // idempotencyWindow is how long the provider remembers idempotency keys. Refunds that
+    // never got a provider ID can't be safely sent again after that.
+    idempotencyWindow = 24 * time.Hour
+)
+
+// Refunds sends refunds to the payment provider and records their outcome. A refund
+// stays pending, with its amount reserved, until the provider reports it succeeded or
+// failed. When the provider can't be reached or hasn't finished, the refund is retried
+// with the same refund ID, and so the same idempotency key, so it's only made once.
+type Refunds struct {
+    orders   repository.OrderRepository
+    provider payments.PaymentProvider
+}
+
+// NewRefunds creates a Refunds sending refunds through provider.
+func NewRefunds(orders repository.OrderRepository, provider payments.PaymentProvider) *Refunds {
+    return &Refunds{orders: orders, provider: provider}
+}
+
+// Send makes a pending refund of the payment intent with the provider and records the
+// outcome in refund, returning the order as it is now. The refund is still pending if
+// the outcome isn't known yet.
+func (rf *Refunds) Send(ctx context.Context, refund *models.Refund, intentID string) (*models.Order, error) {
+    result, err := rf.provider.Refund(ctx, payments.RefundRequest{
+        RefundID:    refund.ID,
+        IntentID:    intentID,
+        OrderID:     refund.OrderID,
+        AmountCents: refund.AmountCents,
+    })
+    return rf.record(ctx, refund, result, err)
+}
+
+// Run retries pending refunds every interval until ctx is done.
+func (rf *Refunds) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := rf.RetryPending(ctx); err != nil {
+                slog.ErrorContext(ctx, "Error retrying pending refunds", "error", err)
+            }
+        }
+    }
+}
+
+// RetryPending looks up the outcome of refunds the provider accepted, and sends again
+// the ones it may never have received.
+func (rf *Refunds) RetryPending(ctx context.Context) error {
+    return rf.retryPending(ctx, time.Now().Add(-retryAfter))
+}
+
+// retryPending retries the pending refunds created before createdBefore.
+func (rf *Refunds) retryPending(ctx context.Context, createdBefore time.Time) error {
+    refunds, err := rf.orders.ListPendingRefunds(ctx, createdBefore, batchSize)
+    if err != nil {
+        return fmt.Errorf("failed to list pending refunds: %w", err)
+    }
+    for i := range refunds {
+        refund := &refunds[i]
+        if refund.ProviderRefundID != "" {
+            result, err := rf.provider.GetRefund(ctx, refund.ProviderRefundID)
+            if err != nil {
+                slog.ErrorContext(ctx, "Error getting refund from payment provider", "refund_id", refund.ID, "provider_refund_id", refund.ProviderRefundID, "error", err)
+                continue
+            }
+            if _, err := rf.record(ctx, refund, result, nil); err != nil {
+                return err
+            }
+            continue
+        }
+
+        if time.Since(refund.CreatedAt) > idempotencyWindow {
+            // Sending it again could refund twice; someone has to check with the provider
+            slog.ErrorContext(ctx, "Refund outcome unknown past the idempotency window", "refund_id", refund.ID, "order_id", refund.OrderID)
+            continue
+        }
+        order, err := rf.orders.GetOrder(ctx, refund.OrderID)
+        if err != nil {
+            return fmt.Errorf("failed to get order %s of refund %s: %w", refund.OrderID, refund.ID, err)
+        }
+        if _, err := rf.Send(ctx, refund, order.PaymentIntentID); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// record stores the provider's response to a refund. Only a refund the provider reports
+// as succeeded counts; one it rejected or that failed frees its amount, and anything
+// else leaves it pending.
+func (rf *Refunds) record(ctx context.Context, refund *models.Refund, result *payments.Refund, err error) (*models.Order, error) {
+    switch {
+    case err == nil && result.Status == payments.RefundSucceeded:
+        order, err := rf.orders.CompleteRefund(ctx, refund.ID, result.ID, true)
+        if err != nil {
+            return nil, fmt.Errorf("failed to record refund %s made with provider as %s: %w", refund.ID, result.ID, err)
+        }
+        refund.Status, refund.ProviderRefundID = models.RefundSucceeded, result.ID
+        return order, nil
+
+    case errors.Is(err, payments.ErrRejected) || errors.Is(err, payments.ErrIntentNotFound) || (err == nil && result.Status == payments.RefundFailed):
+        var providerRefundID string
+        if err == nil {
+            providerRefundID = result.ID
+            err = fmt.Errorf("refund %s failed", result.ID)
+        }
+        slog.WarnContext(ctx, "Refund failed", "refund_id", refund.ID, "order_id", refund.OrderID, "error", err)
+        order, cerr := rf.orders.CompleteRefund(ctx, refund.ID, providerRefundID, false)
+        if cerr != nil {
+            return nil, fmt.Errorf("failed to record failed refund %s: %w", refund.ID, cerr)
+        }
+        refund.Status, refund.ProviderRefundID = models.RefundFailed, providerRefundID
+        return order, nil
+
+    case err != nil:
+        // The provider may or may not have made the refund
+        slog.WarnContext(ctx, "Refund outcome unknown, will retry", "refund_id", refund.ID, "order_id", refund.OrderID, "error", err)
+
+    default:
+        if err := rf.orders.SetProviderRefundID(ctx, refund.ID, result.ID); err != nil {
+            return nil, fmt.Errorf("failed to record pending refund %s as %s: %w", refund.ID, result.ID, err)
+        }
+        refund.ProviderRefundID = result.ID
+    }
+    return rf.orders.GetOrder(ctx, refund.OrderID)
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "orderservice/models"
+    "time"
+)
+
+// ErrInvalidCursor is returned for cursors that are malformed or belong to a different sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// OrderSort is the order of a listing: a field, descending when prefixed with "-".
+type OrderSort string
+
+const (
+    SortNewest       OrderSort = "-created_at"
+    SortOldest       OrderSort = "created_at"
+    SortTotalHighest OrderSort = "-total_cents"
+    SortTotalLowest  OrderSort = "total_cents"
+)
+
+// ParseOrderSort returns the named sort, or false if there is no such sort.
+func ParseOrderSort(s string) (OrderSort, bool) {
+    switch sort := OrderSort(s); sort {
+    case SortNewest, SortOldest, SortTotalHighest, SortTotalLowest:
+        return sort, true
+    }
+    return "", false
+}
+
+func (s OrderSort) descending() bool {
+    return s == SortNewest || s == SortTotalHighest
+}
+
+func (s OrderSort) byTotal() bool {
+    return s == SortTotalHighest || s == SortTotalLowest
+}
+
+// key returns the order's value for the sort. Ties are broken by order ID.
+func (s OrderSort) key(o *models.Order) int64 {
+    if s.byTotal() {
+        return o.TotalCents
+    }
+    return o.CreatedAt.UnixNano()
+}
+
+// OrderFilter selects orders to list. Zero fields match every order.
+type OrderFilter struct {
+    UserID      string
+    Statuses    []string  // Any of these
+    ProductID   string    // Orders with a line item for the product
+    CreatedFrom time.Time // Inclusive
+    CreatedTo   time.Time // Exclusive
+}
+
+// ListOptions pages through a listing. Cursor is empty for the first page, then the
+// previous page's NextCursor.
+type ListOptions struct {
+    Sort   OrderSort
+    Limit  int
+    Cursor string
+}
+
+// OrderPage is a page of a listing. Total counts every order matching the filter, not
+// just this page; NextCursor is empty on the last page.
+type OrderPage struct {
+    Orders     []models.Order `json:"orders"`
+    Total      int            `json:"total"`
+    NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// cursor is the position after the last order of a page. It's opaque to clients.
+type cursor struct {
+    Sort OrderSort `json:"s"`
+    Key  int64     `json:"k"`
+    ID   string    `json:"id"`
+}
+
+func encodeCursor(sort OrderSort, last *models.Order) string {
+    b, _ := json.Marshal(cursor{Sort: sort, Key: sort.key(last), ID: last.ID})
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the position to continue after, or nil for the first page.
+func decodeCursor(sort OrderSort, s string) (*cursor, error) {
+    if s == "" {
+        return nil, nil
+    }
+    b, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return nil, ErrInvalidCursor
+    }
+    var c cursor
+    if err := json.Unmarshal(b, &c); err != nil || c.Sort != sort || c.ID == "" {
+        return nil, ErrInvalidCursor
+    }
+    return &c, nil
+}
+
+// matches reports whether the order passes the filter, for the in-memory repository.
+func (f *OrderFilter) matches(o *models.Order) bool {
+    if f.UserID != "" && o.UserID != f.UserID {
+        return false
+    }
+    if len(f.Statuses) > 0 && !contains(f.Statuses, o.Status) {
+        return false
+    }
+    if !f.CreatedFrom.IsZero() && o.CreatedAt.Before(f.CreatedFrom) {
+        return false
+    }
+    if !f.CreatedTo.IsZero() && !o.CreatedAt.Before(f.CreatedTo) {
+        return false
+    }
+    if f.ProductID != "" {
+        for _, item := range o.Items {
+            if item.ProductID == f.ProductID {
+                return true
+            }
+        }
+        return false
+    }
+    return true
+}
+
+func contains(values []string, v string) bool {
+    for _, value := range values {
+        if value == v {
+            return true
+        }
+    }
+    return false
+}
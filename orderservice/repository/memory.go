@@ -0,0 +1,474 @@
+package repository
+
+import (
+    "context"
+    "orderservice/models"
+    "sort"
+    "sync"
+    "time"
+)
+
+// memoryOrderRepository keeps orders in a map, for development and tests. Orders are
+// lost on restart.
+type memoryOrderRepository struct {
+    mu      sync.RWMutex
+    orders  map[string]models.Order
+    history map[string][]models.StatusChange // Keyed by order ID
+    refunds map[string][]models.Refund       // Keyed by order ID
+}
+
+// NewMemoryOrderRepository creates an empty in-memory order repository.
+func NewMemoryOrderRepository() OrderRepository {
+    return &memoryOrderRepository{
+        orders:  make(map[string]models.Order),
+        history: make(map[string][]models.StatusChange),
+        refunds: make(map[string][]models.Refund),
+    }
+}
+
+func (r *memoryOrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if _, exists := r.orders[order.ID]; exists {
+        return ErrOrderExists
+    }
+    now := time.Now().UTC()
+    order.CreatedAt, order.UpdatedAt = now, now
+    stored := *order
+    stored.Items = append([]models.LineItem(nil), order.Items...) // Don't share the caller's slice
+    r.orders[order.ID] = stored
+    r.history[order.ID] = []models.StatusChange{{OrderID: order.ID, To: order.Status, Actor: order.UserID, ChangedAt: now}}
+    return nil
+}
+
+func (r *memoryOrderRepository) GetOrder(ctx context.Context, id string) (*models.Order, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    order, exists := r.orders[id]
+    if !exists {
+        return nil, ErrOrderNotFound
+    }
+    return &order, nil
+}
+
+func (r *memoryOrderRepository) GetOrderByPaymentIntent(ctx context.Context, intentID string) (*models.Order, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    for _, order := range r.orders {
+        if intentID != "" && order.PaymentIntentID == intentID {
+            return &order, nil
+        }
+    }
+    return nil, ErrOrderNotFound
+}
+
+func (r *memoryOrderRepository) UpdateStatus(ctx context.Context, id, status, actor string) (*models.Order, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    order, exists := r.orders[id]
+    if !exists {
+        return nil, ErrOrderNotFound
+    }
+    if !models.CanTransition(order.Status, status) {
+        return nil, transitionError(order.Status, status)
+    }
+    now := time.Now().UTC()
+    r.history[id] = append(r.history[id], models.StatusChange{OrderID: id, From: order.Status, To: status, Actor: actor, ChangedAt: now})
+    order.Status = status
+    order.UpdatedAt = now
+    if status == models.StatusRefunded {
+        order.RefundedCents = order.TotalCents
+    }
+    r.orders[id] = order
+    return &order, nil
+}
+
+func (r *memoryOrderRepository) StartPayment(ctx context.Context, id, intentID, actor string) (*models.Order, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    order, exists := r.orders[id]
+    if !exists {
+        return nil, ErrOrderNotFound
+    }
+    now := time.Now().UTC()
+    switch order.Status {
+    case models.StatusPending:
+        r.history[id] = append(r.history[id], models.StatusChange{OrderID: id, From: order.Status, To: models.StatusAwaitingPayment, Actor: actor, ChangedAt: now})
+        order.Status = models.StatusAwaitingPayment
+    case models.StatusAwaitingPayment:
+    default:
+        return nil, transitionError(order.Status, models.StatusAwaitingPayment)
+    }
+    order.PaymentIntentID = intentID
+    order.UpdatedAt = now
+    r.orders[id] = order
+    return &order, nil
+}
+
+func (r *memoryOrderRepository) CreateRefund(ctx context.Context, refund *models.Refund) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    order, exists := r.orders[refund.OrderID]
+    if !exists {
+        return ErrOrderNotFound
+    }
+    if !models.Refundable(order.Status) {
+        return transitionError(order.Status, models.StatusRefunded)
+    }
+    refunded := make(map[string]int)
+    for _, rf := range r.refunds[order.ID] {
+        if rf.Status != models.RefundFailed {
+            for _, item := range rf.Items {
+                refunded[item.ProductID] += item.Quantity
+            }
+        }
+    }
+    if err := priceRefund(&order, refunded, refund); err != nil {
+        return err
+    }
+    refund.Status = models.RefundPending
+    refund.CreatedAt = time.Now().UTC()
+    stored := *refund
+    stored.Items = append([]models.RefundItem(nil), refund.Items...)
+    r.refunds[order.ID] = append(r.refunds[order.ID], stored)
+    return nil
+}
+
+func (r *memoryOrderRepository) CompleteRefund(ctx context.Context, refundID, providerRefundID string, succeeded bool) (*models.Order, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    for orderID, refunds := range r.refunds {
+        for i := range refunds {
+            refund := &refunds[i]
+            if refund.ID != refundID {
+                continue
+            }
+            order := r.orders[orderID]
+            if refund.Status != models.RefundPending {
+                return &order, nil
+            }
+            refund.ProviderRefundID = providerRefundID
+            if !succeeded {
+                refund.Status = models.RefundFailed
+                return &order, nil
+            }
+            refund.Status = models.RefundSucceeded
+
+            now := time.Now().UTC()
+            // A lost dispute may already have counted the whole total as refunded
+            order.RefundedCents = min(order.RefundedCents+refund.AmountCents, order.TotalCents)
+            order.UpdatedAt = now
+            if status := models.RefundStatus(order.RefundedCents, order.TotalCents); status != order.Status && models.CanTransition(order.Status, status) {
+                r.history[orderID] = append(r.history[orderID], models.StatusChange{OrderID: orderID, From: order.Status, To: status, Actor: refund.Actor, ChangedAt: now})
+                order.Status = status
+            }
+            r.orders[orderID] = order
+            return &order, nil
+        }
+    }
+    return nil, ErrRefundNotFound
+}
+
+func (r *memoryOrderRepository) SetProviderRefundID(ctx context.Context, refundID, providerRefundID string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    for _, refunds := range r.refunds {
+        for i := range refunds {
+            if refunds[i].ID == refundID {
+                if refunds[i].Status == models.RefundPending {
+                    refunds[i].ProviderRefundID = providerRefundID
+                }
+                return nil
+            }
+        }
+    }
+    return ErrRefundNotFound
+}
+
+func (r *memoryOrderRepository) ListPendingRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]models.Refund, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    var pending []models.Refund
+    for _, refunds := range r.refunds {
+        for _, refund := range refunds {
+            if refund.Status == models.RefundPending && refund.CreatedAt.Before(createdBefore) {
+                refund.Items = []models.RefundItem{}
+                pending = append(pending, refund)
+            }
+        }
+    }
+    sort.Slice(pending, func(i, j int) bool {
+        if !pending[i].CreatedAt.Equal(pending[j].CreatedAt) {
+            return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+        }
+        return pending[i].ID < pending[j].ID
+    })
+    if len(pending) > limit {
+        pending = pending[:limit]
+    }
+    return pending, nil
+}
+
+func (r *memoryOrderRepository) ListRefunds(ctx context.Context, orderID string) ([]models.Refund, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    if _, exists := r.orders[orderID]; !exists {
+        return nil, ErrOrderNotFound
+    }
+    refunds := make([]models.Refund, 0, len(r.refunds[orderID]))
+    for _, refund := range r.refunds[orderID] {
+        refund.Items = append([]models.RefundItem(nil), refund.Items...)
+        refunds = append(refunds, refund)
+    }
+    return refunds, nil
+}
+
+func (r *memoryOrderRepository) ListStatusHistory(ctx context.Context, id string) ([]models.StatusChange, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    if _, exists := r.orders[id]; !exists {
+        return nil, ErrOrderNotFound
+    }
+    return append([]models.StatusChange(nil), r.history[id]...), nil
+}
+
+func (r *memoryOrderRepository) ListOrders(ctx context.Context, filter OrderFilter, opts ListOptions) (*OrderPage, error) {
+    after, err := decodeCursor(opts.Sort, opts.Cursor)
+    if err != nil {
+        return nil, err
+    }
+
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    var matches []models.Order
+    for _, o := range r.orders {
+        if filter.matches(&o) {
+            matches = append(matches, o)
+        }
+    }
+    // before reports whether a comes before b in the listing
+    before := func(a, b *models.Order) bool {
+        ka, kb := opts.Sort.key(a), opts.Sort.key(b)
+        if ka == kb {
+            if opts.Sort.descending() {
+                return a.ID > b.ID
+            }
+            return a.ID < b.ID
+        }
+        return (ka < kb) != opts.Sort.descending()
+    }
+    sort.Slice(matches, func(i, j int) bool { return before(&matches[i], &matches[j]) })
+
+    page := &OrderPage{Orders: []models.Order{}, Total: len(matches)}
+    start := 0
+    if after != nil {
+        start = sort.Search(len(matches), func(i int) bool {
+            k := opts.Sort.key(&matches[i])
+            if k == after.Key {
+                if opts.Sort.descending() {
+                    return matches[i].ID < after.ID
+                }
+                return matches[i].ID > after.ID
+            }
+            return (k > after.Key) != opts.Sort.descending()
+        })
+    }
+    for i := start; i < len(matches) && len(page.Orders) < opts.Limit; i++ {
+        order := matches[i]
+        order.Items = append([]models.LineItem(nil), order.Items...)
+        page.Orders = append(page.Orders, order)
+    }
+    if n := len(page.Orders); n > 0 && start+n < len(matches) {
+        page.NextCursor = encodeCursor(opts.Sort, &page.Orders[n-1])
+    }
+    return page, nil
+}
+
+func (r *memoryOrderRepository) ListOrdersByUser(ctx context.Context, userID string) ([]models.Order, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    var orders []models.Order
+    for _, o := range r.orders {
+        if o.UserID == userID {
+            orders = append(orders, o)
+        }
+    }
+    sort.Slice(orders, func(i, j int) bool {
+        if !orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+            return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+        }
+        return orders[i].ID < orders[j].ID
+    })
+    return orders, nil
+}
+
+func (r *memoryOrderRepository) AnonymizeUser(ctx context.Context, userID string) (int, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    anonymized := 0
+    for id, o := range r.orders {
+        if o.UserID == userID {
+            o.UserID = ""
+            o.UpdatedAt = time.Now().UTC()
+            r.orders[id] = o
+            anonymized++
+        }
+    }
+    for _, changes := range r.history {
+        for i := range changes {
+            if changes[i].Actor == userID {
+                changes[i].Actor = ""
+            }
+        }
+    }
+    for _, refunds := range r.refunds {
+        for i := range refunds {
+            if refunds[i].Actor == userID {
+                refunds[i].Actor = ""
+            }
+        }
+    }
+    return anonymized, nil
+}
+
+// memoryPaymentEventRepository keeps payment events in a map, alongside the in-memory
+// order repository.
+type memoryPaymentEventRepository struct {
+    mu     sync.Mutex
+    events map[string]models.PaymentEvent
+}
+
+// NewMemoryPaymentEventRepository creates an empty in-memory payment event repository.
+func NewMemoryPaymentEventRepository() PaymentEventRepository {
+    return &memoryPaymentEventRepository{events: make(map[string]models.PaymentEvent)}
+}
+
+func (r *memoryPaymentEventRepository) SaveEvent(ctx context.Context, event *models.PaymentEvent) (bool, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if _, exists := r.events[event.ID]; exists {
+        return false, nil
+    }
+    event.ReceivedAt = time.Now().UTC()
+    r.events[event.ID] = *event
+    return true, nil
+}
+
+func (r *memoryPaymentEventRepository) SetResult(ctx context.Context, id, result string, processed bool) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    event, exists := r.events[id]
+    if !exists {
+        return nil
+    }
+    event.Result = result
+    if processed {
+        now := time.Now().UTC()
+        event.ProcessedAt = &now
+    }
+    r.events[id] = event
+    return nil
+}
+
+func (r *memoryPaymentEventRepository) ListUnprocessed(ctx context.Context, intentID string, limit int) ([]models.PaymentEvent, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    var events []models.PaymentEvent
+    for _, e := range r.events {
+        if e.ProcessedAt == nil && (intentID == "" || e.IntentID == intentID) {
+            events = append(events, e)
+        }
+    }
+    sort.Slice(events, func(i, j int) bool {
+        if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+            return events[i].CreatedAt.Before(events[j].CreatedAt)
+        }
+        return events[i].ID < events[j].ID
+    })
+    if len(events) > limit {
+        events = events[:limit]
+    }
+    return events, nil
+}
+
+// memoryIdempotencyRepository keeps idempotency keys in a map, alongside the in-memory
+// order repository.
+type memoryIdempotencyRepository struct {
+    mu   sync.Mutex
+    keys map[string]models.IdempotencyRecord
+}
+
+// NewMemoryIdempotencyRepository creates an empty in-memory idempotency key repository.
+func NewMemoryIdempotencyRepository() IdempotencyRepository {
+    return &memoryIdempotencyRepository{keys: make(map[string]models.IdempotencyRecord)}
+}
+
+func (r *memoryIdempotencyRepository) ClaimKey(ctx context.Context, key, fingerprint string, expiresAt, staleBefore time.Time) (*models.IdempotencyRecord, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    now := time.Now().UTC()
+    if record, exists := r.keys[key]; exists && now.Before(record.ExpiresAt) && (record.Completed || !record.CreatedAt.Before(staleBefore)) {
+        record.Body = append([]byte(nil), record.Body...)
+        return &record, nil
+    }
+    r.keys[key] = models.IdempotencyRecord{Key: key, Fingerprint: fingerprint, CreatedAt: now, ExpiresAt: expiresAt}
+    return nil, nil
+}
+
+func (r *memoryIdempotencyRepository) CompleteKey(ctx context.Context, key string, statusCode int, contentType string, body []byte) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    record, exists := r.keys[key]
+    if !exists {
+        return nil
+    }
+    record.Completed = true
+    record.StatusCode, record.ContentType = statusCode, contentType
+    record.Body = append([]byte(nil), body...)
+    r.keys[key] = record
+    return nil
+}
+
+func (r *memoryIdempotencyRepository) ReleaseKey(ctx context.Context, key string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if record, exists := r.keys[key]; exists && !record.Completed {
+        delete(r.keys, key)
+    }
+    return nil
+}
+
+func (r *memoryIdempotencyRepository) DeleteExpiredKeys(ctx context.Context) (int, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    now := time.Now()
+    deleted := 0
+    for key, record := range r.keys {
+        if !now.Before(record.ExpiresAt) {
+            delete(r.keys, key)
+            deleted++
+        }
+    }
+    return deleted, nil
+}
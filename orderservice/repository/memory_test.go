@@ -0,0 +1,232 @@
+package repository
+
+import (
+    "context"
+    "errors"
+    "orderservice/models"
+    "testing"
+    "time"
+)
+
+// paidOrder stores an order of two products, 2 x 300 and 1 x 400 cents, and pays it.
+func paidOrder(t *testing.T, orders OrderRepository) *models.Order {
+    t.Helper()
+    ctx := context.Background()
+    order := &models.Order{ID: models.NewOrderID(), UserID: "7", Status: models.StatusPending, Items: []models.LineItem{
+        {ProductID: "a", Name: "A", Quantity: 2, UnitPriceCents: 300},
+        {ProductID: "b", Name: "B", Quantity: 1, UnitPriceCents: 400},
+    }}
+    order.CalculateTotals()
+    if err := orders.CreateOrder(ctx, order); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := orders.StartPayment(ctx, order.ID, "pi_1", "7"); err != nil {
+        t.Fatal(err)
+    }
+    paid, err := orders.UpdateStatus(ctx, order.ID, models.StatusPaid, "payments")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return paid
+}
+
+func TestUpdateStatus(t *testing.T) {
+    orders := NewMemoryOrderRepository()
+    ctx := context.Background()
+    order := paidOrder(t, orders)
+
+    if _, err := orders.UpdateStatus(ctx, order.ID, models.StatusPending, "1"); !errors.Is(err, ErrInvalidTransition) {
+        t.Errorf("paid to pending: err = %v, want ErrInvalidTransition", err)
+    }
+    if _, err := orders.UpdateStatus(ctx, "missing", models.StatusShipped, "1"); !errors.Is(err, ErrOrderNotFound) {
+        t.Errorf("missing order: err = %v, want ErrOrderNotFound", err)
+    }
+    refunded, err := orders.UpdateStatus(ctx, order.ID, models.StatusRefunded, "payments")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if refunded.RefundedCents != refunded.TotalCents {
+        t.Errorf("refunded %d of %d cents, want all", refunded.RefundedCents, refunded.TotalCents)
+    }
+
+    history, err := orders.ListStatusHistory(ctx, order.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []string
+    for _, change := range history {
+        got = append(got, change.To)
+    }
+    want := []string{models.StatusPending, models.StatusAwaitingPayment, models.StatusPaid, models.StatusRefunded}
+    if len(got) != len(want) {
+        t.Fatalf("history = %v, want %v", got, want)
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            t.Fatalf("history = %v, want %v", got, want)
+        }
+    }
+}
+
+func TestRefunds(t *testing.T) {
+    orders := NewMemoryOrderRepository()
+    ctx := context.Background()
+    order := paidOrder(t, orders)
+
+    first := &models.Refund{ID: models.NewRefundID(), OrderID: order.ID, Items: []models.RefundItem{{ProductID: "a", Quantity: 1}}}
+    if err := orders.CreateRefund(ctx, first); err != nil {
+        t.Fatal(err)
+    }
+    if first.AmountCents != 300 || first.Status != models.RefundPending {
+        t.Errorf("refund = %d cents %s, want 300 cents pending", first.AmountCents, first.Status)
+    }
+    // The pending refund's quantity is reserved
+    tooMany := &models.Refund{ID: models.NewRefundID(), OrderID: order.ID, Items: []models.RefundItem{{ProductID: "a", Quantity: 2}}}
+    if err := orders.CreateRefund(ctx, tooMany); !errors.Is(err, ErrRefundExceedsPayment) {
+        t.Errorf("refunding reserved items: err = %v, want ErrRefundExceedsPayment", err)
+    }
+
+    updated, err := orders.CompleteRefund(ctx, first.ID, "re_1", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if updated.RefundedCents != 300 || updated.Status != models.StatusPartiallyRefunded {
+        t.Errorf("order = %d cents refunded, %s; want 300, partially refunded", updated.RefundedCents, updated.Status)
+    }
+    if again, err := orders.CompleteRefund(ctx, first.ID, "re_1", true); err != nil || again.RefundedCents != 300 {
+        t.Errorf("completing again: %v, %v; want nothing to change", again, err)
+    }
+
+    // A failed refund frees what it reserved
+    failed := &models.Refund{ID: models.NewRefundID(), OrderID: order.ID, Items: []models.RefundItem{{ProductID: "b", Quantity: 1}}}
+    if err := orders.CreateRefund(ctx, failed); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := orders.CompleteRefund(ctx, failed.ID, "", false); err != nil {
+        t.Fatal(err)
+    }
+
+    rest := &models.Refund{ID: models.NewRefundID(), OrderID: order.ID}
+    if err := orders.CreateRefund(ctx, rest); err != nil {
+        t.Fatal(err)
+    }
+    if rest.AmountCents != 700 {
+        t.Errorf("refund of the rest = %d cents, want 700", rest.AmountCents)
+    }
+    updated, err = orders.CompleteRefund(ctx, rest.ID, "re_3", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if updated.RefundedCents != 1000 || updated.Status != models.StatusRefunded {
+        t.Errorf("order = %d cents refunded, %s; want 1000, refunded", updated.RefundedCents, updated.Status)
+    }
+    if _, err := orders.CompleteRefund(ctx, "missing", "", true); !errors.Is(err, ErrRefundNotFound) {
+        t.Errorf("missing refund: err = %v, want ErrRefundNotFound", err)
+    }
+}
+
+func TestRefundAfterLostDisputeIsCapped(t *testing.T) {
+    orders := NewMemoryOrderRepository()
+    ctx := context.Background()
+    order := paidOrder(t, orders)
+
+    refund := &models.Refund{ID: models.NewRefundID(), OrderID: order.ID, Items: []models.RefundItem{{ProductID: "b", Quantity: 1}}}
+    if err := orders.CreateRefund(ctx, refund); err != nil {
+        t.Fatal(err)
+    }
+    // The refund is still pending with the provider when the dispute is lost
+    if _, err := orders.UpdateStatus(ctx, order.ID, models.StatusDisputed, "payments"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := orders.UpdateStatus(ctx, order.ID, models.StatusRefunded, "payments"); err != nil {
+        t.Fatal(err)
+    }
+
+    updated, err := orders.CompleteRefund(ctx, refund.ID, "re_1", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if updated.RefundedCents != updated.TotalCents || updated.Status != models.StatusRefunded {
+        t.Errorf("order = %d of %d cents refunded, %s; want the total, refunded", updated.RefundedCents, updated.TotalCents, updated.Status)
+    }
+}
+
+func TestListPendingRefunds(t *testing.T) {
+    orders := NewMemoryOrderRepository()
+    ctx := context.Background()
+    order := paidOrder(t, orders)
+
+    refund := &models.Refund{ID: models.NewRefundID(), OrderID: order.ID, Items: []models.RefundItem{{ProductID: "a", Quantity: 1}}}
+    if err := orders.CreateRefund(ctx, refund); err != nil {
+        t.Fatal(err)
+    }
+    if pending, _ := orders.ListPendingRefunds(ctx, refund.CreatedAt, 10); len(pending) != 0 {
+        t.Errorf("refunds created before the refund = %v, want none", pending)
+    }
+    if err := orders.SetProviderRefundID(ctx, refund.ID, "re_1"); err != nil {
+        t.Fatal(err)
+    }
+    pending, err := orders.ListPendingRefunds(ctx, time.Now().Add(time.Second), 10)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(pending) != 1 || pending[0].ID != refund.ID || pending[0].ProviderRefundID != "re_1" {
+        t.Fatalf("pending refunds = %+v, want the refund with its provider ID", pending)
+    }
+    if _, err := orders.CompleteRefund(ctx, refund.ID, "re_1", true); err != nil {
+        t.Fatal(err)
+    }
+    if pending, _ := orders.ListPendingRefunds(ctx, time.Now().Add(time.Second), 10); len(pending) != 0 {
+        t.Errorf("pending refunds after completion = %v, want none", pending)
+    }
+}
+
+func TestSaveEventOnlyOnce(t *testing.T) {
+    events := NewMemoryPaymentEventRepository()
+    ctx := context.Background()
+
+    if isNew, err := events.SaveEvent(ctx, &models.PaymentEvent{ID: "evt_1", IntentID: "pi_1"}); err != nil || !isNew {
+        t.Fatalf("first save = %v, %v; want new", isNew, err)
+    }
+    if isNew, err := events.SaveEvent(ctx, &models.PaymentEvent{ID: "evt_1", IntentID: "pi_1"}); err != nil || isNew {
+        t.Fatalf("second save = %v, %v; want a duplicate", isNew, err)
+    }
+    if err := events.SetResult(ctx, "evt_1", "applied", true); err != nil {
+        t.Fatal(err)
+    }
+    if waiting, _ := events.ListUnprocessed(ctx, "", 10); len(waiting) != 0 {
+        t.Errorf("unprocessed events = %v, want none", waiting)
+    }
+}
+
+func TestClaimKey(t *testing.T) {
+    keys := NewMemoryIdempotencyRepository()
+    ctx := context.Background()
+    now := time.Now()
+
+    if record, err := keys.ClaimKey(ctx, "k", "f", now.Add(time.Hour), now.Add(-time.Minute)); err != nil || record != nil {
+        t.Fatalf("claiming a new key = %v, %v; want it claimed", record, err)
+    }
+    record, err := keys.ClaimKey(ctx, "k", "f", now.Add(time.Hour), now.Add(-time.Minute))
+    if err != nil || record == nil || record.Completed {
+        t.Fatalf("claiming a key in progress = %v, %v; want its incomplete record", record, err)
+    }
+    // A key held since before staleBefore is taken over
+    if record, err := keys.ClaimKey(ctx, "k", "f", now.Add(time.Hour), now.Add(time.Minute)); err != nil || record != nil {
+        t.Fatalf("claiming a stale key = %v, %v; want it claimed", record, err)
+    }
+
+    if err := keys.CompleteKey(ctx, "k", 201, "application/json", []byte(`{}`)); err != nil {
+        t.Fatal(err)
+    }
+    record, err = keys.ClaimKey(ctx, "k", "f", now.Add(time.Hour), now.Add(time.Minute))
+    if err != nil || record == nil || !record.Completed || record.StatusCode != 201 || string(record.Body) != `{}` {
+        t.Fatalf("claiming a completed key = %+v, %v; want its response", record, err)
+    }
+    if err := keys.ReleaseKey(ctx, "k"); err != nil {
+        t.Fatal(err)
+    }
+    if record, _ := keys.ClaimKey(ctx, "k", "f", now.Add(time.Hour), now.Add(time.Minute)); record == nil {
+        t.Error("releasing a completed key forgot it")
+    }
+}
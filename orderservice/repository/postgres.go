@@ -0,0 +1,752 @@
+package repository
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "orderservice/models"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgconn"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+const orderColumns = `id, user_id, subtotal_cents, total_cents, refunded_cents, status, payment_intent_id, created_at, updated_at`
+
+// postgresOrderRepository implements OrderRepository for PostgreSQL.
+type postgresOrderRepository struct {
+    pool *pgxpool.Pool
+}
+
+// NewPostgresOrderRepository creates a new PostgreSQL order repository.
+func NewPostgresOrderRepository(pool *pgxpool.Pool) OrderRepository {
+    return &postgresOrderRepository{pool: pool}
+}
+
+func (r *postgresOrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
+    tx, err := r.pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    query := `INSERT INTO orders (id, user_id, subtotal_cents, total_cents, status)
+              VALUES ($1, $2, $3, $4, $5)
+              RETURNING created_at, updated_at`
+    err = tx.QueryRow(ctx, query, order.ID, order.UserID, order.SubtotalCents, order.TotalCents, order.Status).
+        Scan(&order.CreatedAt, &order.UpdatedAt)
+    if isUniqueViolation(err) {
+        return ErrOrderExists
+    }
+    if err != nil {
+        return err
+    }
+    for i, item := range order.Items {
+        _, err := tx.Exec(ctx, `INSERT INTO order_items (order_id, position, product_id, name, quantity, unit_price_cents)
+                                VALUES ($1, $2, $3, $4, $5, $6)`,
+            order.ID, i, item.ProductID, item.Name, item.Quantity, item.UnitPriceCents)
+        if err != nil {
+            return err
+        }
+    }
+    if err := recordStatusChange(ctx, tx, order.ID, "", order.Status, order.UserID); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+func (r *postgresOrderRepository) GetOrder(ctx context.Context, id string) (*models.Order, error) {
+    query := `SELECT ` + orderColumns + ` FROM orders WHERE id = $1`
+    order, err := scanOrder(r.pool.QueryRow(ctx, query, id))
+    if err != nil {
+        return nil, err
+    }
+    if err := loadItems(ctx, r.pool, []*models.Order{order}); err != nil {
+        return nil, err
+    }
+    return order, nil
+}
+
+func (r *postgresOrderRepository) GetOrderByPaymentIntent(ctx context.Context, intentID string) (*models.Order, error) {
+    if intentID == "" {
+        return nil, ErrOrderNotFound
+    }
+    query := `SELECT ` + orderColumns + ` FROM orders WHERE payment_intent_id = $1`
+    order, err := scanOrder(r.pool.QueryRow(ctx, query, intentID))
+    if err != nil {
+        return nil, err
+    }
+    if err := loadItems(ctx, r.pool, []*models.Order{order}); err != nil {
+        return nil, err
+    }
+    return order, nil
+}
+
+func (r *postgresOrderRepository) UpdateStatus(ctx context.Context, id, status, actor string) (*models.Order, error) {
+    tx, err := r.pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    // Lock the order so concurrent updates see each other's transitions
+    var current string
+    err = tx.QueryRow(ctx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrOrderNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if !models.CanTransition(current, status) {
+        return nil, transitionError(current, status)
+    }
+
+    query := `UPDATE orders SET status = $2, updated_at = now(),
+                     refunded_cents = CASE WHEN $2 = $3 THEN total_cents ELSE refunded_cents END
+              WHERE id = $1
+              RETURNING ` + orderColumns
+    order, err := scanOrder(tx.QueryRow(ctx, query, id, status, models.StatusRefunded))
+    if err != nil {
+        return nil, err
+    }
+    if err := recordStatusChange(ctx, tx, id, current, status, actor); err != nil {
+        return nil, err
+    }
+    if err := loadItems(ctx, tx, []*models.Order{order}); err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+    return order, nil
+}
+
+func (r *postgresOrderRepository) StartPayment(ctx context.Context, id, intentID, actor string) (*models.Order, error) {
+    tx, err := r.pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    var current string
+    err = tx.QueryRow(ctx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrOrderNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if current != models.StatusPending && current != models.StatusAwaitingPayment {
+        return nil, transitionError(current, models.StatusAwaitingPayment)
+    }
+
+    query := `UPDATE orders SET status = $2, payment_intent_id = $3, updated_at = now()
+              WHERE id = $1
+              RETURNING ` + orderColumns
+    order, err := scanOrder(tx.QueryRow(ctx, query, id, models.StatusAwaitingPayment, intentID))
+    if err != nil {
+        return nil, err
+    }
+    if current == models.StatusPending {
+        if err := recordStatusChange(ctx, tx, id, current, models.StatusAwaitingPayment, actor); err != nil {
+            return nil, err
+        }
+    }
+    if err := loadItems(ctx, tx, []*models.Order{order}); err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+    return order, nil
+}
+
+func (r *postgresOrderRepository) CreateRefund(ctx context.Context, refund *models.Refund) error {
+    tx, err := r.pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    // Lock the order so concurrent refunds see each other's items
+    order, err := scanOrder(tx.QueryRow(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = $1 FOR UPDATE`, refund.OrderID))
+    if err != nil {
+        return err
+    }
+    if !models.Refundable(order.Status) {
+        return transitionError(order.Status, models.StatusRefunded)
+    }
+    if err := loadItems(ctx, tx, []*models.Order{order}); err != nil {
+        return err
+    }
+
+    rows, err := tx.Query(ctx, `SELECT ri.product_id, sum(ri.quantity)
+                                FROM refund_items ri JOIN refunds rf ON rf.id = ri.refund_id
+                                WHERE rf.order_id = $1 AND rf.status <> $2
+                                GROUP BY ri.product_id`, order.ID, models.RefundFailed)
+    if err != nil {
+        return err
+    }
+    refunded := make(map[string]int)
+    for rows.Next() {
+        var productID string
+        var quantity int
+        if err := rows.Scan(&productID, &quantity); err != nil {
+            rows.Close()
+            return err
+        }
+        refunded[productID] = quantity
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    if err := priceRefund(order, refunded, refund); err != nil {
+        return err
+    }
+
+    refund.Status = models.RefundPending
+    err = tx.QueryRow(ctx, `INSERT INTO refunds (id, order_id, amount_cents, reason, status, actor)
+                            VALUES ($1, $2, $3, $4, $5, $6)
+                            RETURNING created_at`,
+        refund.ID, refund.OrderID, refund.AmountCents, refund.Reason, refund.Status, refund.Actor).Scan(&refund.CreatedAt)
+    if err != nil {
+        return err
+    }
+    for _, item := range refund.Items {
+        _, err := tx.Exec(ctx, `INSERT INTO refund_items (refund_id, product_id, quantity, amount_cents)
+                                VALUES ($1, $2, $3, $4)`, refund.ID, item.ProductID, item.Quantity, item.AmountCents)
+        if err != nil {
+            return err
+        }
+    }
+    return tx.Commit(ctx)
+}
+
+func (r *postgresOrderRepository) CompleteRefund(ctx context.Context, refundID, providerRefundID string, succeeded bool) (*models.Order, error) {
+    tx, err := r.pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    var orderID, status, actor string
+    var amount int64
+    err = tx.QueryRow(ctx, `SELECT order_id, status, actor, amount_cents FROM refunds WHERE id = $1 FOR UPDATE`, refundID).
+        Scan(&orderID, &status, &actor, &amount)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrRefundNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    order, err := scanOrder(tx.QueryRow(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = $1 FOR UPDATE`, orderID))
+    if err != nil {
+        return nil, err
+    }
+
+    if status == models.RefundPending {
+        status = models.RefundFailed
+        if succeeded {
+            status = models.RefundSucceeded
+        }
+        _, err := tx.Exec(ctx, `UPDATE refunds SET status = $2, provider_refund_id = $3 WHERE id = $1`, refundID, status, providerRefundID)
+        if err != nil {
+            return nil, err
+        }
+        if succeeded {
+            newStatus := order.Status
+            if next := models.RefundStatus(order.RefundedCents+amount, order.TotalCents); models.CanTransition(order.Status, next) {
+                newStatus = next
+            }
+            // A lost dispute may already have counted the whole total as refunded
+            query := `UPDATE orders SET refunded_cents = LEAST(refunded_cents + $2, total_cents), status = $3, updated_at = now()
+                      WHERE id = $1
+                      RETURNING ` + orderColumns
+            previous := order.Status
+            if order, err = scanOrder(tx.QueryRow(ctx, query, orderID, amount, newStatus)); err != nil {
+                return nil, err
+            }
+            if newStatus != previous {
+                if err := recordStatusChange(ctx, tx, orderID, previous, newStatus, actor); err != nil {
+                    return nil, err
+                }
+            }
+        }
+    }
+
+    if err := loadItems(ctx, tx, []*models.Order{order}); err != nil {
+        return nil, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+    return order, nil
+}
+
+func (r *postgresOrderRepository) ListRefunds(ctx context.Context, orderID string) ([]models.Refund, error) {
+    var exists bool
+    if err := r.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, orderID).Scan(&exists); err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, ErrOrderNotFound
+    }
+
+    query := `SELECT id, order_id, amount_cents, reason, status, provider_refund_id, actor, created_at
+              FROM refunds
+              WHERE order_id = $1
+              ORDER BY created_at, id`
+    rows, err := r.pool.Query(ctx, query, orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    refunds := []models.Refund{}
+    byID := make(map[string]int)
+    for rows.Next() {
+        var rf models.Refund
+        if err := rows.Scan(&rf.ID, &rf.OrderID, &rf.AmountCents, &rf.Reason, &rf.Status, &rf.ProviderRefundID, &rf.Actor, &rf.CreatedAt); err != nil {
+            return nil, err
+        }
+        rf.Items = []models.RefundItem{}
+        byID[rf.ID] = len(refunds)
+        refunds = append(refunds, rf)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows.Close()
+
+    rows, err = r.pool.Query(ctx, `SELECT ri.refund_id, ri.product_id, ri.quantity, ri.amount_cents
+                                   FROM refund_items ri JOIN refunds rf ON rf.id = ri.refund_id
+                                   WHERE rf.order_id = $1
+                                   ORDER BY ri.refund_id, ri.product_id`, orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var refundID string
+        var item models.RefundItem
+        if err := rows.Scan(&refundID, &item.ProductID, &item.Quantity, &item.AmountCents); err != nil {
+            return nil, err
+        }
+        rf := &refunds[byID[refundID]]
+        rf.Items = append(rf.Items, item)
+    }
+    return refunds, rows.Err()
+}
+
+func (r *postgresOrderRepository) SetProviderRefundID(ctx context.Context, refundID, providerRefundID string) error {
+    tag, err := r.pool.Exec(ctx, `UPDATE refunds SET provider_refund_id = $2 WHERE id = $1 AND status = $3`, refundID, providerRefundID, models.RefundPending)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        var exists bool
+        if err := r.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM refunds WHERE id = $1)`, refundID).Scan(&exists); err != nil {
+            return err
+        }
+        if !exists {
+            return ErrRefundNotFound
+        }
+    }
+    return nil
+}
+
+func (r *postgresOrderRepository) ListPendingRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]models.Refund, error) {
+    query := `SELECT id, order_id, amount_cents, reason, status, provider_refund_id, actor, created_at
+              FROM refunds
+              WHERE status = $1 AND created_at < $2
+              ORDER BY created_at, id
+              LIMIT $3`
+    rows, err := r.pool.Query(ctx, query, models.RefundPending, createdBefore, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var refunds []models.Refund
+    for rows.Next() {
+        rf := models.Refund{Items: []models.RefundItem{}}
+        if err := rows.Scan(&rf.ID, &rf.OrderID, &rf.AmountCents, &rf.Reason, &rf.Status, &rf.ProviderRefundID, &rf.Actor, &rf.CreatedAt); err != nil {
+            return nil, err
+        }
+        refunds = append(refunds, rf)
+    }
+    return refunds, rows.Err()
+}
+
+func (r *postgresOrderRepository) ListStatusHistory(ctx context.Context, id string) ([]models.StatusChange, error) {
+    var exists bool
+    if err := r.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, id).Scan(&exists); err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, ErrOrderNotFound
+    }
+
+    query := `SELECT order_id, from_status, to_status, actor, changed_at
+              FROM order_status_history
+              WHERE order_id = $1
+              ORDER BY changed_at, id`
+    rows, err := r.pool.Query(ctx, query, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var changes []models.StatusChange
+    for rows.Next() {
+        var c models.StatusChange
+        if err := rows.Scan(&c.OrderID, &c.From, &c.To, &c.Actor, &c.ChangedAt); err != nil {
+            return nil, err
+        }
+        changes = append(changes, c)
+    }
+    return changes, rows.Err()
+}
+
+func (r *postgresOrderRepository) ListOrders(ctx context.Context, filter OrderFilter, opts ListOptions) (*OrderPage, error) {
+    after, err := decodeCursor(opts.Sort, opts.Cursor)
+    if err != nil {
+        return nil, err
+    }
+
+    var conditions []string
+    var args []any
+    // arg adds a query argument and returns its placeholder
+    arg := func(v any) string {
+        args = append(args, v)
+        return fmt.Sprintf("$%d", len(args))
+    }
+    if filter.UserID != "" {
+        conditions = append(conditions, "user_id = "+arg(filter.UserID))
+    }
+    if len(filter.Statuses) > 0 {
+        conditions = append(conditions, "status = ANY("+arg(filter.Statuses)+")")
+    }
+    if filter.ProductID != "" {
+        conditions = append(conditions, "EXISTS (SELECT 1 FROM order_items i WHERE i.order_id = orders.id AND i.product_id = "+arg(filter.ProductID)+")")
+    }
+    if !filter.CreatedFrom.IsZero() {
+        conditions = append(conditions, "created_at >= "+arg(filter.CreatedFrom))
+    }
+    if !filter.CreatedTo.IsZero() {
+        conditions = append(conditions, "created_at < "+arg(filter.CreatedTo))
+    }
+    where := ""
+    if len(conditions) > 0 {
+        where = " WHERE " + strings.Join(conditions, " AND ")
+    }
+
+    page := &OrderPage{Orders: []models.Order{}}
+    if err := r.pool.QueryRow(ctx, `SELECT count(*) FROM orders`+where, args...).Scan(&page.Total); err != nil {
+        return nil, err
+    }
+
+    column, direction, comparison := "created_at", "ASC", ">"
+    if opts.Sort.byTotal() {
+        column = "total_cents"
+    }
+    if opts.Sort.descending() {
+        direction, comparison = "DESC", "<"
+    }
+    if after != nil {
+        var key any = after.Key
+        if !opts.Sort.byTotal() {
+            key = time.Unix(0, after.Key).UTC()
+        }
+        cond := fmt.Sprintf("(%s, id) %s (%s, %s)", column, comparison, arg(key), arg(after.ID))
+        if where == "" {
+            where = " WHERE " + cond
+        } else {
+            where += " AND " + cond
+        }
+    }
+    // One extra row tells whether there is another page
+    query := `SELECT ` + orderColumns + ` FROM orders` + where +
+        fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", column, direction, direction, arg(opts.Limit+1))
+    rows, err := r.pool.Query(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var orders []*models.Order
+    for rows.Next() {
+        order, err := scanOrder(rows)
+        if err != nil {
+            return nil, err
+        }
+        orders = append(orders, order)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows.Close()
+
+    if len(orders) > opts.Limit {
+        orders = orders[:opts.Limit]
+        page.NextCursor = encodeCursor(opts.Sort, orders[len(orders)-1])
+    }
+    if err := loadItems(ctx, r.pool, orders); err != nil {
+        return nil, err
+    }
+    for _, o := range orders {
+        page.Orders = append(page.Orders, *o)
+    }
+    return page, nil
+}
+
+func (r *postgresOrderRepository) ListOrdersByUser(ctx context.Context, userID string) ([]models.Order, error) {
+    query := `SELECT ` + orderColumns + ` FROM orders
+              WHERE user_id = $1
+              ORDER BY created_at, id`
+    rows, err := r.pool.Query(ctx, query, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var orders []*models.Order
+    for rows.Next() {
+        order, err := scanOrder(rows)
+        if err != nil {
+            return nil, err
+        }
+        orders = append(orders, order)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows.Close()
+
+    if err := loadItems(ctx, r.pool, orders); err != nil {
+        return nil, err
+    }
+    result := make([]models.Order, 0, len(orders))
+    for _, o := range orders {
+        result = append(result, *o)
+    }
+    return result, nil
+}
+
+func (r *postgresOrderRepository) AnonymizeUser(ctx context.Context, userID string) (int, error) {
+    tx, err := r.pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    tag, err := tx.Exec(ctx, `UPDATE orders SET user_id = '', updated_at = now() WHERE user_id = $1`, userID)
+    if err != nil {
+        return 0, err
+    }
+    if _, err := tx.Exec(ctx, `UPDATE order_status_history SET actor = '' WHERE actor = $1`, userID); err != nil {
+        return 0, err
+    }
+    if _, err := tx.Exec(ctx, `UPDATE refunds SET actor = '' WHERE actor = $1`, userID); err != nil {
+        return 0, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return 0, err
+    }
+    return int(tag.RowsAffected()), nil
+}
+
+// postgresPaymentEventRepository implements PaymentEventRepository for PostgreSQL.
+type postgresPaymentEventRepository struct {
+    pool *pgxpool.Pool
+}
+
+// NewPostgresPaymentEventRepository creates a new PostgreSQL payment event repository.
+func NewPostgresPaymentEventRepository(pool *pgxpool.Pool) PaymentEventRepository {
+    return &postgresPaymentEventRepository{pool: pool}
+}
+
+func (r *postgresPaymentEventRepository) SaveEvent(ctx context.Context, event *models.PaymentEvent) (bool, error) {
+    query := `INSERT INTO payment_events (id, type, intent_id, order_id, amount_cents, currency, dispute_status, payload, created_at, result)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+              ON CONFLICT (id) DO NOTHING
+              RETURNING received_at`
+    err := r.pool.QueryRow(ctx, query, event.ID, event.Type, event.IntentID, event.OrderID, event.AmountCents, event.Currency,
+        event.DisputeStatus, string(event.Payload), event.CreatedAt, event.Result).Scan(&event.ReceivedAt)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    return true, nil
+}
+
+func (r *postgresPaymentEventRepository) SetResult(ctx context.Context, id, result string, processed bool) error {
+    query := `UPDATE payment_events
+              SET result = $2, processed_at = CASE WHEN $3 THEN now() END
+              WHERE id = $1`
+    _, err := r.pool.Exec(ctx, query, id, result, processed)
+    return err
+}
+
+func (r *postgresPaymentEventRepository) ListUnprocessed(ctx context.Context, intentID string, limit int) ([]models.PaymentEvent, error) {
+    query := `SELECT id, type, intent_id, order_id, amount_cents, currency, dispute_status, payload, created_at, received_at, result
+              FROM payment_events
+              WHERE processed_at IS NULL AND ($1 = '' OR intent_id = $1)
+              ORDER BY created_at, id
+              LIMIT $2`
+    rows, err := r.pool.Query(ctx, query, intentID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var events []models.PaymentEvent
+    for rows.Next() {
+        var e models.PaymentEvent
+        var payload string
+        if err := rows.Scan(&e.ID, &e.Type, &e.IntentID, &e.OrderID, &e.AmountCents, &e.Currency, &e.DisputeStatus, &payload, &e.CreatedAt, &e.ReceivedAt, &e.Result); err != nil {
+            return nil, err
+        }
+        e.Payload = []byte(payload)
+        events = append(events, e)
+    }
+    return events, rows.Err()
+}
+
+// postgresIdempotencyRepository implements IdempotencyRepository for PostgreSQL.
+type postgresIdempotencyRepository struct {
+    pool *pgxpool.Pool
+}
+
+// NewPostgresIdempotencyRepository creates a new PostgreSQL idempotency key repository.
+func NewPostgresIdempotencyRepository(pool *pgxpool.Pool) IdempotencyRepository {
+    return &postgresIdempotencyRepository{pool: pool}
+}
+
+func (r *postgresIdempotencyRepository) ClaimKey(ctx context.Context, key, fingerprint string, expiresAt, staleBefore time.Time) (*models.IdempotencyRecord, error) {
+    // The key can be deleted between the two queries, so it's tried again then
+    for attempt := 0; attempt < 2; attempt++ {
+        var claimed string
+        err := r.pool.QueryRow(ctx, `
+            INSERT INTO idempotency_keys (key, fingerprint, expires_at)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (key) DO UPDATE
+            SET fingerprint = EXCLUDED.fingerprint, completed = false, status_code = 0, content_type = '',
+                body = NULL, created_at = now(), expires_at = EXCLUDED.expires_at
+            WHERE idempotency_keys.expires_at <= now()
+               OR (NOT idempotency_keys.completed AND idempotency_keys.created_at < $4)
+            RETURNING key`, key, fingerprint, expiresAt, staleBefore).Scan(&claimed)
+        if err == nil {
+            return nil, nil
+        }
+        if !errors.Is(err, pgx.ErrNoRows) {
+            return nil, err
+        }
+
+        var record models.IdempotencyRecord
+        err = r.pool.QueryRow(ctx, `
+            SELECT key, fingerprint, completed, status_code, content_type, body, created_at, expires_at
+            FROM idempotency_keys WHERE key = $1`, key).
+            Scan(&record.Key, &record.Fingerprint, &record.Completed, &record.StatusCode, &record.ContentType,
+                &record.Body, &record.CreatedAt, &record.ExpiresAt)
+        if errors.Is(err, pgx.ErrNoRows) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        return &record, nil
+    }
+    return nil, fmt.Errorf("idempotency key %q changed concurrently", key)
+}
+
+func (r *postgresIdempotencyRepository) CompleteKey(ctx context.Context, key string, statusCode int, contentType string, body []byte) error {
+    query := `UPDATE idempotency_keys
+              SET completed = true, status_code = $2, content_type = $3, body = $4
+              WHERE key = $1`
+    _, err := r.pool.Exec(ctx, query, key, statusCode, contentType, body)
+    return err
+}
+
+func (r *postgresIdempotencyRepository) ReleaseKey(ctx context.Context, key string) error {
+    _, err := r.pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND NOT completed`, key)
+    return err
+}
+
+func (r *postgresIdempotencyRepository) DeleteExpiredKeys(ctx context.Context) (int, error) {
+    tag, err := r.pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`)
+    if err != nil {
+        return 0, err
+    }
+    return int(tag.RowsAffected()), nil
+}
+
+// recordStatusChange appends to the order's status history.
+func recordStatusChange(ctx context.Context, tx pgx.Tx, orderID, from, to, actor string) error {
+    _, err := tx.Exec(ctx, `INSERT INTO order_status_history (order_id, from_status, to_status, actor)
+                            VALUES ($1, $2, $3, $4)`, orderID, from, to, actor)
+    return err
+}
+
+// querier is satisfied by both the pool and transactions.
+type querier interface {
+    Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// loadItems fills in the orders' line items with a single query.
+func loadItems(ctx context.Context, q querier, orders []*models.Order) error {
+    if len(orders) == 0 {
+        return nil
+    }
+    byID := make(map[string]*models.Order, len(orders))
+    ids := make([]string, 0, len(orders))
+    for _, o := range orders {
+        o.Items = []models.LineItem{}
+        byID[o.ID] = o
+        ids = append(ids, o.ID)
+    }
+
+    query := `SELECT order_id, product_id, name, quantity, unit_price_cents
+              FROM order_items
+              WHERE order_id = ANY($1)
+              ORDER BY order_id, position`
+    rows, err := q.Query(ctx, query, ids)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var orderID string
+        var item models.LineItem
+        if err := rows.Scan(&orderID, &item.ProductID, &item.Name, &item.Quantity, &item.UnitPriceCents); err != nil {
+            return err
+        }
+        item.LineTotalCents = item.UnitPriceCents * int64(item.Quantity)
+        byID[orderID].Items = append(byID[orderID].Items, item)
+    }
+    return rows.Err()
+}
+
+func scanOrder(row pgx.Row) (*models.Order, error) {
+    var o models.Order
+    err := row.Scan(&o.ID, &o.UserID, &o.SubtotalCents, &o.TotalCents, &o.RefundedCents, &o.Status, &o.PaymentIntentID, &o.CreatedAt, &o.UpdatedAt)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil, ErrOrderNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &o, nil
+}
+
+func isUniqueViolation(err error) bool {
+    var pgErr *pgconn.PgError
+    return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
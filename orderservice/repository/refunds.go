@@ -0,0 +1,48 @@
+package repository
+
+import (
+    "fmt"
+    "orderservice/models"
+)
+
+// priceRefund fills in the refund's item amounts and total from the order's prices.
+// Without items, it refunds everything not yet refunded. refunded holds the quantities
+// of each product already refunded or being refunded.
+func priceRefund(order *models.Order, refunded map[string]int, refund *models.Refund) error {
+    if len(refund.Items) == 0 {
+        for _, item := range order.Items {
+            if remaining := item.Quantity - refunded[item.ProductID]; remaining > 0 {
+                refund.Items = append(refund.Items, models.RefundItem{ProductID: item.ProductID, Quantity: remaining})
+            }
+        }
+        if len(refund.Items) == 0 {
+            return fmt.Errorf("%w: order %s is already fully refunded", ErrRefundExceedsPayment, order.ID)
+        }
+    }
+
+    refund.AmountCents = 0
+    for i := range refund.Items {
+        ri := &refund.Items[i]
+        var bought *models.LineItem
+        for j := range order.Items {
+            if order.Items[j].ProductID == ri.ProductID {
+                bought = &order.Items[j]
+            }
+        }
+        if bought == nil {
+            return fmt.Errorf("%w: order %s has no product %s", ErrRefundExceedsPayment, order.ID, ri.ProductID)
+        }
+        if remaining := bought.Quantity - refunded[ri.ProductID]; ri.Quantity < 1 || ri.Quantity > remaining {
+            return fmt.Errorf("%w: %d of product %s can still be refunded", ErrRefundExceedsPayment, remaining, ri.ProductID)
+        }
+        ri.AmountCents = bought.UnitPriceCents * int64(ri.Quantity)
+        refund.AmountCents += ri.AmountCents
+    }
+    if refund.AmountCents == 0 {
+        return fmt.Errorf("%w: the items were free", ErrRefundExceedsPayment)
+    }
+    if refund.AmountCents > order.TotalCents-order.RefundedCents {
+        return fmt.Errorf("%w: %d cents can still be refunded", ErrRefundExceedsPayment, order.TotalCents-order.RefundedCents)
+    }
+    return nil
+}
@@ -0,0 +1,100 @@
+// Package repository stores orders, in memory or in PostgreSQL.
+package repository
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "orderservice/models"
+    "time"
+)
+
+var (
+    ErrOrderNotFound     = errors.New("order not found")
+    ErrOrderExists       = errors.New("order already exists")
+    ErrInvalidTransition = errors.New("invalid status transition")
+    // ErrRefundExceedsPayment is returned for refunds of more than is left to refund.
+    ErrRefundExceedsPayment = errors.New("refund exceeds what is left to refund")
+    ErrRefundNotFound       = errors.New("refund not found")
+)
+
+// OrderRepository stores orders. Implementations are safe for concurrent use.
+type OrderRepository interface {
+    // CreateOrder stores a new order, sets its timestamps and records its initial status
+    // in the history, with the ordering user as the actor.
+    CreateOrder(ctx context.Context, order *models.Order) error
+    GetOrder(ctx context.Context, id string) (*models.Order, error)
+    // GetOrderByPaymentIntent returns the order the payment intent was started for.
+    GetOrderByPaymentIntent(ctx context.Context, intentID string) (*models.Order, error)
+    // UpdateStatus moves the order to status, if its current status allows it (otherwise
+    // ErrInvalidTransition), records the change and returns the updated order. An order
+    // moved to refunded, as when a dispute is lost, has all of its total refunded.
+    UpdateStatus(ctx context.Context, id, status, actor string) (*models.Order, error)
+    // StartPayment records the provider's payment intent for the order and moves pending
+    // orders to awaiting payment. Orders already awaiting payment just get the new intent;
+    // others return ErrInvalidTransition.
+    StartPayment(ctx context.Context, id, intentID, actor string) (*models.Order, error)
+    // CreateRefund reserves a pending refund of some of the order's items, or of
+    // everything not yet refunded when it has no items, and sets its amounts from the
+    // order's prices. It returns ErrInvalidTransition if the order can't be refunded, and
+    // ErrRefundExceedsPayment if an item would be refunded more than it was bought,
+    // counting pending refunds.
+    CreateRefund(ctx context.Context, refund *models.Refund) error
+    // CompleteRefund records the provider's outcome of a pending refund. A successful
+    // refund adds to the order's refunded amount and moves it to refunded or partially
+    // refunded, never counting more than the order's total as refunded; a failed one
+    // frees its amount. Completing a refund again changes nothing.
+    CompleteRefund(ctx context.Context, refundID, providerRefundID string, succeeded bool) (*models.Order, error)
+    // SetProviderRefundID records the provider's ID for a refund that is still pending
+    // with the provider, so its outcome can be looked up later.
+    SetProviderRefundID(ctx context.Context, refundID, providerRefundID string) error
+    // ListRefunds returns the order's refunds, oldest first.
+    ListRefunds(ctx context.Context, orderID string) ([]models.Refund, error)
+    // ListPendingRefunds returns up to limit refunds created before createdBefore that
+    // are still pending, oldest first and without their items.
+    ListPendingRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]models.Refund, error)
+    // ListStatusHistory returns the order's status changes, oldest first.
+    ListStatusHistory(ctx context.Context, id string) ([]models.StatusChange, error)
+    // ListOrders returns a page of the orders matching the filter, with the total number
+    // of matches. It returns ErrInvalidCursor for cursors it didn't issue for the sort.
+    ListOrders(ctx context.Context, filter OrderFilter, opts ListOptions) (*OrderPage, error)
+    // ListOrdersByUser returns all of the user's orders, oldest first.
+    ListOrdersByUser(ctx context.Context, userID string) ([]models.Order, error)
+    // AnonymizeUser unlinks the user's orders and status changes from them, and returns
+    // how many orders there were.
+    AnonymizeUser(ctx context.Context, userID string) (int, error)
+}
+
+// PaymentEventRepository stores the payment provider's webhook events. Implementations
+// are safe for concurrent use.
+type PaymentEventRepository interface {
+    // SaveEvent stores a newly received event and reports whether it was new; events
+    // the provider delivers again are only stored once.
+    SaveEvent(ctx context.Context, event *models.PaymentEvent) (bool, error)
+    // SetResult records the outcome of processing the event. Processed events are done;
+    // others stay waiting to be applied.
+    SetResult(ctx context.Context, id, result string, processed bool) error
+    // ListUnprocessed returns up to limit events waiting to be applied, in the order the
+    // provider created them. With an intent ID, only that payment intent's events.
+    ListUnprocessed(ctx context.Context, intentID string, limit int) ([]models.PaymentEvent, error)
+}
+
+// IdempotencyRepository stores requests made with idempotency keys. Implementations
+// are safe for concurrent use.
+type IdempotencyRepository interface {
+    // ClaimKey starts a request with the key. The key is claimed, and ClaimKey returns
+    // nil, if it's unused, expired, or its request has been in progress since before
+    // staleBefore (its server presumably died). Otherwise it returns the key's record.
+    ClaimKey(ctx context.Context, key, fingerprint string, expiresAt, staleBefore time.Time) (*models.IdempotencyRecord, error)
+    // CompleteKey stores the response to the key's request.
+    CompleteKey(ctx context.Context, key string, statusCode int, contentType string, body []byte) error
+    // ReleaseKey forgets a claimed key, so its request can be retried.
+    ReleaseKey(ctx context.Context, key string) error
+    // DeleteExpiredKeys removes expired keys and returns how many there were.
+    DeleteExpiredKeys(ctx context.Context) (int, error)
+}
+
+// transitionError explains why an order can't move to a status.
+func transitionError(from, to string) error {
+    return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, from, to)
+}
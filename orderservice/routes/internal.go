@@ -0,0 +1,55 @@
+package routes
+
+import (
+    "encoding/json"
+    "net/http"
+    "orderservice/logging"
+    "orderservice/models"
+    "orderservice/repository"
+
+    "github.com/gorilla/mux"
+)
+
+// RegisterInternal adds the service-to-service endpoints used by authservice for
+// data export and account erasure. They require "Authorization: Bearer <token>".
+func RegisterInternal(r *mux.Router, token string, orders repository.OrderRepository) {
+    h := &handler{orders: orders}
+    r.Handle("/internal/users/{userID}/export", logging.RequireToken(token, http.HandlerFunc(h.exportUserData))).Methods("GET")
+    r.Handle("/internal/user-deletions", logging.RequireToken(token, http.HandlerFunc(h.deleteUserData))).Methods("POST")
+}
+
+func (h *handler) exportUserData(w http.ResponseWriter, r *http.Request) {
+    userID := mux.Vars(r)["userID"]
+    userOrders, err := h.orders.ListOrdersByUser(r.Context(), userID)
+    if err != nil {
+        serverError(w, r, "Error listing orders for export", err)
+        return
+    }
+    if userOrders == nil {
+        userOrders = []models.Order{}
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{"orders": userOrders})
+}
+
+// deleteUserData unlinks the user's orders. The orders themselves are kept for
+// bookkeeping; without the user ID they no longer identify anyone. Repeating the
+// request is harmless.
+func (h *handler) deleteUserData(w http.ResponseWriter, r *http.Request) {
+    var event struct {
+        ErasureID int64  `json:"erasure_id"`
+        UserID    string `json:"user_id"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event.UserID == "" {
+        respondWithError(w, http.StatusBadRequest, "invalid deletion event")
+        return
+    }
+
+    anonymized, err := h.orders.AnonymizeUser(r.Context(), event.UserID)
+    if err != nil {
+        serverError(w, r, "Error anonymizing orders", err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{"erasure_id": event.ErasureID, "orders_anonymized": anonymized})
+}
@@ -0,0 +1,136 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "orderservice/auth"
+    "orderservice/models"
+    "orderservice/repository"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    defaultPageSize = 20
+    maxPageSize     = 100
+)
+
+// listOrders lists the user's own orders. Query parameters:
+//
+//	status      one or more statuses, comma-separated or repeated
+//	product_id  orders containing the product
+//	from, to    creation time range, as RFC 3339 times or dates (to is inclusive for dates)
+//	sort        -created_at (default), created_at, -total_cents or total_cents
+//	limit       page size, 1 to 100 (default 20)
+//	cursor      next_cursor from the previous page
+func (h *handler) listOrders(w http.ResponseWriter, r *http.Request) {
+    claims, _ := auth.FromContext(r.Context())
+    filter, opts, err := parseListQuery(r.URL.Query())
+    if err != nil {
+        respondWithError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    filter.UserID = claims.Subject()
+    h.respondWithOrders(w, r, filter, opts)
+}
+
+// adminListOrders lists orders across all users, for staff. It takes the same query
+// parameters as listOrders, plus user_id to narrow it to one user.
+func (h *handler) adminListOrders(w http.ResponseWriter, r *http.Request) {
+    claims, _ := auth.FromContext(r.Context())
+    if !claims.IsStaff() {
+        respondWithError(w, http.StatusForbidden, "only staff can list all orders")
+        return
+    }
+    filter, opts, err := parseListQuery(r.URL.Query())
+    if err != nil {
+        respondWithError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    filter.UserID = r.URL.Query().Get("user_id")
+    h.respondWithOrders(w, r, filter, opts)
+}
+
+func (h *handler) respondWithOrders(w http.ResponseWriter, r *http.Request, filter repository.OrderFilter, opts repository.ListOptions) {
+    page, err := h.orders.ListOrders(r.Context(), filter, opts)
+    if err != nil {
+        if errors.Is(err, repository.ErrInvalidCursor) {
+            respondWithError(w, http.StatusBadRequest, "invalid cursor")
+            return
+        }
+        serverError(w, r, "Error listing orders", err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(page)
+}
+
+// parseListQuery reads the filter and paging parameters shared by the order listings.
+func parseListQuery(q url.Values) (repository.OrderFilter, repository.ListOptions, error) {
+    var filter repository.OrderFilter
+    opts := repository.ListOptions{Sort: repository.SortNewest, Limit: defaultPageSize, Cursor: q.Get("cursor")}
+
+    for _, value := range q["status"] {
+        for _, status := range strings.Split(value, ",") {
+            if status = strings.TrimSpace(status); status == "" {
+                continue
+            }
+            if !models.ValidStatus(status) {
+                return filter, opts, fmt.Errorf("unknown status %q", status)
+            }
+            filter.Statuses = append(filter.Statuses, status)
+        }
+    }
+    filter.ProductID = q.Get("product_id")
+
+    var err error
+    if v := q.Get("from"); v != "" {
+        if filter.CreatedFrom, err = parseTimeParam(v, false); err != nil {
+            return filter, opts, fmt.Errorf("invalid from: %w", err)
+        }
+    }
+    if v := q.Get("to"); v != "" {
+        if filter.CreatedTo, err = parseTimeParam(v, true); err != nil {
+            return filter, opts, fmt.Errorf("invalid to: %w", err)
+        }
+    }
+    if !filter.CreatedFrom.IsZero() && !filter.CreatedTo.IsZero() && !filter.CreatedFrom.Before(filter.CreatedTo) {
+        return filter, opts, errors.New("from must be before to")
+    }
+
+    if v := q.Get("sort"); v != "" {
+        sort, ok := repository.ParseOrderSort(v)
+        if !ok {
+            return filter, opts, fmt.Errorf("unknown sort %q", v)
+        }
+        opts.Sort = sort
+    }
+    if v := q.Get("limit"); v != "" {
+        limit, err := strconv.Atoi(v)
+        if err != nil || limit < 1 || limit > maxPageSize {
+            return filter, opts, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+        }
+        opts.Limit = limit
+    }
+    return filter, opts, nil
+}
+
+// parseTimeParam parses an RFC 3339 time or a date. A date as the end of a range means
+// the end of that day, so the whole day is included.
+func parseTimeParam(v string, end bool) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339, v); err == nil {
+        return t, nil
+    }
+    t, err := time.Parse(time.DateOnly, v)
+    if err != nil {
+        return time.Time{}, errors.New("expected an RFC 3339 time or a YYYY-MM-DD date")
+    }
+    if end {
+        t = t.AddDate(0, 0, 1)
+    }
+    return t, nil
+}
@@ -0,0 +1,119 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+    "orderservice/models"
+    "orderservice/payments"
+    "orderservice/repository"
+
+    "github.com/gorilla/mux"
+)
+
+// paymentActor is recorded in the history for status changes confirmed by the provider.
+const paymentActor = "payments"
+
+// startPayment creates a payment intent for the order's total with the provider and
+// responds with it; the customer completes it with the provider using its client
+// secret. Repeating the request returns the same intent while it can still be paid.
+func (h *handler) startPayment(w http.ResponseWriter, r *http.Request) {
+    order, ok := h.loadOrder(w, r, mux.Vars(r)["id"], false)
+    if !ok {
+        return
+    }
+    if order.Status != models.StatusPending && order.Status != models.StatusAwaitingPayment {
+        respondWithError(w, http.StatusConflict, "order is "+order.Status+", not awaiting payment")
+        return
+    }
+    if order.TotalCents <= 0 {
+        respondWithError(w, http.StatusConflict, "order has nothing to pay")
+        return
+    }
+
+    if order.PaymentIntentID != "" {
+        intent, err := h.payments.GetIntent(r.Context(), order.PaymentIntentID)
+        if err != nil && !errors.Is(err, payments.ErrIntentNotFound) {
+            providerError(w, r, err)
+            return
+        }
+        if err == nil && intent.Status != payments.StatusCanceled {
+            json.NewEncoder(w).Encode(intent)
+            return
+        }
+    }
+
+    intent, err := h.payments.CreateIntent(r.Context(), payments.IntentRequest{
+        OrderID:     order.ID,
+        AmountCents: order.TotalCents,
+        Currency:    h.currency,
+        Replaces:    order.PaymentIntentID,
+    })
+    if err != nil {
+        providerError(w, r, err)
+        return
+    }
+    if _, err := h.orders.StartPayment(r.Context(), order.ID, intent.ID, order.UserID); err != nil {
+        if errors.Is(err, repository.ErrInvalidTransition) {
+            respondWithError(w, http.StatusConflict, err.Error())
+            return
+        }
+        serverError(w, r, "Error starting payment", err)
+        return
+    }
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(intent)
+}
+
+// confirmPayment marks one of the user's orders as paid, once the provider confirms its
+// payment intent succeeded for the order's total. The client calls it after completing
+// the payment; it's safe to repeat.
+func (h *handler) confirmPayment(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        OrderID string `json:"order_id"`
+    }
+    if !decodeJSON(w, r, &req) {
+        return
+    }
+    if req.OrderID == "" {
+        respondWithValidationError(w, "invalid payment", fieldError{Field: "order_id", Message: "is required"})
+        return
+    }
+    order, ok := h.loadOrder(w, r, req.OrderID, false)
+    if !ok {
+        return
+    }
+    if order.Status == models.StatusPaid {
+        json.NewEncoder(w).Encode(order)
+        return
+    }
+    if order.PaymentIntentID == "" {
+        respondWithError(w, http.StatusConflict, "no payment has been started for the order")
+        return
+    }
+
+    intent, err := h.payments.GetIntent(r.Context(), order.PaymentIntentID)
+    if err != nil {
+        providerError(w, r, err)
+        return
+    }
+    if err := intent.CheckPays(order.ID, order.TotalCents, h.currency); err != nil {
+        slog.ErrorContext(r.Context(), "Payment intent doesn't match order", "order_id", order.ID, "error", err)
+        respondWithError(w, http.StatusConflict, "payment doesn't match the order")
+        return
+    }
+    if intent.Status != payments.StatusSucceeded {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusPaymentRequired)
+        json.NewEncoder(w).Encode(map[string]string{"error": "payment not completed", "payment_status": intent.Status})
+        return
+    }
+    h.setStatus(w, r, order.ID, models.StatusPaid, paymentActor)
+}
+
+// providerError logs err and responds with 502, as the provider failed the request.
+func providerError(w http.ResponseWriter, r *http.Request, err error) {
+    slog.ErrorContext(r.Context(), "Error calling payment provider", "error", err)
+    respondWithError(w, http.StatusBadGateway, "payment provider unavailable")
+}
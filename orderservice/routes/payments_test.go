@@ -0,0 +1,248 @@
+package routes
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "orderservice/auth"
+    "orderservice/payments"
+    "testing"
+    "time"
+)
+
+// startPayment starts paying the order and returns the intent.
+func (s *testServer) startPayment(token, orderID string, wantStatus int) payments.Intent {
+    s.t.Helper()
+    var intent payments.Intent
+    if rec := s.do("POST", "/orders/"+orderID+"/payment", token, nil, &intent); rec.Code != wantStatus {
+        s.t.Fatalf("starting the payment returned %d %s, want %d", rec.Code, rec.Body.String(), wantStatus)
+    }
+    return intent
+}
+
+// fakeAPI calls the fake provider directly: with the client secret, as the customer's
+// browser does, or else with the secret key.
+func (s *testServer) fakeAPI(path string, form url.Values) int {
+    s.t.Helper()
+    req, _ := http.NewRequest(http.MethodPost, s.fake.URL+path, bytes.NewBufferString(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    if form.Get("client_secret") == "" {
+        req.Header.Set("Authorization", "Bearer "+testKey)
+    }
+    resp, err := s.fake.Client().Do(req)
+    if err != nil {
+        s.t.Fatal(err)
+    }
+    resp.Body.Close()
+    return resp.StatusCode
+}
+
+// pay completes the intent with the test card, as the customer's browser would.
+func (s *testServer) pay(intent payments.Intent, card string) int {
+    s.t.Helper()
+    return s.fakeAPI("/v1/payment_intents/"+intent.ID+"/confirm", url.Values{"payment_method": {card}, "client_secret": {intent.ClientSecret}})
+}
+
+// paidOrder creates an order for the user and pays it.
+func (s *testServer) paidOrder(token string) order {
+    s.t.Helper()
+    o := s.createOrder(token)
+    s.pay(s.startPayment(token, o.ID, http.StatusCreated), payments.FakeCardSucceeds)
+    var paid order
+    if rec := s.do("POST", "/payments", token, map[string]string{"order_id": o.ID}, &paid); rec.Code != http.StatusOK || paid.Status != "paid" {
+        s.t.Fatalf("confirming the payment returned %d %s", rec.Code, rec.Body.String())
+    }
+    return paid
+}
+
+func TestPayment(t *testing.T) {
+    s := newTestServer(t)
+    customer := s.token(7, "", nil)
+    o := s.createOrder(customer)
+
+    intent := s.startPayment(customer, o.ID, http.StatusCreated)
+    if intent.OrderID != o.ID || intent.AmountCents != 3200 || intent.Currency != "usd" || intent.ClientSecret == "" {
+        t.Fatalf("intent = %+v", intent)
+    }
+    if again := s.startPayment(customer, o.ID, http.StatusOK); again.ID != intent.ID {
+        t.Errorf("starting again gave intent %s, want %s", again.ID, intent.ID)
+    }
+
+    var declined map[string]string
+    if rec := s.do("POST", "/payments", customer, map[string]string{"order_id": o.ID}, &declined); rec.Code != http.StatusPaymentRequired || declined["payment_status"] != payments.StatusRequiresPaymentMethod {
+        t.Errorf("confirming before paying returned %d %v, want 402", rec.Code, declined)
+    }
+    if code := s.pay(intent, payments.FakeCardDeclined); code != http.StatusPaymentRequired {
+        t.Errorf("declined card returned %d", code)
+    }
+    if code := s.pay(intent, payments.FakeCardSucceeds); code != http.StatusOK {
+        t.Fatalf("paying returned %d", code)
+    }
+
+    var paid order
+    if rec := s.do("POST", "/payments", customer, map[string]string{"order_id": o.ID}, &paid); rec.Code != http.StatusOK || paid.Status != "paid" {
+        t.Fatalf("confirming returned %d %+v", rec.Code, paid)
+    }
+    if rec := s.do("POST", "/payments", customer, map[string]string{"order_id": o.ID}, &paid); rec.Code != http.StatusOK || paid.Status != "paid" {
+        t.Errorf("confirming again returned %d %+v", rec.Code, paid)
+    }
+    if rec := s.do("POST", "/orders/"+o.ID+"/payment", customer, nil, nil); rec.Code != http.StatusConflict {
+        t.Errorf("starting a payment of a paid order returned %d, want 409", rec.Code)
+    }
+}
+
+func TestCanceledIntentIsReplaced(t *testing.T) {
+    s := newTestServer(t)
+    customer := s.token(7, "", nil)
+    o := s.createOrder(customer)
+
+    intent := s.startPayment(customer, o.ID, http.StatusCreated)
+    if code := s.fakeAPI("/v1/payment_intents/"+intent.ID+"/cancel", nil); code != http.StatusOK {
+        t.Fatalf("canceling returned %d", code)
+    }
+    replacement := s.startPayment(customer, o.ID, http.StatusCreated)
+    if replacement.ID == intent.ID || replacement.Status != payments.StatusRequiresPaymentMethod {
+        t.Fatalf("replacement = %+v, want a new intent awaiting payment", replacement)
+    }
+    var current order
+    s.do("GET", "/orders/"+o.ID, customer, nil, &current)
+    if current.PaymentIntentID != replacement.ID {
+        t.Errorf("order's intent = %s, want %s", current.PaymentIntentID, replacement.ID)
+    }
+}
+
+func TestPaymentsNeedTheUsersOwnToken(t *testing.T) {
+    s := newTestServer(t)
+    o := s.createOrder(s.token(7, "", nil))
+    impersonating := s.token(7, "", func(c *auth.Claims) { c.Act = &auth.Actor{Subject: "1", UserID: 1} })
+
+    for _, path := range []string{"/orders/" + o.ID + "/payment", "/orders/" + o.ID + "/refunds"} {
+        if rec := s.do("POST", path, impersonating, map[string]interface{}{}, nil); rec.Code != http.StatusForbidden {
+            t.Errorf("POST %s with a delegated token returned %d, want 403", path, rec.Code)
+        }
+    }
+}
+
+// webhook delivers a signed event about the intent to the webhook endpoint.
+func (s *testServer) webhook(id, typ string, object map[string]interface{}, secret string) *httptest.ResponseRecorder {
+    s.t.Helper()
+    payload, _ := json.Marshal(map[string]interface{}{"id": id, "type": typ, "created": time.Now().Unix(), "data": map[string]interface{}{"object": object}})
+    req := httptest.NewRequest("POST", "/payments/webhook", bytes.NewReader(payload))
+    req.Header.Set("Stripe-Signature", payments.SignWebhook(payload, secret, time.Now()))
+    rec := httptest.NewRecorder()
+    s.router.ServeHTTP(rec, req)
+    return rec
+}
+
+func TestWebhooks(t *testing.T) {
+    s := newTestServer(t)
+    customer := s.token(7, "", nil)
+    o := s.createOrder(customer)
+    intent := s.startPayment(customer, o.ID, http.StatusCreated)
+    succeeded := map[string]interface{}{"id": intent.ID, "amount": 3200, "currency": "usd", "metadata": map[string]string{"order_id": o.ID}}
+
+    if rec := s.webhook("evt_1", payments.EventPaymentSucceeded, succeeded, "whsec_wrong"); rec.Code != http.StatusBadRequest {
+        t.Errorf("wrongly signed webhook returned %d, want 400", rec.Code)
+    }
+    var current order
+    s.do("GET", "/orders/"+o.ID, customer, nil, &current)
+    if current.Status != "awaiting_payment" {
+        t.Fatalf("status after a forged webhook = %s", current.Status)
+    }
+
+    var ack struct {
+        Received  bool `json:"received"`
+        Duplicate bool `json:"duplicate"`
+    }
+    if rec := s.webhook("evt_1", payments.EventPaymentSucceeded, succeeded, testWebhookSecret); rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &ack) != nil || ack.Duplicate {
+        t.Fatalf("webhook returned %d %s", rec.Code, rec.Body.String())
+    }
+    s.do("GET", "/orders/"+o.ID, customer, nil, &current)
+    if current.Status != "paid" {
+        t.Errorf("status after the webhook = %s, want paid", current.Status)
+    }
+    if rec := s.webhook("evt_1", payments.EventPaymentSucceeded, succeeded, testWebhookSecret); rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &ack) != nil || !ack.Duplicate {
+        t.Errorf("redelivered webhook returned %d %s, want a duplicate", rec.Code, rec.Body.String())
+    }
+
+    dispute := map[string]interface{}{"id": "dp_1", "payment_intent": intent.ID, "amount": 3200, "currency": "usd", "status": "needs_response"}
+    s.webhook("evt_2", payments.EventDisputeCreated, dispute, testWebhookSecret)
+    dispute["status"] = payments.DisputeLost
+    s.webhook("evt_3", payments.EventDisputeClosed, dispute, testWebhookSecret)
+    s.do("GET", "/orders/"+o.ID, customer, nil, &current)
+    if current.Status != "refunded" || current.RefundedCents != current.TotalCents {
+        t.Errorf("order after a lost dispute = %+v, want refunded in full", current)
+    }
+}
+
+func TestRefunds(t *testing.T) {
+    s := newTestServer(t)
+    customer := s.token(7, "", nil)
+    staff := s.token(1, auth.RoleSupport, nil)
+    o := s.paidOrder(customer)
+
+    if rec := s.do("POST", "/orders/"+o.ID+"/refunds", customer, map[string]interface{}{}, nil); rec.Code != http.StatusForbidden {
+        t.Errorf("customer refunding returned %d, want 403", rec.Code)
+    }
+
+    var result struct {
+        Refund struct {
+            AmountCents      int64  `json:"amount_cents"`
+            Status           string `json:"status"`
+            ProviderRefundID string `json:"provider_refund_id"`
+        } `json:"refund"`
+        Order order `json:"order"`
+    }
+    mug := map[string]interface{}{"items": []map[string]interface{}{{"product_id": "p1", "quantity": 1}}, "reason": "broken"}
+    rec := s.do("POST", "/orders/"+o.ID+"/refunds", staff, mug, &result)
+    if rec.Code != http.StatusCreated || result.Refund.AmountCents != 1200 || result.Refund.Status != "succeeded" || result.Refund.ProviderRefundID == "" {
+        t.Fatalf("refunding a mug returned %d %s", rec.Code, rec.Body.String())
+    }
+    if result.Order.Status != "partially_refunded" || result.Order.RefundedCents != 1200 {
+        t.Errorf("order after a partial refund = %+v", result.Order)
+    }
+
+    tooMany := map[string]interface{}{"items": []map[string]interface{}{{"product_id": "p1", "quantity": 2}}}
+    if rec := s.do("POST", "/orders/"+o.ID+"/refunds", staff, tooMany, nil); rec.Code != http.StatusUnprocessableEntity {
+        t.Errorf("refunding more mugs than are left returned %d, want 422", rec.Code)
+    }
+
+    rec = s.do("POST", "/orders/"+o.ID+"/refunds", staff, map[string]interface{}{}, &result)
+    if rec.Code != http.StatusCreated || result.Refund.AmountCents != 2000 || result.Order.Status != "refunded" || result.Order.RefundedCents != 3200 {
+        t.Fatalf("refunding the rest returned %d %s", rec.Code, rec.Body.String())
+    }
+    if rec := s.do("POST", "/orders/"+o.ID+"/refunds", staff, map[string]interface{}{}, nil); rec.Code != http.StatusConflict {
+        t.Errorf("refunding a refunded order returned %d, want 409", rec.Code)
+    }
+
+    var refunds []struct {
+        Status string `json:"status"`
+    }
+    if rec := s.do("GET", "/orders/"+o.ID+"/refunds", customer, nil, &refunds); rec.Code != http.StatusOK || len(refunds) != 2 {
+        t.Errorf("listing refunds returned %d %s", rec.Code, rec.Body.String())
+    }
+}
+
+func TestRefundPendingWhileProviderIsDown(t *testing.T) {
+    s := newTestServer(t)
+    customer := s.token(7, "", nil)
+    o := s.paidOrder(customer)
+    s.fake.Close()
+
+    var result struct {
+        Refund struct {
+            Status string `json:"status"`
+        } `json:"refund"`
+        Order order `json:"order"`
+    }
+    rec := s.do("POST", "/orders/"+o.ID+"/refunds", s.token(1, auth.RoleAdmin, nil), map[string]interface{}{}, &result)
+    if rec.Code != http.StatusAccepted || result.Refund.Status != "pending" || result.Order.Status != "paid" {
+        t.Errorf("refund while the provider is down returned %d %s, want it pending", rec.Code, rec.Body.String())
+    }
+    // The pending refund keeps its amount reserved
+    if rec := s.do("POST", "/orders/"+o.ID+"/refunds", s.token(1, auth.RoleAdmin, nil), map[string]interface{}{}, nil); rec.Code != http.StatusUnprocessableEntity {
+        t.Errorf("second refund of everything returned %d, want 422", rec.Code)
+    }
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "orderservice/auth"
+    "orderservice/models"
+    "orderservice/repository"
+
+    "github.com/gorilla/mux"
+)
+
+// maxRefundReasonLength bounds the free-text reason kept with a refund.
+const maxRefundReasonLength = 500
+
+// createRefund gives back some of an order's line items, or everything not yet
+// refunded when no items are given, through the payment provider. Only staff can issue
+// refunds. The refund is reserved before the provider is called, so concurrent refunds
+// can't add up to more than was paid. It responds with 202 while the refund is pending
+// with the provider, which happens when its outcome isn't known yet.
+func (h *handler) createRefund(w http.ResponseWriter, r *http.Request) {
+    claims, _ := auth.FromContext(r.Context())
+    if !claims.IsStaff() {
+        respondWithError(w, http.StatusForbidden, "only staff can refund orders")
+        return
+    }
+
+    var req struct {
+        Items []struct {
+            ProductID string `json:"product_id"`
+            Quantity  int    `json:"quantity"`
+        } `json:"items"`
+        Reason string `json:"reason"`
+    }
+    if !decodeJSON(w, r, &req) {
+        return
+    }
+
+    refund := &models.Refund{ID: models.NewRefundID(), OrderID: mux.Vars(r)["id"], Reason: req.Reason, Actor: claims.Subject()}
+    positions := make(map[string]int) // Product ID -> index in refund.Items, to merge repeated products
+    var invalid []fieldError
+    if len(req.Reason) > maxRefundReasonLength {
+        invalid = append(invalid, fieldError{Field: "reason", Message: fmt.Sprintf("must be at most %d characters", maxRefundReasonLength)})
+    }
+    for n, item := range req.Items {
+        field := fmt.Sprintf("items[%d]", n)
+        if item.ProductID == "" {
+            invalid = append(invalid, fieldError{Field: field + ".product_id", Message: "is required"})
+        }
+        if item.Quantity < 1 {
+            invalid = append(invalid, fieldError{Field: field + ".quantity", Message: "must be at least 1"})
+        }
+        if item.ProductID == "" || item.Quantity < 1 {
+            continue
+        }
+        if i, seen := positions[item.ProductID]; seen {
+            refund.Items[i].Quantity += item.Quantity
+            continue
+        }
+        positions[item.ProductID] = len(refund.Items)
+        refund.Items = append(refund.Items, models.RefundItem{ProductID: item.ProductID, Quantity: item.Quantity})
+    }
+    if len(invalid) > 0 {
+        respondWithValidationError(w, "invalid refund", invalid...)
+        return
+    }
+
+    order, ok := h.loadOrder(w, r, refund.OrderID, true)
+    if !ok {
+        return
+    }
+    if order.PaymentIntentID == "" {
+        respondWithError(w, http.StatusConflict, "order wasn't paid through the payment provider")
+        return
+    }
+    if err := h.orders.CreateRefund(r.Context(), refund); err != nil {
+        switch {
+        case errors.Is(err, repository.ErrInvalidTransition):
+            respondWithError(w, http.StatusConflict, "order is "+order.Status+" and can't be refunded")
+        case errors.Is(err, repository.ErrRefundExceedsPayment):
+            respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+        default:
+            serverError(w, r, "Error creating refund", err)
+        }
+        return
+    }
+
+    order, err := h.refunds.Send(r.Context(), refund, order.PaymentIntentID)
+    if err != nil {
+        // The refund stays pending and keeps its amount reserved until it's retried
+        serverError(w, r, "Error recording refund", err)
+        return
+    }
+    switch refund.Status {
+    case models.RefundFailed:
+        respondWithError(w, http.StatusBadGateway, "payment provider didn't make the refund")
+        return
+    case models.RefundPending:
+        // The provider hasn't confirmed it yet; it's retried with the same refund ID
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusAccepted)
+    default:
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusCreated)
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{"refund": refund, "order": order})
+}
+
+// listRefunds lists the order's refunds, oldest first, to its owner and staff.
+func (h *handler) listRefunds(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+    if _, ok := h.loadOrder(w, r, id, true); !ok {
+        return
+    }
+    refunds, err := h.orders.ListRefunds(r.Context(), id)
+    if err != nil {
+        if errors.Is(err, repository.ErrOrderNotFound) {
+            respondWithError(w, http.StatusNotFound, "order not found")
+            return
+        }
+        serverError(w, r, "Error listing refunds", err)
+        return
+    }
+    json.NewEncoder(w).Encode(refunds)
+}
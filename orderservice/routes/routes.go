@@ -2,60 +2,243 @@ package routes
 
 import (
     "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
     "net/http"
+    "orderservice/auth"
+    "orderservice/catalog"
+    "orderservice/logging"
     "orderservice/models"
+    "orderservice/payments"
+    "orderservice/reconcile"
+    "orderservice/repository"
+    "orderservice/telemetry"
 
     "github.com/gorilla/mux"
 )
 
-var orders = make(map[string]models.Order)
+// maxItemQuantity bounds a line item's quantity, keeping totals far from overflow.
+const maxItemQuantity = 10000
 
-func SetupRouter() *mux.Router {
+// handler serves the order endpoints from its repository.
+type handler struct {
+    orders   repository.OrderRepository
+    products catalog.Catalog
+    payments payments.PaymentProvider
+    events   *reconcile.Processor
+    refunds  *reconcile.Refunds
+    currency string // Currency of order amounts, as an ISO 4217 code in lower case
+}
+
+// SetupRouter creates the router for the public API. Every endpoint needs an access
+// token from authservice, with the orders:read or orders:write scope when it's scoped;
+// customers only see their own orders. Mutating endpoints go through idempotent, which
+// replays responses to retried requests.
+func SetupRouter(orders repository.OrderRepository, products catalog.Catalog, provider payments.PaymentProvider, refunds *reconcile.Refunds, currency string, verifier *auth.Verifier, idempotent func(http.Handler) http.Handler) *mux.Router {
+    h := &handler{orders: orders, products: products, payments: provider, refunds: refunds, currency: currency}
+    authn := auth.Middleware(verifier)
+    reading := func(f http.HandlerFunc) http.Handler { return authn(auth.RequireScope(auth.ScopeOrdersRead)(f)) }
+    mutating := func(f http.HandlerFunc) http.Handler {
+        return authn(auth.RequireScope(auth.ScopeOrdersWrite)(idempotent(f)))
+    }
+    // Payments and refunds move money, so they need the user's (or staff member's) own token
+    direct := func(f http.HandlerFunc) http.Handler {
+        return authn(auth.RejectDelegated(auth.RequireScope(auth.ScopeOrdersWrite)(idempotent(f))))
+    }
     r := mux.NewRouter()
-    r.HandleFunc("/orders", createOrder).Methods("POST")
-    r.HandleFunc("/orders/{id}", getOrder).Methods("GET")
-    r.HandleFunc("/orders/{id}/status", updateStatus).Methods("PUT")
-    r.HandleFunc("/payments", mockPayment).Methods("POST")
+    r.Use(telemetry.RouteTagger)
+    r.Use(logging.RouteTagger)
+    // The internal endpoints on the same router use their own token, so authn wraps
+    // each public handler instead of the whole router
+    r.Handle("/orders", mutating(h.createOrder)).Methods("POST")
+    r.Handle("/orders", reading(h.listOrders)).Methods("GET")
+    r.Handle("/orders/{id}", reading(h.getOrder)).Methods("GET")
+    r.Handle("/orders/{id}/status", mutating(h.updateStatus)).Methods("PUT")
+    r.Handle("/orders/{id}/history", reading(h.getHistory)).Methods("GET")
+    r.Handle("/orders/{id}/payment", direct(h.startPayment)).Methods("POST")
+    r.Handle("/payments", direct(h.confirmPayment)).Methods("POST")
+    r.Handle("/orders/{id}/refunds", direct(h.createRefund)).Methods("POST")
+    r.Handle("/orders/{id}/refunds", reading(h.listRefunds)).Methods("GET")
+    r.Handle("/admin/orders", reading(h.adminListOrders)).Methods("GET")
     return r
 }
 
-func createOrder(w http.ResponseWriter, r *http.Request) {
-    var o models.Order
-    json.NewDecoder(r.Body).Decode(&o)
-    orders[o.ID] = o
+// createOrder places an order for the token's user. Clients only choose products and
+// quantities; the order's ID, names, prices and timestamps are set by the service, and
+// names and prices are kept with the order as it was placed.
+func (h *handler) createOrder(w http.ResponseWriter, r *http.Request) {
+    claims, _ := auth.FromContext(r.Context())
+    var req struct {
+        Items []struct {
+            ProductID string `json:"product_id"`
+            Quantity  int    `json:"quantity"`
+        } `json:"items"`
+    }
+    if !decodeJSON(w, r, &req) {
+        return
+    }
+
+    // Orders always start at the beginning of the lifecycle
+    o := models.Order{ID: models.NewOrderID(), UserID: claims.Subject(), Status: models.StatusPending}
+    positions := make(map[string]int) // Product ID -> index in o.Items, to merge repeated products
+    var invalid []fieldError
+    if len(req.Items) == 0 {
+        invalid = append(invalid, fieldError{Field: "items", Message: "an order needs at least one item"})
+    }
+    for n, item := range req.Items {
+        field := fmt.Sprintf("items[%d]", n)
+        if item.ProductID == "" {
+            invalid = append(invalid, fieldError{Field: field + ".product_id", Message: "is required"})
+        }
+        if item.Quantity < 1 || item.Quantity > maxItemQuantity {
+            invalid = append(invalid, fieldError{Field: field + ".quantity", Message: fmt.Sprintf("must be between 1 and %d", maxItemQuantity)})
+            continue
+        }
+        if item.ProductID == "" {
+            continue
+        }
+        if i, seen := positions[item.ProductID]; seen {
+            if o.Items[i].Quantity += item.Quantity; o.Items[i].Quantity > maxItemQuantity {
+                invalid = append(invalid, fieldError{Field: field + ".quantity", Message: fmt.Sprintf("at most %d of a product per order", maxItemQuantity)})
+            }
+            continue
+        }
+        positions[item.ProductID] = len(o.Items)
+        o.Items = append(o.Items, models.LineItem{ProductID: item.ProductID, Quantity: item.Quantity})
+    }
+    if len(invalid) > 0 {
+        respondWithValidationError(w, "invalid order", invalid...)
+        return
+    }
+
+    for i := range o.Items {
+        item := &o.Items[i]
+        product, err := h.products.GetProduct(r.Context(), item.ProductID)
+        if err != nil {
+            if errors.Is(err, catalog.ErrProductNotFound) {
+                respondWithError(w, http.StatusUnprocessableEntity, "unknown product "+item.ProductID)
+                return
+            }
+            slog.ErrorContext(r.Context(), "Error fetching product", "product_id", item.ProductID, "error", err)
+            respondWithError(w, http.StatusBadGateway, "product catalog unavailable")
+            return
+        }
+        item.Name = product.Name
+        item.UnitPriceCents = product.PriceCents
+    }
+    o.CalculateTotals()
+
+    if err := h.orders.CreateOrder(r.Context(), &o); err != nil {
+        if errors.Is(err, repository.ErrOrderExists) {
+            respondWithError(w, http.StatusConflict, "order already exists")
+            return
+        }
+        serverError(w, r, "Error creating order", err)
+        return
+    }
     w.WriteHeader(http.StatusCreated)
     json.NewEncoder(w).Encode(o)
 }
 
-func getOrder(w http.ResponseWriter, r *http.Request) {
-    id := mux.Vars(r)["id"]
-    order, exists := orders[id]
-    if !exists {
-        w.WriteHeader(http.StatusNotFound)
+// getOrder returns one of the user's orders.
+func (h *handler) getOrder(w http.ResponseWriter, r *http.Request) {
+    order, ok := h.loadOrder(w, r, mux.Vars(r)["id"], false)
+    if !ok {
         return
     }
     json.NewEncoder(w).Encode(order)
 }
 
-func updateStatus(w http.ResponseWriter, r *http.Request) {
+// updateStatus moves an order through its lifecycle. Only staff may do this directly,
+// and only to statuses that don't depend on the payment provider.
+func (h *handler) updateStatus(w http.ResponseWriter, r *http.Request) {
+    claims, _ := auth.FromContext(r.Context())
+    if !claims.IsStaff() {
+        respondWithError(w, http.StatusForbidden, "only staff can change an order's status")
+        return
+    }
+
     id := mux.Vars(r)["id"]
     var update struct {
         Status string `json:"status"`
     }
-    json.NewDecoder(r.Body).Decode(&update)
-    order := orders[id]
-    order.Status = update.Status
-    orders[id] = order
-    json.NewEncoder(w).Encode(order)
+    if !decodeJSON(w, r, &update) {
+        return
+    }
+    if !models.ValidStatus(update.Status) {
+        respondWithValidationError(w, "invalid status update", fieldError{Field: "status", Message: "unknown status"})
+        return
+    }
+    if models.SetByProvider(update.Status) {
+        respondWithValidationError(w, "invalid status update", fieldError{Field: "status", Message: "is set by the payment provider"})
+        return
+    }
+    h.setStatus(w, r, id, update.Status, claims.Subject())
 }
 
-func mockPayment(w http.ResponseWriter, r *http.Request) {
-    var req struct {
-        OrderID string `json:"order_id"`
+// getHistory lists the order's status changes, oldest first, to its owner and staff.
+func (h *handler) getHistory(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+    if _, ok := h.loadOrder(w, r, id, true); !ok {
+        return
+    }
+    history, err := h.orders.ListStatusHistory(r.Context(), id)
+    if err != nil {
+        if errors.Is(err, repository.ErrOrderNotFound) {
+            respondWithError(w, http.StatusNotFound, "order not found")
+            return
+        }
+        serverError(w, r, "Error listing order history", err)
+        return
+    }
+    if history == nil {
+        history = []models.StatusChange{}
+    }
+    json.NewEncoder(w).Encode(history)
+}
+
+// loadOrder gets an order the token's user may see: their own, or any order for staff
+// when staffAllowed. Other users' orders are reported as not found, so order IDs can't
+// be probed. It responds itself when the order can't be returned.
+func (h *handler) loadOrder(w http.ResponseWriter, r *http.Request, id string, staffAllowed bool) (*models.Order, bool) {
+    claims, _ := auth.FromContext(r.Context())
+    order, err := h.orders.GetOrder(r.Context(), id)
+    if err != nil {
+        if errors.Is(err, repository.ErrOrderNotFound) {
+            respondWithError(w, http.StatusNotFound, "order not found")
+            return nil, false
+        }
+        serverError(w, r, "Error getting order", err)
+        return nil, false
+    }
+    if order.UserID != claims.Subject() && !(staffAllowed && claims.IsStaff()) {
+        respondWithError(w, http.StatusNotFound, "order not found")
+        return nil, false
+    }
+    return order, true
+}
+
+// setStatus moves the order to status and responds with the order.
+func (h *handler) setStatus(w http.ResponseWriter, r *http.Request, id, status, actor string) {
+    order, err := h.orders.UpdateStatus(r.Context(), id, status, actor)
+    if err != nil {
+        if errors.Is(err, repository.ErrOrderNotFound) {
+            respondWithError(w, http.StatusNotFound, "order not found")
+            return
+        }
+        if errors.Is(err, repository.ErrInvalidTransition) {
+            respondWithError(w, http.StatusConflict, err.Error())
+            return
+        }
+        serverError(w, r, "Error updating order status", err)
+        return
     }
-    json.NewDecoder(r.Body).Decode(&req)
-    order := orders[req.OrderID]
-    order.Status = "paid"
-    orders[req.OrderID] = order
     json.NewEncoder(w).Encode(order)
 }
+
+// serverError logs err and responds with a generic 500.
+func serverError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+    slog.ErrorContext(r.Context(), msg, "error", err)
+    respondWithError(w, http.StatusInternalServerError, "internal server error")
+}
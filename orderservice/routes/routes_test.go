@@ -0,0 +1,220 @@
+package routes
+
+import (
+    "bytes"
+    "context"
+    "crypto/ed25519"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "orderservice/auth"
+    "orderservice/catalog"
+    "orderservice/idempotency"
+    "orderservice/payments"
+    "orderservice/reconcile"
+    "orderservice/repository"
+    "testing"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+    "github.com/gorilla/mux"
+)
+
+const (
+    testKey           = "sk_test_key"
+    testWebhookSecret = "whsec_test"
+    testKeyID         = "test-key"
+)
+
+// products is a fixed catalog.
+type products map[string]catalog.Product
+
+func (p products) GetProduct(ctx context.Context, id string) (*catalog.Product, error) {
+    product, ok := p[id]
+    if !ok {
+        return nil, catalog.ErrProductNotFound
+    }
+    return &product, nil
+}
+
+// testServer is the public API backed by in-memory repositories and the fake payment
+// provider, accepting tokens signed with its own key.
+type testServer struct {
+    t        *testing.T
+    router   *mux.Router
+    orders   repository.OrderRepository
+    fake     *httptest.Server
+    provider payments.PaymentProvider
+    key      ed25519.PrivateKey
+}
+
+func newTestServer(t *testing.T) *testServer {
+    t.Helper()
+    public, private, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+            {"kty": "OKP", "crv": "Ed25519", "kid": testKeyID, "use": "sig", "x": base64.RawURLEncoding.EncodeToString(public)},
+        }})
+    }))
+    t.Cleanup(jwks.Close)
+    fake := httptest.NewServer(payments.NewFakeServer(testKey))
+    t.Cleanup(fake.Close)
+
+    orders := repository.NewMemoryOrderRepository()
+    provider := payments.NewStripe(fake.URL, testKey, testWebhookSecret, fake.Client())
+    verifier := auth.NewVerifier(auth.NewKeySet(jwks.URL), "authservice", []string{"api"})
+    catalog := products{"p1": {ID: "p1", Name: "Mug", PriceCents: 1200}, "p2": {ID: "p2", Name: "Tea", PriceCents: 800}}
+    router := SetupRouter(orders, catalog, provider, reconcile.NewRefunds(orders, provider), "usd", verifier,
+        idempotency.Middleware(repository.NewMemoryIdempotencyRepository(), time.Hour))
+    RegisterWebhooks(router, provider, reconcile.New(orders, repository.NewMemoryPaymentEventRepository(), "usd"))
+    return &testServer{t: t, router: router, orders: orders, fake: fake, provider: provider, key: private}
+}
+
+// token returns an access token for the user, with claims changed by modify.
+func (s *testServer) token(userID int64, role string, modify func(*auth.Claims)) string {
+    s.t.Helper()
+    claims := &auth.Claims{UserID: userID, Role: role, RegisteredClaims: jwt.RegisteredClaims{
+        Issuer:    "authservice",
+        Audience:  jwt.ClaimStrings{"api"},
+        ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+    }}
+    if modify != nil {
+        modify(claims)
+    }
+    token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+    token.Header["kid"] = testKeyID
+    signed, err := token.SignedString(s.key)
+    if err != nil {
+        s.t.Fatal(err)
+    }
+    return signed
+}
+
+// do sends a request with the token and decodes the JSON response into out, if given.
+func (s *testServer) do(method, path, token string, body interface{}, out interface{}, headers ...string) *httptest.ResponseRecorder {
+    s.t.Helper()
+    var payload []byte
+    if body != nil {
+        var err error
+        if payload, err = json.Marshal(body); err != nil {
+            s.t.Fatal(err)
+        }
+    }
+    req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    for i := 0; i+1 < len(headers); i += 2 {
+        req.Header.Set(headers[i], headers[i+1])
+    }
+    rec := httptest.NewRecorder()
+    s.router.ServeHTTP(rec, req)
+    if out != nil {
+        if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+            s.t.Fatalf("%s %s returned %d %q: %v", method, path, rec.Code, rec.Body.String(), err)
+        }
+    }
+    return rec
+}
+
+type order struct {
+    ID              string `json:"id"`
+    UserID          string `json:"user_id"`
+    Status          string `json:"status"`
+    TotalCents      int64  `json:"total_cents"`
+    RefundedCents   int64  `json:"refunded_cents"`
+    PaymentIntentID string `json:"payment_intent_id"`
+}
+
+// createOrder places an order of two mugs and a tea, 3200 cents, for the token's user.
+func (s *testServer) createOrder(token string) order {
+    s.t.Helper()
+    var o order
+    rec := s.do("POST", "/orders", token, map[string]interface{}{"items": []map[string]interface{}{
+        {"product_id": "p1", "quantity": 2},
+        {"product_id": "p2", "quantity": 1},
+    }}, &o)
+    if rec.Code != http.StatusCreated {
+        s.t.Fatalf("creating an order returned %d %s", rec.Code, rec.Body.String())
+    }
+    return o
+}
+
+func TestCreateOrder(t *testing.T) {
+    s := newTestServer(t)
+    customer := s.token(7, "", nil)
+
+    o := s.createOrder(customer)
+    if o.UserID != "7" || o.Status != "pending" || o.TotalCents != 3200 {
+        t.Errorf("order = %+v, want user 7's pending order of 3200 cents", o)
+    }
+    if rec := s.do("GET", "/orders/"+o.ID, s.token(8, "", nil), nil, nil); rec.Code != http.StatusNotFound {
+        t.Errorf("another user's order returned %d, want 404", rec.Code)
+    }
+    if rec := s.do("GET", "/orders/"+o.ID, "", nil, nil); rec.Code != http.StatusUnauthorized {
+        t.Errorf("request without a token returned %d, want 401", rec.Code)
+    }
+
+    var invalid errorResponse
+    rec := s.do("POST", "/orders", customer, map[string]interface{}{"items": []map[string]interface{}{{"product_id": "p1", "quantity": 0}}}, &invalid)
+    if rec.Code != http.StatusBadRequest || len(invalid.Fields) != 1 || invalid.Fields[0].Field != "items[0].quantity" {
+        t.Errorf("invalid order returned %d %+v", rec.Code, invalid)
+    }
+    if rec := s.do("POST", "/orders", customer, map[string]interface{}{"items": []map[string]interface{}{{"product_id": "nope", "quantity": 1}}}, nil); rec.Code != http.StatusUnprocessableEntity {
+        t.Errorf("unknown product returned %d, want 422", rec.Code)
+    }
+}
+
+func TestUpdateStatus(t *testing.T) {
+    s := newTestServer(t)
+    o := s.createOrder(s.token(7, "", nil))
+    staff := s.token(1, auth.RoleSupport, nil)
+
+    if rec := s.do("PUT", "/orders/"+o.ID+"/status", s.token(7, "", nil), map[string]string{"status": "cancelled"}, nil); rec.Code != http.StatusForbidden {
+        t.Errorf("customer changing the status returned %d, want 403", rec.Code)
+    }
+    if rec := s.do("PUT", "/orders/"+o.ID+"/status", staff, map[string]string{"status": "paid"}, nil); rec.Code != http.StatusBadRequest {
+        t.Errorf("setting a provider status by hand returned %d, want 400", rec.Code)
+    }
+    if rec := s.do("PUT", "/orders/"+o.ID+"/status", staff, map[string]string{"status": "shipped"}, nil); rec.Code != http.StatusConflict {
+        t.Errorf("shipping a pending order returned %d, want 409", rec.Code)
+    }
+    var cancelled order
+    if rec := s.do("PUT", "/orders/"+o.ID+"/status", staff, map[string]string{"status": "cancelled"}, &cancelled); rec.Code != http.StatusOK || cancelled.Status != "cancelled" {
+        t.Errorf("cancelling returned %d %+v", rec.Code, cancelled)
+    }
+}
+
+func TestScopedTokens(t *testing.T) {
+    s := newTestServer(t)
+    reader := s.token(7, "", func(c *auth.Claims) { c.Scope = auth.ScopeOrdersRead })
+
+    if rec := s.do("GET", "/orders", reader, nil, nil); rec.Code != http.StatusOK {
+        t.Errorf("listing with orders:read returned %d", rec.Code)
+    }
+    rec := s.do("POST", "/orders", reader, map[string]interface{}{"items": []map[string]interface{}{{"product_id": "p1", "quantity": 1}}}, nil)
+    if rec.Code != http.StatusForbidden {
+        t.Errorf("ordering with only orders:read returned %d, want 403", rec.Code)
+    }
+}
+
+func TestIdempotentRequestsAreScopedToTheUser(t *testing.T) {
+    s := newTestServer(t)
+    body := map[string]interface{}{"items": []map[string]interface{}{{"product_id": "p1", "quantity": 1}}}
+
+    var first, replay, other order
+    s.do("POST", "/orders", s.token(7, "", nil), body, &first, "Idempotency-Key", "k1")
+    rec := s.do("POST", "/orders", s.token(7, "", nil), body, &replay, "Idempotency-Key", "k1")
+    if rec.Code != http.StatusCreated || replay.ID != first.ID || rec.Header().Get("Idempotent-Replayed") != "true" {
+        t.Errorf("retry returned %d with order %s, want order %s replayed", rec.Code, replay.ID, first.ID)
+    }
+    s.do("POST", "/orders", s.token(8, "", nil), body, &other, "Idempotency-Key", "k1")
+    if other.ID == first.ID || other.UserID != "8" {
+        t.Errorf("another user's request with the same key got order %+v", other)
+    }
+}
@@ -0,0 +1,77 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "orderservice/apierror"
+    "strings"
+)
+
+// maxBodyBytes bounds request bodies; the largest, an order, stays far below it.
+const maxBodyBytes = 64 << 10
+
+// fieldError describes a problem with one field of a request body. Nested fields are
+// named like items[0].quantity.
+type fieldError struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// errorResponse is apierror.Response with the invalid fields of a 400 response to an
+// invalid request.
+type errorResponse struct {
+    Error  string       `json:"error"`
+    Fields []fieldError `json:"fields,omitempty"`
+}
+
+// decodeJSON strictly decodes the request body into dst: it must be a single JSON
+// value without unknown fields, within maxBodyBytes. It responds itself when the body
+// is invalid.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+    dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+    dec.DisallowUnknownFields()
+    err := dec.Decode(dst)
+    if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+        err = errors.New("body must contain a single JSON value")
+    }
+    if err == nil {
+        return true
+    }
+
+    var syntaxErr *json.SyntaxError
+    var typeErr *json.UnmarshalTypeError
+    var maxBytesErr *http.MaxBytesError
+    switch {
+    case errors.As(err, &maxBytesErr):
+        respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit))
+    case errors.Is(err, io.EOF):
+        respondWithValidationError(w, "request body is empty")
+    case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+        respondWithValidationError(w, "malformed JSON")
+    case errors.As(err, &typeErr) && typeErr.Field != "":
+        respondWithValidationError(w, "invalid request", fieldError{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()})
+    case strings.HasPrefix(err.Error(), "json: unknown field "):
+        // encoding/json has no error type for unknown fields
+        field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+        respondWithValidationError(w, "invalid request", fieldError{Field: field, Message: "unknown field"})
+    default:
+        respondWithValidationError(w, err.Error())
+    }
+    return false
+}
+
+// respondWithValidationError responds with 400, listing the invalid fields if there are any.
+func respondWithValidationError(w http.ResponseWriter, msg string, fields ...fieldError) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusBadRequest)
+    json.NewEncoder(w).Encode(errorResponse{Error: msg, Fields: fields})
+}
+
+// respondWithError responds with status and msg in the same JSON body as validation
+// errors and the middleware's errors.
+func respondWithError(w http.ResponseWriter, status int, msg string) {
+    apierror.Write(w, status, msg)
+}
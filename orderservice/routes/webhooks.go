@@ -0,0 +1,50 @@
+package routes
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "log/slog"
+    "net/http"
+    "orderservice/payments"
+    "orderservice/reconcile"
+
+    "github.com/gorilla/mux"
+)
+
+// maxWebhookBytes bounds webhook bodies, which carry whole provider objects.
+const maxWebhookBytes = 256 << 10
+
+// RegisterWebhooks adds the endpoint the payment provider delivers events to. Requests
+// are authenticated by the provider's signature rather than a token.
+func RegisterWebhooks(r *mux.Router, provider payments.PaymentProvider, processor *reconcile.Processor) {
+    h := &handler{payments: provider, events: processor}
+    r.HandleFunc("/payments/webhook", h.receiveWebhook).Methods("POST")
+}
+
+// receiveWebhook stores and applies a payment event. It succeeds once the event is
+// stored, even if it can't be applied yet, so the provider doesn't keep redelivering
+// it; redelivered events are acknowledged without being applied again.
+func (h *handler) receiveWebhook(w http.ResponseWriter, r *http.Request) {
+    payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+    if err != nil {
+        respondWithError(w, http.StatusBadRequest, "invalid webhook body")
+        return
+    }
+    event, err := h.payments.ParseWebhook(payload, r.Header)
+    if err != nil {
+        if !errors.Is(err, payments.ErrInvalidSignature) {
+            slog.WarnContext(r.Context(), "Rejected invalid payment webhook", "error", err)
+        }
+        respondWithError(w, http.StatusBadRequest, "invalid webhook")
+        return
+    }
+
+    isNew, err := h.events.Handle(r.Context(), event)
+    if err != nil {
+        serverError(w, r, "Error handling payment webhook", err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{"received": true, "duplicate": !isNew})
+}
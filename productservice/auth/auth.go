@@ -0,0 +1,78 @@
+// Package auth verifies the access tokens authservice issues, like orderservice does.
+// Tokens are signed with Ed25519 and checked against the public keys authservice
+// publishes, so the service needs no shared secret.
+package auth
+
+import (
+    "context"
+    "fmt"
+    "slices"
+    "strconv"
+    "strings"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes the service checks on tokens that carry one. User tokens have no scope and
+// may do anything the user may; exchanged tokens only carry the scopes asked for.
+const (
+    ScopeProductsRead  = "products:read"
+    ScopeProductsWrite = "products:write"
+)
+
+// Claims are the access-token claims the service uses.
+type Claims struct {
+    UserID int64  `json:"user_id"`
+    Role   string `json:"role,omitempty"`
+    Act    *Actor `json:"act,omitempty"` // Who is actually acting, when not the user (impersonation, token exchange)
+    Scope  string `json:"scope,omitempty"`
+    jwt.RegisteredClaims
+}
+
+// Actor identifies who acts on the user's behalf (RFC 8693).
+type Actor struct {
+    Subject string `json:"sub"`
+    UserID  int64  `json:"user_id,omitempty"` // Set when the actor is a staff user
+    Act     *Actor `json:"act,omitempty"`     // The previous actor in a delegation chain
+}
+
+// Subject returns the user ID in the form reviews and saved items store it.
+func (c *Claims) Subject() string {
+    return strconv.FormatInt(c.UserID, 10)
+}
+
+// HasScope reports whether the token allows scope. Tokens without a scope are unrestricted.
+func (c *Claims) HasScope(scope string) bool {
+    return c.Scope == "" || slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// Verifier checks access tokens.
+type Verifier struct {
+    keys      *KeySet
+    issuer    string
+    audiences []string
+}
+
+// NewVerifier creates a verifier accepting tokens from issuer for any of audiences
+// (user tokens are addressed to "api"; tokens exchanged for this service to its name).
+func NewVerifier(keys *KeySet, issuer string, audiences []string) *Verifier {
+    return &Verifier{keys: keys, issuer: issuer, audiences: audiences}
+}
+
+// Verify checks the token's signature, expiry, issuer and audience.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+    claims := &Claims{}
+    _, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+        kid, _ := t.Header["kid"].(string)
+        return v.keys.Key(ctx, kid)
+    }, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}), jwt.WithIssuer(v.issuer), jwt.WithExpirationRequired())
+    if err != nil {
+        return nil, err
+    }
+    for _, aud := range claims.Audience {
+        if slices.Contains(v.audiences, aud) {
+            return claims, nil
+        }
+    }
+    return nil, fmt.Errorf("token is not intended for this service")
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+    "context"
+    "crypto/ed25519"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "productservice/telemetry"
+    "sync"
+    "time"
+)
+
+const (
+    // keySetTTL is how long fetched keys are used before they are fetched again.
+    keySetTTL = time.Hour
+    // minRefreshInterval limits refetching for unknown key IDs, so tokens with made-up
+    // kids can't make the service hammer authservice.
+    minRefreshInterval = 30 * time.Second
+)
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// KeySet holds authservice's public keys, fetched from its JWKS endpoint and refreshed
+// when they get old or a token names a key that isn't known yet (key rotation).
+type KeySet struct {
+    url    string
+    client *http.Client
+
+    mu        sync.Mutex
+    keys      map[string]ed25519.PublicKey // Keyed by kid
+    fetchedAt time.Time
+}
+
+// NewKeySet creates a key set backed by the JWKS document at url. Keys are fetched
+// on first use.
+func NewKeySet(url string) *KeySet {
+    return &KeySet{url: url, client: telemetry.NewHTTPClient(5 * time.Second)}
+}
+
+// Key returns the public key with the given key ID.
+func (s *KeySet) Key(ctx context.Context, kid string) (ed25519.PublicKey, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key, ok := s.keys[kid]
+    stale := time.Since(s.fetchedAt) > keySetTTL
+    if ok && !stale {
+        return key, nil
+    }
+    if !stale && time.Since(s.fetchedAt) < minRefreshInterval {
+        return nil, errUnknownKey
+    }
+
+    keys, err := s.fetch(ctx)
+    if err != nil {
+        if ok {
+            // Keep verifying with the known key while authservice is unreachable
+            slog.WarnContext(ctx, "Failed to refresh JWKS, using cached keys", "error", err)
+            return key, nil
+        }
+        return nil, err
+    }
+    s.keys, s.fetchedAt = keys, time.Now()
+    if key, ok = s.keys[kid]; !ok {
+        return nil, errUnknownKey
+    }
+    return key, nil
+}
+
+// fetch downloads the key set. Keys other than Ed25519 signing keys are skipped.
+func (s *KeySet) fetch(ctx context.Context) (map[string]ed25519.PublicKey, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+    if err != nil {
+        return nil, err
+    }
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+    }
+
+    var set struct {
+        Keys []struct {
+            KeyType string `json:"kty"`
+            Curve   string `json:"crv"`
+            X       string `json:"x"`
+            KeyID   string `json:"kid"`
+            Use     string `json:"use"`
+        } `json:"keys"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+        return nil, fmt.Errorf("invalid JWKS: %w", err)
+    }
+
+    keys := make(map[string]ed25519.PublicKey, len(set.Keys))
+    for _, k := range set.Keys {
+        if k.KeyType != "OKP" || k.Curve != "Ed25519" || (k.Use != "" && k.Use != "sig") {
+            continue
+        }
+        x, err := base64.RawURLEncoding.DecodeString(k.X)
+        if err != nil || len(x) != ed25519.PublicKeySize {
+            continue
+        }
+        keys[k.KeyID] = ed25519.PublicKey(x)
+    }
+    return keys, nil
+}
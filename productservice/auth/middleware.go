@@ -0,0 +1,59 @@
+package auth
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+    "productservice/logging"
+    "strings"
+)
+
+type contextKey struct{}
+
+// Middleware rejects requests without a valid bearer token and makes the token's
+// claims available through FromContext. The user is added to the access log.
+func Middleware(v *Verifier) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+            if !ok || token == "" {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="productservice"`)
+                http.Error(w, "missing bearer token", http.StatusUnauthorized)
+                return
+            }
+            claims, err := v.Verify(r.Context(), token)
+            if err != nil {
+                slog.DebugContext(r.Context(), "Rejected access token", "error", err)
+                w.Header().Set("WWW-Authenticate", `Bearer realm="productservice", error="invalid_token"`)
+                http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+                return
+            }
+            logging.AddAttrs(r.Context(), slog.Int64("user_id", claims.UserID))
+            if claims.Act != nil {
+                logging.AddAttrs(r.Context(), slog.String("actor", claims.Act.Subject))
+            }
+            next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, claims)))
+        })
+    }
+}
+
+// RequireScope rejects tokens that don't allow scope. It must run after Middleware.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            claims, _ := FromContext(r.Context())
+            if !claims.HasScope(scope) {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="productservice", error="insufficient_scope", scope="`+scope+`"`)
+                http.Error(w, "token lacks the "+scope+" scope", http.StatusForbidden)
+                return
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// FromContext returns the claims of the request's verified token.
+func FromContext(ctx context.Context) (*Claims, bool) {
+    claims, ok := ctx.Value(contextKey{}).(*Claims)
+    return claims, ok
+}
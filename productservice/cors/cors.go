@@ -0,0 +1,81 @@
+// Package cors applies the cross-origin policy configured through the environment:
+//
+//	CORS_ALLOWED_ORIGINS    comma-separated origins, "*" for any (never with credentials)
+//	CORS_ALLOW_CREDENTIALS  "true" to allow cookies and Authorization from those origins
+//	CORS_MAX_AGE            preflight cache duration, e.g. "10m" (default)
+//
+// With no allowed origins, cross-origin requests get no CORS headers.
+package cors
+
+import (
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Config is the cross-origin policy.
+type Config struct {
+    AllowedOrigins   []string
+    AllowCredentials bool
+    MaxAge           time.Duration
+}
+
+// FromEnv reads the policy from the environment.
+func FromEnv() Config {
+    cfg := Config{MaxAge: 10 * time.Minute}
+    for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+        if origin = strings.TrimSpace(origin); origin != "" {
+            cfg.AllowedOrigins = append(cfg.AllowedOrigins, origin)
+        }
+    }
+    cfg.AllowCredentials, _ = strconv.ParseBool(os.Getenv("CORS_ALLOW_CREDENTIALS"))
+    if d, err := time.ParseDuration(os.Getenv("CORS_MAX_AGE")); err == nil {
+        cfg.MaxAge = d
+    }
+    return cfg
+}
+
+// Middleware applies cfg to next and answers preflight requests.
+func Middleware(cfg Config, next http.Handler) http.Handler {
+    allowAny := false
+    allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+    for _, origin := range cfg.AllowedOrigins {
+        if origin == "*" {
+            allowAny = true
+        }
+        allowed[origin] = true
+    }
+    maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        origin := r.Header.Get("Origin")
+        if origin == "" || len(allowed) == 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+        w.Header().Add("Vary", "Origin")
+
+        // A wildcard never grants credentialed access; those origins must be listed explicitly
+        if !allowed[origin] && !(allowAny && !cfg.AllowCredentials) {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        w.Header().Set("Access-Control-Allow-Origin", origin)
+        if cfg.AllowCredentials {
+            w.Header().Set("Access-Control-Allow-Credentials", "true")
+        }
+
+        // Preflight
+        if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+            w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+            w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID")
+            w.Header().Set("Access-Control-Max-Age", maxAge)
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
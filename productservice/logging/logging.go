@@ -0,0 +1,159 @@
+// Package logging provides structured JSON logging with request correlation. It is
+// kept identical to orderservice/logging: each service is a separate module built from
+// its own directory, so the two copies are maintained side by side rather than shared.
+package logging
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "log/slog"
+    "net/http"
+    "strings"
+    "sync"
+
+    "go.opentelemetry.io/otel/trace"
+)
+
+// redacted replaces the value of any attribute that looks like a credential.
+const redacted = "[REDACTED]"
+
+// New creates a JSON logger whose level can be changed at runtime through level.
+// Records logged with a context carry the request ID, trace ID and any attributes
+// added with AddAttrs. Passwords, tokens and Authorization headers are redacted.
+func New(w io.Writer, level *slog.LevelVar) *slog.Logger {
+    handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+        Level:       level,
+        ReplaceAttr: redact,
+    })
+    return slog.New(&contextHandler{Handler: handler})
+}
+
+// ParseLevel converts a level name such as "debug" or "WARN" into a slog.Level.
+func ParseLevel(s string) (slog.Level, error) {
+    var level slog.Level
+    err := level.UnmarshalText([]byte(s))
+    return level, err
+}
+
+// isSensitiveKey reports whether an attribute key names a secret.
+func isSensitiveKey(key string) bool {
+    key = strings.ToLower(key)
+    switch key {
+    case "authorization", "cookie", "set-cookie", "x-csrf-token":
+        return true
+    }
+    return strings.Contains(key, "password") ||
+        strings.Contains(key, "token") ||
+        strings.Contains(key, "secret")
+}
+
+// redact is a slog ReplaceAttr func that masks sensitive attributes.
+func redact(_ []string, a slog.Attr) slog.Attr {
+    if isSensitiveKey(a.Key) {
+        return slog.String(a.Key, redacted)
+    }
+
+    switch v := a.Value.Any().(type) {
+    case string:
+        // Catch credentials logged under an innocent key, e.g. a raw header value
+        if lower := strings.ToLower(v); strings.HasPrefix(lower, "bearer ") || strings.HasPrefix(lower, "basic ") {
+            return slog.String(a.Key, redacted)
+        }
+    case http.Header:
+        attrs := make([]any, 0, len(v))
+        for name, values := range v {
+            value := strings.Join(values, ", ")
+            if isSensitiveKey(name) {
+                value = redacted
+            }
+            attrs = append(attrs, slog.String(name, value))
+        }
+        return slog.Group(a.Key, attrs...)
+    }
+    return a
+}
+
+// attrSet collects attributes that are discovered while a request is being served
+// (e.g. the user ID once the token has been verified).
+type attrSet struct {
+    mu    sync.Mutex
+    attrs []slog.Attr
+}
+
+type attrSetKey struct{}
+
+// NewContext returns a context that can collect attributes through AddAttrs.
+func NewContext(ctx context.Context) context.Context {
+    return context.WithValue(ctx, attrSetKey{}, &attrSet{})
+}
+
+// AddAttrs attaches attributes to every later record logged with ctx, including
+// records logged by outer middleware that share the context created by NewContext.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+    set, ok := ctx.Value(attrSetKey{}).(*attrSet)
+    if !ok {
+        return
+    }
+    set.mu.Lock()
+    set.attrs = append(set.attrs, attrs...)
+    set.mu.Unlock()
+}
+
+// contextHandler enriches records with request-scoped attributes.
+type contextHandler struct {
+    slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+    if reqID := RequestIDFromContext(ctx); reqID != "" {
+        r.AddAttrs(slog.String("request_id", reqID))
+    }
+    if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+        r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+    }
+    if set, ok := ctx.Value(attrSetKey{}).(*attrSet); ok {
+        set.mu.Lock()
+        r.AddAttrs(set.attrs...)
+        set.mu.Unlock()
+    }
+    return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+    return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// LevelHandler reports (GET) or changes (PUT {"level":"debug"}) the log level.
+func LevelHandler(level *slog.LevelVar) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPut {
+            var req struct {
+                Level string `json:"level"`
+            }
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+                writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+                return
+            }
+            newLevel, err := ParseLevel(req.Level)
+            if err != nil {
+                writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown log level"})
+                return
+            }
+            slog.InfoContext(r.Context(), "Log level changed", "from", level.Level().String(), "to", newLevel.String())
+            level.Set(newLevel)
+        }
+
+        writeJSON(w, http.StatusOK, map[string]string{"level": level.Level().String()})
+    }
+}
+
+func writeJSON(w http.ResponseWriter, code int, payload any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    json.NewEncoder(w).Encode(payload)
+}
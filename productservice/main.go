@@ -1,14 +1,86 @@
 package main
 
 import (
-    "log"
+    "context"
+    "log/slog"
     "net/http"
+    "os"
+    "os/signal"
+    "productservice/auth"
+    "productservice/cors"
+    "productservice/logging"
     "productservice/routes"
+    "productservice/telemetry"
+    "strings"
+    "syscall"
+    "time"
 )
 
 func main() {
-    r := routes.SetupRouter()
-    log.Println("ProductService started on :8082")
+    logLevel := new(slog.LevelVar)
+    slog.SetDefault(logging.New(os.Stdout, logLevel))
+    if lvl := os.Getenv("LOG_LEVEL"); lvl != "" {
+        level, err := logging.ParseLevel(lvl)
+        if err != nil {
+            fatal("Invalid LOG_LEVEL", err)
+        }
+        logLevel.Set(level)
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    shutdownTracing, err := telemetry.Setup(ctx, "productservice")
+    if err != nil {
+        fatal("Failed to setup tracing", err)
+    }
+
+    // Access tokens are verified with authservice's published public keys
+    jwksURL := os.Getenv("AUTH_JWKS_URL")
+    if jwksURL == "" {
+        jwksURL = "http://authservice:8080/.well-known/jwks.json"
+    }
+    audiences := []string{"api", "productservice"} // User tokens, and tokens exchanged for this service
+    if aud := os.Getenv("AUTH_AUDIENCES"); aud != "" {
+        audiences = strings.Split(aud, ",")
+    }
+    verifier := auth.NewVerifier(auth.NewKeySet(jwksURL), "authservice", audiences)
+
+    router := routes.SetupRouter(verifier)
     http.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads"))))
-    log.Fatal(http.ListenAndServe(":8082", r))
+    if token := os.Getenv("INTERNAL_API_TOKEN"); token != "" {
+        routes.RegisterInternal(router, token)
+    }
+    if token := os.Getenv("LOG_ADMIN_TOKEN"); token != "" {
+        router.Handle("/debug/log-level", logging.RequireToken(token, logging.LevelHandler(logLevel))).Methods("GET", "PUT")
+    }
+
+    server := &http.Server{Addr: ":8082", Handler: telemetry.Middleware("productservice", logging.Middleware(slog.Default(), cors.Middleware(cors.FromEnv(), router)))}
+    // ListenAndServe returns as soon as Shutdown starts, so wait for in-flight
+    // requests to finish and the last spans to be flushed before exiting
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        if err := server.Shutdown(shutdownCtx); err != nil {
+            slog.Error("Graceful shutdown failed", "error", err)
+        }
+        if err := shutdownTracing(shutdownCtx); err != nil {
+            slog.Error("Failed to shutdown tracing", "error", err)
+        }
+    }()
+
+    slog.Info("ProductService started", "addr", server.Addr)
+    if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+        fatal("Server failed", err)
+    }
+    <-done
+    slog.Info("Server gracefully stopped")
+}
+
+func fatal(msg string, err error) {
+    slog.Error(msg, "error", err)
+    os.Exit(1)
 }
@@ -0,0 +1,9 @@
+package models
+
+type Review struct {
+    ID        string `json:"id"`
+    ProductID string `json:"product_id"`
+    UserID    string `json:"user_id"`
+    Rating    int    `json:"rating"`
+    Comment   string `json:"comment"`
+}
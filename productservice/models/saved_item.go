@@ -0,0 +1,7 @@
+package models
+
+// SavedItem is a product a user saved for later (wishlist).
+type SavedItem struct {
+    UserID    string `json:"user_id"`
+    ProductID string `json:"product_id"`
+}
@@ -0,0 +1,65 @@
+package routes
+
+import (
+    "encoding/json"
+    "net/http"
+
+    "github.com/gorilla/mux"
+    "productservice/logging"
+    "productservice/models"
+)
+
+// RegisterInternal adds the service-to-service endpoints used by authservice for
+// data export and account erasure. They require "Authorization: Bearer <token>".
+func RegisterInternal(r *mux.Router, token string) {
+    r.Handle("/internal/users/{userID}/export", logging.RequireToken(token, http.HandlerFunc(exportUserData))).Methods("GET")
+    r.Handle("/internal/user-deletions", logging.RequireToken(token, http.HandlerFunc(deleteUserData))).Methods("POST")
+}
+
+func exportUserData(w http.ResponseWriter, r *http.Request) {
+    userID := mux.Vars(r)["userID"]
+    userReviews := []models.Review{}
+    userDataMu.RLock()
+    for _, rev := range reviews {
+        if rev.UserID == userID {
+            userReviews = append(userReviews, rev)
+        }
+    }
+    userDataMu.RUnlock()
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "saved_items": userSavedItems(userID),
+        "reviews":     userReviews,
+    })
+}
+
+// deleteUserData removes the user's saved items and reviews. Repeating the request is harmless.
+func deleteUserData(w http.ResponseWriter, r *http.Request) {
+    var event struct {
+        ErasureID int64  `json:"erasure_id"`
+        UserID    string `json:"user_id"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event.UserID == "" {
+        http.Error(w, "invalid deletion event", http.StatusBadRequest)
+        return
+    }
+
+    userDataMu.Lock()
+    deletedReviews := 0
+    for id, rev := range reviews {
+        if rev.UserID == event.UserID {
+            delete(reviews, id)
+            deletedReviews++
+        }
+    }
+    deletedItems := len(savedItems[event.UserID])
+    delete(savedItems, event.UserID)
+    userDataMu.Unlock()
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "erasure_id":          event.ErasureID,
+        "reviews_deleted":     deletedReviews,
+        "saved_items_deleted": deletedItems,
+    })
+}
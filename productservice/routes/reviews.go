@@ -0,0 +1,110 @@
+package routes
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "sync"
+
+    "github.com/gorilla/mux"
+    "productservice/auth"
+    "productservice/models"
+)
+
+// userDataMu guards reviews and savedItems, which every request may read or change.
+var userDataMu sync.RWMutex
+
+var reviews = make(map[string]models.Review)
+
+// savedItems maps a user ID to the IDs of the products they saved.
+var savedItems = make(map[string][]string)
+
+// createReview adds a review of the product by the token's user.
+func createReview(w http.ResponseWriter, r *http.Request) {
+    claims, _ := auth.FromContext(r.Context())
+    productID := mux.Vars(r)["id"]
+    if _, exists := products[productID]; !exists {
+        http.Error(w, "product not found", http.StatusNotFound)
+        return
+    }
+
+    var rev models.Review
+    if err := json.NewDecoder(r.Body).Decode(&rev); err != nil {
+        http.Error(w, "invalid review", http.StatusBadRequest)
+        return
+    }
+    if rev.Rating < 1 || rev.Rating > 5 {
+        http.Error(w, "rating must be between 1 and 5", http.StatusBadRequest)
+        return
+    }
+    rev.ID = newID()
+    rev.ProductID = productID
+    rev.UserID = claims.Subject() // Whatever the body says, reviews are by the token's user
+
+    userDataMu.Lock()
+    reviews[rev.ID] = rev
+    userDataMu.Unlock()
+
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(rev)
+}
+
+func listReviews(w http.ResponseWriter, r *http.Request) {
+    productID := mux.Vars(r)["id"]
+    result := []models.Review{}
+    userDataMu.RLock()
+    for _, rev := range reviews {
+        if rev.ProductID == productID {
+            result = append(result, rev)
+        }
+    }
+    userDataMu.RUnlock()
+    json.NewEncoder(w).Encode(result)
+}
+
+// saveItem adds a product to the token's user's saved items.
+func saveItem(w http.ResponseWriter, r *http.Request) {
+    claims, _ := auth.FromContext(r.Context())
+    var item models.SavedItem
+    if err := json.NewDecoder(r.Body).Decode(&item); err != nil || item.ProductID == "" {
+        http.Error(w, "product_id is required", http.StatusBadRequest)
+        return
+    }
+    item.UserID = claims.Subject()
+
+    userDataMu.Lock()
+    defer userDataMu.Unlock()
+    for _, id := range savedItems[item.UserID] {
+        if id == item.ProductID {
+            json.NewEncoder(w).Encode(item)
+            return
+        }
+    }
+    savedItems[item.UserID] = append(savedItems[item.UserID], item.ProductID)
+
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(item)
+}
+
+// listSavedItems lists the token's user's saved items.
+func listSavedItems(w http.ResponseWriter, r *http.Request) {
+    claims, _ := auth.FromContext(r.Context())
+    json.NewEncoder(w).Encode(userSavedItems(claims.Subject()))
+}
+
+func userSavedItems(userID string) []models.SavedItem {
+    userDataMu.RLock()
+    defer userDataMu.RUnlock()
+    items := []models.SavedItem{}
+    for _, id := range savedItems[userID] {
+        items = append(items, models.SavedItem{UserID: userID, ProductID: id})
+    }
+    return items
+}
+
+func newID() string {
+    b := make([]byte, 8)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
@@ -10,17 +10,32 @@ import (
     "strings"
 
     "github.com/gorilla/mux"
+    "productservice/auth"
+    "productservice/logging"
     "productservice/models"
+    "productservice/telemetry"
 )
 
 var products = make(map[string]models.Product)
 
-func SetupRouter() *mux.Router {
+// SetupRouter creates the router for the public API. Reviews are by, and saved items
+// belong to, the user of the access token from authservice, verified by verifier.
+func SetupRouter(verifier *auth.Verifier) *mux.Router {
+    authn := auth.Middleware(verifier)
+    reading := func(f http.HandlerFunc) http.Handler { return authn(auth.RequireScope(auth.ScopeProductsRead)(f)) }
+    writing := func(f http.HandlerFunc) http.Handler { return authn(auth.RequireScope(auth.ScopeProductsWrite)(f)) }
     r := mux.NewRouter()
+    r.Use(telemetry.RouteTagger)
+    r.Use(logging.RouteTagger)
 
     r.HandleFunc("/products", createProduct).Methods("POST")
     r.HandleFunc("/products", listProducts).Methods("GET")
+    r.HandleFunc("/products/{id}", getProduct).Methods("GET")
     r.HandleFunc("/upload", uploadImage).Methods("POST")
+    r.Handle("/products/{id}/reviews", writing(createReview)).Methods("POST")
+    r.HandleFunc("/products/{id}/reviews", listReviews).Methods("GET")
+    r.Handle("/saved-items", writing(saveItem)).Methods("POST")
+    r.Handle("/saved-items", reading(listSavedItems)).Methods("GET")
 
     return r
 }
@@ -33,6 +48,15 @@ func createProduct(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(p)
 }
 
+func getProduct(w http.ResponseWriter, r *http.Request) {
+    p, exists := products[mux.Vars(r)["id"]]
+    if !exists {
+        http.Error(w, "product not found", http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(p)
+}
+
 func listProducts(w http.ResponseWriter, r *http.Request) {
     category := r.URL.Query().Get("category")
     name := r.URL.Query().Get("name")
@@ -0,0 +1,120 @@
+// Package telemetry sets up OpenTelemetry tracing. It is kept identical to
+// orderservice/telemetry: each service is a separate module built from its own
+// directory, so the two copies are maintained side by side rather than shared.
+package telemetry
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "time"
+
+    "github.com/gorilla/mux"
+    "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// Setup installs the global tracer provider and the W3C trace context propagator.
+// TRACE_EXPORTER selects the exporter: none (default), stdout, file (TRACE_FILE) or otlp.
+func Setup(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+    otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+        propagation.TraceContext{},
+        propagation.Baggage{},
+    ))
+
+    var (
+        exporter sdktrace.SpanExporter
+        closer   func() error
+        err      error
+    )
+    switch kind := os.Getenv("TRACE_EXPORTER"); kind {
+    case "", "none":
+        return func(context.Context) error { return nil }, nil
+    case "stdout":
+        exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+    case "file":
+        path := os.Getenv("TRACE_FILE")
+        if path == "" {
+            path = "traces.jsonl"
+        }
+        var f *os.File
+        f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+        if err != nil {
+            return nil, fmt.Errorf("failed to open trace file: %w", err)
+        }
+        closer = f.Close
+        exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+    case "otlp":
+        exporter, err = otlptracehttp.New(ctx)
+    default:
+        return nil, fmt.Errorf("unknown trace exporter %q", kind)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+    }
+
+    res, err := resource.New(ctx,
+        resource.WithFromEnv(),
+        resource.WithTelemetrySDK(),
+        resource.WithAttributes(attribute.String("service.name", serviceName)),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("failed to create trace resource: %w", err)
+    }
+
+    tp := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(res),
+    )
+    otel.SetTracerProvider(tp)
+
+    return func(ctx context.Context) error {
+        err := tp.Shutdown(ctx)
+        if closer != nil {
+            err = errors.Join(err, closer())
+        }
+        return err
+    }, nil
+}
+
+// NewHTTPClient returns a client that traces outgoing calls and sends traceparent headers.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+    return &http.Client{
+        Transport: otelhttp.NewTransport(http.DefaultTransport),
+        Timeout:   timeout,
+    }
+}
+
+// Middleware starts a server span for every request and continues the caller's trace.
+// It wraps the whole handler, outside the access log, so log lines carry the trace ID;
+// RouteTagger names the span after the matched route.
+func Middleware(serviceName string, next http.Handler) http.Handler {
+    return otelhttp.NewHandler(next, serviceName,
+        otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+            return r.Method
+        }),
+    )
+}
+
+// RouteTagger is a mux middleware that names the request's span after the matched route.
+func RouteTagger(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if route := mux.CurrentRoute(r); route != nil {
+            if tmpl, err := route.GetPathTemplate(); err == nil {
+                span := trace.SpanFromContext(r.Context())
+                span.SetName(r.Method + " " + tmpl)
+                span.SetAttributes(attribute.String("http.route", tmpl))
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}